@@ -2,18 +2,19 @@ package application
 
 import (
 	"encoding/json"
-	"fmt"
 	"strings"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	dbconf "github.com/kthomas/go-db-config"
 	natsutil "github.com/kthomas/go-natsutil"
 	"github.com/kthomas/go-pgputil"
-	redisutil "github.com/kthomas/go-redisutil"
 	uuid "github.com/kthomas/go.uuid"
 	"github.com/nats-io/nats.go"
 	"github.com/provideplatform/ident/common"
 	"github.com/provideplatform/ident/organization"
+	"github.com/provideplatform/ident/role"
+	"github.com/provideplatform/ident/token"
 	"github.com/provideplatform/ident/user"
 	provide "github.com/provideplatform/provide-go/api"
 )
@@ -173,19 +174,9 @@ func (app *Application) sanitizeConfig() {
 	app.setEncryptedConfig(encryptedConfig)
 }
 
-// pendingInvitations returns the pending invitations for the application; these are ephemeral, in-memory only
-func (app *Application) pendingInvitations() []*user.Invite {
-	var invitations []*user.Invite
-
-	key := fmt.Sprintf("application.%s.invitations", app.ID.String())
-	rawinvites, err := redisutil.Get(key)
-	if err != nil {
-		common.Log.Debugf("failed to retrieve cached application invitations from key: %s; %s", key, err.Error())
-		return invitations
-	}
-
-	json.Unmarshal([]byte(*rawinvites), &invitations)
-	return invitations
+// InvitationsListQuery returns a query for the invitations to the application
+func (app *Application) InvitationsListQuery(db *gorm.DB) *gorm.DB {
+	return db.Where("invitations.application_id = ?", app.ID)
 }
 
 // initImplicitDiffieHellmanKeyExchange initializes a Diffie-Hellman key exchange for all organizations
@@ -288,7 +279,7 @@ func (app *Application) updateOrganization(tx *gorm.DB, org organization.Organiz
 	return success
 }
 
-func (app *Application) addUser(tx *gorm.DB, usr user.User, permissions common.Permission) bool {
+func (app *Application) addUser(tx *gorm.DB, usr user.User, permissions common.Permission, roleID *uuid.UUID) bool {
 	var db *gorm.DB
 	if tx != nil {
 		db = tx
@@ -297,7 +288,7 @@ func (app *Application) addUser(tx *gorm.DB, usr user.User, permissions common.P
 	}
 
 	common.Log.Debugf("adding user %s to application: %s", usr.ID, app.ID)
-	result := db.Exec("INSERT INTO applications_users (application_id, user_id, permissions) VALUES (?, ?, ?)", app.ID, usr.ID, permissions)
+	result := db.Exec("INSERT INTO applications_users (application_id, user_id, permissions, role_id) VALUES (?, ?, ?, ?)", app.ID, usr.ID, permissions, roleID)
 	success := result.RowsAffected == 1
 	if success {
 		common.Log.Debugf("added user %s to application: %s", usr.ID, app.ID)
@@ -326,6 +317,8 @@ func (app *Application) removeUser(tx *gorm.DB, usr user.User) bool {
 	return success
 }
 
+// updateUser sets the permissions of the membership of the given user; the membership no
+// longer references a role, as its permissions are no longer those of the role
 func (app *Application) updateUser(tx *gorm.DB, usr user.User, permissions common.Permission) bool {
 	var db *gorm.DB
 	if tx != nil {
@@ -335,7 +328,7 @@ func (app *Application) updateUser(tx *gorm.DB, usr user.User, permissions commo
 	}
 
 	common.Log.Debugf("updating user %s for application: %s", usr.ID, app.ID)
-	result := db.Exec("UPDATE applications_users SET permissions = ? WHERE application_id = ? AND user_id = ?", permissions, app.ID, usr.ID)
+	result := db.Exec("UPDATE applications_users SET permissions = ?, role_id = NULL WHERE application_id = ? AND user_id = ?", permissions, app.ID, usr.ID)
 	success := result.RowsAffected == 1
 	if success {
 		common.Log.Debugf("updated user %s for application: %s", usr.ID, app.ID)
@@ -345,6 +338,71 @@ func (app *Application) updateUser(tx *gorm.DB, usr user.User, permissions commo
 	return success
 }
 
+// updateUserRole sets the role of the membership of the given user
+func (app *Application) updateUserRole(tx *gorm.DB, usr user.User, r *role.Role) bool {
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+	}
+
+	common.Log.Debugf("updating role of user %s for application: %s", usr.ID, app.ID)
+	result := db.Exec("UPDATE applications_users SET permissions = ?, role_id = ? WHERE application_id = ? AND user_id = ?", r.Permissions, r.ID, app.ID, usr.ID)
+	success := result.RowsAffected == 1
+	if success {
+		common.Log.Debugf("updated role of user %s for application: %s; role: %s", usr.ID, app.ID, *r.Name)
+	} else {
+		common.Log.Warningf("failed to update role of user %s for application: %s", usr.ID, app.ID)
+	}
+	return success
+}
+
+// setUserExpiration sets, extends or clears the expiration of the membership of the given user
+func (app *Application) setUserExpiration(tx *gorm.DB, usr user.User, expiresAt *time.Time) bool {
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+	}
+
+	common.Log.Debugf("setting expiration of user %s for application: %s", usr.ID, app.ID)
+	result := db.Exec("UPDATE applications_users SET expires_at = ?, expiration_notified_at = NULL WHERE application_id = ? AND user_id = ?", expiresAt, app.ID, usr.ID)
+	success := result.RowsAffected == 1
+	if success {
+		common.Log.Debugf("set expiration of user %s for application: %s", usr.ID, app.ID)
+	} else {
+		common.Log.Warningf("failed to set expiration of user %s for application: %s", usr.ID, app.ID)
+	}
+	return success
+}
+
+// grantablePermissions returns the permission mask and extended permissions which the given bearer
+// may grant to members of the application; sudoers may grant any resource permissions, the owner the
+// owner permissions, other users the permissions of their membership and application bearers the
+// resource permissions asserted by the bearer; the extended permissions of sudoers and the owner are
+// unrestricted, in which case they are nil; false is returned if the bearer user is not a member
+func (app *Application) grantablePermissions(db *gorm.DB, bearer *token.Token) (common.Permission, map[string]common.Permission, bool) {
+	if bearer.HasPermission(common.Sudo) {
+		return role.ResourcePermissions, nil, true
+	}
+
+	if bearer.UserID == nil || *bearer.UserID == uuid.Nil {
+		extendedPermissions := bearer.ParseExtendedPermissions()
+		if extendedPermissions == nil {
+			extendedPermissions = map[string]common.Permission{}
+		}
+		return bearer.Permissions & role.ResourcePermissions, extendedPermissions, true
+	}
+
+	if app.UserID == *bearer.UserID {
+		return role.OwnerPermission, nil, true
+	}
+
+	return role.EffectiveApplicationPermissions(db, app.ID, *bearer.UserID)
+}
+
 // FullName returns the application name; see Invitor interface
 func (app *Application) FullName() *string {
 	return app.Name
@@ -383,7 +441,7 @@ func (app *Application) Create(tx *gorm.DB) bool {
 			success := rowsAffected > 0
 			if success {
 				usr := user.Find(app.UserID)
-				if usr != nil && app.addUser(db, *usr, common.DefaultApplicationUserResourcePermission) {
+				if usr != nil && app.addUser(db, *usr, role.OwnerPermission, role.BuiltinID(db, role.Owner)) {
 					db.Commit()
 				}
 
@@ -403,6 +461,17 @@ func (app *Application) Create(tx *gorm.DB) bool {
 // Validate an application for persistence
 func (app *Application) validate() bool {
 	app.Errors = make([]*provide.Error, 0)
+
+	cfg := app.ParseConfig()
+	if schema, schemaOk := cfg[user.UserAttributesSchemaKey]; schemaOk && schema != nil {
+		_, err := user.ParseAttributesSchema(schema)
+		if err != nil {
+			app.Errors = append(app.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+		}
+	}
+
 	return len(app.Errors) == 0
 }
 
@@ -2,6 +2,10 @@ package application
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/gorm"
@@ -9,6 +13,7 @@ import (
 	uuid "github.com/kthomas/go.uuid"
 	"github.com/provideplatform/ident/common"
 	"github.com/provideplatform/ident/organization"
+	"github.com/provideplatform/ident/role"
 	"github.com/provideplatform/ident/token"
 	"github.com/provideplatform/ident/user"
 	provide "github.com/provideplatform/provide-go/common"
@@ -40,6 +45,11 @@ func InstallApplicationUsersAPI(r *gin.Engine) {
 	r.PUT("/api/v1/applications/:id/users/:userId", updateApplicationUserHandler)
 	r.DELETE("/api/v1/applications/:id/users/:userId", deleteApplicationUserHandler)
 
+	r.GET("/api/v1/applications/:id/roles", applicationRolesListHandler)
+	r.POST("/api/v1/applications/:id/roles", createApplicationRoleHandler)
+	r.PUT("/api/v1/applications/:id/roles/:roleId", updateApplicationRoleHandler)
+	r.DELETE("/api/v1/applications/:id/roles/:roleId", deleteApplicationRoleHandler)
+
 	r.GET("/api/v1/applications/:id/invitations", applicationInvitationsListHandler)
 }
 
@@ -189,7 +199,7 @@ func applicationDetailsHandler(c *gin.Context) {
 	}
 
 	appUser := resolveAppUser(db, app, userID)
-	common.Log.Debugf("APP USER : %s", appUser)
+	common.Log.Debugf("APP USER : %v", appUser)
 
 	if appID != nil && appID.String() != c.Param("id") { // FIXME -- test ListApplications permission
 		provide.RenderError("forbidden", 403, c)
@@ -517,20 +527,176 @@ func applicationInvitationsListHandler(c *gin.Context) {
 		return
 	}
 
-	invitations := app.pendingInvitations() // FIXME-- paginate the in-memory invitations list within redis
-	invitedUsers := make([]*user.User, 0)
-	for _, invite := range invitations {
-		usr := &user.User{
-			FirstName: invite.FirstName,
-			LastName:  invite.LastName,
-			Email:     invite.Email,
-		}
-		if invite.Permissions != nil {
-			usr.Permissions = *invite.Permissions
-		}
-		invitedUsers = append(invitedUsers, usr)
+	if c.Query("status") != "" && !user.IsValidInvitationStatus(c.Query("status")) {
+		provide.RenderError(fmt.Sprintf("invalid invitation status: %s", c.Query("status")), 422, c)
+		return
+	}
+
+	var invitations []*user.Invitation
+	query := user.FilterInvitations(app.InvitationsListQuery(db), c.Query("status"), strings.ToLower(c.Query("email")))
+	provide.Paginate(c, query, &user.Invitation{}).Find(&invitations)
+	provide.Render(invitations, 200, c)
+}
+
+// authorizeApplicationAdministration resolves the application referenced by the id param if the
+// bearer is authorized to administer it; an error has been rendered when nil is returned
+func authorizeApplicationAdministration(c *gin.Context, bearer *token.Token) *Application {
+	if bearer == nil || ((bearer.UserID == nil || *bearer.UserID == uuid.Nil) && (bearer.ApplicationID == nil || *bearer.ApplicationID == uuid.Nil)) {
+		provide.RenderError("unauthorized", 401, c)
+		return nil
+	}
+
+	if bearer.ApplicationID != nil && (*bearer.ApplicationID).String() != c.Param("id") {
+		provide.RenderError("forbidden", 403, c)
+		return nil
+	}
+
+	app := &Application{}
+	dbconf.DatabaseConnection().Where("id = ?", c.Param("id")).Find(&app)
+	if app == nil || app.ID == uuid.Nil {
+		provide.RenderError("application not found", 404, c)
+		return nil
+	}
+
+	if bearer.UserID != nil && *bearer.UserID != app.UserID {
+		provide.RenderError("forbidden", 403, c)
+		return nil
+	}
+
+	return app
+}
+
+func applicationRolesListHandler(c *gin.Context) {
+	app := authorizeApplicationAdministration(c, token.InContext(c))
+	if app == nil {
+		return
+	}
+
+	var roles []*role.Role
+	query := role.ListQuery(dbconf.DatabaseConnection(), &app.ID, nil).Order("roles.created_at ASC")
+	provide.Paginate(c, query, &role.Role{}).Find(&roles)
+	provide.Render(roles, 200, c)
+}
+
+func createApplicationRoleHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	app := authorizeApplicationAdministration(c, bearer)
+	if app == nil {
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	r := &role.Role{}
+	err = json.Unmarshal(buf, r)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	r.ApplicationID = &app.ID
+	r.OrganizationID = nil
+
+	if !authorizeRoleGrant(c, app, bearer, r) {
+		return
+	}
+
+	if r.Create(nil) {
+		provide.Render(r, 201, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = r.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+func updateApplicationRoleHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	app := authorizeApplicationAdministration(c, bearer)
+	if app == nil {
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	r := &role.Role{}
+	dbconf.DatabaseConnection().Where("id = ? AND application_id = ?", c.Param("roleId"), app.ID).Find(&r)
+	if r == nil || r.ID == uuid.Nil {
+		provide.RenderError("role not found", 404, c)
+		return
+	}
+
+	roleID := r.ID
+	err = json.Unmarshal(buf, r)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	r.ID = roleID
+	r.ApplicationID = &app.ID
+	r.OrganizationID = nil
+
+	if !authorizeRoleGrant(c, app, bearer, r) {
+		return
+	}
+
+	if r.Update(nil) {
+		provide.Render(nil, 204, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = r.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+// authorizeRoleGrant returns true if the bearer may define the given role for the application; the
+// permissions of a role are granted to each membership which references it, so a bearer cannot define
+// a role having permissions or extended permissions it is unable to grant; an error has been rendered
+// when false is returned
+func authorizeRoleGrant(c *gin.Context, app *Application, bearer *token.Token, r *role.Role) bool {
+	grantorPermissions, grantorExtendedPermissions, isMember := app.grantablePermissions(dbconf.DatabaseConnection(), bearer)
+	if !isMember {
+		provide.RenderError("forbidden", 403, c)
+		return false
+	}
+
+	if err := role.ValidateGrant(r, r.Permissions, grantorPermissions, grantorExtendedPermissions); err != nil {
+		provide.RenderError(err.Error(), 403, c)
+		return false
+	}
+
+	return true
+}
+
+func deleteApplicationRoleHandler(c *gin.Context) {
+	app := authorizeApplicationAdministration(c, token.InContext(c))
+	if app == nil {
+		return
+	}
+
+	r := &role.Role{}
+	dbconf.DatabaseConnection().Where("id = ? AND application_id = ?", c.Param("roleId"), app.ID).Find(&r)
+	if r == nil || r.ID == uuid.Nil {
+		provide.RenderError("role not found", 404, c)
+		return
+	}
+
+	if r.Delete(nil) {
+		provide.Render(nil, 204, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = r.Errors
+		provide.Render(obj, 422, c)
 	}
-	provide.Render(invitedUsers, 200, c)
 }
 
 func applicationUsersListHandler(c *gin.Context) {
@@ -613,8 +779,11 @@ func createApplicationUserHandler(c *gin.Context) {
 	}
 
 	var permissions common.Permission
+	var roleID *uuid.UUID
+
+	roleParam, roleParamOk := params["role"].(string)
 	appPermissions, permissionsOk := params["permissions"].(common.Permission)
-	if permissionsOk && !bearer.HasAnyExtendedPermission(applicationResourceKey, common.CreateResource, common.GrantResourceAuthorization) {
+	if (roleParamOk || permissionsOk) && !bearer.HasAnyExtendedPermission(applicationResourceKey, common.CreateResource, common.GrantResourceAuthorization) {
 		provide.RenderError("unable to assert arbitrary application user permissions", 403, c)
 		return
 	} else if permissionsOk {
@@ -632,6 +801,30 @@ func createApplicationUserHandler(c *gin.Context) {
 		return
 	}
 
+	if roleParamOk || permissionsOk {
+		var appRole *role.Role
+		if roleParamOk {
+			appRole = role.Resolve(db, roleParam, &app.ID, nil)
+			if appRole == nil {
+				provide.RenderError(fmt.Sprintf("role not found: %s", roleParam), 422, c)
+				return
+			}
+			permissions = appRole.Permissions
+			roleID = &appRole.ID
+		}
+
+		grantorPermissions, grantorExtendedPermissions, isMember := app.grantablePermissions(db, bearer)
+		if !isMember {
+			provide.RenderError("forbidden", 403, c)
+			return
+		}
+
+		if err := role.ValidateGrant(appRole, permissions, grantorPermissions, grantorExtendedPermissions); err != nil {
+			provide.RenderError(err.Error(), 403, c)
+			return
+		}
+	}
+
 	usr := &user.User{}
 	db.Where("id = ?", userID).Find(&usr)
 	if usr == nil || usr.ID == uuid.Nil {
@@ -639,7 +832,25 @@ func createApplicationUserHandler(c *gin.Context) {
 		return
 	}
 
-	if app.addUser(db, *usr, permissions) {
+	if !usr.IsEmailVerified() && user.ApplicationRequiresVerifiedEmail(db, app.ID) {
+		provide.RenderError("application requires a verified email address", 422, c)
+		return
+	}
+
+	var expiresAt *time.Time
+	if _, expiresAtOk := params["expires_at"]; expiresAtOk {
+		expiresAt, err = parseApplicationUserExpiration(params)
+		if err != nil {
+			provide.RenderError(err.Error(), 422, c)
+			return
+		}
+	}
+
+	tx := db.Begin()
+	defer tx.RollbackUnlessCommitted()
+
+	if app.addUser(tx, *usr, permissions, roleID) && (expiresAt == nil || app.setUserExpiration(tx, *usr, expiresAt)) {
+		tx.Commit()
 		provide.Render(nil, 204, c)
 	} else {
 		obj := map[string]interface{}{}
@@ -649,7 +860,133 @@ func createApplicationUserHandler(c *gin.Context) {
 }
 
 func updateApplicationUserHandler(c *gin.Context) {
-	provide.RenderError("not implemented", 501, c)
+	bearer := token.InContext(c)
+	appID := bearer.ApplicationID
+
+	if appID == nil || *appID == uuid.Nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	if appID != nil && appID.String() != c.Param("id") {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	userID, err := uuid.FromString(c.Param("userId"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	params := map[string]interface{}{}
+	err = json.Unmarshal(buf, &params)
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	roleParam, roleParamOk := params["role"].(string)
+	permissions, permissionsOk := params["permissions"].(float64)
+	if (roleParamOk || permissionsOk) && !bearer.HasAnyExtendedPermission(applicationResourceKey, common.UpdateResource, common.GrantResourceAuthorization) {
+		provide.RenderError("unable to assert arbitrary application user permissions", 403, c)
+		return
+	} else if roleParamOk && permissionsOk {
+		provide.RenderError("only one of role or permissions may be given", 422, c)
+		return
+	}
+
+	_, expiresAtOk := params["expires_at"]
+	var expiresAt *time.Time
+	if expiresAtOk {
+		expiresAt, err = parseApplicationUserExpiration(params)
+		if err != nil {
+			provide.RenderError(err.Error(), 422, c)
+			return
+		}
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	var app = &Application{}
+	db.Where("id = ?", c.Param("id")).Find(&app)
+	if app == nil || app.ID == uuid.Nil {
+		provide.RenderError("application not found", 404, c)
+		return
+	}
+
+	usr := &user.User{}
+	db.Joins("JOIN applications_users as au ON au.user_id = users.id").Where("au.application_id = ? AND users.id = ?", app.ID, userID).Find(&usr)
+	if usr == nil || usr.ID == uuid.Nil {
+		provide.RenderError("user not found", 404, c)
+		return
+	}
+
+	var appRole *role.Role
+	if roleParamOk {
+		appRole = role.Resolve(db, roleParam, &app.ID, nil)
+		if appRole == nil {
+			provide.RenderError(fmt.Sprintf("role not found: %s", roleParam), 422, c)
+			return
+		}
+		permissions = float64(appRole.Permissions)
+	}
+
+	if roleParamOk || permissionsOk {
+		grantorPermissions, grantorExtendedPermissions, isMember := app.grantablePermissions(db, bearer)
+		if !isMember {
+			provide.RenderError("forbidden", 403, c)
+			return
+		}
+
+		if err := role.ValidateGrant(appRole, common.Permission(permissions), grantorPermissions, grantorExtendedPermissions); err != nil {
+			provide.RenderError(err.Error(), 403, c)
+			return
+		}
+	}
+
+	tx := db.Begin()
+	defer tx.RollbackUnlessCommitted()
+
+	if permissionsOk && !app.updateUser(tx, *usr, common.Permission(permissions)) {
+		provide.RenderError("failed to update application user permissions", 422, c)
+		return
+	}
+
+	if roleParamOk {
+		if !app.updateUserRole(tx, *usr, appRole) {
+			provide.RenderError("failed to update application user role", 422, c)
+			return
+		}
+	}
+
+	if expiresAtOk && !app.setUserExpiration(tx, *usr, expiresAt) {
+		provide.RenderError("failed to update application user expiration", 422, c)
+		return
+	}
+
+	tx.Commit()
+	provide.Render(nil, 204, c)
+}
+
+// parseApplicationUserExpiration parses the expires_at param of an application membership
+func parseApplicationUserExpiration(params map[string]interface{}) (*time.Time, error) {
+	expiresAt, err := user.ParseExpiration(params)
+	if err != nil {
+		return nil, err
+	}
+
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, errors.New("expires_at must be in the future")
+	}
+
+	return expiresAt, nil
 }
 
 func deleteApplicationUserHandler(c *gin.Context) {
@@ -12,11 +12,13 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/kthomas/go-auth0"
+	dbconf "github.com/kthomas/go-db-config"
 	"github.com/kthomas/go-pgputil"
 	"github.com/kthomas/go-redisutil"
 
 	"github.com/provideplatform/ident/application"
 	"github.com/provideplatform/ident/common"
+	"github.com/provideplatform/ident/legal"
 	"github.com/provideplatform/ident/organization"
 	"github.com/provideplatform/ident/token"
 	"github.com/provideplatform/ident/user"
@@ -28,9 +30,6 @@ import (
 const didDocumentVocab = "https://ident.provide.services/"
 const jsonWebKey2020Type = "JsonWebKey2020"
 
-const privacyPolicyUpdatedAt = "2018-10-19T00:00:00.000000"
-const termsOfServiceUpdatedAt = "2018-10-19T00:00:00.000000"
-
 const runloopSleepInterval = 250 * time.Millisecond
 const runloopTickInterval = 5000 * time.Millisecond
 
@@ -113,8 +112,7 @@ func runAPI() {
 	r.GET("/.well-known/resolve/:did", resolveDIDHandler) // deprecated
 
 	r.GET("/status", statusHandler)
-	r.GET("/legal/privacy_policy", privacyPolicyHandler)
-	r.GET("/legal/terms_of_service", termsOfServiceHandler)
+	legal.InstallPublicLegalAPI(r)
 	user.InstallPublicUserAPI(r)
 
 	r.Use(token.AuthMiddleware())
@@ -127,8 +125,10 @@ func runAPI() {
 	organization.InstallOrganizationAPI(r)
 	organization.InstallOrganizationUsersAPI(r)
 	organization.InstallOrganizationVaultsAPI(r)
+	legal.InstallLegalAPI(r)
 	token.InstallTokenAPI(r)
 	user.InstallUserAPI(r)
+	user.InstallSCIMAPI(r)
 
 	srv = &http.Server{
 		Addr:    util.ListenAddr,
@@ -150,24 +150,23 @@ func openIDConfigurationHandler(c *gin.Context) {
 }
 
 func statusHandler(c *gin.Context) {
-	status := map[string]interface{}{
-		"privacy_policy_updated_at":   privacyPolicyUpdatedAt,
-		"terms_of_service_updated_at": termsOfServiceUpdatedAt,
+	status := map[string]interface{}{}
+
+	db := dbconf.DatabaseConnection()
+	for _, documentType := range legal.DocumentTypes {
+		var updatedAt interface{}
+		var version interface{}
+		if document := legal.Latest(db, documentType); document != nil {
+			updatedAt = document.PublishedAt
+			version = document.Version
+		}
+		status[fmt.Sprintf("%s_updated_at", documentType)] = updatedAt
+		status[fmt.Sprintf("%s_version", documentType)] = version
 	}
 
 	provide.Render(status, 200, c)
 }
 
-func privacyPolicyHandler(c *gin.Context) {
-	resp := map[string]interface{}{}
-	provide.Render(resp, 200, c)
-}
-
-func termsOfServiceHandler(c *gin.Context) {
-	resp := map[string]interface{}{}
-	provide.Render(resp, 200, c)
-}
-
 func resolveDIDHandler(c *gin.Context) {
 	did := c.Param("did")
 
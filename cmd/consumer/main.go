@@ -8,17 +8,20 @@ import (
 	"syscall"
 	"time"
 
+	dbconf "github.com/kthomas/go-db-config"
 	"github.com/kthomas/go-pgputil"
 	"github.com/kthomas/go-redisutil"
 	_ "github.com/provideplatform/ident/application" // Application package
 	"github.com/provideplatform/ident/common"
-	_ "github.com/provideplatform/ident/organization" // Organization package
-	_ "github.com/provideplatform/ident/user"         // User package
+	"github.com/provideplatform/ident/organization"
+	"github.com/provideplatform/ident/user"
 	util "github.com/provideplatform/provide-go/common/util"
 )
 
 const natsStreamingSubscriptionStatusTickerInterval = 5 * time.Second
 const natsStreamingSubscriptionStatusSleepInterval = 250 * time.Millisecond
+const userExpirationSweepTickerInterval = time.Minute
+const organizationDeletionSweepTickerInterval = time.Hour
 
 var (
 	cancelF     context.CancelFunc
@@ -47,10 +50,20 @@ func main() {
 	timer := time.NewTicker(natsStreamingSubscriptionStatusTickerInterval)
 	defer timer.Stop()
 
+	expirationTimer := time.NewTicker(userExpirationSweepTickerInterval)
+	defer expirationTimer.Stop()
+
+	deletionTimer := time.NewTicker(organizationDeletionSweepTickerInterval)
+	defer deletionTimer.Stop()
+
 	for !shuttingDown() {
 		select {
 		case <-timer.C:
 			// TODO: check NATS subscription statuses
+		case <-expirationTimer.C:
+			user.SweepExpirations(dbconf.DatabaseConnection())
+		case <-deletionTimer.C:
+			organization.SweepDeletions(dbconf.DatabaseConnection())
 		case sig := <-sigs:
 			common.Log.Infof("received signal: %s", sig)
 			common.Log.Warningf("NATS streaming connection subscriptions are not yet being drained...")
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	"github.com/kthomas/go-redisutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+	util "github.com/provideplatform/provide-go/common/util"
+)
+
+// cachedInvitation is an invitation as previously cached in redis at the
+// application.<id>.invitations and organization.<id>.invitations keys
+type cachedInvitation struct {
+	FirstName   *string            `json:"first_name"`
+	LastName    *string            `json:"last_name"`
+	Email       *string            `json:"email"`
+	Permissions *common.Permission `json:"permissions"`
+}
+
+// importCachedInvitations persists the pending invitations cached in redis prior to the
+// introduction of the invitations table; imported invitations have no token hash and are
+// therefore matched by email address upon acceptance, so an invitation is never imported for an
+// email address which was previously invited, and cached invitations are deleted once imported
+func importCachedInvitations() {
+	if os.Getenv("REDIS_HOSTS") == "" {
+		common.Log.Debug("skipping import of cached invitations; REDIS_HOSTS not configured")
+		return
+	}
+
+	redisutil.RequireRedis()
+	db := dbconf.DatabaseConnection()
+
+	importCachedInvitationsForKind(db, "applications", "application")
+	importCachedInvitationsForKind(db, "organizations", "organization")
+}
+
+func importCachedInvitationsForKind(db *gorm.DB, table, kind string) {
+	var ids []uuid.UUID
+	rows, err := db.Raw(fmt.Sprintf("SELECT id FROM %s", table)).Rows()
+	if err != nil {
+		common.Log.Warningf("failed to resolve %s for import of cached invitations; %s", table, err.Error())
+		return
+	}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		key := fmt.Sprintf("%s.%s.invitations", kind, id.String())
+		raw, err := redisutil.Get(key)
+		if err != nil || raw == nil {
+			continue
+		}
+
+		var invitations []*cachedInvitation
+		err = json.Unmarshal([]byte(*raw), &invitations)
+		if err != nil {
+			common.Log.Warningf("failed to unmarshal cached invitations from key: %s; %s", key, err.Error())
+			continue
+		}
+
+		// imported invitations expire with the cached invitations, or after the default ttl of an
+		// invitation token if the cached invitations do not expire
+		ttl := redisTTL(key)
+		if ttl <= 0 {
+			ttl = util.JWTAuthorizationTTL
+		}
+		expiresAt := time.Now().Add(ttl)
+
+		imported := 0
+		failed := 0
+		for _, invitation := range invitations {
+			if invitation.Email == nil {
+				continue
+			}
+
+			email := strings.ToLower(*invitation.Email)
+			result := db.Exec(
+				fmt.Sprintf(
+					"INSERT INTO invitations (created_at, %s_id, first_name, last_name, email, permissions, status, expires_at) SELECT now(), ?, ?, ?, ?, ?, 'pending', ? WHERE NOT EXISTS (SELECT 1 FROM invitations WHERE %s_id = ? AND lower(email) = ?)",
+					kind,
+					kind,
+				),
+				id,
+				invitation.FirstName,
+				invitation.LastName,
+				email,
+				invitation.Permissions,
+				expiresAt,
+				id,
+				email,
+			)
+			if result.Error != nil {
+				common.Log.Warningf("failed to import cached invitation from key: %s; %s", key, result.Error.Error())
+				failed++
+				continue
+			}
+			imported += int(result.RowsAffected)
+		}
+
+		common.Log.Debugf("imported %d cached invitation(s) from key: %s", imported, key)
+
+		if failed > 0 {
+			common.Log.Warningf("retaining key: %s; failed to import %d cached invitation(s)", key, failed)
+			continue
+		}
+
+		err = redisDel(key)
+		if err != nil {
+			common.Log.Warningf("failed to delete imported cached invitations at key: %s; %s", key, err.Error())
+		}
+	}
+}
+
+// redisTTL returns the remaining ttl of the given key, or zero if the key does not exist or does
+// not expire; go-redisutil does not expose TTL
+func redisTTL(key string) time.Duration {
+	var ttl time.Duration
+	if redisutil.RedisClusterClient != nil {
+		ttl, _ = redisutil.RedisClusterClient.PTTL(key).Result()
+	} else if redisutil.RedisClient != nil {
+		ttl, _ = redisutil.RedisClient.PTTL(key).Result()
+	}
+	if ttl < 0 {
+		return 0
+	}
+	return ttl
+}
+
+// redisDel deletes the given key; go-redisutil does not expose DEL
+func redisDel(key string) error {
+	if redisutil.RedisClusterClient != nil {
+		return redisutil.RedisClusterClient.Del(key).Err()
+	} else if redisutil.RedisClient != nil {
+		return redisutil.RedisClient.Del(key).Err()
+	}
+	return nil
+}
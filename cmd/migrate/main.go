@@ -64,7 +64,10 @@ func main() {
 	err = m.Up()
 	if err != nil && err != migrate.ErrNoChange {
 		common.Log.Warningf("migrations failed 4: %s", err.Error())
+		return
 	}
+
+	importCachedInvitations()
 }
 
 func initIfNotExists(cfg *dbconf.DBConfig, superuser, password string) error {
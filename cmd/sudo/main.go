@@ -10,6 +10,7 @@ import (
 	"github.com/kthomas/go-auth0"
 	dbconf "github.com/kthomas/go-db-config"
 	natsutil "github.com/kthomas/go-natsutil"
+	redisutil "github.com/kthomas/go-redisutil"
 	uuid "github.com/kthomas/go.uuid"
 	"github.com/provideplatform/ident/application"
 	"github.com/provideplatform/ident/common"
@@ -25,8 +26,10 @@ const createSudoerCmd = "createsudoer"
 const createUserCmd = "createuser"
 const deleteUserCmd = "deleteuser"
 const natsPublishCmd = "natspublish"
+const passwordReportCmd = "passwordreport"
 const syncAuth0Cmd = "syncauth0"
 const syncIdentCmd = "syncident"
+const unlockUserCmd = "unlockuser"
 const vendTokenCmd = "vendtoken"
 const vendApplicationTokenCmd = "vendapptoken"
 
@@ -80,10 +83,15 @@ func main() {
 			streaming = argv[3] == "--streaming"
 		}
 		natsPublish(subject, payload, streaming)
+	case passwordReportCmd:
+		passwordReport()
 	case syncAuth0Cmd:
 		syncAuth0()
 	case syncIdentCmd:
 		syncIdent()
+	case unlockUserCmd:
+		email := strings.ToLower(argv[1])
+		unlockUser(email)
 	case vendTokenCmd:
 		email := strings.ToLower(argv[1])
 
@@ -193,6 +201,17 @@ func natsPublish(subject, payload string, streaming bool) {
 	}
 }
 
+func passwordReport() {
+	report, err := user.PasswordHashReport(dbconf.DatabaseConnection())
+	if err != nil {
+		exit(fmt.Sprintf("failed to generate password hash report; %s", err.Error()), 1)
+	}
+
+	for scheme, count := range report {
+		fmt.Printf("%s\t%d\n", scheme, count)
+	}
+}
+
 func syncAuth0() {
 	common.Log.Debugf("attempting to sync auth0 users to ident system of record")
 	err := syncAuth0UsersAndLegacyTokens(dbconf.DatabaseConnection())
@@ -211,6 +230,20 @@ func syncIdent() {
 	common.Log.Debug("ident -> auth0 sync completed successfully")
 }
 
+func unlockUser(email string) {
+	redisutil.RequireRedis()
+
+	usr := user.FindByEmail(email, nil, nil)
+	if usr == nil {
+		exit(fmt.Sprintf("failed to unlock user: %s; user does not exist", email), 1)
+	}
+
+	if !usr.Unlock() {
+		exit(fmt.Sprintf("failed to unlock user: %s; %s", email, *usr.Errors[0].Message), 1)
+	}
+	common.Log.Debugf("unlocked user: %s", email)
+}
+
 func vendToken(email string, ttl *int) {
 	user := user.FindByEmail(email, nil, nil)
 	if user == nil {
@@ -24,6 +24,41 @@ const defaultBannedErrorMessage = "Your IP address has been banned from making A
 const defaultEmailVerificationAttempts = int(4)
 const defaultEmailVerificationTimeout = time.Millisecond * time.Duration(2500)
 
+const defaultEmailVerificationTokenTTL = time.Hour * 24
+const defaultMagicLinkTokenTTL = time.Minute * 15
+const defaultResetPasswordTokenTTL = time.Hour * 1
+
+const defaultSAMLServiceProviderBaseURL = "https://ident.provide.services"
+
+const defaultAuthenticationFailureDelay = time.Millisecond * 250
+const defaultAuthenticationFailureMaxDelay = time.Second * 5
+const defaultAuthenticationFailureWindow = time.Minute * 15
+const defaultAuthenticationLockoutDuration = time.Minute * 15
+const defaultAuthenticationMaxAccountFailures = int64(10)
+const defaultAuthenticationMaxIPFailures = int64(100)
+
+const defaultLDAPGroupAttribute = "memberOf"
+const defaultLDAPTimeout = time.Second * 10
+const defaultLDAPUserAttribute = "mail"
+
+const defaultOrganizationDeletionGracePeriod = time.Hour * 24 * 30
+const defaultOrganizationHierarchyMaxDepth = 8
+
+const defaultMagicLinkMaxRequests = int64(5)
+const defaultMagicLinkRequestWindow = time.Hour
+
+const defaultPasswordHistorySize = 5
+const defaultPasswordMaxLength = 128
+const defaultPasswordMinLength = 8
+
+const defaultArgon2Iterations = uint32(3)
+const defaultArgon2KeyLength = uint32(32)
+const defaultArgon2Memory = uint32(64 * 1024)
+const defaultArgon2Parallelism = uint8(2)
+const defaultArgon2SaltLength = 16
+const defaultBcryptCost = 10
+const defaultPasswordHashAlgorithm = "argon2id"
+
 var (
 	// apiAccountingAddress is the UDP network address to which API call accounting packets will be delivered
 	apiAccountingAddress *net.UDPAddr
@@ -31,6 +66,39 @@ var (
 	// apiAccountingConn is the network connection to which API call accounting packets will be delivered
 	apiAccountingConn *net.UDPConn
 
+	// Argon2Iterations is the number of passes over memory used when hashing passwords using argon2id
+	Argon2Iterations uint32
+
+	// Argon2KeyLength is the length in bytes of the derived key when hashing passwords using argon2id
+	Argon2KeyLength uint32
+
+	// Argon2Memory is the amount of memory in KiB used when hashing passwords using argon2id
+	Argon2Memory uint32
+
+	// Argon2Parallelism is the number of threads used when hashing passwords using argon2id
+	Argon2Parallelism uint8
+
+	// Argon2SaltLength is the length in bytes of the random salt used when hashing passwords using argon2id
+	Argon2SaltLength int
+
+	// AuthenticationFailureDelay is the base duration for which authentication attempts for an account are throttled after a failed attempt; the duration doubles with each subsequent failure
+	AuthenticationFailureDelay time.Duration
+
+	// AuthenticationFailureMaxDelay is the maximum duration for which authentication attempts for an account are throttled after a failed attempt
+	AuthenticationFailureMaxDelay time.Duration
+
+	// AuthenticationFailureWindow is the sliding window within which failed authentication attempts are counted
+	AuthenticationFailureWindow time.Duration
+
+	// AuthenticationLockoutDuration is the duration for which an account or IP address is locked out after too many failed authentication attempts
+	AuthenticationLockoutDuration time.Duration
+
+	// AuthenticationMaxAccountFailures is the number of failed authentication attempts for a single account after which the account is locked out
+	AuthenticationMaxAccountFailures int64
+
+	// AuthenticationMaxIPFailures is the number of failed authentication attempts from a single IP address after which the IP address is locked out
+	AuthenticationMaxIPFailures int64
+
 	// Auth0IntegrationEnabled is a flag that indicates if the configured Auth0 integration should be used; this is a temporary config item and will be removed in the future
 	Auth0IntegrationEnabled bool
 
@@ -64,26 +132,117 @@ var (
 	// EmailVerificationTimeout is the timeout upon which deliverability verification will fail
 	EmailVerificationTimeout time.Duration
 
+	// EmailVerificationTokenTTL is the duration for which an email address verification token remains valid after it has been issued
+	EmailVerificationTokenTTL time.Duration
+
 	// JWTKeypairs holds a reference to the configured keypairs
 	JWTKeypairs map[string]*util.JWTKeypair
 
+	// LDAPBaseDN is the base DN beneath which users are searched in the configured directory
+	LDAPBaseDN string
+
+	// LDAPBindDN is the DN of the service account used to search the configured directory; the search is anonymous when not configured
+	LDAPBindDN *string
+
+	// LDAPBindPassword is the password of the service account used to search the configured directory
+	LDAPBindPassword *string
+
+	// LDAPGroupAttribute is the user attribute which lists the DNs of the groups of which the user is a member
+	LDAPGroupAttribute string
+
+	// LDAPGroupOrganizations maps directory group DNs to the ids of the organizations of which group members are members
+	LDAPGroupOrganizations map[string]string
+
+	// LDAPGroupPermissions maps directory group DNs to the permissions granted to group members
+	LDAPGroupPermissions map[string]Permission
+
+	// LDAPTimeout is the timeout applied to each connection to the configured directory
+	LDAPTimeout time.Duration
+
+	// LDAPURL is the ldap:// or ldaps:// url of the directory against which platform users authenticate; ldap:// connections must be upgraded using StartTLS; LDAP authentication is disabled when not configured
+	LDAPURL *string
+
+	// LDAPUserAttribute is the user attribute matched against the email address given upon authentication
+	LDAPUserAttribute string
+
 	// Log is the configured logger
 	Log *logger.Logger
 
+	// MagicLinkMaxRequests is the number of magic links which may be requested for an address within the request window
+	MagicLinkMaxRequests int64
+
+	// MagicLinkRequestWindow is the duration within which magic link requests for an address are rate limited
+	MagicLinkRequestWindow time.Duration
+
+	// MagicLinkTokenTTL is the duration for which a magic link login token remains valid after it has been issued
+	MagicLinkTokenTTL time.Duration
+
+	// BcryptCost is the cost used when hashing passwords using bcrypt
+	BcryptCost int
+
+	// BreachedPasswordsPath is the path to a local breached password hash list; this is either a directory of
+	// HIBP-style k-anonymity range files (named by 5-character SHA-1 prefix) or a single file of SHA-1 hashes
+	BreachedPasswordsPath *string
+
+	// PasswordHashAlgorithm is the algorithm used to hash passwords; one of argon2id or bcrypt
+	PasswordHashAlgorithm string
+
+	// PasswordHistorySize is the number of previous passwords which may not be reused
+	PasswordHistorySize int
+
+	// PasswordMaxLength is the maximum password length
+	PasswordMaxLength int
+
+	// PasswordMinLength is the minimum password length
+	PasswordMinLength int
+
+	// PasswordRequireDigit flag indicates if passwords must contain at least one digit
+	PasswordRequireDigit bool
+
+	// PasswordRequireLowercase flag indicates if passwords must contain at least one lowercase letter
+	PasswordRequireLowercase bool
+
+	// PasswordRequireSymbol flag indicates if passwords must contain at least one symbol
+	PasswordRequireSymbol bool
+
+	// PasswordRequireUppercase flag indicates if passwords must contain at least one uppercase letter
+	PasswordRequireUppercase bool
+
 	// PerformEmailVerification flag indicates if email deliverability should be verified when creating new users
 	PerformEmailVerification bool
 
 	// OpenIDConfiguration is the openid configuration JSON which is served from .well-known/openid-configuration
 	OpenIDConfiguration map[string]interface{}
+
+	// OrganizationDeletionGracePeriod is the duration for which a deleted organization is retained, suspended, before it is permanently deleted
+	OrganizationDeletionGracePeriod time.Duration
+
+	// OrganizationHierarchyMaxDepth is the maximum number of organizations from the root of an organization hierarchy to any of its descendants
+	OrganizationHierarchyMaxDepth int
+
+	// ResetPasswordTokenTTL is the duration for which a reset password token remains valid after it has been issued
+	ResetPasswordTokenTTL time.Duration
+
+	// SAMLServiceProviderBaseURL is the public base url of the ident API, from which the SAML service provider entity id and assertion consumer service url of each organization are derived
+	SAMLServiceProviderBaseURL string
 )
 
 func init() {
 	godotenv.Load()
 
 	requireLogger()
+	requireAuthenticationLockout()
 	requireEmailVerification()
 	requireIPLists()
+	requireLDAP()
+	requireMagicLink()
 	requireOpenIDConfiguration()
+	requireOrganizationDeletionGracePeriod()
+	requireOrganizationHierarchy()
+	requirePasswordHashing()
+	requirePasswordPolicy()
+	requireResetPasswordTokenTTL()
+	requireSAMLServiceProvider()
 
 	Auth0IntegrationEnabled = strings.ToLower(os.Getenv("AUTH0_INTEGRATION_ENABLED")) == "true"
 	Auth0IntegrationCustomDatabase = strings.ToLower(os.Getenv("AUTH0_INTEGRATION_CUSTOM_DATABASE")) == "true"
@@ -150,6 +309,15 @@ func requireEmailVerification() {
 		EmailVerificationFromAddress = os.Getenv("EMAIL_VERIFICATION_FROM_ADDRESS")
 	}
 	PerformEmailVerification = EmailVerificationFromDomain != "" && EmailVerificationFromAddress != ""
+	if os.Getenv("EMAIL_VERIFICATION_TOKEN_TTL") != "" {
+		ttl, err := strconv.Atoi(os.Getenv("EMAIL_VERIFICATION_TOKEN_TTL"))
+		if err != nil {
+			log.Panicf("failed to parse EMAIL_VERIFICATION_TOKEN_TTL from environment; %s", err.Error())
+		}
+		EmailVerificationTokenTTL = time.Second * time.Duration(ttl)
+	} else {
+		EmailVerificationTokenTTL = defaultEmailVerificationTokenTTL
+	}
 }
 
 func requireLogger() {
@@ -197,6 +365,302 @@ func requireIPLists() {
 	BannedIPs = []string{}
 }
 
+func requireAuthenticationLockout() {
+	if os.Getenv("AUTHENTICATION_FAILURE_DELAY_MILLIS") != "" {
+		delayMillis, err := strconv.Atoi(os.Getenv("AUTHENTICATION_FAILURE_DELAY_MILLIS"))
+		if err != nil {
+			log.Panicf("failed to parse AUTHENTICATION_FAILURE_DELAY_MILLIS from environment; %s", err.Error())
+		}
+		AuthenticationFailureDelay = time.Millisecond * time.Duration(delayMillis)
+	} else {
+		AuthenticationFailureDelay = defaultAuthenticationFailureDelay
+	}
+	if os.Getenv("AUTHENTICATION_FAILURE_MAX_DELAY_MILLIS") != "" {
+		delayMillis, err := strconv.Atoi(os.Getenv("AUTHENTICATION_FAILURE_MAX_DELAY_MILLIS"))
+		if err != nil {
+			log.Panicf("failed to parse AUTHENTICATION_FAILURE_MAX_DELAY_MILLIS from environment; %s", err.Error())
+		}
+		AuthenticationFailureMaxDelay = time.Millisecond * time.Duration(delayMillis)
+	} else {
+		AuthenticationFailureMaxDelay = defaultAuthenticationFailureMaxDelay
+	}
+	if os.Getenv("AUTHENTICATION_FAILURE_WINDOW") != "" {
+		window, err := strconv.Atoi(os.Getenv("AUTHENTICATION_FAILURE_WINDOW"))
+		if err != nil {
+			log.Panicf("failed to parse AUTHENTICATION_FAILURE_WINDOW from environment; %s", err.Error())
+		}
+		AuthenticationFailureWindow = time.Second * time.Duration(window)
+	} else {
+		AuthenticationFailureWindow = defaultAuthenticationFailureWindow
+	}
+	if os.Getenv("AUTHENTICATION_LOCKOUT_DURATION") != "" {
+		duration, err := strconv.Atoi(os.Getenv("AUTHENTICATION_LOCKOUT_DURATION"))
+		if err != nil {
+			log.Panicf("failed to parse AUTHENTICATION_LOCKOUT_DURATION from environment; %s", err.Error())
+		}
+		AuthenticationLockoutDuration = time.Second * time.Duration(duration)
+	} else {
+		AuthenticationLockoutDuration = defaultAuthenticationLockoutDuration
+	}
+	if os.Getenv("AUTHENTICATION_MAX_ACCOUNT_FAILURES") != "" {
+		maxFailures, err := strconv.ParseInt(os.Getenv("AUTHENTICATION_MAX_ACCOUNT_FAILURES"), 10, 64)
+		if err != nil {
+			log.Panicf("failed to parse AUTHENTICATION_MAX_ACCOUNT_FAILURES from environment; %s", err.Error())
+		}
+		AuthenticationMaxAccountFailures = maxFailures
+	} else {
+		AuthenticationMaxAccountFailures = defaultAuthenticationMaxAccountFailures
+	}
+	if os.Getenv("AUTHENTICATION_MAX_IP_FAILURES") != "" {
+		maxFailures, err := strconv.ParseInt(os.Getenv("AUTHENTICATION_MAX_IP_FAILURES"), 10, 64)
+		if err != nil {
+			log.Panicf("failed to parse AUTHENTICATION_MAX_IP_FAILURES from environment; %s", err.Error())
+		}
+		AuthenticationMaxIPFailures = maxFailures
+	} else {
+		AuthenticationMaxIPFailures = defaultAuthenticationMaxIPFailures
+	}
+}
+
+func requirePasswordHashing() {
+	if os.Getenv("PASSWORD_HASH_ALGORITHM") != "" {
+		PasswordHashAlgorithm = strings.ToLower(os.Getenv("PASSWORD_HASH_ALGORITHM"))
+		if PasswordHashAlgorithm != "argon2id" && PasswordHashAlgorithm != "bcrypt" {
+			log.Panicf("failed to parse PASSWORD_HASH_ALGORITHM from environment; unsupported algorithm: %s", PasswordHashAlgorithm)
+		}
+	} else {
+		PasswordHashAlgorithm = defaultPasswordHashAlgorithm
+	}
+	if os.Getenv("ARGON2_ITERATIONS") != "" {
+		iterations, err := strconv.ParseUint(os.Getenv("ARGON2_ITERATIONS"), 10, 32)
+		if err != nil {
+			log.Panicf("failed to parse ARGON2_ITERATIONS from environment; %s", err.Error())
+		}
+		Argon2Iterations = uint32(iterations)
+	} else {
+		Argon2Iterations = defaultArgon2Iterations
+	}
+	if os.Getenv("ARGON2_KEY_LENGTH") != "" {
+		keyLength, err := strconv.ParseUint(os.Getenv("ARGON2_KEY_LENGTH"), 10, 32)
+		if err != nil {
+			log.Panicf("failed to parse ARGON2_KEY_LENGTH from environment; %s", err.Error())
+		}
+		Argon2KeyLength = uint32(keyLength)
+	} else {
+		Argon2KeyLength = defaultArgon2KeyLength
+	}
+	if os.Getenv("ARGON2_MEMORY") != "" {
+		memory, err := strconv.ParseUint(os.Getenv("ARGON2_MEMORY"), 10, 32)
+		if err != nil {
+			log.Panicf("failed to parse ARGON2_MEMORY from environment; %s", err.Error())
+		}
+		Argon2Memory = uint32(memory)
+	} else {
+		Argon2Memory = defaultArgon2Memory
+	}
+	if os.Getenv("ARGON2_PARALLELISM") != "" {
+		parallelism, err := strconv.ParseUint(os.Getenv("ARGON2_PARALLELISM"), 10, 8)
+		if err != nil {
+			log.Panicf("failed to parse ARGON2_PARALLELISM from environment; %s", err.Error())
+		}
+		Argon2Parallelism = uint8(parallelism)
+	} else {
+		Argon2Parallelism = defaultArgon2Parallelism
+	}
+	if os.Getenv("ARGON2_SALT_LENGTH") != "" {
+		saltLength, err := strconv.Atoi(os.Getenv("ARGON2_SALT_LENGTH"))
+		if err != nil {
+			log.Panicf("failed to parse ARGON2_SALT_LENGTH from environment; %s", err.Error())
+		}
+		Argon2SaltLength = saltLength
+	} else {
+		Argon2SaltLength = defaultArgon2SaltLength
+	}
+	if os.Getenv("BCRYPT_COST") != "" {
+		cost, err := strconv.Atoi(os.Getenv("BCRYPT_COST"))
+		if err != nil {
+			log.Panicf("failed to parse BCRYPT_COST from environment; %s", err.Error())
+		}
+		BcryptCost = cost
+	} else {
+		BcryptCost = defaultBcryptCost
+	}
+}
+
+func requirePasswordPolicy() {
+	if os.Getenv("PASSWORD_MIN_LENGTH") != "" {
+		minLength, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH"))
+		if err != nil {
+			log.Panicf("failed to parse PASSWORD_MIN_LENGTH from environment; %s", err.Error())
+		}
+		PasswordMinLength = minLength
+	} else {
+		PasswordMinLength = defaultPasswordMinLength
+	}
+	if os.Getenv("PASSWORD_MAX_LENGTH") != "" {
+		maxLength, err := strconv.Atoi(os.Getenv("PASSWORD_MAX_LENGTH"))
+		if err != nil {
+			log.Panicf("failed to parse PASSWORD_MAX_LENGTH from environment; %s", err.Error())
+		}
+		PasswordMaxLength = maxLength
+	} else {
+		PasswordMaxLength = defaultPasswordMaxLength
+	}
+	if os.Getenv("PASSWORD_HISTORY_SIZE") != "" {
+		historySize, err := strconv.Atoi(os.Getenv("PASSWORD_HISTORY_SIZE"))
+		if err != nil {
+			log.Panicf("failed to parse PASSWORD_HISTORY_SIZE from environment; %s", err.Error())
+		}
+		PasswordHistorySize = historySize
+	} else {
+		PasswordHistorySize = defaultPasswordHistorySize
+	}
+	PasswordRequireDigit = strings.ToLower(os.Getenv("PASSWORD_REQUIRE_DIGIT")) == "true"
+	PasswordRequireLowercase = strings.ToLower(os.Getenv("PASSWORD_REQUIRE_LOWERCASE")) == "true"
+	PasswordRequireSymbol = strings.ToLower(os.Getenv("PASSWORD_REQUIRE_SYMBOL")) == "true"
+	PasswordRequireUppercase = strings.ToLower(os.Getenv("PASSWORD_REQUIRE_UPPERCASE")) == "true"
+	if os.Getenv("BREACHED_PASSWORDS_PATH") != "" {
+		BreachedPasswordsPath = StringOrNil(os.Getenv("BREACHED_PASSWORDS_PATH"))
+	}
+}
+
+func requireLDAP() {
+	if os.Getenv("LDAP_URL") == "" {
+		return
+	}
+
+	LDAPURL = StringOrNil(os.Getenv("LDAP_URL"))
+	LDAPBaseDN = os.Getenv("LDAP_BASE_DN")
+	if LDAPBaseDN == "" {
+		log.Panicf("failed to configure LDAP authentication; LDAP_BASE_DN is required")
+	}
+
+	LDAPBindDN = StringOrNil(os.Getenv("LDAP_BIND_DN"))
+	LDAPBindPassword = StringOrNil(os.Getenv("LDAP_BIND_PASSWORD"))
+
+	if os.Getenv("LDAP_USER_ATTRIBUTE") != "" {
+		LDAPUserAttribute = os.Getenv("LDAP_USER_ATTRIBUTE")
+	} else {
+		LDAPUserAttribute = defaultLDAPUserAttribute
+	}
+
+	if os.Getenv("LDAP_GROUP_ATTRIBUTE") != "" {
+		LDAPGroupAttribute = os.Getenv("LDAP_GROUP_ATTRIBUTE")
+	} else {
+		LDAPGroupAttribute = defaultLDAPGroupAttribute
+	}
+
+	LDAPGroupPermissions = map[string]Permission{}
+	if os.Getenv("LDAP_GROUP_PERMISSIONS") != "" {
+		groupPermissions := map[string]Permission{}
+		err := json.Unmarshal([]byte(os.Getenv("LDAP_GROUP_PERMISSIONS")), &groupPermissions)
+		if err != nil {
+			log.Panicf("failed to parse LDAP_GROUP_PERMISSIONS from environment; %s", err.Error())
+		}
+		for dn, permissions := range groupPermissions {
+			LDAPGroupPermissions[NormalizeDN(dn)] = permissions
+		}
+	}
+
+	LDAPGroupOrganizations = map[string]string{}
+	if os.Getenv("LDAP_GROUP_ORGANIZATIONS") != "" {
+		groupOrganizations := map[string]string{}
+		err := json.Unmarshal([]byte(os.Getenv("LDAP_GROUP_ORGANIZATIONS")), &groupOrganizations)
+		if err != nil {
+			log.Panicf("failed to parse LDAP_GROUP_ORGANIZATIONS from environment; %s", err.Error())
+		}
+		for dn, organizationID := range groupOrganizations {
+			LDAPGroupOrganizations[NormalizeDN(dn)] = organizationID
+		}
+	}
+
+	if os.Getenv("LDAP_TIMEOUT") != "" {
+		timeout, err := strconv.Atoi(os.Getenv("LDAP_TIMEOUT"))
+		if err != nil {
+			log.Panicf("failed to parse LDAP_TIMEOUT from environment; %s", err.Error())
+		}
+		LDAPTimeout = time.Second * time.Duration(timeout)
+	} else {
+		LDAPTimeout = defaultLDAPTimeout
+	}
+}
+
+func requireMagicLink() {
+	if os.Getenv("MAGIC_LINK_TOKEN_TTL") != "" {
+		ttl, err := strconv.Atoi(os.Getenv("MAGIC_LINK_TOKEN_TTL"))
+		if err != nil {
+			log.Panicf("failed to parse MAGIC_LINK_TOKEN_TTL from environment; %s", err.Error())
+		}
+		MagicLinkTokenTTL = time.Second * time.Duration(ttl)
+	} else {
+		MagicLinkTokenTTL = defaultMagicLinkTokenTTL
+	}
+
+	if os.Getenv("MAGIC_LINK_MAX_REQUESTS") != "" {
+		maxRequests, err := strconv.ParseInt(os.Getenv("MAGIC_LINK_MAX_REQUESTS"), 10, 64)
+		if err != nil {
+			log.Panicf("failed to parse MAGIC_LINK_MAX_REQUESTS from environment; %s", err.Error())
+		}
+		MagicLinkMaxRequests = maxRequests
+	} else {
+		MagicLinkMaxRequests = defaultMagicLinkMaxRequests
+	}
+
+	if os.Getenv("MAGIC_LINK_REQUEST_WINDOW") != "" {
+		window, err := strconv.Atoi(os.Getenv("MAGIC_LINK_REQUEST_WINDOW"))
+		if err != nil {
+			log.Panicf("failed to parse MAGIC_LINK_REQUEST_WINDOW from environment; %s", err.Error())
+		}
+		MagicLinkRequestWindow = time.Second * time.Duration(window)
+	} else {
+		MagicLinkRequestWindow = defaultMagicLinkRequestWindow
+	}
+}
+
+func requireOrganizationDeletionGracePeriod() {
+	if os.Getenv("ORGANIZATION_DELETION_GRACE_PERIOD") != "" {
+		gracePeriod, err := strconv.Atoi(os.Getenv("ORGANIZATION_DELETION_GRACE_PERIOD"))
+		if err != nil {
+			log.Panicf("failed to parse ORGANIZATION_DELETION_GRACE_PERIOD from environment; %s", err.Error())
+		}
+		OrganizationDeletionGracePeriod = time.Second * time.Duration(gracePeriod)
+	} else {
+		OrganizationDeletionGracePeriod = defaultOrganizationDeletionGracePeriod
+	}
+}
+
+func requireOrganizationHierarchy() {
+	if os.Getenv("ORGANIZATION_HIERARCHY_MAX_DEPTH") != "" {
+		maxDepth, err := strconv.Atoi(os.Getenv("ORGANIZATION_HIERARCHY_MAX_DEPTH"))
+		if err != nil || maxDepth < 1 {
+			log.Panicf("failed to parse ORGANIZATION_HIERARCHY_MAX_DEPTH from environment; must be a positive integer")
+		}
+		OrganizationHierarchyMaxDepth = maxDepth
+	} else {
+		OrganizationHierarchyMaxDepth = defaultOrganizationHierarchyMaxDepth
+	}
+}
+
+func requireResetPasswordTokenTTL() {
+	if os.Getenv("RESET_PASSWORD_TOKEN_TTL") != "" {
+		ttl, err := strconv.Atoi(os.Getenv("RESET_PASSWORD_TOKEN_TTL"))
+		if err != nil {
+			log.Panicf("failed to parse RESET_PASSWORD_TOKEN_TTL from environment; %s", err.Error())
+		}
+		ResetPasswordTokenTTL = time.Second * time.Duration(ttl)
+	} else {
+		ResetPasswordTokenTTL = defaultResetPasswordTokenTTL
+	}
+}
+
+func requireSAMLServiceProvider() {
+	if os.Getenv("SAML_SERVICE_PROVIDER_BASE_URL") != "" {
+		SAMLServiceProviderBaseURL = strings.TrimRight(os.Getenv("SAML_SERVICE_PROVIDER_BASE_URL"), "/")
+	} else {
+		SAMLServiceProviderBaseURL = defaultSAMLServiceProviderBaseURL
+	}
+}
+
 func requireOpenIDConfiguration() {
 	openIDConfigURL := os.Getenv("OPENID_CONFIGURATION_URL")
 	if openIDConfigURL != "" {
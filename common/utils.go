@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"math/rand"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -92,3 +93,39 @@ func SHA256(str string) string {
 	digest.Write([]byte(str))
 	return hex.EncodeToString(digest.Sum(nil))
 }
+
+// NormalizeDN returns the given LDAP distinguished name in a form suitable for comparison;
+// attribute types and values are lowercased and whitespace surrounding separators is removed
+func NormalizeDN(dn string) string {
+	rdns := make([]string, 0)
+	var rdn strings.Builder
+	escaped := false
+	for _, c := range dn {
+		if escaped {
+			rdn.WriteRune(c)
+			escaped = false
+			continue
+		}
+		switch c {
+		case '\\':
+			rdn.WriteRune(c)
+			escaped = true
+		case ',', ';':
+			rdns = append(rdns, rdn.String())
+			rdn.Reset()
+		default:
+			rdn.WriteRune(c)
+		}
+	}
+	rdns = append(rdns, rdn.String())
+
+	for i, rdn := range rdns {
+		parts := strings.SplitN(rdn, "=", 2)
+		for j := range parts {
+			parts[j] = strings.TrimSpace(parts[j])
+		}
+		rdns[i] = strings.Join(parts, "=")
+	}
+
+	return strings.ToLower(strings.Join(rdns, ","))
+}
@@ -0,0 +1,142 @@
+package legal
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	dbconf "github.com/kthomas/go-db-config"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+	"github.com/provideplatform/ident/token"
+	provide "github.com/provideplatform/provide-go/common"
+)
+
+// InstallPublicLegalAPI installs handlers using the given gin Engine which serve the
+// published legal documents without API authorization
+func InstallPublicLegalAPI(r *gin.Engine) {
+	r.GET("/legal/privacy_policy", latestDocumentHandler(DocumentTypePrivacyPolicy))
+	r.GET("/legal/terms_of_service", latestDocumentHandler(DocumentTypeTermsOfService))
+}
+
+// InstallLegalAPI installs handlers using the given gin Engine which require API authorization
+func InstallLegalAPI(r *gin.Engine) {
+	r.GET("/api/v1/legal/documents", documentsListHandler)
+	r.POST("/api/v1/legal/documents", createDocumentHandler)
+	r.GET("/api/v1/legal/documents/:id", documentDetailsHandler)
+	r.POST("/api/v1/legal/documents/:id/publish", publishDocumentHandler)
+}
+
+func latestDocumentHandler(documentType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		document := Latest(dbconf.DatabaseConnection(), documentType)
+		if document == nil {
+			provide.RenderError(fmt.Sprintf("%s has not been published", documentType), 404, c)
+			return
+		}
+
+		provide.Render(document, 200, c)
+	}
+}
+
+func documentsListHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	if bearer == nil {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	query := dbconf.DatabaseConnection()
+	if !bearer.HasPermission(common.Sudo) {
+		query = query.Where("published_at IS NOT NULL")
+	}
+
+	if c.Query("type") != "" {
+		query = query.Where("type = ?", c.Query("type"))
+	}
+
+	var documents []*Document
+	query = query.Order("created_at DESC")
+	provide.Paginate(c, query, &Document{}).Find(&documents)
+	provide.Render(documents, 200, c)
+}
+
+func documentDetailsHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	if bearer == nil {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	documentID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError("legal document not found", 404, c)
+		return
+	}
+
+	document := FindDocument(dbconf.DatabaseConnection(), documentID)
+	if document == nil || (document.PublishedAt == nil && !bearer.HasPermission(common.Sudo)) {
+		provide.RenderError("legal document not found", 404, c)
+		return
+	}
+
+	provide.Render(document, 200, c)
+}
+
+func createDocumentHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	if bearer == nil || !bearer.HasPermission(common.Sudo) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	document := &Document{}
+	err = json.Unmarshal(buf, &document)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	if document.Create(dbconf.DatabaseConnection()) {
+		provide.Render(document, 201, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = document.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+func publishDocumentHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	if bearer == nil || !bearer.HasPermission(common.Sudo) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	documentID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError("legal document not found", 404, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+	document := FindDocument(db, documentID)
+	if document == nil {
+		provide.RenderError("legal document not found", 404, c)
+		return
+	}
+
+	if document.Publish(db) {
+		provide.Render(document, 200, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = document.Errors
+		provide.Render(obj, 422, c)
+	}
+}
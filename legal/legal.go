@@ -0,0 +1,235 @@
+package legal
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+// DocumentTypePrivacyPolicy is the type of privacy policy documents
+const DocumentTypePrivacyPolicy = "privacy_policy"
+
+// DocumentTypeTermsOfService is the type of terms of service documents
+const DocumentTypeTermsOfService = "terms_of_service"
+
+// DocumentTypes are the types of legal documents to which users must consent
+var DocumentTypes = []string{
+	DocumentTypePrivacyPolicy,
+	DocumentTypeTermsOfService,
+}
+
+// Document is a version of a legal document; a document is immutable once it has been
+// published, and publishing a new version requires all users to consent to it
+type Document struct {
+	provide.Model
+	Type        *string    `sql:"not null" json:"type"`
+	Version     *string    `sql:"not null" json:"version"`
+	Title       *string    `json:"title,omitempty"`
+	Content     *string    `json:"content,omitempty"`
+	URL         *string    `json:"url,omitempty"`
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+}
+
+// Consent records the acceptance of a published legal document by a user
+type Consent struct {
+	provide.Model
+	UserID          *uuid.UUID `sql:"not null;type:uuid" json:"user_id"`
+	DocumentID      *uuid.UUID `sql:"not null;type:uuid" json:"document_id"`
+	DocumentType    *string    `sql:"not null" json:"document_type"`
+	DocumentVersion *string    `sql:"not null" json:"document_version"`
+	IPAddress       *string    `json:"ip_address,omitempty"`
+	UserAgent       *string    `json:"user_agent,omitempty"`
+}
+
+// TableName returns the db table name for gorm
+func (d *Document) TableName() string {
+	return "legal_documents"
+}
+
+// TableName returns the db table name for gorm
+func (c *Consent) TableName() string {
+	return "legal_consents"
+}
+
+// FindDocument returns the legal document with the given id
+func FindDocument(db *gorm.DB, documentID uuid.UUID) *Document {
+	document := &Document{}
+	db.Where("id = ?", documentID).Find(&document)
+	if document.ID == uuid.Nil {
+		return nil
+	}
+	return document
+}
+
+// Latest returns the most recently published version of the legal document of the given type
+func Latest(db *gorm.DB, documentType string) *Document {
+	document := &Document{}
+	db.Where("type = ? AND published_at IS NOT NULL", documentType).Order("published_at DESC").First(&document)
+	if document.ID == uuid.Nil {
+		return nil
+	}
+	return document
+}
+
+// PendingDocuments returns the most recently published versions of the legal documents to
+// which the user with the given id has not yet consented
+func PendingDocuments(db *gorm.DB, userID uuid.UUID) []*Document {
+	pending := make([]*Document, 0)
+	for _, documentType := range DocumentTypes {
+		document := Latest(db, documentType)
+		if document == nil {
+			continue
+		}
+
+		var consents int
+		db.Model(&Consent{}).Where("user_id = ? AND document_id = ?", userID, document.ID).Count(&consents)
+		if consents == 0 {
+			pending = append(pending, document)
+		}
+	}
+	return pending
+}
+
+// Accept records the consent of the user with the given id to the given published legal
+// documents; consenting to a document more than once is a no-op
+func Accept(tx *gorm.DB, userID uuid.UUID, documentIDs []uuid.UUID, ipAddress, userAgent *string) ([]*Consent, error) {
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+	}
+
+	consents := make([]*Consent, 0)
+	for _, documentID := range documentIDs {
+		document := FindDocument(db, documentID)
+		if document == nil || document.PublishedAt == nil {
+			return nil, fmt.Errorf("legal document not found: %s", documentID)
+		}
+
+		existing := &Consent{}
+		db.Where("user_id = ? AND document_id = ?", userID, document.ID).Find(&existing)
+		if existing.ID != uuid.Nil {
+			consents = append(consents, existing)
+			continue
+		}
+
+		consent := &Consent{
+			UserID:          &userID,
+			DocumentID:      &document.ID,
+			DocumentType:    document.Type,
+			DocumentVersion: document.Version,
+			IPAddress:       ipAddress,
+			UserAgent:       userAgent,
+		}
+
+		result := db.Create(&consent)
+		if result.Error != nil {
+			return nil, fmt.Errorf("failed to record consent of user %s to legal document: %s; %s", userID, document.ID, result.Error.Error())
+		}
+
+		common.Log.Debugf("user %s consented to %s version %s", userID, *document.Type, *document.Version)
+		consents = append(consents, consent)
+	}
+
+	return consents, nil
+}
+
+// Create and persist an unpublished legal document
+func (d *Document) Create(tx *gorm.DB) bool {
+	if !d.validate() {
+		return false
+	}
+
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+	}
+
+	d.PublishedAt = nil
+
+	if db.NewRecord(d) {
+		result := db.Create(&d)
+		rowsAffected := result.RowsAffected
+		errors := result.GetErrors()
+		if len(errors) > 0 {
+			for _, err := range errors {
+				d.Errors = append(d.Errors, &provide.Error{
+					Message: common.StringOrNil(err.Error()),
+				})
+			}
+		}
+		return rowsAffected > 0 && len(d.Errors) == 0
+	}
+
+	return false
+}
+
+// Publish the legal document; users are required to consent to the published
+// document upon their next authentication
+func (d *Document) Publish(tx *gorm.DB) bool {
+	d.Errors = make([]*provide.Error, 0)
+
+	if d.PublishedAt != nil {
+		d.Errors = append(d.Errors, &provide.Error{
+			Message: common.StringOrNil("legal document has already been published"),
+		})
+		return false
+	}
+
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+	}
+
+	publishedAt := time.Now()
+	result := db.Model(d).Update("published_at", publishedAt)
+	if result.Error != nil {
+		d.Errors = append(d.Errors, &provide.Error{
+			Message: common.StringOrNil(result.Error.Error()),
+		})
+		return false
+	}
+
+	d.PublishedAt = &publishedAt
+	common.Log.Debugf("published %s version %s", *d.Type, *d.Version)
+	return true
+}
+
+// validate the legal document
+func (d *Document) validate() bool {
+	d.Errors = make([]*provide.Error, 0)
+
+	validType := false
+	for _, documentType := range DocumentTypes {
+		validType = validType || (d.Type != nil && *d.Type == documentType)
+	}
+	if !validType {
+		d.Errors = append(d.Errors, &provide.Error{
+			Message: common.StringOrNil("legal document type must be one of privacy_policy or terms_of_service"),
+		})
+	}
+
+	if d.Version == nil || *d.Version == "" {
+		d.Errors = append(d.Errors, &provide.Error{
+			Message: common.StringOrNil("legal document requires a version"),
+		})
+	}
+
+	if (d.Content == nil || *d.Content == "") && (d.URL == nil || *d.URL == "") {
+		d.Errors = append(d.Errors, &provide.Error{
+			Message: common.StringOrNil("legal document requires content or a url"),
+		})
+	}
+
+	return len(d.Errors) == 0
+}
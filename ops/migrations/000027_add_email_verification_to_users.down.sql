@@ -0,0 +1,2 @@
+ALTER TABLE ONLY users DROP COLUMN pending_email;
+ALTER TABLE ONLY users DROP COLUMN email_verified_at;
@@ -0,0 +1,2 @@
+ALTER TABLE ONLY users ADD COLUMN email_verified_at timestamp with time zone;
+ALTER TABLE ONLY users ADD COLUMN pending_email text;
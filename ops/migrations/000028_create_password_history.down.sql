@@ -0,0 +1,4 @@
+DROP INDEX idx_password_history_user_id_created_at;
+ALTER TABLE ONLY password_history DROP CONSTRAINT password_history_user_id_users_id_foreign;
+
+DROP TABLE password_history;
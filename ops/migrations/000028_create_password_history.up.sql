@@ -0,0 +1,11 @@
+CREATE TABLE password_history (
+    id uuid DEFAULT uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    user_id uuid NOT NULL,
+    password text NOT NULL
+);
+
+ALTER TABLE ONLY password_history ADD CONSTRAINT password_history_pkey PRIMARY KEY (id);
+
+CREATE INDEX idx_password_history_user_id_created_at ON password_history USING btree (user_id, created_at);
+ALTER TABLE ONLY password_history ADD CONSTRAINT password_history_user_id_users_id_foreign FOREIGN KEY (user_id) REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE;
@@ -0,0 +1,5 @@
+DROP INDEX idx_sessions_user_id_revoked_at;
+ALTER TABLE ONLY sessions DROP CONSTRAINT sessions_application_id_applications_id_foreign;
+ALTER TABLE ONLY sessions DROP CONSTRAINT sessions_user_id_users_id_foreign;
+
+DROP TABLE sessions;
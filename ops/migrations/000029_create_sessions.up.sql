@@ -0,0 +1,19 @@
+CREATE TABLE sessions (
+    id uuid DEFAULT uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    user_id uuid NOT NULL,
+    application_id uuid,
+    user_agent text,
+    ip_address text,
+    refreshed_at timestamp with time zone,
+    expires_at timestamp with time zone,
+    revoked_at timestamp with time zone,
+    refresh_token_family uuid,
+    refresh_token_hash text
+);
+
+ALTER TABLE ONLY sessions ADD CONSTRAINT sessions_pkey PRIMARY KEY (id);
+
+CREATE INDEX idx_sessions_user_id_revoked_at ON sessions USING btree (user_id, revoked_at);
+ALTER TABLE ONLY sessions ADD CONSTRAINT sessions_user_id_users_id_foreign FOREIGN KEY (user_id) REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY sessions ADD CONSTRAINT sessions_application_id_applications_id_foreign FOREIGN KEY (application_id) REFERENCES applications(id) ON UPDATE CASCADE ON DELETE CASCADE;
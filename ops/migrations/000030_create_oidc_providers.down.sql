@@ -0,0 +1,13 @@
+DROP INDEX idx_user_identities_user_id;
+DROP INDEX idx_user_identities_provider_id_subject;
+ALTER TABLE ONLY user_identities DROP CONSTRAINT user_identities_provider_id_oidc_providers_id_foreign;
+ALTER TABLE ONLY user_identities DROP CONSTRAINT user_identities_user_id_users_id_foreign;
+
+DROP TABLE user_identities;
+
+DROP INDEX idx_oidc_providers_organization_id;
+DROP INDEX idx_oidc_providers_application_id;
+ALTER TABLE ONLY oidc_providers DROP CONSTRAINT oidc_providers_organization_id_organizations_id_foreign;
+ALTER TABLE ONLY oidc_providers DROP CONSTRAINT oidc_providers_application_id_applications_id_foreign;
+
+DROP TABLE oidc_providers;
@@ -0,0 +1,34 @@
+CREATE TABLE oidc_providers (
+    id uuid DEFAULT uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    application_id uuid,
+    organization_id uuid,
+    name text NOT NULL,
+    issuer text NOT NULL,
+    client_id text NOT NULL,
+    encrypted_client_secret bytea,
+    scope text,
+    enabled boolean DEFAULT true NOT NULL
+);
+
+ALTER TABLE ONLY oidc_providers ADD CONSTRAINT oidc_providers_pkey PRIMARY KEY (id);
+
+CREATE INDEX idx_oidc_providers_application_id ON oidc_providers USING btree (application_id);
+CREATE INDEX idx_oidc_providers_organization_id ON oidc_providers USING btree (organization_id);
+ALTER TABLE ONLY oidc_providers ADD CONSTRAINT oidc_providers_application_id_applications_id_foreign FOREIGN KEY (application_id) REFERENCES applications(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY oidc_providers ADD CONSTRAINT oidc_providers_organization_id_organizations_id_foreign FOREIGN KEY (organization_id) REFERENCES organizations(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+CREATE TABLE user_identities (
+    id uuid DEFAULT uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    user_id uuid NOT NULL,
+    provider_id uuid NOT NULL,
+    subject text NOT NULL
+);
+
+ALTER TABLE ONLY user_identities ADD CONSTRAINT user_identities_pkey PRIMARY KEY (id);
+
+CREATE UNIQUE INDEX idx_user_identities_provider_id_subject ON user_identities USING btree (provider_id, subject);
+CREATE INDEX idx_user_identities_user_id ON user_identities USING btree (user_id);
+ALTER TABLE ONLY user_identities ADD CONSTRAINT user_identities_user_id_users_id_foreign FOREIGN KEY (user_id) REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY user_identities ADD CONSTRAINT user_identities_provider_id_oidc_providers_id_foreign FOREIGN KEY (provider_id) REFERENCES oidc_providers(id) ON UPDATE CASCADE ON DELETE CASCADE;
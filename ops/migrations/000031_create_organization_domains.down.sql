@@ -0,0 +1,3 @@
+ALTER TABLE ONLY organization_domains DROP CONSTRAINT organization_domains_organization_id_organizations_id_foreign;
+
+DROP TABLE organization_domains;
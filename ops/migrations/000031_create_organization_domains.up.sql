@@ -0,0 +1,14 @@
+CREATE TABLE organization_domains (
+    id uuid DEFAULT uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    organization_id uuid NOT NULL,
+    domain text NOT NULL,
+    verification_token text NOT NULL,
+    verified_at timestamp with time zone
+);
+
+ALTER TABLE ONLY organization_domains ADD CONSTRAINT organization_domains_pkey PRIMARY KEY (id);
+
+CREATE UNIQUE INDEX idx_organization_domains_organization_id_domain ON organization_domains USING btree (organization_id, domain);
+CREATE UNIQUE INDEX idx_organization_domains_verified_domain ON organization_domains USING btree (domain) WHERE verified_at IS NOT NULL;
+ALTER TABLE ONLY organization_domains ADD CONSTRAINT organization_domains_organization_id_organizations_id_foreign FOREIGN KEY (organization_id) REFERENCES organizations(id) ON UPDATE CASCADE ON DELETE CASCADE;
@@ -0,0 +1,11 @@
+DROP INDEX idx_saml_identities_user_id;
+DROP INDEX idx_saml_identities_saml_provider_id_name_id;
+ALTER TABLE ONLY saml_identities DROP CONSTRAINT saml_identities_saml_provider_id_saml_providers_id_foreign;
+ALTER TABLE ONLY saml_identities DROP CONSTRAINT saml_identities_user_id_users_id_foreign;
+
+DROP TABLE saml_identities;
+
+DROP INDEX idx_saml_providers_organization_id;
+ALTER TABLE ONLY saml_providers DROP CONSTRAINT saml_providers_organization_id_organizations_id_foreign;
+
+DROP TABLE saml_providers;
@@ -0,0 +1,29 @@
+CREATE TABLE saml_providers (
+    id uuid DEFAULT uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    organization_id uuid NOT NULL,
+    idp_metadata text NOT NULL,
+    certificate text,
+    attribute_mapping json,
+    enabled boolean DEFAULT true NOT NULL
+);
+
+ALTER TABLE ONLY saml_providers ADD CONSTRAINT saml_providers_pkey PRIMARY KEY (id);
+
+CREATE UNIQUE INDEX idx_saml_providers_organization_id ON saml_providers USING btree (organization_id);
+ALTER TABLE ONLY saml_providers ADD CONSTRAINT saml_providers_organization_id_organizations_id_foreign FOREIGN KEY (organization_id) REFERENCES organizations(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+CREATE TABLE saml_identities (
+    id uuid DEFAULT uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    user_id uuid NOT NULL,
+    saml_provider_id uuid NOT NULL,
+    name_id text NOT NULL
+);
+
+ALTER TABLE ONLY saml_identities ADD CONSTRAINT saml_identities_pkey PRIMARY KEY (id);
+
+CREATE UNIQUE INDEX idx_saml_identities_saml_provider_id_name_id ON saml_identities USING btree (saml_provider_id, name_id);
+CREATE INDEX idx_saml_identities_user_id ON saml_identities USING btree (user_id);
+ALTER TABLE ONLY saml_identities ADD CONSTRAINT saml_identities_user_id_users_id_foreign FOREIGN KEY (user_id) REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY saml_identities ADD CONSTRAINT saml_identities_saml_provider_id_saml_providers_id_foreign FOREIGN KEY (saml_provider_id) REFERENCES saml_providers(id) ON UPDATE CASCADE ON DELETE CASCADE;
@@ -0,0 +1 @@
+ALTER TABLE ONLY users DROP COLUMN ldap_permissions;
@@ -0,0 +1 @@
+ALTER TABLE ONLY users ADD COLUMN ldap_permissions integer NOT NULL DEFAULT 0;
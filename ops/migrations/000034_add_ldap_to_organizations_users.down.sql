@@ -0,0 +1 @@
+ALTER TABLE ONLY organizations_users DROP COLUMN ldap;
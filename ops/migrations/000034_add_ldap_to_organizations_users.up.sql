@@ -0,0 +1 @@
+ALTER TABLE ONLY organizations_users ADD COLUMN ldap boolean NOT NULL DEFAULT false;
@@ -0,0 +1,3 @@
+ALTER TABLE ONLY membership_token_revocations DROP CONSTRAINT membership_token_revocations_user_id_users_id_foreign;
+
+DROP TABLE membership_token_revocations;
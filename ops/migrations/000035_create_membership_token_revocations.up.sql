@@ -0,0 +1,8 @@
+CREATE TABLE membership_token_revocations (
+    user_id uuid NOT NULL,
+    resource_id uuid NOT NULL,
+    revoked_at timestamp with time zone NOT NULL
+);
+
+ALTER TABLE ONLY membership_token_revocations ADD CONSTRAINT membership_token_revocations_pkey PRIMARY KEY (user_id, resource_id);
+ALTER TABLE ONLY membership_token_revocations ADD CONSTRAINT membership_token_revocations_user_id_users_id_foreign FOREIGN KEY (user_id) REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE;
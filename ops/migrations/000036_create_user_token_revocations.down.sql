@@ -0,0 +1,3 @@
+ALTER TABLE ONLY user_token_revocations DROP CONSTRAINT user_token_revocations_user_id_users_id_foreign;
+
+DROP TABLE user_token_revocations;
@@ -0,0 +1,7 @@
+CREATE TABLE user_token_revocations (
+    user_id uuid NOT NULL,
+    revoked_at timestamp with time zone NOT NULL
+);
+
+ALTER TABLE ONLY user_token_revocations ADD CONSTRAINT user_token_revocations_pkey PRIMARY KEY (user_id);
+ALTER TABLE ONLY user_token_revocations ADD CONSTRAINT user_token_revocations_user_id_users_id_foreign FOREIGN KEY (user_id) REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE;
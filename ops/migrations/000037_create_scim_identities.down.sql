@@ -0,0 +1,7 @@
+DROP INDEX idx_scim_identities_user_id;
+DROP INDEX idx_scim_identities_organization_id_external_id;
+DROP INDEX idx_scim_identities_organization_id_user_id;
+ALTER TABLE ONLY scim_identities DROP CONSTRAINT scim_identities_user_id_users_id_foreign;
+ALTER TABLE ONLY scim_identities DROP CONSTRAINT scim_identities_organization_id_organizations_id_foreign;
+
+DROP TABLE scim_identities;
@@ -0,0 +1,15 @@
+CREATE TABLE scim_identities (
+    id uuid DEFAULT uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    organization_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    external_id text
+);
+
+ALTER TABLE ONLY scim_identities ADD CONSTRAINT scim_identities_pkey PRIMARY KEY (id);
+
+CREATE UNIQUE INDEX idx_scim_identities_organization_id_user_id ON scim_identities USING btree (organization_id, user_id);
+CREATE UNIQUE INDEX idx_scim_identities_organization_id_external_id ON scim_identities USING btree (organization_id, external_id) WHERE external_id IS NOT NULL;
+CREATE INDEX idx_scim_identities_user_id ON scim_identities USING btree (user_id);
+ALTER TABLE ONLY scim_identities ADD CONSTRAINT scim_identities_organization_id_organizations_id_foreign FOREIGN KEY (organization_id) REFERENCES organizations(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY scim_identities ADD CONSTRAINT scim_identities_user_id_users_id_foreign FOREIGN KEY (user_id) REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE;
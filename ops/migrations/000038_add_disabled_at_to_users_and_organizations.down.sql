@@ -0,0 +1,13 @@
+UPDATE users SET permissions = permissions & ~1 WHERE disabled_reason = 'scim_deprovisioned';
+
+ALTER TABLE ONLY organization_token_revocations DROP CONSTRAINT organization_token_revocations_organization_id_organizations_id_foreign;
+
+DROP TABLE organization_token_revocations;
+
+DROP INDEX idx_organizations_disabled_at;
+ALTER TABLE ONLY organizations DROP COLUMN disabled_reason;
+ALTER TABLE ONLY organizations DROP COLUMN disabled_at;
+
+DROP INDEX idx_users_disabled_at;
+ALTER TABLE ONLY users DROP COLUMN disabled_reason;
+ALTER TABLE ONLY users DROP COLUMN disabled_at;
@@ -0,0 +1,19 @@
+ALTER TABLE ONLY users ADD COLUMN disabled_at timestamp with time zone;
+ALTER TABLE ONLY users ADD COLUMN disabled_reason text;
+CREATE INDEX idx_users_disabled_at ON users USING btree (disabled_at);
+
+ALTER TABLE ONLY organizations ADD COLUMN disabled_at timestamp with time zone;
+ALTER TABLE ONLY organizations ADD COLUMN disabled_reason text;
+CREATE INDEX idx_organizations_disabled_at ON organizations USING btree (disabled_at);
+
+CREATE TABLE organization_token_revocations (
+    organization_id uuid NOT NULL,
+    revoked_at timestamp with time zone NOT NULL
+);
+
+ALTER TABLE ONLY organization_token_revocations ADD CONSTRAINT organization_token_revocations_pkey PRIMARY KEY (organization_id);
+ALTER TABLE ONLY organization_token_revocations ADD CONSTRAINT organization_token_revocations_organization_id_organizations_id_foreign FOREIGN KEY (organization_id) REFERENCES organizations(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+-- users previously deprovisioned via scim by revoking the authenticate permission are suspended instead
+UPDATE users SET disabled_at = now(), disabled_reason = 'scim_deprovisioned', permissions = permissions | 1
+    WHERE (permissions & 1) = 0 AND id IN (SELECT user_id FROM scim_identities);
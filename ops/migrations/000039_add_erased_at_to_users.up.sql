@@ -0,0 +1 @@
+ALTER TABLE ONLY users ADD COLUMN erased_at timestamp with time zone;
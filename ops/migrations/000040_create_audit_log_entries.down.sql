@@ -0,0 +1,4 @@
+ALTER TABLE ONLY audit_log_entries DROP CONSTRAINT audit_log_entries_actor_id_users_id_foreign;
+ALTER TABLE ONLY audit_log_entries DROP CONSTRAINT audit_log_entries_user_id_users_id_foreign;
+
+DROP TABLE audit_log_entries;
@@ -0,0 +1,20 @@
+CREATE TABLE audit_log_entries (
+    id uuid DEFAULT uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    user_id uuid NOT NULL,
+    actor_id uuid,
+    event text NOT NULL,
+    method text,
+    path text,
+    status integer,
+    ip_address text,
+    user_agent text,
+    reason text
+);
+
+ALTER TABLE ONLY audit_log_entries ADD CONSTRAINT audit_log_entries_pkey PRIMARY KEY (id);
+
+CREATE INDEX idx_audit_log_entries_user_id_created_at ON audit_log_entries USING btree (user_id, created_at);
+CREATE INDEX idx_audit_log_entries_actor_id ON audit_log_entries USING btree (actor_id);
+ALTER TABLE ONLY audit_log_entries ADD CONSTRAINT audit_log_entries_user_id_users_id_foreign FOREIGN KEY (user_id) REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY audit_log_entries ADD CONSTRAINT audit_log_entries_actor_id_users_id_foreign FOREIGN KEY (actor_id) REFERENCES users(id) ON UPDATE CASCADE ON DELETE SET NULL;
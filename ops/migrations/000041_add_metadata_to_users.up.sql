@@ -0,0 +1 @@
+ALTER TABLE ONLY users ADD COLUMN metadata json;
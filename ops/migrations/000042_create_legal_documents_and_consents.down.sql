@@ -0,0 +1,5 @@
+ALTER TABLE ONLY legal_consents DROP CONSTRAINT legal_consents_document_id_legal_documents_id_foreign;
+ALTER TABLE ONLY legal_consents DROP CONSTRAINT legal_consents_user_id_users_id_foreign;
+
+DROP TABLE legal_consents;
+DROP TABLE legal_documents;
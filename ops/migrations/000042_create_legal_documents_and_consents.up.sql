@@ -0,0 +1,33 @@
+CREATE TABLE legal_documents (
+    id uuid DEFAULT uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    type text NOT NULL,
+    version text NOT NULL,
+    title text,
+    content text,
+    url text,
+    published_at timestamp with time zone
+);
+
+ALTER TABLE ONLY legal_documents ADD CONSTRAINT legal_documents_pkey PRIMARY KEY (id);
+
+CREATE UNIQUE INDEX idx_legal_documents_type_version ON legal_documents USING btree (type, version);
+CREATE INDEX idx_legal_documents_type_published_at ON legal_documents USING btree (type, published_at);
+
+CREATE TABLE legal_consents (
+    id uuid DEFAULT uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    user_id uuid NOT NULL,
+    document_id uuid NOT NULL,
+    document_type text NOT NULL,
+    document_version text NOT NULL,
+    ip_address text,
+    user_agent text
+);
+
+ALTER TABLE ONLY legal_consents ADD CONSTRAINT legal_consents_pkey PRIMARY KEY (id);
+
+CREATE UNIQUE INDEX idx_legal_consents_user_id_document_id ON legal_consents USING btree (user_id, document_id);
+CREATE INDEX idx_legal_consents_document_id ON legal_consents USING btree (document_id);
+ALTER TABLE ONLY legal_consents ADD CONSTRAINT legal_consents_user_id_users_id_foreign FOREIGN KEY (user_id) REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY legal_consents ADD CONSTRAINT legal_consents_document_id_legal_documents_id_foreign FOREIGN KEY (document_id) REFERENCES legal_documents(id) ON UPDATE CASCADE ON DELETE RESTRICT;
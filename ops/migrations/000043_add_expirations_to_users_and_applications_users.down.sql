@@ -0,0 +1,6 @@
+DROP INDEX idx_applications_users_expires_at;
+DROP INDEX idx_users_expires_at;
+
+ALTER TABLE ONLY applications_users DROP COLUMN expiration_notified_at;
+ALTER TABLE ONLY applications_users DROP COLUMN expires_at;
+ALTER TABLE ONLY users DROP COLUMN expiration_notified_at;
@@ -0,0 +1,6 @@
+ALTER TABLE ONLY users ADD COLUMN expiration_notified_at timestamp with time zone;
+ALTER TABLE ONLY applications_users ADD COLUMN expires_at timestamp with time zone;
+ALTER TABLE ONLY applications_users ADD COLUMN expiration_notified_at timestamp with time zone;
+
+CREATE INDEX idx_users_expires_at ON users USING btree (expires_at) WHERE expires_at IS NOT NULL AND disabled_at IS NULL;
+CREATE INDEX idx_applications_users_expires_at ON applications_users USING btree (expires_at) WHERE expires_at IS NOT NULL;
@@ -0,0 +1,6 @@
+ALTER TABLE ONLY invitations DROP CONSTRAINT invitations_invitor_id_users_id_foreign;
+ALTER TABLE ONLY invitations DROP CONSTRAINT invitations_user_id_users_id_foreign;
+ALTER TABLE ONLY invitations DROP CONSTRAINT invitations_organization_id_organizations_id_foreign;
+ALTER TABLE ONLY invitations DROP CONSTRAINT invitations_application_id_applications_id_foreign;
+
+DROP TABLE invitations;
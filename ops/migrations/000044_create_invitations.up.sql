@@ -0,0 +1,31 @@
+CREATE TABLE invitations (
+    id uuid DEFAULT uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    application_id uuid,
+    organization_id uuid,
+    organization_name text,
+    user_id uuid,
+    invitor_id uuid,
+    invitor_name text,
+    first_name text,
+    last_name text,
+    email text NOT NULL,
+    permissions integer,
+    status text NOT NULL,
+    token_hash text,
+    expires_at timestamp with time zone,
+    accepted_at timestamp with time zone,
+    revoked_at timestamp with time zone
+);
+
+ALTER TABLE ONLY invitations ADD CONSTRAINT invitations_pkey PRIMARY KEY (id);
+
+CREATE UNIQUE INDEX idx_invitations_token_hash ON invitations USING btree (token_hash);
+CREATE INDEX idx_invitations_application_id_status_created_at ON invitations USING btree (application_id, status, created_at);
+CREATE INDEX idx_invitations_organization_id_status_created_at ON invitations USING btree (organization_id, status, created_at);
+CREATE INDEX idx_invitations_email ON invitations USING btree (email);
+CREATE INDEX idx_invitations_status_expires_at ON invitations USING btree (status, expires_at) WHERE expires_at IS NOT NULL;
+ALTER TABLE ONLY invitations ADD CONSTRAINT invitations_application_id_applications_id_foreign FOREIGN KEY (application_id) REFERENCES applications(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY invitations ADD CONSTRAINT invitations_organization_id_organizations_id_foreign FOREIGN KEY (organization_id) REFERENCES organizations(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY invitations ADD CONSTRAINT invitations_user_id_users_id_foreign FOREIGN KEY (user_id) REFERENCES users(id) ON UPDATE CASCADE ON DELETE SET NULL;
+ALTER TABLE ONLY invitations ADD CONSTRAINT invitations_invitor_id_users_id_foreign FOREIGN KEY (invitor_id) REFERENCES users(id) ON UPDATE CASCADE ON DELETE SET NULL;
@@ -0,0 +1 @@
+ALTER TABLE ONLY invitations DROP COLUMN params;
@@ -0,0 +1 @@
+ALTER TABLE ONLY invitations ADD COLUMN params json;
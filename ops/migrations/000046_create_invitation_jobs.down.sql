@@ -0,0 +1,4 @@
+ALTER TABLE ONLY invitation_jobs DROP CONSTRAINT invitation_jobs_user_id_users_id_foreign;
+ALTER TABLE ONLY invitation_jobs DROP CONSTRAINT invitation_jobs_organization_id_organizations_id_foreign;
+
+DROP TABLE invitation_jobs;
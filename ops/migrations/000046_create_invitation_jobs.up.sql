@@ -0,0 +1,15 @@
+CREATE TABLE invitation_jobs (
+    id uuid DEFAULT uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    organization_id uuid NOT NULL,
+    user_id uuid,
+    status text NOT NULL,
+    rows json,
+    completed_at timestamp with time zone
+);
+
+ALTER TABLE ONLY invitation_jobs ADD CONSTRAINT invitation_jobs_pkey PRIMARY KEY (id);
+
+CREATE INDEX idx_invitation_jobs_organization_id_created_at ON invitation_jobs USING btree (organization_id, created_at);
+ALTER TABLE ONLY invitation_jobs ADD CONSTRAINT invitation_jobs_organization_id_organizations_id_foreign FOREIGN KEY (organization_id) REFERENCES organizations(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY invitation_jobs ADD CONSTRAINT invitation_jobs_user_id_users_id_foreign FOREIGN KEY (user_id) REFERENCES users(id) ON UPDATE CASCADE ON DELETE SET NULL;
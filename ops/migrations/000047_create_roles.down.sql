@@ -0,0 +1,13 @@
+ALTER TABLE ONLY invitations DROP CONSTRAINT invitations_role_id_roles_id_foreign;
+ALTER TABLE ONLY invitations DROP COLUMN role_id;
+
+ALTER TABLE ONLY organizations_users DROP CONSTRAINT organizations_users_role_id_roles_id_foreign;
+ALTER TABLE ONLY organizations_users DROP COLUMN role_id;
+
+ALTER TABLE ONLY applications_users DROP CONSTRAINT applications_users_role_id_roles_id_foreign;
+ALTER TABLE ONLY applications_users DROP COLUMN role_id;
+
+ALTER TABLE ONLY roles DROP CONSTRAINT roles_organization_id_organizations_id_foreign;
+ALTER TABLE ONLY roles DROP CONSTRAINT roles_application_id_applications_id_foreign;
+
+DROP TABLE roles;
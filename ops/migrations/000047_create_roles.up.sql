@@ -0,0 +1,41 @@
+CREATE TABLE roles (
+    id uuid DEFAULT uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    application_id uuid,
+    organization_id uuid,
+    name text NOT NULL,
+    description text,
+    permissions integer NOT NULL,
+    extended_permissions json
+);
+
+ALTER TABLE ONLY roles ADD CONSTRAINT roles_pkey PRIMARY KEY (id);
+
+CREATE UNIQUE INDEX idx_roles_builtin_name ON roles USING btree (name) WHERE application_id IS NULL AND organization_id IS NULL;
+CREATE UNIQUE INDEX idx_roles_application_id_name ON roles USING btree (application_id, name) WHERE application_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_roles_organization_id_name ON roles USING btree (organization_id, name) WHERE organization_id IS NOT NULL;
+ALTER TABLE ONLY roles ADD CONSTRAINT roles_application_id_applications_id_foreign FOREIGN KEY (application_id) REFERENCES applications(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY roles ADD CONSTRAINT roles_organization_id_organizations_id_foreign FOREIGN KEY (organization_id) REFERENCES organizations(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+INSERT INTO roles (created_at, name, description, permissions) VALUES
+    (now(), 'owner', 'Owner of the organization or application', 510),
+    (now(), 'admin', 'Administers the organization or application and its memberships', 494),
+    (now(), 'member', 'Member of the organization or application', 386),
+    (now(), 'viewer', 'Read-only member of the organization or application', 258);
+
+ALTER TABLE ONLY applications_users ADD COLUMN role_id uuid;
+ALTER TABLE ONLY applications_users ADD CONSTRAINT applications_users_role_id_roles_id_foreign FOREIGN KEY (role_id) REFERENCES roles(id) ON UPDATE CASCADE ON DELETE RESTRICT;
+CREATE INDEX idx_applications_users_role_id ON applications_users USING btree (role_id);
+
+ALTER TABLE ONLY organizations_users ADD COLUMN role_id uuid;
+ALTER TABLE ONLY organizations_users ADD CONSTRAINT organizations_users_role_id_roles_id_foreign FOREIGN KEY (role_id) REFERENCES roles(id) ON UPDATE CASCADE ON DELETE RESTRICT;
+CREATE INDEX idx_organizations_users_role_id ON organizations_users USING btree (role_id);
+
+ALTER TABLE ONLY invitations ADD COLUMN role_id uuid;
+ALTER TABLE ONLY invitations ADD CONSTRAINT invitations_role_id_roles_id_foreign FOREIGN KEY (role_id) REFERENCES roles(id) ON UPDATE CASCADE ON DELETE SET NULL;
+
+UPDATE applications_users au SET role_id = (SELECT id FROM roles WHERE name = 'owner' AND application_id IS NULL AND organization_id IS NULL) FROM applications a WHERE a.id = au.application_id AND a.user_id = au.user_id;
+UPDATE applications_users SET role_id = (SELECT id FROM roles WHERE name = 'member' AND application_id IS NULL AND organization_id IS NULL) WHERE role_id IS NULL AND permissions = 386;
+
+UPDATE organizations_users ou SET role_id = (SELECT id FROM roles WHERE name = 'owner' AND application_id IS NULL AND organization_id IS NULL) FROM organizations o WHERE o.id = ou.organization_id AND o.user_id = ou.user_id;
+UPDATE organizations_users SET role_id = (SELECT id FROM roles WHERE name = 'member' AND application_id IS NULL AND organization_id IS NULL) WHERE role_id IS NULL AND permissions = 386;
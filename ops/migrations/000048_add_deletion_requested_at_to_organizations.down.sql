@@ -0,0 +1,5 @@
+DELETE FROM organization_token_revocations WHERE organization_id NOT IN (SELECT id FROM organizations);
+ALTER TABLE ONLY organization_token_revocations ADD CONSTRAINT organization_token_revocations_organization_id_organizations_id_foreign FOREIGN KEY (organization_id) REFERENCES organizations(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+DROP INDEX idx_organizations_deletion_requested_at;
+ALTER TABLE ONLY organizations DROP COLUMN deletion_requested_at;
@@ -0,0 +1,5 @@
+ALTER TABLE ONLY organizations ADD COLUMN deletion_requested_at timestamp with time zone;
+CREATE INDEX idx_organizations_deletion_requested_at ON organizations USING btree (deletion_requested_at) WHERE deletion_requested_at IS NOT NULL;
+
+-- token revocations are retained upon deletion of an organization so its outstanding tokens remain revoked
+ALTER TABLE ONLY organization_token_revocations DROP CONSTRAINT organization_token_revocations_organization_id_organizations_id_foreign;
@@ -0,0 +1,4 @@
+DROP INDEX idx_organizations_parent_organization_id;
+ALTER TABLE ONLY organizations DROP CONSTRAINT organizations_parent_organization_id_check;
+ALTER TABLE ONLY organizations DROP CONSTRAINT organizations_parent_organization_id_organizations_id_foreign;
+ALTER TABLE ONLY organizations DROP COLUMN parent_organization_id;
@@ -0,0 +1,4 @@
+ALTER TABLE ONLY organizations ADD COLUMN parent_organization_id uuid;
+ALTER TABLE ONLY organizations ADD CONSTRAINT organizations_parent_organization_id_organizations_id_foreign FOREIGN KEY (parent_organization_id) REFERENCES organizations(id) ON UPDATE CASCADE ON DELETE SET NULL;
+ALTER TABLE ONLY organizations ADD CONSTRAINT organizations_parent_organization_id_check CHECK (parent_organization_id <> id);
+CREATE INDEX idx_organizations_parent_organization_id ON organizations USING btree (parent_organization_id);
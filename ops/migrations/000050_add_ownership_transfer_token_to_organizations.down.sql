@@ -0,0 +1 @@
+ALTER TABLE ONLY organizations DROP COLUMN ownership_transfer_token;
@@ -0,0 +1 @@
+ALTER TABLE ONLY organizations ADD COLUMN ownership_transfer_token text;
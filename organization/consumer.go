@@ -55,6 +55,10 @@ const organizationRegistrationMethod = "registerOrg"
 const organizationUpdateRegistrationMethod = "updateOrg"
 const organizationSetInterfaceImplementerMethod = "setInterfaceImplementer"
 
+const natsOrganizationInvitationJobMaxInFlight = 256
+const natsOrganizationInvitationJobAckWait = time.Minute * 5
+const natsOrganizationInvitationJobMaxDeliveries = 5
+
 const contractTypeRegistry = "registry"
 const contractTypeOrgRegistry = "organization-registry"
 const contractTypeERC1820Registry = "erc1820-registry"
@@ -79,6 +83,7 @@ func init() {
 	createNatsOrganizationImplicitKeyExchangeCompleteSubscriptions(&waitGroup)
 	createNatsOrganizationImplicitKeyExchangeSubscriptions(&waitGroup)
 	createNatsOrganizationRegistrationSubscriptions(&waitGroup)
+	createNatsOrganizationInvitationJobSubscriptions(&waitGroup)
 }
 
 func createNatsOrganizationCreatedSubscriptions(wg *sync.WaitGroup) {
@@ -161,6 +166,70 @@ func createNatsOrganizationRegistrationSubscriptions(wg *sync.WaitGroup) {
 	}
 }
 
+func createNatsOrganizationInvitationJobSubscriptions(wg *sync.WaitGroup) {
+	for i := uint64(0); i < natsutil.GetNatsConsumerConcurrency(); i++ {
+		_, err := natsutil.RequireNatsJetstreamSubscription(wg,
+			natsOrganizationInvitationJobAckWait,
+			natsOrganizationInvitationJobSubject,
+			natsOrganizationInvitationJobSubject,
+			natsOrganizationInvitationJobSubject,
+			consumeOrganizationInvitationJobMsg,
+			natsOrganizationInvitationJobAckWait,
+			natsOrganizationInvitationJobMaxInFlight,
+			natsOrganizationInvitationJobMaxDeliveries,
+			nil,
+		)
+
+		if err != nil {
+			common.Log.Panicf("failed to subscribe to NATS stream via subject: %s; %s", natsOrganizationInvitationJobSubject, err.Error())
+		}
+	}
+}
+
+func consumeOrganizationInvitationJobMsg(msg *nats.Msg) {
+	defer func() {
+		if r := recover(); r != nil {
+			msg.Nak()
+		}
+	}()
+
+	common.Log.Debugf("consuming %d-byte NATS organization invitation job message on subject: %s", len(msg.Data), msg.Subject)
+
+	params := map[string]interface{}{}
+	err := json.Unmarshal(msg.Data, &params)
+	if err != nil {
+		common.Log.Warningf("failed to unmarshal organization invitation job message; %s", err.Error())
+		msg.Nak()
+		return
+	}
+
+	jobID, jobIDOk := params["invitation_job_id"].(string)
+	if !jobIDOk {
+		common.Log.Warning("failed to unmarshal invitation_job_id during organization invitation job message handler")
+		msg.Nak()
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	job := &InvitationJob{}
+	db.Where("id = ?", jobID).Find(&job)
+	if job == nil || job.ID == uuid.Nil {
+		common.Log.Warningf("failed to resolve invitation job during organization invitation job message handler; invitation job id: %s", jobID)
+		msg.Nak()
+		return
+	}
+
+	err = job.process(db)
+	if err != nil {
+		common.Log.Warningf("failed to process invitation job: %s; %s", job.ID, err.Error())
+		msg.Nak()
+		return
+	}
+
+	msg.Ack()
+}
+
 func consumeCreatedOrganizationMsg(msg *nats.Msg) {
 	defer func() {
 		if r := recover(); r != nil {
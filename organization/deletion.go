@@ -0,0 +1,175 @@
+package organization
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	natsutil "github.com/kthomas/go-natsutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+	"github.com/provideplatform/ident/role"
+	"github.com/provideplatform/ident/token"
+	"github.com/provideplatform/ident/user"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+const natsOrganizationDeletedSubject = "ident.organization.deleted"
+
+// organizationDeletionDisabledReason is recorded as the suspension reason of organizations pending deletion
+const organizationDeletionDisabledReason = "deletion"
+
+// organizationDeletionTables contain rows which are removed upon deletion of the organization they
+// reference; memberships are removed prior to the roles they may reference
+var organizationDeletionTables = []string{
+	"organizations_users",
+	"applications_organizations",
+	"invitations",
+	"invitation_jobs",
+	"organization_domains",
+	"roles",
+	"tokens",
+}
+
+// IsDeletionRequested returns true if the organization is pending deletion
+func (o *Organization) IsDeletionRequested() bool {
+	return o.DeletionRequestedAt != nil
+}
+
+// IsOwner returns true if the given user created the organization or is a member of the
+// organization having the builtin owner role
+func (o *Organization) IsOwner(db *gorm.DB, userID uuid.UUID) bool {
+	if o.UserID != nil && *o.UserID == userID {
+		return true
+	}
+
+	ownerRoleID := role.BuiltinID(db, role.Owner)
+	if ownerRoleID == nil {
+		return false
+	}
+
+	var owners uint64
+	db.Table("organizations_users").Where("organization_id = ? AND user_id = ? AND role_id = ?", o.ID, userID, ownerRoleID).Count(&owners)
+	return owners > 0
+}
+
+// RequestDeletion suspends the organization and revokes its pending invitations; the organization
+// is permanently deleted once the deletion grace period has elapsed, or immediately if there is none
+func (o *Organization) RequestDeletion() bool {
+	if o.IsDeletionRequested() {
+		o.Errors = append(o.Errors, &provide.Error{
+			Message: common.StringOrNil("organization is pending deletion"),
+		})
+		return false
+	}
+
+	if common.OrganizationDeletionGracePeriod <= 0 {
+		return o.Delete()
+	}
+
+	db := dbconf.DatabaseConnection()
+	tx := db.Begin()
+	defer tx.RollbackUnlessCommitted()
+
+	if o.IsDisabled() {
+		err := token.RevokeOrganizationTokens(tx, o.ID)
+		if err != nil {
+			o.Errors = append(o.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+			return false
+		}
+	} else if !o.Suspend(tx, common.StringOrNil(organizationDeletionDisabledReason)) {
+		return false
+	}
+
+	deletionRequestedAt := time.Now()
+	result := tx.Model(o).Update("deletion_requested_at", deletionRequestedAt)
+	if result.Error != nil {
+		o.Errors = append(o.Errors, &provide.Error{
+			Message: common.StringOrNil(result.Error.Error()),
+		})
+		return false
+	}
+
+	result = tx.Model(&user.Invitation{}).Where("organization_id = ? AND status = ?", o.ID, user.InvitationStatusPending).Updates(map[string]interface{}{
+		"status":     user.InvitationStatusRevoked,
+		"revoked_at": deletionRequestedAt,
+	})
+	if result.Error != nil {
+		o.Errors = append(o.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("failed to revoke pending invitations of organization: %s; %s", o.ID, result.Error.Error())),
+		})
+		return false
+	}
+
+	tx.Commit()
+
+	o.DeletionRequestedAt = &deletionRequestedAt
+	common.Log.Debugf("requested deletion of organization: %s; %d pending invitation(s) revoked", o.ID, result.RowsAffected)
+	return true
+}
+
+// Delete permanently deletes the organization along with its memberships, application associations,
+// invitations, roles and legacy tokens, and publishes a deleted event so dependent services can react;
+// outstanding tokens issued on behalf of the organization remain revoked. Deletion is idempotent.
+func (o *Organization) Delete() bool {
+	db := dbconf.DatabaseConnection()
+	tx := db.Begin()
+	defer tx.RollbackUnlessCommitted()
+
+	err := token.RevokeOrganizationTokens(tx, o.ID)
+	if err != nil {
+		o.Errors = append(o.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+		return false
+	}
+
+	for _, table := range organizationDeletionTables {
+		result := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE organization_id = ?", table), o.ID)
+		if result.Error != nil {
+			o.Errors = append(o.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("failed to delete %s of organization: %s; %s", table, o.ID, result.Error.Error())),
+			})
+			return false
+		}
+	}
+
+	result := tx.Exec("DELETE FROM organizations WHERE id = ?", o.ID)
+	if result.Error != nil {
+		o.Errors = append(o.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("failed to delete organization: %s; %s", o.ID, result.Error.Error())),
+		})
+		return false
+	}
+	deleted := result.RowsAffected > 0
+
+	tx.Commit()
+
+	if deleted {
+		payload, _ := json.Marshal(map[string]interface{}{
+			"organization_id": o.ID.String(),
+			"user_id":         o.UserID,
+		})
+		natsutil.NatsJetstreamPublish(natsOrganizationDeletedSubject, payload)
+		common.Log.Debugf("deleted organization: %s", o.ID)
+	}
+
+	return true
+}
+
+// SweepDeletions permanently deletes each organization for which deletion was requested
+// prior to the deletion grace period
+func SweepDeletions(db *gorm.DB) {
+	var orgs []*Organization
+	db.Where("deletion_requested_at <= ?", time.Now().Add(-common.OrganizationDeletionGracePeriod)).Find(&orgs)
+
+	for _, org := range orgs {
+		if !org.Delete() {
+			common.Log.Warningf("failed to delete organization: %s; %s", org.ID, *org.Errors[0].Message)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package organization
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+// domainVerificationRecordPrefix is prepended to a domain to derive the name of the DNS TXT
+// record which must assert the verification token of the domain
+const domainVerificationRecordPrefix = "_ident-challenge"
+
+// domainVerificationValuePrefix prefixes the verification token in the DNS TXT record value
+const domainVerificationValuePrefix = "ident-domain-verification="
+
+const domainVerificationTokenLength = 32
+
+var domainPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// lookupTXT resolves the DNS TXT records of the given name; overridden in tests
+var lookupTXT = net.LookupTXT
+
+// Domain is an email domain claimed by an organization; the organization proves control of the
+// domain by publishing its verification token in a DNS TXT record, and only verified domains
+// permit the organization's identity providers to provision users
+type Domain struct {
+	provide.Model
+	OrganizationID    *uuid.UUID `sql:"not null;type:uuid" json:"organization_id"`
+	Domain            *string    `sql:"not null" json:"domain"`
+	VerificationToken *string    `sql:"not null" json:"verification_token"`
+	VerifiedAt        *time.Time `json:"verified_at"`
+}
+
+// TableName returns the db table name for gorm
+func (d *Domain) TableName() string {
+	return "organization_domains"
+}
+
+// VerificationRecordName returns the name of the DNS TXT record which must assert the verification token
+func (d *Domain) VerificationRecordName() string {
+	return fmt.Sprintf("%s.%s", domainVerificationRecordPrefix, *d.Domain)
+}
+
+// IsVerified returns true if control of the domain has been verified
+func (d *Domain) IsVerified() bool {
+	return d.VerifiedAt != nil
+}
+
+// DomainsListQuery returns a query for the domains claimed by the organization
+func (o *Organization) DomainsListQuery(db *gorm.DB) *gorm.DB {
+	return db.Where("organization_id = ?", o.ID).Order("domain ASC")
+}
+
+// Create and persist the domain claim along with a new verification token
+func (d *Domain) Create(tx *gorm.DB) bool {
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+	}
+
+	if !d.validate() {
+		return false
+	}
+
+	d.VerificationToken = common.StringOrNil(common.RandomString(domainVerificationTokenLength))
+	d.VerifiedAt = nil
+
+	if db.NewRecord(d) {
+		result := db.Create(&d)
+		rowsAffected := result.RowsAffected
+		errors := result.GetErrors()
+		if len(errors) > 0 {
+			for _, err := range errors {
+				d.Errors = append(d.Errors, &provide.Error{
+					Message: common.StringOrNil(err.Error()),
+				})
+			}
+		}
+		if !db.NewRecord(d) {
+			success := rowsAffected > 0
+			if success {
+				common.Log.Debugf("created domain %s for organization: %s", *d.Domain, d.OrganizationID)
+			}
+			return success
+		}
+	}
+
+	return false
+}
+
+// Verify the domain by resolving its verification record; a domain may only be verified by
+// a single organization
+func (d *Domain) Verify(tx *gorm.DB) bool {
+	d.Errors = make([]*provide.Error, 0)
+
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+	}
+
+	if d.IsVerified() {
+		return true
+	}
+
+	var claims uint64
+	db.Model(&Domain{}).Where("domain = ? AND verified_at IS NOT NULL AND id <> ?", d.Domain, d.ID).Count(&claims)
+	if claims > 0 {
+		d.Errors = append(d.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("domain has been verified by another organization: %s", *d.Domain)),
+		})
+		return false
+	}
+
+	records, err := lookupTXT(d.VerificationRecordName())
+	if err != nil {
+		d.Errors = append(d.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("failed to resolve domain verification record: %s; %s", d.VerificationRecordName(), err.Error())),
+		})
+		return false
+	}
+
+	expected := fmt.Sprintf("%s%s", domainVerificationValuePrefix, *d.VerificationToken)
+	verified := false
+	for _, record := range records {
+		if strings.TrimSpace(record) == expected {
+			verified = true
+			break
+		}
+	}
+
+	if !verified {
+		d.Errors = append(d.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("domain verification record %s does not assert the verification token", d.VerificationRecordName())),
+		})
+		return false
+	}
+
+	verifiedAt := time.Now()
+	result := db.Model(d).Update("verified_at", verifiedAt)
+	if result.Error != nil {
+		d.Errors = append(d.Errors, &provide.Error{
+			Message: common.StringOrNil(result.Error.Error()),
+		})
+		return false
+	}
+
+	d.VerifiedAt = &verifiedAt
+	common.Log.Debugf("verified domain %s for organization: %s", *d.Domain, d.OrganizationID)
+	return true
+}
+
+// Delete the domain claim
+func (d *Domain) Delete(tx *gorm.DB) bool {
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+	}
+
+	result := db.Delete(&d)
+	if result.Error != nil {
+		d.Errors = append(d.Errors, &provide.Error{
+			Message: common.StringOrNil(result.Error.Error()),
+		})
+		return false
+	}
+
+	common.Log.Debugf("deleted domain %s for organization: %s", *d.Domain, d.OrganizationID)
+	return true
+}
+
+// validate the domain claim for persistence
+func (d *Domain) validate() bool {
+	d.Errors = make([]*provide.Error, 0)
+
+	if d.OrganizationID == nil || *d.OrganizationID == uuid.Nil {
+		d.Errors = append(d.Errors, &provide.Error{
+			Message: common.StringOrNil("organization_id is required"),
+		})
+	}
+
+	if d.Domain == nil || strings.TrimSpace(*d.Domain) == "" {
+		d.Errors = append(d.Errors, &provide.Error{
+			Message: common.StringOrNil("domain is required"),
+		})
+	} else {
+		d.Domain = common.StringOrNil(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(*d.Domain)), "."))
+		if len(*d.Domain) > 253 || !domainPattern.MatchString(*d.Domain) {
+			d.Errors = append(d.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("invalid domain: %s", *d.Domain)),
+			})
+		}
+	}
+
+	return len(d.Errors) == 0
+}
@@ -2,12 +2,15 @@ package organization
 
 import (
 	"encoding/json"
+	"fmt"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/gorm"
 	dbconf "github.com/kthomas/go-db-config"
 	uuid "github.com/kthomas/go.uuid"
 	"github.com/provideplatform/ident/common"
+	"github.com/provideplatform/ident/role"
 	"github.com/provideplatform/ident/token"
 	"github.com/provideplatform/ident/user"
 
@@ -19,9 +22,23 @@ import (
 func InstallOrganizationAPI(r *gin.Engine) {
 	r.GET("/api/v1/organizations", organizationsListHandler)
 	r.GET("/api/v1/organizations/:id", organizationDetailsHandler)
+	r.GET("/api/v1/organizations/:id/descendants", organizationDescendantsListHandler)
 	r.POST("/api/v1/organizations", createOrganizationHandler)
 	r.PUT("/api/v1/organizations/:id", updateOrganizationHandler)
 	r.DELETE("/api/v1/organizations/:id", deleteOrganizationHandler)
+	r.POST("/api/v1/organizations/:id/suspend", suspendOrganizationHandler)
+	r.POST("/api/v1/organizations/:id/reactivate", reactivateOrganizationHandler)
+	r.POST("/api/v1/organizations/:id/ownership_transfer", createOrganizationOwnershipTransferHandler)
+	r.POST("/api/v1/organizations/:id/ownership_transfer/accept", acceptOrganizationOwnershipTransferHandler)
+
+	r.GET("/api/v1/organizations/:id/saml", organizationSAMLProviderDetailsHandler)
+	r.PUT("/api/v1/organizations/:id/saml", updateOrganizationSAMLProviderHandler)
+	r.DELETE("/api/v1/organizations/:id/saml", deleteOrganizationSAMLProviderHandler)
+
+	r.GET("/api/v1/organizations/:id/domains", organizationDomainsListHandler)
+	r.POST("/api/v1/organizations/:id/domains", createOrganizationDomainHandler)
+	r.POST("/api/v1/organizations/:id/domains/:domainId/verify", verifyOrganizationDomainHandler)
+	r.DELETE("/api/v1/organizations/:id/domains/:domainId", deleteOrganizationDomainHandler)
 }
 
 // InstallOrganizationUsersAPI installs the handlers using the given gin Engine
@@ -31,7 +48,14 @@ func InstallOrganizationUsersAPI(r *gin.Engine) {
 	r.PUT("/api/v1/organizations/:id/users/:userId", updateOrganizationUserHandler)
 	r.DELETE("/api/v1/organizations/:id/users/:userId", deleteOrganizationUserHandler)
 
+	r.GET("/api/v1/organizations/:id/roles", organizationRolesListHandler)
+	r.POST("/api/v1/organizations/:id/roles", createOrganizationRoleHandler)
+	r.PUT("/api/v1/organizations/:id/roles/:roleId", updateOrganizationRoleHandler)
+	r.DELETE("/api/v1/organizations/:id/roles/:roleId", deleteOrganizationRoleHandler)
+
 	r.GET("/api/v1/organizations/:id/invitations", organizationInvitationsListHandler)
+	r.POST("/api/v1/organizations/:id/invitation_jobs", createOrganizationInvitationJobHandler)
+	r.GET("/api/v1/organizations/:id/invitation_jobs/:jobId", organizationInvitationJobDetailsHandler)
 }
 
 // InstallOrganizationVaultsAPI installs the handlers using the given gin Engine
@@ -62,7 +86,8 @@ func resolveOrganization(db *gorm.DB, orgID, appID, userID *uuid.UUID) *gorm.DB
 		}
 	}
 	if userID != nil {
-		query = query.Joins("JOIN organizations_users as ou ON ou.organization_id = organizations.id").Where("ou.user_id = ?", userID)
+		// members of an organization are implicitly members of each of its descendants
+		query = query.Where(fmt.Sprintf("organizations.id IN (%s)", memberOrganizationsSQL), userID, common.OrganizationHierarchyMaxDepth)
 	}
 	return query.Order("organizations.created_at DESC").Group("organizations.id")
 }
@@ -90,6 +115,60 @@ func organizationsListHandler(c *gin.Context) {
 
 	db := dbconf.DatabaseConnection()
 	query := resolveOrganization(db, nil, applicationID, userID)
+
+	if c.Query("parent_organization_id") != "" {
+		parentOrganizationID, err := uuid.FromString(c.Query("parent_organization_id"))
+		if err != nil {
+			provide.RenderError("invalid parent_organization_id", 400, c)
+			return
+		}
+		query = query.Where("organizations.parent_organization_id = ?", parentOrganizationID)
+	}
+
+	provide.Paginate(c, query, &Organization{}).Find(&orgs)
+	for _, org := range orgs {
+		org.Enrich(db, nil)
+	}
+	provide.Render(orgs, 200, c)
+}
+
+// organizationDescendantsListHandler lists the sub-organizations beneath the organization at any depth
+func organizationDescendantsListHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	userID := bearer.UserID
+	orgID := bearer.OrganizationID
+
+	if (userID == nil || *userID == uuid.Nil) && (orgID == nil || *orgID == uuid.Nil) {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	organizationID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError("bad request", 400, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	org := &Organization{}
+	if orgID != nil && *orgID != uuid.Nil {
+		db.Where("id = ?", organizationID).Find(&org)
+		if org.ID != uuid.Nil && org.ID != *orgID && !org.IsDescendantOf(db, *orgID) {
+			provide.RenderError("forbidden", 403, c)
+			return
+		}
+	} else {
+		resolveOrganization(db, &organizationID, nil, userID).Find(&org)
+	}
+
+	if org == nil || org.ID == uuid.Nil {
+		provide.RenderError("organization not found", 404, c)
+		return
+	}
+
+	var orgs []*Organization
+	query := org.DescendantsQuery(db).Order("organizations.created_at DESC")
 	provide.Paginate(c, query, &Organization{}).Find(&orgs)
 	for _, org := range orgs {
 		org.Enrich(db, nil)
@@ -107,9 +186,17 @@ func organizationDetailsHandler(c *gin.Context) {
 		return
 	}
 
+	db := dbconf.DatabaseConnection()
+
+	// an organization bearer token authorizes access to the organization and its descendants
 	if orgID != nil && orgID.String() != c.Param("id") {
-		provide.RenderError("forbidden", 403, c)
-		return
+		descendant := &Organization{}
+		db.Where("id = ?", c.Param("id")).Find(&descendant)
+		if descendant.ID == uuid.Nil || !descendant.IsDescendantOf(db, *orgID) {
+			provide.RenderError("forbidden", 403, c)
+			return
+		}
+		orgID = &descendant.ID
 	}
 
 	// if we don't have an org bearer token, pull the org id from the params
@@ -124,7 +211,6 @@ func organizationDetailsHandler(c *gin.Context) {
 		orgID = &organizationID
 	}
 
-	db := dbconf.DatabaseConnection()
 	org := &Organization{}
 	resolveOrganization(db, orgID, nil, userID).Find(&org)
 
@@ -166,6 +252,8 @@ func createOrganizationHandler(c *gin.Context) {
 		return
 	}
 	org.UserID = userID
+	org.DisabledAt = nil
+	org.DisabledReason = nil
 
 	var invite *user.Invite
 	var permissions common.Permission
@@ -200,6 +288,22 @@ func createOrganizationHandler(c *gin.Context) {
 	}
 
 	db := dbconf.DatabaseConnection()
+
+	// sub-organizations may only be created by administrators of the parent organization
+	if org.ParentOrganizationID != nil {
+		parent := &Organization{}
+		db.Where("id = ?", org.ParentOrganizationID).Find(&parent)
+		if parent.ID == uuid.Nil {
+			provide.RenderError("parent organization not found", 404, c)
+			return
+		}
+
+		if !parent.IsAdministrator(db, *userID) {
+			provide.RenderError("forbidden", 403, c)
+			return
+		}
+	}
+
 	tx := db.Begin()
 	success := org.Create(tx)
 	if success && invite != nil {
@@ -210,7 +314,7 @@ func createOrganizationHandler(c *gin.Context) {
 		if success {
 			success = invite.Token.IsRevoked() || invite.Token.Revoke(tx)
 			if success {
-				invite.InvalidateCache()
+				success = invite.Accept(tx, userID) == nil
 			}
 		}
 	}
@@ -239,82 +343,716 @@ func updateOrganizationHandler(c *gin.Context) {
 		return
 	}
 
-	org := &Organization{}
-	dbconf.DatabaseConnection().Where("id = ?", c.Param("id")).Find(&org)
-	if org.ID == uuid.Nil {
-		provide.RenderError("org not found", 404, c)
+	db := dbconf.DatabaseConnection()
+
+	org := &Organization{}
+	db.Where("id = ?", c.Param("id")).Find(&org)
+	if org.ID == uuid.Nil {
+		provide.RenderError("org not found", 404, c)
+		return
+	}
+
+	if bearer.UserID != nil && bearer.UserID.String() != org.UserID.String() { // FIXME-- this should be more than just org.UserID
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	if org.IsDisabled() {
+		provide.RenderError("organization has been suspended", 403, c)
+		return
+	}
+
+	userID := org.UserID
+	parentOrganizationID := org.ParentOrganizationID
+
+	err = json.Unmarshal(buf, org)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	// ownership is only changed by way of an ownership transfer
+	org.UserID = userID
+
+	// an organization may only be detached from, or moved beneath, an organization administered by the bearer
+	if (parentOrganizationID == nil) != (org.ParentOrganizationID == nil) || (parentOrganizationID != nil && *parentOrganizationID != *org.ParentOrganizationID) {
+		if parentOrganizationID != nil {
+			currentParent := &Organization{}
+			db.Where("id = ?", parentOrganizationID).Find(&currentParent)
+			if currentParent.ID == uuid.Nil || !currentParent.IsAdministrator(db, *bearer.UserID) {
+				provide.RenderError("forbidden", 403, c)
+				return
+			}
+		}
+
+		if org.ParentOrganizationID != nil {
+			parent := &Organization{}
+			db.Where("id = ?", org.ParentOrganizationID).Find(&parent)
+			if parent.ID == uuid.Nil {
+				provide.RenderError("parent organization not found", 404, c)
+				return
+			}
+
+			if !parent.IsAdministrator(db, *bearer.UserID) {
+				provide.RenderError("forbidden", 403, c)
+				return
+			}
+		}
+	}
+
+	// suspension and deletion are managed using the suspend, reactivate and delete endpoints
+	org.DisabledAt = nil
+	org.DisabledReason = nil
+	org.DeletionRequestedAt = nil
+
+	if org.Update() {
+		provide.Render(nil, 204, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = org.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+// deleteOrganizationHandler requests deletion of the organization on behalf of its owner; the
+// organization is suspended immediately and permanently deleted after the deletion grace period
+func deleteOrganizationHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	if bearer == nil || ((bearer.UserID == nil || *bearer.UserID == uuid.Nil) && !bearer.HasPermission(common.Sudo)) {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	org := &Organization{}
+	db.Where("id = ?", c.Param("id")).Find(&org)
+	if org.ID == uuid.Nil {
+		provide.RenderError("organization not found", 404, c)
+		return
+	}
+
+	if !bearer.HasPermission(common.Sudo) && !org.IsOwner(db, *bearer.UserID) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	if org.RequestDeletion() {
+		provide.Render(nil, 204, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = org.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+func suspendOrganizationHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	if !bearer.HasPermission(common.Sudo) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	params := map[string]interface{}{}
+	if len(buf) > 0 {
+		err = json.Unmarshal(buf, &params)
+		if err != nil {
+			provide.RenderError(err.Error(), 400, c)
+			return
+		}
+	}
+
+	org := &Organization{}
+	dbconf.DatabaseConnection().Where("id = ?", c.Param("id")).Find(&org)
+	if org.ID == uuid.Nil {
+		provide.RenderError("organization not found", 404, c)
+		return
+	}
+
+	if org.IsDisabled() {
+		provide.RenderError("organization has already been suspended", 422, c)
+		return
+	}
+
+	var reason *string
+	if disabledReason, disabledReasonOk := params["reason"].(string); disabledReasonOk {
+		reason = common.StringOrNil(disabledReason)
+	}
+
+	if org.Suspend(nil, reason) {
+		provide.Render(nil, 204, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = org.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+func reactivateOrganizationHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	if !bearer.HasPermission(common.Sudo) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	org := &Organization{}
+	dbconf.DatabaseConnection().Where("id = ?", c.Param("id")).Find(&org)
+	if org.ID == uuid.Nil {
+		provide.RenderError("organization not found", 404, c)
+		return
+	}
+
+	if org.Reactivate(nil) {
+		provide.Render(nil, 204, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = org.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+// createOrganizationOwnershipTransferHandler nominates a member to become the owner of the
+// organization on behalf of its owner; a sudoer transfers ownership to the given user immediately
+func createOrganizationOwnershipTransferHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	if bearer == nil || ((bearer.UserID == nil || *bearer.UserID == uuid.Nil) && !bearer.HasPermission(common.Sudo)) {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	if bearer.IsImpersonation() {
+		provide.RenderError("impersonation tokens cannot be used to transfer ownership of organizations", 403, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	params := map[string]interface{}{}
+	err = json.Unmarshal(buf, &params)
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	userID, err := uuid.FromString(fmt.Sprintf("%v", params["user_id"]))
+	if err != nil {
+		provide.RenderError("user_id required", 422, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	org := &Organization{}
+	db.Where("id = ?", c.Param("id")).Find(&org)
+	if org.ID == uuid.Nil {
+		provide.RenderError("organization not found", 404, c)
+		return
+	}
+
+	sudo := bearer.HasPermission(common.Sudo)
+	if !sudo && (org.UserID == nil || *org.UserID != *bearer.UserID) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	if org.IsDeletionRequested() {
+		provide.RenderError("organization is pending deletion", 422, c)
+		return
+	}
+
+	nominee := user.Find(userID)
+	if nominee == nil || nominee.ID == uuid.Nil {
+		provide.RenderError("user not found", 404, c)
+		return
+	}
+
+	if nominee.IsDisabled() {
+		provide.RenderError("user has been suspended", 422, c)
+		return
+	}
+
+	var success bool
+	if sudo {
+		success = org.TransferOwnership(nil, nominee.ID)
+	} else {
+		success = org.RequestOwnershipTransfer(db, nominee)
+	}
+
+	if success {
+		provide.Render(nil, 204, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = org.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+// acceptOrganizationOwnershipTransferHandler accepts ownership of the organization on behalf of
+// the nominee to which the given ownership transfer token was vended
+func acceptOrganizationOwnershipTransferHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	if bearer == nil || bearer.UserID == nil || *bearer.UserID == uuid.Nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	if bearer.IsImpersonation() {
+		provide.RenderError("impersonation tokens cannot be used to accept ownership of organizations", 403, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	params := map[string]interface{}{}
+	err = json.Unmarshal(buf, &params)
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	rawToken, rawTokenOk := params["token"].(string)
+	if !rawTokenOk {
+		provide.RenderError("token required", 422, c)
+		return
+	}
+
+	transferToken, err := token.Parse(rawToken)
+	if err != nil {
+		provide.RenderError(fmt.Sprintf("invalid ownership transfer token; %s", err.Error()), 422, c)
+		return
+	}
+
+	if transferToken.Audience == nil || *transferToken.Audience != token.OwnershipTransferAudience {
+		provide.RenderError("invalid ownership transfer token", 422, c)
+		return
+	}
+
+	if transferToken.IsRevoked() {
+		provide.RenderError("ownership transfer token has been revoked", 422, c)
+		return
+	}
+
+	if transferToken.OrganizationID == nil || transferToken.OrganizationID.String() != c.Param("id") {
+		provide.RenderError("ownership transfer token organization_id did not match organization", 403, c)
+		return
+	}
+
+	if transferToken.UserID == nil || *transferToken.UserID != *bearer.UserID {
+		provide.RenderError("ownership transfer token user_id did not match authorized user", 403, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	org := &Organization{}
+	db.Where("id = ?", transferToken.OrganizationID).Find(&org)
+	if org.ID == uuid.Nil {
+		provide.RenderError("organization not found", 404, c)
+		return
+	}
+
+	if org.IsDeletionRequested() {
+		provide.RenderError("organization is pending deletion", 422, c)
+		return
+	}
+
+	// only the most recently-issued ownership transfer token may be accepted
+	if org.OwnershipTransferToken == nil || *org.OwnershipTransferToken != rawToken {
+		provide.RenderError("ownership transfer token has been superseded", 422, c)
+		return
+	}
+
+	ownerID, _ := transferToken.ParseData()["owner_id"].(string)
+	if org.UserID == nil || org.UserID.String() != ownerID {
+		provide.RenderError("ownership of the organization has changed since the ownership transfer was requested", 422, c)
+		return
+	}
+
+	if !org.hasMember(db, *bearer.UserID) {
+		provide.RenderError("ownership may only be transferred to a member of the organization", 422, c)
+		return
+	}
+
+	if org.TransferOwnership(nil, *bearer.UserID) {
+		if !transferToken.Revoke(nil) {
+			common.Log.Warningf("failed to revoke ownership transfer token for organization: %s", org.ID)
+		}
+		provide.Render(nil, 204, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = org.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+func organizationInvitationsListHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	userID := bearer.UserID
+	applicationID := bearer.ApplicationID
+	organizationID := bearer.OrganizationID
+
+	if (userID == nil || *userID == uuid.Nil) && (applicationID == nil || *applicationID == uuid.Nil) && (organizationID == nil || *organizationID == uuid.Nil) {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	orgID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	if organizationID != nil && organizationID.String() != orgID.String() {
+		provide.RenderError(err.Error(), 403, c)
+		return
+	}
+
+	org := &Organization{}
+	query := dbconf.DatabaseConnection()
+	resolveOrganization(query, &orgID, applicationID, userID).Find(&org)
+
+	if org == nil || org.ID == uuid.Nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	if c.Query("status") != "" && !user.IsValidInvitationStatus(c.Query("status")) {
+		provide.RenderError(fmt.Sprintf("invalid invitation status: %s", c.Query("status")), 422, c)
+		return
+	}
+
+	var invitations []*user.Invitation
+	query = user.FilterInvitations(org.InvitationsListQuery(query), c.Query("status"), strings.ToLower(c.Query("email")))
+	provide.Paginate(c, query, &user.Invitation{}).Find(&invitations)
+	provide.Render(invitations, 200, c)
+}
+
+// createOrganizationInvitationJobHandler invites users to the organization in bulk given CSV
+// or JSON rows; every row is validated before the job is queued for processing
+func createOrganizationInvitationJobHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	org := authorizeOrganizationAdministration(c, bearer)
+	if org == nil {
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	rows, err := ParseInvitationJobRows(c.ContentType(), buf)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	for _, row := range rows {
+		if (row.Permissions != nil || row.Role != nil) && !bearer.HasAnyExtendedPermission(organizationResourceKey, common.CreateResource, common.GrantResourceAuthorization) {
+			provide.RenderError("unable to assert arbitrary organization user permissions", 403, c)
+			return
+		}
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	grantorPermissions, grantorExtendedPermissions, isMember := org.grantablePermissions(db, bearer)
+	if !isMember {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	if !org.validateInvitationJobRows(db, rows, grantorPermissions, grantorExtendedPermissions) {
+		obj := map[string]interface{}{}
+		obj["errors"] = invitationJobRowErrors(rows)
+		obj["rows"] = rows
+		provide.Render(obj, 422, c)
+		return
+	}
+
+	job := &InvitationJob{
+		OrganizationID: &org.ID,
+		UserID:         bearer.UserID,
+	}
+	job.setRows(rows)
+
+	if job.Create(db) {
+		provide.Render(job, 202, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = job.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+func organizationInvitationJobDetailsHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	org := authorizeOrganizationAdministration(c, bearer)
+	if org == nil {
+		return
+	}
+
+	job := &InvitationJob{}
+	dbconf.DatabaseConnection().Where("id = ? AND organization_id = ?", c.Param("jobId"), org.ID).Find(&job)
+	if job == nil || job.ID == uuid.Nil {
+		provide.RenderError("invitation job not found", 404, c)
+		return
+	}
+
+	provide.Render(job, 200, c)
+}
+
+func organizationRolesListHandler(c *gin.Context) {
+	org := authorizeOrganizationAdministration(c, token.InContext(c))
+	if org == nil {
+		return
+	}
+
+	var roles []*role.Role
+	query := role.ListQuery(dbconf.DatabaseConnection(), nil, &org.ID).Order("roles.created_at ASC")
+	provide.Paginate(c, query, &role.Role{}).Find(&roles)
+	provide.Render(roles, 200, c)
+}
+
+func createOrganizationRoleHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	org := authorizeOrganizationAdministration(c, bearer)
+	if org == nil {
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	r := &role.Role{}
+	err = json.Unmarshal(buf, r)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	r.ApplicationID = nil
+	r.OrganizationID = &org.ID
+
+	if !authorizeRoleGrant(c, org, bearer, r) {
+		return
+	}
+
+	if r.Create(nil) {
+		provide.Render(r, 201, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = r.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+func updateOrganizationRoleHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	org := authorizeOrganizationAdministration(c, bearer)
+	if org == nil {
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	r := &role.Role{}
+	dbconf.DatabaseConnection().Where("id = ? AND organization_id = ?", c.Param("roleId"), org.ID).Find(&r)
+	if r == nil || r.ID == uuid.Nil {
+		provide.RenderError("role not found", 404, c)
+		return
+	}
+
+	roleID := r.ID
+	err = json.Unmarshal(buf, r)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	r.ID = roleID
+	r.ApplicationID = nil
+	r.OrganizationID = &org.ID
+
+	if !authorizeRoleGrant(c, org, bearer, r) {
+		return
+	}
+
+	if r.Update(nil) {
+		provide.Render(nil, 204, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = r.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+// authorizeRoleGrant returns true if the bearer may define the given role for the organization; the
+// permissions of a role are granted to each membership which references it, so a bearer cannot define
+// a role having permissions or extended permissions it is unable to grant; an error has been rendered
+// when false is returned
+func authorizeRoleGrant(c *gin.Context, org *Organization, bearer *token.Token, r *role.Role) bool {
+	grantorPermissions, grantorExtendedPermissions, isMember := org.grantablePermissions(dbconf.DatabaseConnection(), bearer)
+	if !isMember {
+		provide.RenderError("forbidden", 403, c)
+		return false
+	}
+
+	if err := role.ValidateGrant(r, r.Permissions, grantorPermissions, grantorExtendedPermissions); err != nil {
+		provide.RenderError(err.Error(), 403, c)
+		return false
+	}
+
+	return true
+}
+
+func deleteOrganizationRoleHandler(c *gin.Context) {
+	org := authorizeOrganizationAdministration(c, token.InContext(c))
+	if org == nil {
+		return
+	}
+
+	r := &role.Role{}
+	dbconf.DatabaseConnection().Where("id = ? AND organization_id = ?", c.Param("roleId"), org.ID).Find(&r)
+	if r == nil || r.ID == uuid.Nil {
+		provide.RenderError("role not found", 404, c)
+		return
+	}
+
+	if r.Delete(nil) {
+		provide.Render(nil, 204, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = r.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+func organizationDomainsListHandler(c *gin.Context) {
+	org := authorizeOrganizationAdministration(c, token.InContext(c))
+	if org == nil {
+		return
+	}
+
+	var domains []*Domain
+	query := org.DomainsListQuery(dbconf.DatabaseConnection())
+	provide.Paginate(c, query, &Domain{}).Find(&domains)
+	provide.Render(domains, 200, c)
+}
+
+// createOrganizationDomainHandler claims an email domain on behalf of the organization; the
+// response includes the verification token which must be published in a DNS TXT record
+func createOrganizationDomainHandler(c *gin.Context) {
+	org := authorizeOrganizationAdministration(c, token.InContext(c))
+	if org == nil {
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	params := map[string]interface{}{}
+	err = json.Unmarshal(buf, &params)
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
 		return
 	}
 
-	if bearer.UserID != nil && bearer.UserID.String() != org.UserID.String() { // FIXME-- this should be more than just org.UserID
-		provide.RenderError("forbidden", 403, c)
-		return
+	domain := &Domain{
+		OrganizationID: &org.ID,
 	}
-
-	err = json.Unmarshal(buf, org)
-	if err != nil {
-		provide.RenderError(err.Error(), 422, c)
-		return
+	if name, nameOk := params["domain"].(string); nameOk {
+		domain.Domain = common.StringOrNil(name)
 	}
 
-	if org.Update() {
-		provide.Render(nil, 204, c)
+	if domain.Create(nil) {
+		provide.Render(map[string]interface{}{
+			"id":                 domain.ID,
+			"created_at":         domain.CreatedAt,
+			"organization_id":    domain.OrganizationID,
+			"domain":             domain.Domain,
+			"verification_token": domain.VerificationToken,
+			"verification_record": map[string]interface{}{
+				"type":  "TXT",
+				"name":  domain.VerificationRecordName(),
+				"value": fmt.Sprintf("%s%s", domainVerificationValuePrefix, *domain.VerificationToken),
+			},
+			"verified_at": domain.VerifiedAt,
+		}, 201, c)
 	} else {
 		obj := map[string]interface{}{}
-		obj["errors"] = org.Errors
+		obj["errors"] = domain.Errors
 		provide.Render(obj, 422, c)
 	}
 }
 
-func deleteOrganizationHandler(c *gin.Context) {
-	provide.RenderError("not implemented", 501, c)
-}
-
-func organizationInvitationsListHandler(c *gin.Context) {
-	bearer := token.InContext(c)
-	userID := bearer.UserID
-	applicationID := bearer.ApplicationID
-	organizationID := bearer.OrganizationID
-
-	if (userID == nil || *userID == uuid.Nil) && (applicationID == nil || *applicationID == uuid.Nil) && (organizationID == nil || *organizationID == uuid.Nil) {
-		provide.RenderError("unauthorized", 401, c)
+func verifyOrganizationDomainHandler(c *gin.Context) {
+	org := authorizeOrganizationAdministration(c, token.InContext(c))
+	if org == nil {
 		return
 	}
 
-	orgID, err := uuid.FromString(c.Param("id"))
-	if err != nil {
-		provide.RenderError(err.Error(), 422, c)
+	domain := &Domain{}
+	dbconf.DatabaseConnection().Where("id = ? AND organization_id = ?", c.Param("domainId"), org.ID).Find(&domain)
+	if domain == nil || domain.ID == uuid.Nil {
+		provide.RenderError("domain not found", 404, c)
 		return
 	}
 
-	if organizationID != nil && organizationID.String() != orgID.String() {
-		provide.RenderError(err.Error(), 403, c)
-		return
+	if domain.Verify(nil) {
+		provide.Render(domain, 200, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = domain.Errors
+		provide.Render(obj, 422, c)
 	}
+}
 
-	org := &Organization{}
-	query := dbconf.DatabaseConnection()
-	resolveOrganization(query, &orgID, applicationID, userID).Find(&org)
+func deleteOrganizationDomainHandler(c *gin.Context) {
+	org := authorizeOrganizationAdministration(c, token.InContext(c))
+	if org == nil {
+		return
+	}
 
-	if org == nil || org.ID == uuid.Nil {
-		provide.RenderError("unauthorized", 401, c)
+	domain := &Domain{}
+	dbconf.DatabaseConnection().Where("id = ? AND organization_id = ?", c.Param("domainId"), org.ID).Find(&domain)
+	if domain == nil || domain.ID == uuid.Nil {
+		provide.RenderError("domain not found", 404, c)
 		return
 	}
 
-	invitations := org.pendingInvitations() // FIXME-- paginate the in-memory invitations list within redis
-	invitedUsers := make([]*user.User, 0)
-	for _, invite := range invitations {
-		usr := &user.User{
-			FirstName: invite.FirstName,
-			LastName:  invite.LastName,
-			Email:     invite.Email,
-		}
-		if invite.Permissions != nil {
-			usr.Permissions = *invite.Permissions
-		}
-		invitedUsers = append(invitedUsers, usr)
+	if domain.Delete(nil) {
+		provide.Render(nil, 204, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = domain.Errors
+		provide.Render(obj, 422, c)
 	}
-	provide.Render(invitedUsers, 200, c)
 }
 
 func organizationUsersListHandler(c *gin.Context) {
@@ -469,6 +1207,16 @@ func createOrganizationUserHandler(c *gin.Context) {
 		return
 	}
 
+	if usr.IsDisabled() {
+		provide.RenderError("user has been suspended", 422, c)
+		return
+	}
+
+	if !usr.IsEmailVerified() && user.OrganizationRequiresVerifiedEmail(db, org.ID) {
+		provide.RenderError("organization requires a verified email address", 422, c)
+		return
+	}
+
 	// CHECKME this all left as is (mostly, I assume the bearerUserID is the inviter, and the invite.UserID is the invitee)
 	// - could be dragons
 	var invite *user.Invite
@@ -497,25 +1245,52 @@ func createOrganizationUserHandler(c *gin.Context) {
 		}
 	}
 
+	var roleID *uuid.UUID
+
+	roleParam, roleParamOk := params["role"].(string)
 	orgPermissions, permissionsOk := params["permissions"].(common.Permission)
-	if permissionsOk && !bearer.HasAnyExtendedPermission(organizationResourceKey, common.CreateResource, common.GrantResourceAuthorization) {
+	if (roleParamOk || permissionsOk) && !bearer.HasAnyExtendedPermission(organizationResourceKey, common.CreateResource, common.GrantResourceAuthorization) {
 		provide.RenderError("unable to assert arbitrary organization user permissions", 403, c)
 		return
-	} else if permissionsOk {
-		permissions = orgPermissions
+	} else if roleParamOk || permissionsOk {
+		var orgRole *role.Role
+		if roleParamOk {
+			orgRole = role.Resolve(db, roleParam, nil, &org.ID)
+			if orgRole == nil {
+				provide.RenderError(fmt.Sprintf("role not found: %s", roleParam), 422, c)
+				return
+			}
+			permissions = orgRole.Permissions
+			roleID = &orgRole.ID
+		} else {
+			permissions = orgPermissions
+		}
+
+		grantorPermissions, grantorExtendedPermissions, isMember := org.grantablePermissions(db, bearer)
+		if !isMember {
+			provide.RenderError("forbidden", 403, c)
+			return
+		}
+
+		if err := role.ValidateGrant(orgRole, permissions, grantorPermissions, grantorExtendedPermissions); err != nil {
+			provide.RenderError(err.Error(), 403, c)
+			return
+		}
 	} else if invite != nil && invite.Permissions != nil {
 		permissions = *invite.Permissions
+		roleID = invite.RoleID
 	} else {
-		permissions = common.DefaultOrganizationUserPermission //CHECKME - easy to miss this one!
+		permissions = role.MemberPermission
+		roleID = role.BuiltinID(db, role.Member)
 	}
 
 	tx := db.Begin()
 
-	success := org.addUser(tx, *usr, permissions)
+	success := org.addUser(tx, *usr, permissions, roleID)
 	if success && invite != nil {
 		success = invite.Token.IsRevoked() || invite.Token.Revoke(tx)
 		if success {
-			invite.InvalidateCache()
+			success = invite.Accept(tx, &usr.ID) == nil
 		}
 	}
 
@@ -530,8 +1305,155 @@ func createOrganizationUserHandler(c *gin.Context) {
 	}
 }
 
+// updateOrganizationUserHandler updates the permissions or role of an organization membership;
+// the bearer is unable to grant permissions which it does not itself hold, the owner role is only
+// granted by transfer of ownership and only the organization owner may revoke the owner role, such
+// that the organization always retains an owner
 func updateOrganizationUserHandler(c *gin.Context) {
-	provide.RenderError("not implemented", 501, c)
+	bearer := token.InContext(c)
+	if bearer == nil || ((bearer.UserID == nil || *bearer.UserID == uuid.Nil) && (bearer.ApplicationID == nil || *bearer.ApplicationID == uuid.Nil) && (bearer.OrganizationID == nil || *bearer.OrganizationID == uuid.Nil)) {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	organizationID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	userID, err := uuid.FromString(c.Param("userId"))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	if bearer.OrganizationID != nil && *bearer.OrganizationID != organizationID {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	params := map[string]interface{}{}
+	err = json.Unmarshal(buf, &params)
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	roleParam, roleParamOk := params["role"].(string)
+	permissionsParam, permissionsOk := params["permissions"].(float64)
+	if roleParamOk == permissionsOk {
+		provide.RenderError("exactly one of role or permissions is required", 422, c)
+		return
+	}
+
+	revokeTokens, _ := params["revoke_tokens"].(bool)
+
+	db := dbconf.DatabaseConnection()
+
+	org := &Organization{}
+	resolveOrganization(db, &organizationID, bearer.ApplicationID, nil).Find(&org)
+	if org == nil || org.ID == uuid.Nil {
+		provide.RenderError("organization not found", 404, c)
+		return
+	}
+
+	usr := &user.User{}
+	db.Joins("JOIN organizations_users as ou ON ou.user_id = users.id").Where("ou.organization_id = ? AND users.id = ?", org.ID, userID).Find(&usr)
+	if usr == nil || usr.ID == uuid.Nil {
+		provide.RenderError("user not found", 404, c)
+		return
+	}
+
+	var orgRole *role.Role
+	var permissions common.Permission
+	if roleParamOk {
+		orgRole = role.Resolve(db, roleParam, nil, &org.ID)
+		if orgRole == nil {
+			provide.RenderError(fmt.Sprintf("role not found: %s", roleParam), 422, c)
+			return
+		}
+		permissions = orgRole.Permissions
+	} else {
+		permissions = common.Permission(permissionsParam)
+		if permissions&^role.ResourcePermissions != 0 {
+			provide.RenderError(fmt.Sprintf("permissions must be within the resource permissions mask: %d", role.ResourcePermissions), 422, c)
+			return
+		}
+	}
+
+	if org.UserID != nil && *org.UserID == usr.ID {
+		provide.RenderError("the membership of the organization owner cannot be updated; ownership must first be transferred", 422, c)
+		return
+	}
+
+	// only sudoers and the organization owner may demote members having the owner role
+	bearerIsOwner := bearer.HasPermission(common.Sudo) || (bearer.UserID != nil && org.UserID != nil && *bearer.UserID == *org.UserID)
+
+	grantorPermissions, grantorExtendedPermissions, isMember := org.grantablePermissions(db, bearer)
+	if !isMember || (bearer.UserID != nil && !bearerIsOwner && !grantorPermissions.Has(common.GrantResourceAuthorization)) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	if err := role.ValidateGrant(orgRole, permissions, grantorPermissions, grantorExtendedPermissions); err != nil {
+		provide.RenderError(err.Error(), 403, c)
+		return
+	}
+
+	ownerIDs := org.ownerIDs(db)
+	targetIsOwner := false
+	for _, ownerID := range ownerIDs {
+		if ownerID == usr.ID {
+			targetIsOwner = true
+		}
+	}
+
+	if targetIsOwner {
+		if !bearerIsOwner {
+			provide.RenderError("only the organization owner may revoke the owner role", 403, c)
+			return
+		}
+
+		if org.UserID == nil && len(ownerIDs) <= 1 {
+			provide.RenderError("the last owner of the organization cannot be demoted", 422, c)
+			return
+		}
+	}
+
+	tx := db.Begin()
+	defer tx.RollbackUnlessCommitted()
+
+	var success bool
+	if orgRole != nil {
+		success = org.updateUserRole(tx, usr, orgRole)
+	} else {
+		success = org.updateUser(tx, usr, permissions)
+	}
+
+	if !success {
+		obj := map[string]interface{}{}
+		obj["errors"] = org.Errors
+		provide.Render(obj, 422, c)
+		return
+	}
+
+	if revokeTokens {
+		err = token.RevokeOrganizationUserTokens(tx, org.ID, usr.ID)
+		if err != nil {
+			provide.RenderError(err.Error(), 500, c)
+			return
+		}
+	}
+
+	tx.Commit()
+	provide.Render(nil, 204, c)
 }
 
 // deleteOrganizationUserHandler deletes an organization user
@@ -687,6 +1609,16 @@ func deleteOrganizationUserHandler(c *gin.Context) {
 	}
 
 	if deleteRightsGranted {
+		if org.UserID != nil && *org.UserID == usr.ID {
+			provide.RenderError("the organization owner cannot be removed; ownership must first be transferred", 422, c)
+			return
+		}
+
+		if ownerIDs := org.ownerIDs(db); org.UserID == nil && len(ownerIDs) == 1 && ownerIDs[0] == usr.ID {
+			provide.RenderError("the last owner of the organization cannot be removed", 422, c)
+			return
+		}
+
 		if org.removeUser(db, usr) {
 			provide.Render(nil, 204, c)
 		} else {
@@ -838,3 +1770,144 @@ func organizationVaultSecretsListHandler(c *gin.Context) {
 	}
 	provide.Render(secrets, 200, c)
 }
+
+// authorizeOrganizationAdministration resolves the organization referenced by the id param if the
+// bearer is authorized to administer it; an error has been rendered when nil is returned
+func authorizeOrganizationAdministration(c *gin.Context, bearer *token.Token) *Organization {
+	if bearer == nil || ((bearer.UserID == nil || *bearer.UserID == uuid.Nil) && (bearer.OrganizationID == nil || *bearer.OrganizationID == uuid.Nil)) {
+		provide.RenderError("unauthorized", 401, c)
+		return nil
+	}
+
+	org := &Organization{}
+	dbconf.DatabaseConnection().Where("id = ?", c.Param("id")).Find(&org)
+	if org.ID == uuid.Nil {
+		provide.RenderError("organization not found", 404, c)
+		return nil
+	}
+
+	if bearer.HasPermission(common.Sudo) {
+		return org
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	// organization tokens which were not vended on behalf of a user administer the organization
+	// and its descendants; tokens vended on behalf of a user are authorized by the membership of
+	// the user, regardless of the organization asserted by the token
+	if bearer.UserID == nil || *bearer.UserID == uuid.Nil {
+		if bearer.OrganizationID != nil && (*bearer.OrganizationID == org.ID || org.IsDescendantOf(db, *bearer.OrganizationID)) {
+			return org
+		}
+
+		provide.RenderError("forbidden", 403, c)
+		return nil
+	}
+
+	// owners of the organization or any of its ancestors administer the organization
+	if org.IsAdministrator(db, *bearer.UserID) {
+		return org
+	}
+
+	permissions, _, isMember := role.EffectiveOrganizationPermissions(db, org.ID, *bearer.UserID)
+	if isMember && permissions&role.AdminPermission == role.AdminPermission {
+		return org
+	}
+
+	provide.RenderError("forbidden", 403, c)
+	return nil
+}
+
+func organizationSAMLProviderDetailsHandler(c *gin.Context) {
+	org := authorizeOrganizationAdministration(c, token.InContext(c))
+	if org == nil {
+		return
+	}
+
+	provider := &user.SAMLProvider{}
+	dbconf.DatabaseConnection().Where("organization_id = ?", org.ID).Find(&provider)
+	if provider.ID == uuid.Nil {
+		provide.RenderError("saml is not configured for organization", 404, c)
+		return
+	}
+
+	provider.Enrich()
+	provide.Render(provider, 200, c)
+}
+
+func updateOrganizationSAMLProviderHandler(c *gin.Context) {
+	org := authorizeOrganizationAdministration(c, token.InContext(c))
+	if org == nil {
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	params := map[string]interface{}{}
+	err = json.Unmarshal(buf, &params)
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	provider := &user.SAMLProvider{}
+	db.Where("organization_id = ?", org.ID).Find(&provider)
+	providerID := provider.ID
+	isNew := providerID == uuid.Nil
+
+	err = json.Unmarshal(buf, &provider)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+	provider.ID = providerID
+	provider.OrganizationID = &org.ID
+
+	if _, enabledOk := params["enabled"].(bool); isNew && !enabledOk {
+		provider.Enabled = true
+	}
+
+	if isNew {
+		if provider.Create(db) {
+			provide.Render(provider, 201, c)
+			return
+		}
+	} else if provider.Update(db) {
+		provide.Render(provider, 200, c)
+		return
+	}
+
+	obj := map[string]interface{}{}
+	obj["errors"] = provider.Errors
+	provide.Render(obj, 422, c)
+}
+
+func deleteOrganizationSAMLProviderHandler(c *gin.Context) {
+	org := authorizeOrganizationAdministration(c, token.InContext(c))
+	if org == nil {
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	provider := &user.SAMLProvider{}
+	db.Where("organization_id = ?", org.ID).Find(&provider)
+	if provider.ID == uuid.Nil {
+		provide.RenderError("saml is not configured for organization", 404, c)
+		return
+	}
+
+	if provider.Delete(db) {
+		provide.Render(nil, 204, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = provider.Errors
+		provide.Render(obj, 422, c)
+	}
+}
@@ -0,0 +1,184 @@
+package organization
+
+import (
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+	"github.com/provideplatform/ident/role"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+// organizationHierarchyLockID is the key of the transaction-level advisory lock which serializes
+// changes to the organization hierarchy such that concurrent changes cannot introduce a cycle
+const organizationHierarchyLockID = 0x6f7267
+
+// ancestorsSQL selects the id and depth of the given organization and each of its ancestors,
+// where the depth of the given organization is 0
+const ancestorsSQL = `WITH RECURSIVE ancestors AS (
+	SELECT id, parent_organization_id, 0 AS depth FROM organizations WHERE id = ?
+	UNION
+	SELECT o.id, o.parent_organization_id, a.depth + 1 FROM organizations o JOIN ancestors a ON o.id = a.parent_organization_id WHERE a.depth < ?
+) SELECT id, depth FROM ancestors`
+
+// descendantsSQL selects the id and depth of the given organization and each of its descendants,
+// where the depth of the given organization is 0
+const descendantsSQL = `WITH RECURSIVE descendants AS (
+	SELECT id, 0 AS depth FROM organizations WHERE id = ?
+	UNION
+	SELECT o.id, d.depth + 1 FROM organizations o JOIN descendants d ON o.parent_organization_id = d.id WHERE d.depth < ?
+) SELECT id, depth FROM descendants`
+
+// memberOrganizationsSQL selects the ids of the organizations of which the given user is a member,
+// either directly or by way of membership in an ancestor organization
+const memberOrganizationsSQL = `WITH RECURSIVE tree AS (
+	SELECT organization_id AS id, 0 AS depth FROM organizations_users WHERE user_id = ?
+	UNION
+	SELECT o.id, t.depth + 1 FROM organizations o JOIN tree t ON o.parent_organization_id = t.id WHERE t.depth < ?
+) SELECT id FROM tree`
+
+// lockHierarchy acquires the organization hierarchy advisory lock for the duration of the given transaction
+func lockHierarchy(tx *gorm.DB) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock(?)", organizationHierarchyLockID).Error
+}
+
+// ancestorIDs returns the ids of the ancestors of the organization with the given id, nearest first;
+// the given organization is not included
+func ancestorIDs(db *gorm.DB, orgID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := db.Raw(fmt.Sprintf("SELECT id FROM (%s) ancestors WHERE depth > 0 ORDER BY depth", ancestorsSQL), orgID, common.OrganizationHierarchyMaxDepth).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]uuid.UUID, 0)
+	for rows.Next() {
+		var id uuid.UUID
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// IsDescendantOf returns true if the organization is a descendant of the organization with the given id
+func (o *Organization) IsDescendantOf(db *gorm.DB, ancestorID uuid.UUID) bool {
+	if o.ParentOrganizationID == nil || ancestorID == o.ID {
+		return false
+	}
+
+	ancestors, err := ancestorIDs(db, o.ID)
+	if err != nil {
+		common.Log.Warningf("failed to resolve ancestors of organization: %s; %s", o.ID, err.Error())
+		return false
+	}
+
+	for _, id := range ancestors {
+		if id == ancestorID {
+			return true
+		}
+	}
+	return false
+}
+
+// DescendantsQuery returns a query for the descendants of the organization
+func (o *Organization) DescendantsQuery(db *gorm.DB) *gorm.DB {
+	return db.Where(
+		fmt.Sprintf("organizations.id IN (SELECT id FROM (%s) descendants WHERE depth > 0)", descendantsSQL),
+		o.ID,
+		common.OrganizationHierarchyMaxDepth,
+	)
+}
+
+// validateParent ensures the parent organization exists and that assigning it neither introduces
+// a cycle into the organization hierarchy nor exceeds its maximum depth; callers must hold the
+// organization hierarchy lock
+func (o *Organization) validateParent(db *gorm.DB) bool {
+	if o.ParentOrganizationID == nil {
+		return true
+	}
+
+	if *o.ParentOrganizationID == o.ID {
+		o.Errors = append(o.Errors, &provide.Error{
+			Message: common.StringOrNil("organization cannot be its own parent"),
+		})
+		return false
+	}
+
+	var parents uint64
+	db.Table("organizations").Where("id = ?", o.ParentOrganizationID).Count(&parents)
+	if parents == 0 {
+		o.Errors = append(o.Errors, &provide.Error{
+			Message: common.StringOrNil("parent organization not found"),
+		})
+		return false
+	}
+
+	ancestors, err := ancestorIDs(db, *o.ParentOrganizationID)
+	if err != nil {
+		o.Errors = append(o.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("failed to resolve ancestors of parent organization; %s", err.Error())),
+		})
+		return false
+	}
+
+	for _, id := range ancestors {
+		if id == o.ID {
+			o.Errors = append(o.Errors, &provide.Error{
+				Message: common.StringOrNil("parent organization cannot be a descendant of the organization"),
+			})
+			return false
+		}
+	}
+
+	// the depth of the hierarchy is that of the parent, the organization and its deepest descendant
+	height := 0
+	if o.ID != uuid.Nil {
+		err = db.Raw(fmt.Sprintf("SELECT COALESCE(MAX(depth), 0) FROM (%s) descendants", descendantsSQL), o.ID, common.OrganizationHierarchyMaxDepth).Row().Scan(&height)
+		if err != nil {
+			o.Errors = append(o.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("failed to resolve descendants of organization; %s", err.Error())),
+			})
+			return false
+		}
+	}
+
+	if len(ancestors)+2+height > common.OrganizationHierarchyMaxDepth {
+		o.Errors = append(o.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("organization hierarchy cannot exceed a depth of %d", common.OrganizationHierarchyMaxDepth)),
+		})
+		return false
+	}
+
+	return true
+}
+
+// IsAdministrator returns true if the given user owns the organization or any of its ancestors
+func (o *Organization) IsAdministrator(db *gorm.DB, userID uuid.UUID) bool {
+	if o.UserID != nil && *o.UserID == userID {
+		return true
+	}
+
+	var owned uint64
+	err := db.Raw(
+		fmt.Sprintf(`SELECT COUNT(*) FROM (%s) a JOIN organizations o ON o.id = a.id
+		WHERE o.user_id = ? OR EXISTS (
+			SELECT 1 FROM organizations_users m JOIN roles r ON r.id = m.role_id
+			WHERE m.organization_id = a.id AND m.user_id = ? AND r.name = ? AND r.application_id IS NULL AND r.organization_id IS NULL
+		)`, ancestorsSQL),
+		o.ID,
+		common.OrganizationHierarchyMaxDepth,
+		userID,
+		userID,
+		role.Owner,
+	).Row().Scan(&owned)
+	if err != nil {
+		common.Log.Warningf("failed to resolve administrators of organization: %s; %s", o.ID, err.Error())
+		return false
+	}
+	return owned > 0
+}
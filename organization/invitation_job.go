@@ -0,0 +1,446 @@
+package organization
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/badoux/checkmail"
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	natsutil "github.com/kthomas/go-natsutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+	"github.com/provideplatform/ident/role"
+	"github.com/provideplatform/ident/user"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+const natsOrganizationInvitationJobSubject = "ident.organization.invitation.job"
+
+// maxInvitationJobRows is the maximum number of rows which may be given in a single bulk invitation
+const maxInvitationJobRows = 1000
+
+// InvitationJobStatusPending is the status of an invitation job which has been queued for processing
+const InvitationJobStatusPending = "pending"
+
+// InvitationJobStatusProcessing is the status of an invitation job which is being processed
+const InvitationJobStatusProcessing = "processing"
+
+// InvitationJobStatusCompleted is the status of an invitation job for which every row has been processed
+const InvitationJobStatusCompleted = "completed"
+
+// invitationJobRowActionInvite is the action of a row on behalf of which an invitation is sent
+const invitationJobRowActionInvite = "invite"
+
+// invitationJobRowActionAdd is the action of a row for which a user already exists; the existing
+// user is added to the organization directly
+const invitationJobRowActionAdd = "add"
+
+const invitationJobRowStatusPending = "pending"
+const invitationJobRowStatusInvited = "invited"
+const invitationJobRowStatusAdded = "added"
+const invitationJobRowStatusFailed = "failed"
+
+// InvitationJob is a bulk invitation of users to an organization which is processed asynchronously
+type InvitationJob struct {
+	provide.Model
+	OrganizationID *uuid.UUID       `sql:"not null;type:uuid" json:"organization_id"`
+	UserID         *uuid.UUID       `sql:"type:uuid" json:"user_id,omitempty"`
+	Status         *string          `sql:"not null" json:"status"`
+	Rows           *json.RawMessage `sql:"type:json" json:"rows"`
+	CompletedAt    *time.Time       `json:"completed_at,omitempty"`
+}
+
+// InvitationJobRow is a single row of a bulk invitation and the result of processing it
+type InvitationJobRow struct {
+	Row          int                `json:"row"`
+	Email        *string            `json:"email"`
+	FirstName    *string            `json:"first_name,omitempty"`
+	LastName     *string            `json:"last_name,omitempty"`
+	Permissions  *common.Permission `json:"permissions,omitempty"`
+	Role         *string            `json:"role,omitempty"`
+	RoleID       *uuid.UUID         `json:"role_id,omitempty"`
+	Action       *string            `json:"action,omitempty"`
+	Status       *string            `json:"status,omitempty"`
+	InvitationID *uuid.UUID         `json:"invitation_id,omitempty"`
+	UserID       *uuid.UUID         `json:"user_id,omitempty"`
+	Errors       []*provide.Error   `json:"errors,omitempty"`
+}
+
+// TableName returns the db table name for gorm
+func (j *InvitationJob) TableName() string {
+	return "invitation_jobs"
+}
+
+// ParseInvitationJobRows parses the rows of a bulk invitation from a CSV document having a
+// header row, or from a JSON array (optionally given as the rows of a JSON object); the
+// email, first_name, last_name, role and permissions columns (or keys) are recognized
+func ParseInvitationJobRows(contentType string, buf []byte) ([]*InvitationJobRow, error) {
+	var rows []*InvitationJobRow
+
+	if strings.HasPrefix(contentType, "text/csv") {
+		reader := csv.NewReader(strings.NewReader(string(buf)))
+		reader.TrimLeadingSpace = true
+
+		header, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read csv header; %s", err.Error())
+		}
+
+		columns := map[string]int{}
+		for i, column := range header {
+			columns[strings.ToLower(strings.TrimSpace(column))] = i
+		}
+		if _, emailOk := columns["email"]; !emailOk {
+			return nil, errors.New("csv header must include an email column")
+		}
+
+		value := func(record []string, column string) *string {
+			if i, ok := columns[column]; ok && i < len(record) && strings.TrimSpace(record[i]) != "" {
+				return common.StringOrNil(strings.TrimSpace(record[i]))
+			}
+			return nil
+		}
+
+		rows = make([]*InvitationJobRow, 0)
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("failed to read csv; %s", err.Error())
+			}
+
+			row := &InvitationJobRow{
+				Row:       len(rows) + 1,
+				Email:     value(record, "email"),
+				FirstName: value(record, "first_name"),
+				LastName:  value(record, "last_name"),
+				Role:      value(record, "role"),
+			}
+
+			if permissions := value(record, "permissions"); permissions != nil {
+				val, err := strconv.ParseUint(*permissions, 10, 32)
+				if err != nil {
+					row.Errors = append(row.Errors, &provide.Error{
+						Message: common.StringOrNil(fmt.Sprintf("invalid permissions: %s", *permissions)),
+					})
+				} else {
+					perm := common.Permission(val)
+					row.Permissions = &perm
+				}
+			}
+
+			rows = append(rows, row)
+		}
+	} else {
+		var err error
+		if trimmed := strings.TrimSpace(string(buf)); strings.HasPrefix(trimmed, "{") {
+			params := struct {
+				Rows []*InvitationJobRow `json:"rows"`
+			}{}
+			err = json.Unmarshal(buf, &params)
+			rows = params.Rows
+		} else {
+			err = json.Unmarshal(buf, &rows)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse bulk invitation rows; %s", err.Error())
+		}
+
+		for i, row := range rows {
+			if row == nil {
+				return nil, fmt.Errorf("invalid bulk invitation row: %d", i+1)
+			}
+			row.Row = i + 1
+			row.RoleID = nil
+			row.Action = nil
+			row.Status = nil
+			row.InvitationID = nil
+			row.UserID = nil
+			row.Errors = nil
+		}
+	}
+
+	if len(rows) == 0 {
+		return nil, errors.New("at least one row is required")
+	} else if len(rows) > maxInvitationJobRows {
+		return nil, fmt.Errorf("at most %d rows may be given", maxInvitationJobRows)
+	}
+
+	return rows, nil
+}
+
+// validateInvitationJobRows validates every row of a bulk invitation to the organization and
+// resolves whether an invitation is sent or an existing user is added on behalf of each row; the
+// role or permissions of each row must be grantable given the permissions and extended permissions
+// of the grantor;
+// returns false if any row is invalid, in which case the errors are set on the invalid rows
+func (o *Organization) validateInvitationJobRows(db *gorm.DB, rows []*InvitationJobRow, grantorPermissions common.Permission, grantorExtendedPermissions map[string]common.Permission) bool {
+	valid := true
+	emails := map[string]int{}
+
+	for _, row := range rows {
+		if row.Email == nil || strings.TrimSpace(*row.Email) == "" {
+			row.Errors = append(row.Errors, &provide.Error{
+				Message: common.StringOrNil("email address is required"),
+			})
+		} else {
+			row.Email = common.StringOrNil(strings.ToLower(strings.TrimSpace(*row.Email)))
+
+			if err := checkmail.ValidateFormat(*row.Email); err != nil {
+				row.Errors = append(row.Errors, &provide.Error{
+					Message: common.StringOrNil(fmt.Sprintf("invalid email address: %s; %s", *row.Email, err.Error())),
+				})
+			} else if prev, prevOk := emails[*row.Email]; prevOk {
+				row.Errors = append(row.Errors, &provide.Error{
+					Message: common.StringOrNil(fmt.Sprintf("duplicate email address: %s; see row %d", *row.Email, prev)),
+				})
+			} else {
+				emails[*row.Email] = row.Row
+			}
+		}
+
+		if row.Role != nil {
+			if row.Permissions != nil {
+				row.Errors = append(row.Errors, &provide.Error{
+					Message: common.StringOrNil("only one of role or permissions may be given"),
+				})
+			} else if orgRole := role.Resolve(db, *row.Role, nil, &o.ID); orgRole == nil {
+				row.Errors = append(row.Errors, &provide.Error{
+					Message: common.StringOrNil(fmt.Sprintf("role not found: %s", *row.Role)),
+				})
+			} else if err := role.ValidateGrant(orgRole, orgRole.Permissions, grantorPermissions, grantorExtendedPermissions); err != nil {
+				row.Errors = append(row.Errors, &provide.Error{
+					Message: common.StringOrNil(err.Error()),
+				})
+			} else {
+				row.RoleID = &orgRole.ID
+				row.Permissions = &orgRole.Permissions
+			}
+		} else if row.Permissions != nil {
+			if err := role.ValidateGrant(nil, *row.Permissions, grantorPermissions, grantorExtendedPermissions); err != nil {
+				row.Errors = append(row.Errors, &provide.Error{
+					Message: common.StringOrNil(err.Error()),
+				})
+			}
+		}
+
+		if len(row.Errors) == 0 {
+			if usr := user.FindByEmail(*row.Email, nil, nil); usr != nil {
+				var members uint64
+				db.Table("organizations_users").Where("organization_id = ? AND user_id = ?", o.ID, usr.ID).Count(&members)
+
+				if members > 0 {
+					row.Errors = append(row.Errors, &provide.Error{
+						Message: common.StringOrNil(fmt.Sprintf("user is already a member of the organization: %s", *row.Email)),
+					})
+				} else if usr.IsDisabled() {
+					row.Errors = append(row.Errors, &provide.Error{
+						Message: common.StringOrNil(fmt.Sprintf("user has been suspended: %s", *row.Email)),
+					})
+				} else if !usr.IsEmailVerified() && user.OrganizationRequiresVerifiedEmail(db, o.ID) {
+					row.Errors = append(row.Errors, &provide.Error{
+						Message: common.StringOrNil(fmt.Sprintf("organization requires a verified email address: %s", *row.Email)),
+					})
+				} else {
+					row.Action = common.StringOrNil(invitationJobRowActionAdd)
+					row.UserID = &usr.ID
+				}
+			} else {
+				row.Action = common.StringOrNil(invitationJobRowActionInvite)
+			}
+		}
+
+		if len(row.Errors) > 0 {
+			row.Status = common.StringOrNil(invitationJobRowStatusFailed)
+			valid = false
+		} else {
+			row.Status = common.StringOrNil(invitationJobRowStatusPending)
+		}
+	}
+
+	return valid
+}
+
+// invitationJobRowErrors returns the errors of each invalid row, prefixed by the row number
+func invitationJobRowErrors(rows []*InvitationJobRow) []*provide.Error {
+	errors := make([]*provide.Error, 0)
+	for _, row := range rows {
+		for _, err := range row.Errors {
+			errors = append(errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("row %d: %s", row.Row, *err.Message)),
+			})
+		}
+	}
+	return errors
+}
+
+// ParseRows parses the rows of the invitation job
+func (j *InvitationJob) ParseRows() []*InvitationJobRow {
+	rows := make([]*InvitationJobRow, 0)
+	if j.Rows != nil {
+		err := json.Unmarshal(*j.Rows, &rows)
+		if err != nil {
+			common.Log.Warningf("failed to unmarshal invitation job rows; %s", err.Error())
+			return nil
+		}
+	}
+	return rows
+}
+
+func (j *InvitationJob) setRows(rows []*InvitationJobRow) {
+	rowsJSON, _ := json.Marshal(rows)
+	_rowsJSON := json.RawMessage(rowsJSON)
+	j.Rows = &_rowsJSON
+}
+
+// Create and persist the invitation job and queue it for processing
+func (j *InvitationJob) Create(tx *gorm.DB) bool {
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+	}
+
+	j.Status = common.StringOrNil(InvitationJobStatusPending)
+
+	if db.NewRecord(j) {
+		result := db.Create(&j)
+		rowsAffected := result.RowsAffected
+		errors := result.GetErrors()
+		if len(errors) > 0 {
+			for _, err := range errors {
+				j.Errors = append(j.Errors, &provide.Error{
+					Message: common.StringOrNil(err.Error()),
+				})
+			}
+		}
+
+		if rowsAffected > 0 && len(j.Errors) == 0 {
+			payload, _ := json.Marshal(map[string]interface{}{
+				"invitation_job_id": j.ID.String(),
+			})
+			natsutil.NatsJetstreamPublish(natsOrganizationInvitationJobSubject, payload)
+			common.Log.Debugf("queued invitation job %s for organization: %s", j.ID, j.OrganizationID)
+			return true
+		}
+	}
+
+	return false
+}
+
+// process each pending row of the invitation job; the result of each row is persisted as soon
+// as it has been processed, so rows are not processed again if the job is redelivered; only a
+// pending job is claimed, so a job is never processed by concurrent consumers
+func (j *InvitationJob) process(db *gorm.DB) error {
+	org := &Organization{}
+	db.Where("id = ?", j.OrganizationID).Find(&org)
+	if org == nil || org.ID == uuid.Nil {
+		return fmt.Errorf("organization not found: %s", j.OrganizationID)
+	}
+
+	var invitor *user.User
+	if j.UserID != nil {
+		invitor = user.Find(*j.UserID)
+	}
+
+	result := db.Model(j).Where("status = ?", InvitationJobStatusPending).Update("status", InvitationJobStatusProcessing)
+	if result.Error != nil {
+		return fmt.Errorf("failed to claim invitation job: %s; %s", j.ID, result.Error.Error())
+	} else if result.RowsAffected != 1 {
+		common.Log.Debugf("invitation job already claimed or completed: %s", j.ID)
+		return nil
+	}
+
+	rows := j.ParseRows()
+	for _, row := range rows {
+		if row.Status == nil || *row.Status != invitationJobRowStatusPending {
+			continue
+		}
+
+		permissions := role.MemberPermission
+		roleID := row.RoleID
+		if row.Permissions != nil {
+			permissions = *row.Permissions
+		} else {
+			roleID = role.BuiltinID(db, role.Member)
+		}
+
+		if row.Action != nil && *row.Action == invitationJobRowActionAdd && row.UserID != nil {
+			usr := user.Find(*row.UserID)
+			org.Errors = make([]*provide.Error, 0)
+			if usr != nil && org.addUser(db, *usr, permissions, roleID) {
+				row.Status = common.StringOrNil(invitationJobRowStatusAdded)
+			} else {
+				row.Status = common.StringOrNil(invitationJobRowStatusFailed)
+				row.Errors = append(row.Errors, org.Errors...)
+				if usr == nil {
+					row.Errors = append(row.Errors, &provide.Error{
+						Message: common.StringOrNil("user not found"),
+					})
+				}
+			}
+		} else {
+			invite := &user.Invite{
+				Email:            row.Email,
+				FirstName:        row.FirstName,
+				LastName:         row.LastName,
+				OrganizationID:   &org.ID,
+				OrganizationName: org.Name,
+				Permissions:      &permissions,
+				RoleID:           roleID,
+			}
+			if invitor != nil {
+				invite.InvitorID = &invitor.ID
+				invite.InvitorName = invitor.FullName()
+			}
+
+			if invite.Create() {
+				row.Status = common.StringOrNil(invitationJobRowStatusInvited)
+				row.InvitationID = &invite.Invitation.ID
+			} else {
+				row.Status = common.StringOrNil(invitationJobRowStatusFailed)
+				row.Errors = append(row.Errors, invite.Errors...)
+			}
+		}
+
+		j.setRows(rows)
+		result := db.Model(j).Update("rows", j.Rows)
+		if result.Error != nil {
+			j.release(db)
+			return fmt.Errorf("failed to persist result of row %d of invitation job: %s; %s", row.Row, j.ID, result.Error.Error())
+		}
+	}
+
+	completedAt := time.Now()
+	result = db.Model(j).Updates(map[string]interface{}{
+		"status":       InvitationJobStatusCompleted,
+		"completed_at": completedAt,
+	})
+	if result.Error != nil {
+		j.release(db)
+		return fmt.Errorf("failed to complete invitation job: %s; %s", j.ID, result.Error.Error())
+	}
+
+	j.Status = common.StringOrNil(InvitationJobStatusCompleted)
+	j.CompletedAt = &completedAt
+	common.Log.Debugf("completed invitation job %s for organization: %s", j.ID, org.ID)
+	return nil
+}
+
+// release the claim on the invitation job, so the remaining pending rows are processed when the
+// job is redelivered
+func (j *InvitationJob) release(db *gorm.DB) {
+	result := db.Model(j).Where("status = ?", InvitationJobStatusProcessing).Update("status", InvitationJobStatusPending)
+	if result.Error != nil {
+		common.Log.Warningf("failed to release invitation job: %s; %s", j.ID, result.Error.Error())
+	}
+}
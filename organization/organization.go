@@ -2,14 +2,15 @@ package organization
 
 import (
 	"encoding/json"
-	"fmt"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	dbconf "github.com/kthomas/go-db-config"
 	natsutil "github.com/kthomas/go-natsutil"
-	redisutil "github.com/kthomas/go-redisutil"
 	uuid "github.com/kthomas/go.uuid"
 	"github.com/provideplatform/ident/common"
+	"github.com/provideplatform/ident/role"
+	"github.com/provideplatform/ident/token"
 	"github.com/provideplatform/ident/user"
 	provide "github.com/provideplatform/provide-go/api"
 )
@@ -27,6 +28,15 @@ type Organization struct {
 	Permissions common.Permission `sql:"not null" json:"permissions,omitempty"`
 	Metadata    *json.RawMessage  `sql:"type:json" json:"metadata"`
 
+	ParentOrganizationID *uuid.UUID `sql:"type:uuid" json:"parent_organization_id,omitempty"`
+
+	DisabledAt     *time.Time `json:"disabled_at,omitempty"`
+	DisabledReason *string    `json:"disabled_reason,omitempty"`
+
+	DeletionRequestedAt *time.Time `json:"deletion_requested_at,omitempty"`
+
+	OwnershipTransferToken *string `json:"-"`
+
 	Users []*user.User `gorm:"many2many:organizations_users" json:"-"`
 }
 
@@ -70,7 +80,7 @@ func (o *Organization) addApplicationAssociation(tx *gorm.DB, appID uuid.UUID, p
 	return success
 }
 
-func (o *Organization) addUser(tx *gorm.DB, usr user.User, permissions common.Permission) bool {
+func (o *Organization) addUser(tx *gorm.DB, usr user.User, permissions common.Permission, roleID *uuid.UUID) bool {
 	var db *gorm.DB
 	if tx != nil {
 		db = tx
@@ -79,7 +89,7 @@ func (o *Organization) addUser(tx *gorm.DB, usr user.User, permissions common.Pe
 	}
 
 	common.Log.Debugf("adding user %s to organization: %s", usr.ID, o.ID)
-	result := db.Exec("INSERT INTO organizations_users (organization_id, user_id, permissions) VALUES (?, ?, ?)", o.ID, usr.ID, permissions)
+	result := db.Exec("INSERT INTO organizations_users (organization_id, user_id, permissions, role_id) VALUES (?, ?, ?, ?)", o.ID, usr.ID, permissions, roleID)
 	success := result.RowsAffected == 1
 	if success {
 		common.Log.Debugf("added user %s to organization: %s", usr.ID, o.ID)
@@ -124,6 +134,8 @@ func (o *Organization) removeUser(tx *gorm.DB, usr *user.User) bool {
 	return success
 }
 
+// updateUser sets the permissions of the membership of the given user; the membership no
+// longer references a role, as its permissions are no longer those of the role
 func (o *Organization) updateUser(tx *gorm.DB, usr *user.User, permissions common.Permission) bool {
 	var db *gorm.DB
 	if tx != nil {
@@ -133,12 +145,40 @@ func (o *Organization) updateUser(tx *gorm.DB, usr *user.User, permissions commo
 	}
 
 	common.Log.Debugf("updating user %s for organization: %s", usr.ID, o.ID)
-	result := db.Exec("UPDATE organizations_users SET permissions = ? WHERE organization_id = ? AND user_id = ?", permissions, o.ID, usr.ID)
+	result := db.Exec("UPDATE organizations_users SET permissions = ?, role_id = NULL WHERE organization_id = ? AND user_id = ?", permissions, o.ID, usr.ID)
 	success := result.RowsAffected == 1
 	if success {
 		common.Log.Debugf("updated user %s for organization: %s", usr.ID, o.ID)
 	} else {
-		common.Log.Warningf("failed to update user %s for organization: %s; %s", usr.ID, o.ID, result.Error.Error())
+		common.Log.Warningf("failed to update user %s for organization: %s", usr.ID, o.ID)
+		errors := result.GetErrors()
+		if len(errors) > 0 {
+			for _, err := range errors {
+				o.Errors = append(o.Errors, &provide.Error{
+					Message: common.StringOrNil(err.Error()),
+				})
+			}
+		}
+	}
+	return success
+}
+
+// updateUserRole sets the role of the membership of the given user
+func (o *Organization) updateUserRole(tx *gorm.DB, usr *user.User, r *role.Role) bool {
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+	}
+
+	common.Log.Debugf("updating role of user %s for organization: %s", usr.ID, o.ID)
+	result := db.Exec("UPDATE organizations_users SET permissions = ?, role_id = ? WHERE organization_id = ? AND user_id = ?", r.Permissions, r.ID, o.ID, usr.ID)
+	success := result.RowsAffected == 1
+	if success {
+		common.Log.Debugf("updated role of user %s for organization: %s; role: %s", usr.ID, o.ID, *r.Name)
+	} else {
+		common.Log.Warningf("failed to update role of user %s for organization: %s", usr.ID, o.ID)
 		errors := result.GetErrors()
 		if len(errors) > 0 {
 			for _, err := range errors {
@@ -151,6 +191,44 @@ func (o *Organization) updateUser(tx *gorm.DB, usr *user.User, permissions commo
 	return success
 }
 
+// ownerIDs returns the ids of the members of the organization having the builtin owner role
+func (o *Organization) ownerIDs(db *gorm.DB) []uuid.UUID {
+	ownerIDs := make([]uuid.UUID, 0)
+	ownerRoleID := role.BuiltinID(db, role.Owner)
+	if ownerRoleID == nil {
+		return ownerIDs
+	}
+
+	db.Table("organizations_users").Where("organization_id = ? AND role_id = ?", o.ID, ownerRoleID).Pluck("user_id", &ownerIDs)
+	return ownerIDs
+}
+
+// grantablePermissions returns the permission mask and extended permissions which the given bearer
+// may grant to members of the organization; sudoers may grant any resource permissions, owners the
+// owner permissions, other users the permissions of their membership and application or organization
+// bearers the resource permissions asserted by the bearer; the extended permissions of sudoers and
+// owners are unrestricted, in which case they are nil; false is returned if the bearer user is not
+// a member
+func (o *Organization) grantablePermissions(db *gorm.DB, bearer *token.Token) (common.Permission, map[string]common.Permission, bool) {
+	if bearer.HasPermission(common.Sudo) {
+		return role.ResourcePermissions, nil, true
+	}
+
+	if bearer.UserID == nil || *bearer.UserID == uuid.Nil {
+		extendedPermissions := bearer.ParseExtendedPermissions()
+		if extendedPermissions == nil {
+			extendedPermissions = map[string]common.Permission{}
+		}
+		return bearer.Permissions & role.ResourcePermissions, extendedPermissions, true
+	}
+
+	if o.IsAdministrator(db, *bearer.UserID) {
+		return role.OwnerPermission, nil, true
+	}
+
+	return role.EffectiveOrganizationPermissions(db, o.ID, *bearer.UserID)
+}
+
 // Create and persist a user
 func (o *Organization) Create(tx *gorm.DB) bool {
 	var db *gorm.DB
@@ -166,6 +244,20 @@ func (o *Organization) Create(tx *gorm.DB) bool {
 		return false
 	}
 
+	if o.ParentOrganizationID != nil {
+		err := lockHierarchy(db)
+		if err != nil {
+			o.Errors = append(o.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+			return false
+		}
+
+		if !o.validateParent(db) {
+			return false
+		}
+	}
+
 	if db.NewRecord(o) {
 		result := db.Create(&o)
 		rowsAffected := result.RowsAffected
@@ -186,7 +278,7 @@ func (o *Organization) Create(tx *gorm.DB) bool {
 					usr := &user.User{}
 					db.Where("id = ?", o.UserID).Find(&usr)
 					if usr != nil && usr.ID != uuid.Nil {
-						if o.addUser(db, *usr, common.DefaultApplicationResourcePermission) {
+						if o.addUser(db, *usr, role.OwnerPermission, role.BuiltinID(db, role.Owner)) {
 							common.Log.Debugf("associated user %s with organization: %s", *usr.FullName(), *o.Name)
 							if tx == nil {
 								db.Commit()
@@ -215,19 +307,9 @@ func (o *Organization) Create(tx *gorm.DB) bool {
 	return false
 }
 
-// pendingInvitations returns the pending invitations for the organization; these are ephemeral, in-memory only
-func (o *Organization) pendingInvitations() []*user.Invite {
-	var invitations []*user.Invite
-
-	key := fmt.Sprintf("organization.%s.invitations", o.ID.String())
-	rawinvites, err := redisutil.Get(key)
-	if err != nil {
-		common.Log.Debugf("failed to retrieve cached organization invitations from key: %s; %s", key, err.Error())
-		return invitations
-	}
-
-	json.Unmarshal([]byte(*rawinvites), &invitations)
-	return invitations
+// InvitationsListQuery returns a query for the invitations to the organization
+func (o *Organization) InvitationsListQuery(db *gorm.DB) *gorm.DB {
+	return db.Where("invitations.organization_id = ?", o.ID)
 }
 
 // Update an existing user
@@ -239,6 +321,22 @@ func (o *Organization) Update() bool {
 	}
 
 	tx := db.Begin()
+	defer tx.RollbackUnlessCommitted()
+
+	if o.ParentOrganizationID != nil {
+		err := lockHierarchy(tx)
+		if err != nil {
+			o.Errors = append(o.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+			return false
+		}
+
+		if !o.validateParent(tx) {
+			return false
+		}
+	}
+
 	result := tx.Save(&o)
 	success := result.RowsAffected > 0
 	errors := result.GetErrors()
@@ -283,25 +381,89 @@ func (o *Organization) validate() bool {
 	return len(o.Errors) == 0
 }
 
-// Delete an organization
-func (o *Organization) Delete() bool {
-	db := dbconf.DatabaseConnection()
-	tx := db.Begin()
-	result := tx.Delete(&o)
-	errors := result.GetErrors()
-	if len(errors) > 0 {
-		for _, err := range errors {
-			o.Errors = append(o.Errors, &provide.Error{
-				Message: common.StringOrNil(err.Error()),
-			})
-		}
+// IsDisabled returns true if the organization has been suspended
+func (o *Organization) IsDisabled() bool {
+	return o.DisabledAt != nil
+}
+
+// Suspend the organization; all outstanding tokens issued on behalf of the organization are
+// revoked and its memberships are retained so the organization can subsequently be reactivated
+func (o *Organization) Suspend(tx *gorm.DB, reason *string) bool {
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+		db = db.Begin()
+		defer db.RollbackUnlessCommitted()
 	}
-	success := len(o.Errors) == 0
-	if success && common.Auth0IntegrationEnabled {
-		common.Log.Debugf("deleted organization: %s", *o.Name)
+
+	disabledAt := time.Now()
+	result := db.Model(o).Updates(map[string]interface{}{
+		"enabled":         false,
+		"disabled_at":     disabledAt,
+		"disabled_reason": reason,
+	})
+	if result.Error != nil {
+		o.Errors = append(o.Errors, &provide.Error{
+			Message: common.StringOrNil(result.Error.Error()),
+		})
+		return false
 	}
-	tx.Commit()
-	return success
+
+	err := token.RevokeOrganizationTokens(db, o.ID)
+	if err != nil {
+		o.Errors = append(o.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+		return false
+	}
+
+	if tx == nil {
+		db.Commit()
+	}
+
+	o.DisabledAt = &disabledAt
+	o.DisabledReason = reason
+	common.Log.Debugf("suspended organization: %s", o.ID)
+	return true
+}
+
+// Reactivate a previously-suspended organization; reactivating an organization which is
+// pending deletion cancels its deletion
+func (o *Organization) Reactivate(tx *gorm.DB) bool {
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+	}
+
+	if !o.IsDisabled() {
+		o.Errors = append(o.Errors, &provide.Error{
+			Message: common.StringOrNil("organization is not suspended"),
+		})
+		return false
+	}
+
+	result := db.Model(o).Updates(map[string]interface{}{
+		"enabled":               true,
+		"disabled_at":           gorm.Expr("NULL"),
+		"disabled_reason":       gorm.Expr("NULL"),
+		"deletion_requested_at": gorm.Expr("NULL"),
+	})
+	if result.Error != nil {
+		o.Errors = append(o.Errors, &provide.Error{
+			Message: common.StringOrNil(result.Error.Error()),
+		})
+		return false
+	}
+
+	o.DisabledAt = nil
+	o.DisabledReason = nil
+	o.DeletionRequestedAt = nil
+	common.Log.Debugf("reactivated organization: %s", o.ID)
+	return true
 }
 
 // ParseMetadata - parse the Organization JSON metadata
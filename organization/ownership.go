@@ -0,0 +1,231 @@
+package organization
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	natsutil "github.com/kthomas/go-natsutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+	"github.com/provideplatform/ident/role"
+	"github.com/provideplatform/ident/token"
+	"github.com/provideplatform/ident/user"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+const natsOrganizationOwnershipTransferRequestedSubject = "ident.organization.ownership.transfer.requested"
+const natsOrganizationOwnershipTransferredSubject = "ident.organization.ownership.transferred"
+
+// ownershipTransferTokenTTL is the duration for which a nominee may accept ownership of an organization
+const ownershipTransferTokenTTL = time.Hour * 72
+
+// VendOwnershipTransferToken vends a signed, single-use token which authorizes the given member
+// to accept ownership of the organization from its current owner
+func (o *Organization) VendOwnershipTransferToken(userID uuid.UUID) (*token.Token, error) {
+	dataJSON, _ := json.Marshal(map[string]interface{}{
+		"owner_id": o.UserID,
+	})
+	data := json.RawMessage(dataJSON)
+
+	ttl := int(ownershipTransferTokenTTL.Seconds())
+	transferToken := &token.Token{
+		OrganizationID: &o.ID,
+		UserID:         &userID,
+		Audience:       common.StringOrNil(token.OwnershipTransferAudience),
+		Subject:        common.StringOrNil(fmt.Sprintf("user:%s", userID.String())),
+		Data:           &data,
+		TTL:            &ttl,
+	}
+
+	if !transferToken.Vend() {
+		msg := "failed to vend ownership transfer token"
+		if len(transferToken.Errors) > 0 {
+			msg = fmt.Sprintf("%s; %s", msg, *transferToken.Errors[0].Message)
+		}
+		return nil, errors.New(msg)
+	}
+
+	return transferToken, nil
+}
+
+// RequestOwnershipTransfer nominates the given member to become the owner of the organization;
+// an ownership transfer token is vended and dispatched to the nominee out-of-band, and any
+// previously-issued ownership transfer token for the organization is revoked
+func (o *Organization) RequestOwnershipTransfer(db *gorm.DB, nominee *user.User) bool {
+	o.Errors = make([]*provide.Error, 0)
+
+	if o.UserID != nil && *o.UserID == nominee.ID {
+		o.Errors = append(o.Errors, &provide.Error{
+			Message: common.StringOrNil("user already owns the organization"),
+		})
+		return false
+	}
+
+	if !o.hasMember(db, nominee.ID) {
+		o.Errors = append(o.Errors, &provide.Error{
+			Message: common.StringOrNil("ownership may only be transferred to a member of the organization"),
+		})
+		return false
+	}
+
+	if o.OwnershipTransferToken != nil {
+		prevToken, err := token.Parse(*o.OwnershipTransferToken)
+		if err == nil && !prevToken.IsRevoked() {
+			if !prevToken.Revoke(nil) {
+				common.Log.Warningf("failed to revoke previously-issued ownership transfer token for organization: %s", o.ID)
+			}
+		}
+	}
+
+	transferToken, err := o.VendOwnershipTransferToken(nominee.ID)
+	if err != nil {
+		o.Errors = append(o.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+		return false
+	}
+
+	result := db.Exec("UPDATE organizations SET ownership_transfer_token = ? WHERE id = ?", transferToken.Token, o.ID)
+	if result.Error != nil {
+		o.Errors = append(o.Errors, &provide.Error{
+			Message: common.StringOrNil(result.Error.Error()),
+		})
+		return false
+	}
+	o.OwnershipTransferToken = transferToken.Token
+
+	payload, _ := json.Marshal(transferToken)
+	natsutil.NatsJetstreamPublish(natsOrganizationOwnershipTransferRequestedSubject, payload)
+
+	common.Log.Debugf("requested transfer of ownership of organization %s to user: %s", o.ID, nominee.ID)
+	return true
+}
+
+// TransferOwnership transfers ownership of the organization to the given user; the membership
+// permissions and role of the previous owner and the new owner are swapped atomically along with
+// the organization user id. A user who is not a member is added to the organization as its owner,
+// in which case the previous owner becomes a member. The outstanding tokens of the previous owner
+// scoped to the organization are revoked, as they continue to assert the owner permissions.
+func (o *Organization) TransferOwnership(tx *gorm.DB, userID uuid.UUID) bool {
+	o.Errors = make([]*provide.Error, 0)
+
+	if o.UserID != nil && *o.UserID == userID {
+		o.Errors = append(o.Errors, &provide.Error{
+			Message: common.StringOrNil("user already owns the organization"),
+		})
+		return false
+	}
+
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+		db = db.Begin()
+		defer db.RollbackUnlessCommitted()
+	}
+
+	// the organization is locked such that concurrent transfers are serialized
+	current := &Organization{}
+	db.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", o.ID).Find(&current)
+	if current.ID == uuid.Nil {
+		o.Errors = append(o.Errors, &provide.Error{
+			Message: common.StringOrNil("organization not found"),
+		})
+		return false
+	}
+
+	if (current.UserID == nil) != (o.UserID == nil) || (current.UserID != nil && *current.UserID != *o.UserID) {
+		o.Errors = append(o.Errors, &provide.Error{
+			Message: common.StringOrNil("ownership of the organization has changed"),
+		})
+		return false
+	}
+
+	ownerRoleID := role.BuiltinID(db, role.Owner)
+
+	// the previous owner assumes the membership of the new owner, or becomes a member
+	permissions := role.MemberPermission
+	roleID := role.BuiltinID(db, role.Member)
+	isMember := o.hasMember(db, userID)
+	if isMember {
+		err := db.Raw("SELECT permissions, role_id FROM organizations_users WHERE organization_id = ? AND user_id = ?", o.ID, userID).Row().Scan(&permissions, &roleID)
+		if err != nil {
+			o.Errors = append(o.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("failed to resolve membership of user: %s; %s", userID, err.Error())),
+			})
+			return false
+		}
+	}
+
+	if o.UserID != nil {
+		result := db.Exec("UPDATE organizations_users SET permissions = ?, role_id = ? WHERE organization_id = ? AND user_id = ?", permissions, roleID, o.ID, o.UserID)
+		if result.Error != nil {
+			o.Errors = append(o.Errors, &provide.Error{
+				Message: common.StringOrNil(result.Error.Error()),
+			})
+			return false
+		}
+
+		err := token.RevokeOrganizationUserTokens(db, o.ID, *o.UserID)
+		if err != nil {
+			o.Errors = append(o.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+			return false
+		}
+	}
+
+	if isMember {
+		result := db.Exec("UPDATE organizations_users SET permissions = ?, role_id = ? WHERE organization_id = ? AND user_id = ?", role.OwnerPermission, ownerRoleID, o.ID, userID)
+		if result.Error != nil {
+			o.Errors = append(o.Errors, &provide.Error{
+				Message: common.StringOrNil(result.Error.Error()),
+			})
+			return false
+		}
+	} else {
+		usr := user.User{}
+		usr.ID = userID
+		if !o.addUser(db, usr, role.OwnerPermission, ownerRoleID) {
+			return false
+		}
+	}
+
+	result := db.Exec("UPDATE organizations SET user_id = ?, ownership_transfer_token = NULL WHERE id = ?", userID, o.ID)
+	if result.Error != nil {
+		o.Errors = append(o.Errors, &provide.Error{
+			Message: common.StringOrNil(result.Error.Error()),
+		})
+		return false
+	}
+
+	if tx == nil {
+		db.Commit()
+	}
+
+	previousUserID := o.UserID
+	o.UserID = &userID
+	o.OwnershipTransferToken = nil
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"organization_id":  o.ID.String(),
+		"user_id":          userID.String(),
+		"previous_user_id": previousUserID,
+	})
+	natsutil.NatsJetstreamPublish(natsOrganizationOwnershipTransferredSubject, payload)
+
+	common.Log.Debugf("transferred ownership of organization %s to user: %s", o.ID, userID)
+	return true
+}
+
+// hasMember returns true if the given user is a member of the organization
+func (o *Organization) hasMember(db *gorm.DB, userID uuid.UUID) bool {
+	var members uint64
+	db.Table("organizations_users").Where("organization_id = ? AND user_id = ?", o.ID, userID).Count(&members)
+	return members > 0
+}
@@ -0,0 +1,436 @@
+package role
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+// Owner is the name of the builtin role of the owner of an organization or application
+const Owner = "owner"
+
+// Admin is the name of the builtin role of an organization or application administrator
+const Admin = "admin"
+
+// Member is the name of the builtin role of an organization or application member
+const Member = "member"
+
+// Viewer is the name of the builtin role of a read-only organization or application member
+const Viewer = "viewer"
+
+// ApplicationResourceKey is the extended permissions resource key of application memberships
+const ApplicationResourceKey = "application"
+
+// OrganizationResourceKey is the extended permissions resource key of organization memberships
+const OrganizationResourceKey = "organization"
+
+// ResourcePermissions is the mask of the generic resource permissions which may be granted by a role
+const ResourcePermissions = common.ReadResources | common.CreateResource | common.UpdateResource | common.DeleteResource | common.GrantResourceAuthorization | common.RevokeResourceAuthorization | common.Publish | common.Subscribe
+
+// OwnerPermission is the permission mask of the builtin owner role
+const OwnerPermission = ResourcePermissions
+
+// AdminPermission is the permission mask of the builtin admin role
+const AdminPermission = common.ReadResources | common.CreateResource | common.UpdateResource | common.GrantResourceAuthorization | common.RevokeResourceAuthorization | common.Publish | common.Subscribe
+
+// MemberPermission is the permission mask of the builtin member role
+const MemberPermission = common.DefaultOrganizationUserPermission
+
+// ViewerPermission is the permission mask of the builtin viewer role
+const ViewerPermission = common.ReadResources | common.Subscribe
+
+// Builtins are the names of the builtin roles, which are available to every organization and application
+var Builtins = []string{Owner, Admin, Member, Viewer}
+
+// Role maps a named role, defined for an organization or application, to a permission mask and
+// extended permissions; builtin roles are defined for neither an organization nor an application
+type Role struct {
+	provide.Model
+	ApplicationID       *uuid.UUID        `sql:"type:uuid" json:"application_id,omitempty"`
+	OrganizationID      *uuid.UUID        `sql:"type:uuid" json:"organization_id,omitempty"`
+	Name                *string           `sql:"not null" json:"name"`
+	Description         *string           `json:"description,omitempty"`
+	Permissions         common.Permission `sql:"not null" json:"permissions"`
+	ExtendedPermissions *json.RawMessage  `sql:"type:json" json:"extended_permissions,omitempty"`
+}
+
+// TableName returns the db table name for gorm
+func (r *Role) TableName() string {
+	return "roles"
+}
+
+// IsBuiltin returns true if the given name is the name of a builtin role
+func IsBuiltin(name string) bool {
+	for _, builtin := range Builtins {
+		if strings.ToLower(name) == builtin {
+			return true
+		}
+	}
+	return false
+}
+
+// Find returns the role with the given id, or nil if no such role exists
+func Find(db *gorm.DB, roleID uuid.UUID) *Role {
+	role := &Role{}
+	db.Where("id = ?", roleID).Find(&role)
+	if role == nil || role.ID == uuid.Nil {
+		return nil
+	}
+	return role
+}
+
+// FindBuiltin returns the builtin role with the given name, or nil if no such role exists
+func FindBuiltin(db *gorm.DB, name string) *Role {
+	role := &Role{}
+	db.Where("name = ? AND application_id IS NULL AND organization_id IS NULL", name).Find(&role)
+	if role == nil || role.ID == uuid.Nil {
+		return nil
+	}
+	return role
+}
+
+// BuiltinID returns the id of the builtin role with the given name, or nil if no such role exists
+func BuiltinID(db *gorm.DB, name string) *uuid.UUID {
+	if role := FindBuiltin(db, name); role != nil {
+		return &role.ID
+	}
+	return nil
+}
+
+// ListQuery returns a query for the builtin roles and the roles defined for the given
+// application or organization
+func ListQuery(db *gorm.DB, applicationID, organizationID *uuid.UUID) *gorm.DB {
+	if applicationID != nil {
+		return db.Where("roles.application_id = ? OR (roles.application_id IS NULL AND roles.organization_id IS NULL)", applicationID)
+	} else if organizationID != nil {
+		return db.Where("roles.organization_id = ? OR (roles.application_id IS NULL AND roles.organization_id IS NULL)", organizationID)
+	}
+	return db.Where("roles.application_id IS NULL AND roles.organization_id IS NULL")
+}
+
+// Resolve returns the role available to the given application or organization having the
+// given id or name, or nil if no such role is available
+func Resolve(db *gorm.DB, idOrName string, applicationID, organizationID *uuid.UUID) *Role {
+	role := &Role{}
+	query := ListQuery(db, applicationID, organizationID)
+	if roleID, err := uuid.FromString(idOrName); err == nil {
+		query = query.Where("roles.id = ?", roleID)
+	} else {
+		query = query.Where("roles.name = ?", strings.ToLower(idOrName))
+	}
+	query.Find(&role)
+	if role == nil || role.ID == uuid.Nil {
+		return nil
+	}
+	return role
+}
+
+// IsBuiltin returns true if the role is a builtin role
+func (r *Role) IsBuiltin() bool {
+	return r.ApplicationID == nil && r.OrganizationID == nil
+}
+
+// IsOwner returns true if the role is the builtin owner role
+func (r *Role) IsOwner() bool {
+	return r.IsBuiltin() && r.Name != nil && *r.Name == Owner
+}
+
+// ValidateGrant returns an error if the given role or permission mask may not be granted by a
+// grantor holding the given permission mask and extended permissions; the owner role is never
+// granted directly, since ownership is transferred, and a grantor cannot grant permissions or
+// extended permissions it does not itself hold; nil grantor extended permissions are unrestricted
+func ValidateGrant(r *Role, permissions, grantorPermissions common.Permission, grantorExtendedPermissions map[string]common.Permission) error {
+	if r != nil && r.IsOwner() {
+		return errors.New("the owner role cannot be granted; ownership must be transferred")
+	}
+
+	if permissions&^grantorPermissions != 0 {
+		return errors.New("unable to grant permissions beyond those of the authorized bearer")
+	}
+
+	if r != nil && grantorExtendedPermissions != nil {
+		for resource, resourcePermissions := range r.ParseExtendedPermissions() {
+			if resourcePermissions&^grantorExtendedPermissions[resource] != 0 {
+				return fmt.Errorf("unable to grant extended permissions for resource: %s beyond those of the authorized bearer", resource)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ParseExtendedPermissions parses the extended permissions of the role
+func (r *Role) ParseExtendedPermissions() map[string]common.Permission {
+	extendedPermissions := map[string]common.Permission{}
+	if r.ExtendedPermissions != nil {
+		err := json.Unmarshal(*r.ExtendedPermissions, &extendedPermissions)
+		if err != nil {
+			common.Log.Warningf("failed to unmarshal role extended permissions; %s", err.Error())
+			return nil
+		}
+	}
+	return extendedPermissions
+}
+
+// Create and persist the role
+func (r *Role) Create(tx *gorm.DB) bool {
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+	}
+
+	if !r.validate() {
+		return false
+	}
+
+	if db.NewRecord(r) {
+		result := db.Create(&r)
+		rowsAffected := result.RowsAffected
+		errors := result.GetErrors()
+		if len(errors) > 0 {
+			for _, err := range errors {
+				r.Errors = append(r.Errors, &provide.Error{
+					Message: common.StringOrNil(err.Error()),
+				})
+			}
+		}
+		if !db.NewRecord(r) {
+			success := rowsAffected > 0
+			if success {
+				common.Log.Debugf("created role: %s", *r.Name)
+			}
+			return success
+		}
+	}
+
+	return false
+}
+
+// Update the role; the permissions of each membership which references the role are updated
+// such that memberships continue to reflect the permission mask of their role
+func (r *Role) Update(tx *gorm.DB) bool {
+	if r.IsBuiltin() {
+		r.Errors = append(r.Errors, &provide.Error{
+			Message: common.StringOrNil("builtin roles cannot be modified"),
+		})
+		return false
+	}
+
+	if !r.validate() {
+		return false
+	}
+
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+		db = db.Begin()
+		defer db.RollbackUnlessCommitted()
+	}
+
+	result := db.Save(&r)
+	errors := result.GetErrors()
+	if len(errors) > 0 {
+		for _, err := range errors {
+			r.Errors = append(r.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+		}
+		return false
+	}
+
+	for _, table := range []string{"applications_users", "organizations_users"} {
+		result = db.Exec(fmt.Sprintf("UPDATE %s SET permissions = ? WHERE role_id = ?", table), r.Permissions, r.ID)
+		if result.Error != nil {
+			r.Errors = append(r.Errors, &provide.Error{
+				Message: common.StringOrNil(result.Error.Error()),
+			})
+			return false
+		}
+	}
+
+	if tx == nil {
+		db.Commit()
+	}
+
+	common.Log.Debugf("updated role: %s", r.ID)
+	return true
+}
+
+// Delete the role; roles which are referenced by a membership or pending invitation cannot be deleted
+func (r *Role) Delete(tx *gorm.DB) bool {
+	if r.IsBuiltin() {
+		r.Errors = append(r.Errors, &provide.Error{
+			Message: common.StringOrNil("builtin roles cannot be deleted"),
+		})
+		return false
+	}
+
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+	}
+
+	for table, query := range map[string]string{
+		"applications_users":  "role_id = ?",
+		"organizations_users": "role_id = ?",
+		"invitations":         "role_id = ? AND status = 'pending'",
+	} {
+		var references uint64
+		db.Table(table).Where(query, r.ID).Count(&references)
+		if references > 0 {
+			r.Errors = append(r.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("role is referenced by %d %s", references, table)),
+			})
+			return false
+		}
+	}
+
+	result := db.Delete(&r)
+	if result.Error != nil {
+		r.Errors = append(r.Errors, &provide.Error{
+			Message: common.StringOrNil(result.Error.Error()),
+		})
+		return false
+	}
+
+	common.Log.Debugf("deleted role: %s", r.ID)
+	return true
+}
+
+// validate the role for persistence; roles grant only generic resource permissions
+func (r *Role) validate() bool {
+	r.Errors = make([]*provide.Error, 0)
+
+	if r.Name == nil || strings.TrimSpace(*r.Name) == "" {
+		r.Errors = append(r.Errors, &provide.Error{
+			Message: common.StringOrNil("name is required"),
+		})
+	} else {
+		r.Name = common.StringOrNil(strings.ToLower(strings.TrimSpace(*r.Name)))
+		if IsBuiltin(*r.Name) {
+			r.Errors = append(r.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("role name is reserved: %s", *r.Name)),
+			})
+		}
+	}
+
+	if (r.ApplicationID == nil) == (r.OrganizationID == nil) {
+		r.Errors = append(r.Errors, &provide.Error{
+			Message: common.StringOrNil("role must be defined for exactly one of an application or organization"),
+		})
+	}
+
+	if r.Permissions&^ResourcePermissions != 0 {
+		r.Errors = append(r.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("role permissions must be within the resource permissions mask: %d", ResourcePermissions)),
+		})
+	}
+
+	extendedPermissions := r.ParseExtendedPermissions()
+	if extendedPermissions == nil {
+		r.Errors = append(r.Errors, &provide.Error{
+			Message: common.StringOrNil("extended_permissions must map resource names to permission masks"),
+		})
+	}
+	for resource, permissions := range extendedPermissions {
+		if resource == "" || permissions&^ResourcePermissions != 0 {
+			r.Errors = append(r.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("invalid extended permissions for resource: %s", resource)),
+			})
+		}
+	}
+
+	return len(r.Errors) == 0
+}
+
+// EffectiveApplicationPermissions returns the permission mask and extended permissions granted
+// to the user by its membership in the given application; false is returned if the user is
+// not a member of the application
+func EffectiveApplicationPermissions(db *gorm.DB, applicationID, userID uuid.UUID) (common.Permission, map[string]common.Permission, bool) {
+	query := db.Raw(
+		"SELECT m.permissions, r.permissions, r.extended_permissions FROM applications_users m LEFT OUTER JOIN roles r ON r.id = m.role_id WHERE m.application_id = ? AND m.user_id = ?",
+		applicationID,
+		userID,
+	)
+	return effectivePermissions(query, ApplicationResourceKey, applicationID, userID)
+}
+
+// EffectiveOrganizationPermissions returns the permission mask and extended permissions granted
+// to the user by its membership in the given organization or, failing that, by its membership in
+// the nearest ancestor of the organization; false is returned if the user is not a member of the
+// organization or any of its ancestors
+func EffectiveOrganizationPermissions(db *gorm.DB, organizationID, userID uuid.UUID) (common.Permission, map[string]common.Permission, bool) {
+	query := db.Raw(
+		`WITH RECURSIVE ancestors AS (
+			SELECT id, parent_organization_id, 0 AS depth FROM organizations WHERE id = ?
+			UNION
+			SELECT o.id, o.parent_organization_id, a.depth + 1 FROM organizations o JOIN ancestors a ON o.id = a.parent_organization_id WHERE a.depth < ?
+		)
+		SELECT m.permissions, r.permissions, r.extended_permissions FROM organizations_users m
+		JOIN ancestors a ON a.id = m.organization_id
+		LEFT OUTER JOIN roles r ON r.id = m.role_id
+		WHERE m.user_id = ? ORDER BY a.depth LIMIT 1`,
+		organizationID,
+		common.OrganizationHierarchyMaxDepth,
+		userID,
+	)
+	return effectivePermissions(query, OrganizationResourceKey, organizationID, userID)
+}
+
+// effectivePermissions resolves the permissions of a membership; the permission mask of the
+// membership role applies when the membership references a role, otherwise the membership
+// permission mask applies; the mask is granted on the membership resource in addition to
+// any extended permissions of the role; the given query selects the membership permissions,
+// role permissions and role extended permissions of the membership
+func effectivePermissions(query *gorm.DB, resourceKey string, resourceID, userID uuid.UUID) (common.Permission, map[string]common.Permission, bool) {
+	rows, err := query.Rows()
+	if err != nil {
+		common.Log.Warningf("failed to resolve effective permissions of user %s for %s: %s; %s", userID, resourceKey, resourceID, err.Error())
+		return 0, nil, false
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return 0, nil, false
+	}
+
+	var membershipPermissions *int64
+	var rolePermissions *int64
+	var roleExtendedPermissions *string
+	err = rows.Scan(&membershipPermissions, &rolePermissions, &roleExtendedPermissions)
+	if err != nil {
+		common.Log.Warningf("failed to scan effective permissions of user %s for %s: %s; %s", userID, resourceKey, resourceID, err.Error())
+		return 0, nil, false
+	}
+
+	var permissions common.Permission
+	if rolePermissions != nil {
+		permissions = common.Permission(*rolePermissions)
+	} else if membershipPermissions != nil {
+		permissions = common.Permission(*membershipPermissions)
+	}
+
+	extendedPermissions := map[string]common.Permission{}
+	if roleExtendedPermissions != nil {
+		err = json.Unmarshal([]byte(*roleExtendedPermissions), &extendedPermissions)
+		if err != nil {
+			common.Log.Warningf("failed to unmarshal role extended permissions; %s", err.Error())
+		}
+	}
+	extendedPermissions[resourceKey] = permissions
+
+	return permissions, extendedPermissions, true
+}
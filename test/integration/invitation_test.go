@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"testing"
 
+	dbconf "github.com/kthomas/go-db-config"
 	uuid "github.com/kthomas/go.uuid"
 	provide "github.com/provideplatform/provide-go/api/ident"
 )
@@ -300,3 +301,318 @@ func TestInviteOrganizationUserWithOrganizationAPIToken(t *testing.T) {
 		return
 	}
 }
+
+func TestManageInvitation(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	// create the user
+	_, err := userFactory("a", "user", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	// get the auth token
+	auth, err := provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s. error: %s", email, err.Error())
+		return
+	}
+
+	inviteTestId, _ := uuid.NewV4()
+	inviteEmail := fmt.Sprintf("%s@example.local", inviteTestId.String())
+
+	err = provide.CreateInvitation(*auth.Token.AccessToken, map[string]interface{}{
+		"email":      inviteEmail,
+		"first_name": "A",
+		"last_name":  "User",
+	})
+	if err != nil {
+		t.Errorf("creating invitation failed; %s", err.Error())
+		return
+	}
+
+	invitations, err := listInvitations(*auth.Token.AccessToken, map[string]interface{}{})
+	if err != nil {
+		t.Errorf("failed to list invitations; %s", err.Error())
+		return
+	}
+	if len(invitations) != 1 {
+		t.Errorf("expected 1 pending invitation; found %d", len(invitations))
+		return
+	}
+	invitationID := invitations[0].(map[string]interface{})["id"].(string)
+
+	invitation, err := resendInvitation(*auth.Token.AccessToken, invitationID)
+	if err != nil {
+		t.Errorf("failed to resend invitation; %s", err.Error())
+		return
+	}
+	if invitation["status"] != "pending" {
+		t.Errorf("expected resent invitation to be pending; status: %v", invitation["status"])
+		return
+	}
+
+	err = revokeInvitation(*auth.Token.AccessToken, invitationID)
+	if err != nil {
+		t.Errorf("failed to revoke invitation; %s", err.Error())
+		return
+	}
+
+	err = revokeInvitation(*auth.Token.AccessToken, invitationID)
+	if err == nil {
+		t.Error("revoking an invitation which is no longer pending should fail")
+		return
+	}
+
+	invitations, err = listInvitations(*auth.Token.AccessToken, map[string]interface{}{
+		"status": "revoked",
+	})
+	if err != nil {
+		t.Errorf("failed to list revoked invitations; %s", err.Error())
+		return
+	}
+	if len(invitations) != 1 {
+		t.Errorf("expected 1 revoked invitation; found %d", len(invitations))
+		return
+	}
+}
+
+func TestAcceptInvitation(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	// create the user
+	usr, err := userFactory("a", "user", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	// get the auth token
+	auth, err := provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s; %s", email, err.Error())
+		return
+	}
+
+	// create the org
+	org, err := orgFactory(*auth.Token.AccessToken, "test org", "ABC Corp")
+	if err != nil {
+		t.Errorf("org creaton failed; %s", err.Error())
+		return
+	}
+
+	inviteTestId, _ := uuid.NewV4()
+	inviteEmail := fmt.Sprintf("%s@example.local", inviteTestId.String())
+
+	invitationToken, err := invitationTokenFactory(usr.ID, &org.ID, inviteEmail)
+	if err != nil {
+		t.Errorf("failed to vend invitation token; %s", err.Error())
+		return
+	}
+
+	_, err = provide.CreateUser("", map[string]interface{}{
+		"first_name":       "A",
+		"last_name":        "User",
+		"email":            inviteEmail,
+		"password":         "passw0rd",
+		"invitation_token": *invitationToken,
+	})
+	if err != nil {
+		t.Errorf("user creation using invitation token failed; %s", err.Error())
+		return
+	}
+
+	invitations, err := listInvitations(*auth.Token.AccessToken, map[string]interface{}{
+		"status": "accepted",
+		"email":  inviteEmail,
+	})
+	if err != nil {
+		t.Errorf("failed to list accepted invitations; %s", err.Error())
+		return
+	}
+	if len(invitations) != 1 {
+		t.Errorf("expected 1 accepted invitation; found %d", len(invitations))
+		return
+	}
+
+	invitations, err = listInvitations(*auth.Token.AccessToken, map[string]interface{}{})
+	if err != nil {
+		t.Errorf("failed to list pending invitations; %s", err.Error())
+		return
+	}
+	if len(invitations) != 0 {
+		t.Errorf("expected no pending invitations; found %d", len(invitations))
+		return
+	}
+}
+
+func TestRevokedImportedInvitationRejected(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	// create the user
+	usr, err := userFactory("a", "user", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	// get the auth token
+	auth, err := provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s; %s", email, err.Error())
+		return
+	}
+
+	inviteTestId, _ := uuid.NewV4()
+	inviteEmail := fmt.Sprintf("%s@example.local", inviteTestId.String())
+
+	invitationToken, err := invitationTokenFactory(usr.ID, nil, inviteEmail)
+	if err != nil {
+		t.Errorf("failed to vend invitation token; %s", err.Error())
+		return
+	}
+
+	// invitations imported from the legacy invitations cache have no token hash
+	dbconf.DatabaseConnection().Exec("UPDATE invitations SET token_hash = NULL WHERE email = ?", inviteEmail)
+
+	invitations, err := listInvitations(*auth.Token.AccessToken, map[string]interface{}{})
+	if err != nil {
+		t.Errorf("failed to list invitations; %s", err.Error())
+		return
+	}
+	if len(invitations) != 1 {
+		t.Errorf("expected 1 pending invitation; found %d", len(invitations))
+		return
+	}
+
+	err = revokeInvitation(*auth.Token.AccessToken, invitations[0].(map[string]interface{})["id"].(string))
+	if err != nil {
+		t.Errorf("failed to revoke invitation; %s", err.Error())
+		return
+	}
+
+	_, err = provide.CreateUser("", map[string]interface{}{
+		"first_name":       "A",
+		"last_name":        "User",
+		"email":            inviteEmail,
+		"password":         "passw0rd",
+		"invitation_token": *invitationToken,
+	})
+	if err == nil {
+		t.Error("user creation using a revoked imported invitation token should fail")
+		return
+	}
+}
+
+func TestCreateOrganizationInvitationJob(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	// create the user
+	usr, err := userFactory("a", "user", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	// get the auth token
+	auth, err := provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s; %s", email, err.Error())
+		return
+	}
+
+	// create the org
+	org, err := orgFactory(*auth.Token.AccessToken, "test org", "ABC Corp")
+	if err != nil {
+		t.Errorf("org creaton failed; %s", err.Error())
+		return
+	}
+
+	existingTestId, _ := uuid.NewV4()
+	existingEmail := fmt.Sprintf("%s@prvd.local", existingTestId.String())
+	_, err = userFactory("existing", "user", existingEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	inviteTestId, _ := uuid.NewV4()
+	inviteEmail := fmt.Sprintf("%s@example.local", inviteTestId.String())
+
+	status, resp, err := createOrganizationInvitationJob(*auth.Token.AccessToken, org.ID.String(), []interface{}{
+		map[string]interface{}{"email": inviteEmail, "first_name": "A", "last_name": "User"},
+		map[string]interface{}{"email": "not an email address"},
+	})
+	if err != nil {
+		t.Errorf("failed to create invitation job; %s", err.Error())
+		return
+	}
+	if status != 422 {
+		t.Errorf("expected invitation job having an invalid row to be rejected; status: %d", status)
+		return
+	}
+
+	orgUserToken, err := orgUserTokenFactory(*auth.Token.AccessToken, org.ID, usr.ID)
+	if err != nil {
+		t.Errorf("failed to vend organization user token; %s", err.Error())
+		return
+	}
+
+	status, _, err = createOrganizationInvitationJob(*orgUserToken.AccessToken, org.ID.String(), []interface{}{
+		map[string]interface{}{"email": inviteEmail, "role": "owner"},
+	})
+	if err != nil {
+		t.Errorf("failed to create invitation job; %s", err.Error())
+		return
+	}
+	if status != 422 {
+		t.Errorf("expected invitation job granting the owner role to be rejected; status: %d", status)
+		return
+	}
+
+	status, resp, err = createOrganizationInvitationJob(*auth.Token.AccessToken, org.ID.String(), []interface{}{
+		map[string]interface{}{"email": inviteEmail, "first_name": "A", "last_name": "User"},
+		map[string]interface{}{"email": existingEmail},
+	})
+	if err != nil {
+		t.Errorf("failed to create invitation job; %s", err.Error())
+		return
+	}
+	if status != 202 {
+		t.Errorf("expected invitation job to be accepted; status: %d", status)
+		return
+	}
+
+	rows := resp["rows"].([]interface{})
+	if len(rows) != 2 {
+		t.Errorf("expected 2 invitation job rows; found %d", len(rows))
+		return
+	}
+	if action := rows[0].(map[string]interface{})["action"]; action != "invite" {
+		t.Errorf("expected new user to be invited; action: %v", action)
+		return
+	}
+	if action := rows[1].(map[string]interface{})["action"]; action != "add" {
+		t.Errorf("expected existing user to be added; action: %v", action)
+		return
+	}
+
+	job, err := fetchOrganizationInvitationJob(*auth.Token.AccessToken, org.ID.String(), resp["id"].(string))
+	if err != nil {
+		t.Errorf("failed to fetch invitation job; %s", err.Error())
+		return
+	}
+	if job["id"] != resp["id"] {
+		t.Errorf("fetched invitation job did not match; %v", job["id"])
+		return
+	}
+}
@@ -0,0 +1,109 @@
+// +build integration ident
+
+package integration
+
+import (
+	"fmt"
+	"testing"
+
+	uuid "github.com/kthomas/go.uuid"
+	identcommon "github.com/provideplatform/ident/common"
+	provide "github.com/provideplatform/provide-go/api/ident"
+)
+
+// legal documents are not published here; publishing a document requires every user
+// to consent to it upon authentication, which would interfere with concurrent tests
+func TestCreateLegalDocument(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	_, err := userFactory("a", "user", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	auth, err := provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s. error: %s", email, err.Error())
+		return
+	}
+
+	sudoerTestId, _ := uuid.NewV4()
+	sudoerEmail := fmt.Sprintf("%s@prvd.local", sudoerTestId.String())
+	sudoer, err := permissionedUserFactory("sudo", "user", sudoerEmail, "passw0rd", identcommon.DefaultSudoerPermission)
+	if err != nil {
+		t.Errorf("sudoer creation failed. Error: %s", err.Error())
+		return
+	}
+
+	sudoerAuth, err := provide.Authenticate(sudoerEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("sudoer authentication failed for user %s. error: %s", sudoerEmail, err.Error())
+		return
+	}
+
+	params := map[string]interface{}{
+		"type":    "terms_of_service",
+		"version": testId.String(),
+		"content": "be excellent to each other",
+	}
+
+	status, _, _ := provide.InitIdentService(auth.Token.AccessToken).Post("legal/documents", params)
+	if status != 403 {
+		t.Errorf("expected user without the sudo permission to be forbidden from creating legal documents; status: %d", status)
+	}
+
+	status, _, _ = provide.InitIdentService(sudoerAuth.Token.AccessToken).Post("legal/documents", map[string]interface{}{
+		"type":    "cookie_policy",
+		"version": testId.String(),
+		"content": "we have none",
+	})
+	if status != 422 {
+		t.Errorf("expected legal document of an unsupported type to be rejected; status: %d", status)
+	}
+
+	status, resp, err := provide.InitIdentService(sudoerAuth.Token.AccessToken).Post("legal/documents", params)
+	if err != nil || status != 201 {
+		t.Errorf("failed to create legal document; status: %d", status)
+		return
+	}
+
+	documentID := resp.(map[string]interface{})["id"].(string)
+	if resp.(map[string]interface{})["published_at"] != nil {
+		t.Error("expected legal document to be created unpublished")
+	}
+
+	status, _, _ = provide.InitIdentService(sudoerAuth.Token.AccessToken).Post("legal/documents", params)
+	if status != 422 {
+		t.Errorf("expected duplicate legal document version to be rejected; status: %d", status)
+	}
+
+	status, _, _ = provide.InitIdentService(auth.Token.AccessToken).Get(fmt.Sprintf("legal/documents/%s", documentID), map[string]interface{}{})
+	if status != 404 {
+		t.Errorf("expected unpublished legal document to be hidden from users without the sudo permission; status: %d", status)
+	}
+
+	status, _, _ = provide.InitIdentService(sudoerAuth.Token.AccessToken).Get(fmt.Sprintf("legal/documents/%s", documentID), map[string]interface{}{})
+	if status != 200 {
+		t.Errorf("expected unpublished legal document to be visible to sudoers; status: %d", status)
+	}
+
+	status, _, _ = provide.InitIdentService(auth.Token.AccessToken).Post(fmt.Sprintf("legal/documents/%s/publish", documentID), map[string]interface{}{})
+	if status != 403 {
+		t.Errorf("expected user without the sudo permission to be forbidden from publishing legal documents; status: %d", status)
+	}
+
+	status, _, _ = provide.InitIdentService(auth.Token.AccessToken).Post(fmt.Sprintf("users/%s/consents", sudoer.ID), map[string]interface{}{
+		"document_ids": []string{documentID},
+	})
+	if status != 403 {
+		t.Errorf("expected user to be forbidden from consenting on behalf of another user; status: %d", status)
+	}
+
+	_, _, err = provide.InitIdentService(auth.Token.AccessToken).Get("legal/documents", map[string]interface{}{})
+	if err != nil {
+		t.Errorf("failed to list legal documents; %s", err.Error())
+	}
+}
@@ -3,9 +3,15 @@
 package integration
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
+	dbconf "github.com/kthomas/go-db-config"
 	uuid "github.com/kthomas/go.uuid"
+	identcommon "github.com/provideplatform/ident/common"
+	identorganization "github.com/provideplatform/ident/organization"
+	identrole "github.com/provideplatform/ident/role"
 	provide "github.com/provideplatform/provide-go/api/ident"
 )
 
@@ -771,10 +777,874 @@ func TestCreateOrganizationUser(t *testing.T) {
 
 func TestUpdateOrganizationUser(t *testing.T) {
 	t.Parallel()
-	t.Logf("TBD not yet implemented in code")
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	owner, err := userFactory("org", "owner", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	auth, err := provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s; %s", email, err.Error())
+		return
+	}
+
+	org, err := orgFactory(*auth.Token.AccessToken, "test org", "ABC Corp")
+	if err != nil {
+		t.Errorf("org creaton failed; %s", err.Error())
+		return
+	}
+
+	memberTestId, _ := uuid.NewV4()
+	memberEmail := fmt.Sprintf("%s@prvd.local", memberTestId.String())
+	member, err := userFactory("org", "member", memberEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	err = provide.CreateOrganizationUser(*auth.Token.AccessToken, org.ID.String(), map[string]interface{}{
+		"user_id": member.ID.String(),
+	})
+	if err != nil {
+		t.Errorf("failed to add user %s to organization %s; %s", member.ID, org.ID.String(), err.Error())
+		return
+	}
+
+	memberAuth, err := provide.Authenticate(memberEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s; %s", memberEmail, err.Error())
+		return
+	}
+
+	err = provide.UpdateOrganizationUser(*memberAuth.Token.AccessToken, org.ID.String(), member.ID.String(), map[string]interface{}{
+		"role": "owner",
+	})
+	if err == nil {
+		t.Error("expected member to be unable to escalate its own permissions")
+		return
+	}
+
+	err = provide.UpdateOrganizationUser(*auth.Token.AccessToken, org.ID.String(), owner.ID.String(), map[string]interface{}{
+		"role": "member",
+	})
+	if err == nil {
+		t.Error("expected demotion of the organization owner to be rejected")
+		return
+	}
+
+	err = provide.UpdateOrganizationUser(*auth.Token.AccessToken, org.ID.String(), member.ID.String(), map[string]interface{}{
+		"role": "admin",
+	})
+	if err != nil {
+		t.Errorf("failed to update organization user role; %s", err.Error())
+		return
+	}
+
+	var permissions []int64
+	dbconf.DatabaseConnection().Table("organizations_users").Where("organization_id = ? AND user_id = ?", org.ID, member.ID).Pluck("permissions", &permissions)
+	if len(permissions) != 1 || !identcommon.Permission(permissions[0]).Has(identcommon.GrantResourceAuthorization) {
+		t.Errorf("expected admin organization user to be granted admin permissions; permissions: %v", permissions)
+		return
+	}
+
+	memberOrgToken, err := orgUserTokenFactory(*memberAuth.Token.AccessToken, org.ID, member.ID)
+	if err != nil {
+		t.Errorf("failed to vend organization user token; %s", err.Error())
+		return
+	}
+
+	err = provide.UpdateOrganizationUser(*auth.Token.AccessToken, org.ID.String(), member.ID.String(), map[string]interface{}{
+		"permissions":   identcommon.ReadResources,
+		"revoke_tokens": true,
+	})
+	if err != nil {
+		t.Errorf("failed to update organization user permissions; %s", err.Error())
+		return
+	}
+
+	_, err = provide.GetOrganizationDetails(*memberOrgToken.AccessToken, org.ID.String(), map[string]interface{}{})
+	if err == nil {
+		t.Error("expected organization tokens of the updated organization user to be revoked")
+		return
+	}
+
+	_, err = provide.GetOrganizationDetails(*memberAuth.Token.AccessToken, org.ID.String(), map[string]interface{}{})
+	if err != nil {
+		t.Errorf("expected tokens of the updated organization user which are not scoped to the organization to remain authorized; %s", err.Error())
+		return
+	}
+
+	err = provide.DeleteOrganizationUser(*auth.Token.AccessToken, org.ID.String(), owner.ID.String())
+	if err == nil {
+		t.Error("expected removal of the organization owner to be rejected")
+		return
+	}
 }
 
 func TestListApplicationOrganizationUsers(t *testing.T) {
 	t.Parallel()
 	t.Logf("test not implemented yet")
 }
+
+func TestOrganizationRoles(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	usr, err := userFactory("org", "owner", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	auth, err := provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s; %s", email, err.Error())
+		return
+	}
+
+	org, err := orgFactory(*auth.Token.AccessToken, "test org", "ABC Corp")
+	if err != nil {
+		t.Errorf("org creaton failed; %s", err.Error())
+		return
+	}
+
+	orgUserToken, err := orgUserTokenFactory(*auth.Token.AccessToken, org.ID, usr.ID)
+	if err != nil {
+		t.Errorf("failed to vend organization user token; %s", err.Error())
+		return
+	}
+
+	auditor, err := createOrganizationRole(*orgUserToken.AccessToken, org.ID.String(), map[string]interface{}{
+		"name":        "Auditor",
+		"permissions": identcommon.ReadResources,
+	})
+	if err != nil {
+		t.Errorf("failed to create organization role; %s", err.Error())
+		return
+	}
+
+	if auditor["name"] != "auditor" {
+		t.Errorf("expected role name to be normalized; name: %v", auditor["name"])
+		return
+	}
+
+	_, err = createOrganizationRole(*orgUserToken.AccessToken, org.ID.String(), map[string]interface{}{
+		"name":        "owner",
+		"permissions": identcommon.ReadResources,
+	})
+	if err == nil {
+		t.Error("expected builtin role name to be rejected")
+		return
+	}
+
+	roles, err := listOrganizationRoles(*orgUserToken.AccessToken, org.ID.String())
+	if err != nil {
+		t.Errorf("failed to list organization roles; %s", err.Error())
+		return
+	}
+
+	if len(roles) != 5 {
+		t.Errorf("expected 4 builtin roles and 1 organization role; found %d", len(roles))
+		return
+	}
+
+	memberTestId, _ := uuid.NewV4()
+	memberEmail := fmt.Sprintf("%s@prvd.local", memberTestId.String())
+	member, err := userFactory("org", "viewer", memberEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	err = provide.CreateOrganizationUser(*orgUserToken.AccessToken, org.ID.String(), map[string]interface{}{
+		"user_id": member.ID.String(),
+		"role":    "viewer",
+	})
+	if err != nil {
+		t.Errorf("failed to add user %s to organization %s; %s", member.ID, org.ID.String(), err.Error())
+		return
+	}
+
+	var permissions []int64
+	dbconf.DatabaseConnection().Table("organizations_users").Where("organization_id = ? AND user_id = ?", org.ID, member.ID).Pluck("permissions", &permissions)
+	if len(permissions) != 1 || identcommon.Permission(permissions[0]).Has(identcommon.CreateResource) {
+		t.Errorf("expected viewer organization user to be granted read-only permissions; permissions: %v", permissions)
+		return
+	}
+
+	memberAuth, err := provide.Authenticate(memberEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s; %s", memberEmail, err.Error())
+		return
+	}
+
+	memberToken, err := provide.CreateToken(*memberAuth.Token.AccessToken, map[string]interface{}{
+		"organization_id": org.ID,
+		"user_id":         member.ID,
+		"permissions":     identrole.ResourcePermissions,
+	})
+	if err != nil {
+		t.Errorf("failed to vend organization user token; %s", err.Error())
+		return
+	}
+
+	if excess := identcommon.Permission(memberToken.Permissions) & identrole.ResourcePermissions &^ identrole.ViewerPermission; excess != 0 {
+		t.Errorf("expected organization user token to be clamped to the viewer permissions; excess permissions: %d", excess)
+		return
+	}
+
+	_, err = createOrganizationRole(*memberToken.AccessToken, org.ID.String(), map[string]interface{}{
+		"name":        "escalated",
+		"permissions": identcommon.ReadResources,
+	})
+	if err == nil {
+		t.Error("expected organization viewer to be forbidden from administering the organization")
+		return
+	}
+
+	adminTestId, _ := uuid.NewV4()
+	adminEmail := fmt.Sprintf("%s@prvd.local", adminTestId.String())
+	admin, err := userFactory("org", "admin", adminEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	err = provide.CreateOrganizationUser(*orgUserToken.AccessToken, org.ID.String(), map[string]interface{}{
+		"user_id": admin.ID.String(),
+		"role":    "admin",
+	})
+	if err != nil {
+		t.Errorf("failed to add user %s to organization %s; %s", admin.ID, org.ID.String(), err.Error())
+		return
+	}
+
+	adminAuth, err := provide.Authenticate(adminEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s; %s", adminEmail, err.Error())
+		return
+	}
+
+	adminToken, err := orgUserTokenFactory(*adminAuth.Token.AccessToken, org.ID, admin.ID)
+	if err != nil {
+		t.Errorf("failed to vend organization user token; %s", err.Error())
+		return
+	}
+
+	_, err = createOrganizationRole(*adminToken.AccessToken, org.ID.String(), map[string]interface{}{
+		"name":        "destroyer",
+		"permissions": identcommon.ReadResources | identcommon.DeleteResource,
+	})
+	if err == nil {
+		t.Error("expected organization admin to be forbidden from defining a role with permissions beyond its own")
+		return
+	}
+
+	_, err = createOrganizationRole(*adminToken.AccessToken, org.ID.String(), map[string]interface{}{
+		"name":                 "extended",
+		"permissions":          identcommon.ReadResources,
+		"extended_permissions": map[string]interface{}{"workgroup": identcommon.DeleteResource},
+	})
+	if err == nil {
+		t.Error("expected organization admin to be forbidden from defining a role with extended permissions beyond its own")
+		return
+	}
+
+	_, err = createOrganizationRole(*adminToken.AccessToken, org.ID.String(), map[string]interface{}{
+		"name":        "reader",
+		"permissions": identcommon.ReadResources,
+	})
+	if err != nil {
+		t.Errorf("failed to create organization role within the permissions of the organization admin; %s", err.Error())
+		return
+	}
+
+	ownerTestId, _ := uuid.NewV4()
+	ownerEmail := fmt.Sprintf("%s@prvd.local", ownerTestId.String())
+	nominee, err := userFactory("org", "nominee", ownerEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	err = provide.CreateOrganizationUser(*orgUserToken.AccessToken, org.ID.String(), map[string]interface{}{
+		"user_id": nominee.ID.String(),
+		"role":    "owner",
+	})
+	if err == nil {
+		t.Error("expected the owner role to be granted only by transfer of ownership")
+		return
+	}
+
+	err = deleteOrganizationRole(*orgUserToken.AccessToken, org.ID.String(), auditor["id"].(string))
+	if err != nil {
+		t.Errorf("failed to delete organization role; %s", err.Error())
+		return
+	}
+}
+
+func TestOrganizationDomains(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	usr, err := userFactory("org", "owner", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	auth, err := provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s; %s", email, err.Error())
+		return
+	}
+
+	org, err := orgFactory(*auth.Token.AccessToken, "test org", "ABC Corp")
+	if err != nil {
+		t.Errorf("org creaton failed; %s", err.Error())
+		return
+	}
+
+	orgUserToken, err := orgUserTokenFactory(*auth.Token.AccessToken, org.ID, usr.ID)
+	if err != nil {
+		t.Errorf("failed to vend organization user token; %s", err.Error())
+		return
+	}
+
+	name := fmt.Sprintf("%s.example.invalid", testId.String())
+	domain, err := createOrganizationDomain(*orgUserToken.AccessToken, org.ID.String(), strings.ToUpper(name))
+	if err != nil {
+		t.Errorf("failed to create organization domain; %s", err.Error())
+		return
+	}
+
+	if domain["domain"] != name {
+		t.Errorf("expected domain to be normalized; domain: %v", domain["domain"])
+		return
+	}
+	if domain["verification_token"] == nil || domain["verified_at"] != nil {
+		t.Error("expected unverified domain having a verification token")
+		return
+	}
+
+	_, err = createOrganizationDomain(*orgUserToken.AccessToken, org.ID.String(), name)
+	if err == nil {
+		t.Error("expected duplicate organization domain to be rejected")
+		return
+	}
+
+	_, err = createOrganizationDomain(*orgUserToken.AccessToken, org.ID.String(), "not a domain")
+	if err == nil {
+		t.Error("expected invalid organization domain to be rejected")
+		return
+	}
+
+	err = verifyOrganizationDomain(*orgUserToken.AccessToken, org.ID.String(), domain["id"].(string))
+	if err == nil {
+		t.Error("expected verification of a domain without a verification record to fail")
+		return
+	}
+
+	domains, err := listOrganizationDomains(*orgUserToken.AccessToken, org.ID.String())
+	if err != nil {
+		t.Errorf("failed to list organization domains; %s", err.Error())
+		return
+	}
+	if len(domains) != 1 || domains[0].(map[string]interface{})["verified_at"] != nil {
+		t.Errorf("expected 1 unverified organization domain; found %v", domains)
+		return
+	}
+
+	err = deleteOrganizationDomain(*orgUserToken.AccessToken, org.ID.String(), domain["id"].(string))
+	if err != nil {
+		t.Errorf("failed to delete organization domain; %s", err.Error())
+		return
+	}
+}
+
+func TestOrganizationAdministrationRequiresAdminMembership(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	usr, err := userFactory("org", "owner", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	auth, err := provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s; %s", email, err.Error())
+		return
+	}
+
+	org, err := orgFactory(*auth.Token.AccessToken, "test org", "ABC Corp")
+	if err != nil {
+		t.Errorf("org creaton failed; %s", err.Error())
+		return
+	}
+
+	orgUserToken, err := orgUserTokenFactory(*auth.Token.AccessToken, org.ID, usr.ID)
+	if err != nil {
+		t.Errorf("failed to vend organization user token; %s", err.Error())
+		return
+	}
+
+	memberTestId, _ := uuid.NewV4()
+	memberEmail := fmt.Sprintf("%s@prvd.local", memberTestId.String())
+	member, err := userFactory("org", "member", memberEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	err = provide.CreateOrganizationUser(*orgUserToken.AccessToken, org.ID.String(), map[string]interface{}{
+		"user_id": member.ID.String(),
+	})
+	if err != nil {
+		t.Errorf("failed to add user %s to organization %s; %s", member.ID, org.ID.String(), err.Error())
+		return
+	}
+
+	memberAuth, err := provide.Authenticate(memberEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s; %s", memberEmail, err.Error())
+		return
+	}
+
+	memberToken, err := orgTokenFactory(*memberAuth.Token.AccessToken, org.ID)
+	if err != nil {
+		t.Errorf("failed to vend organization token for member of the organization; %s", err.Error())
+		return
+	}
+
+	_, err = createOrganizationDomain(*memberToken.AccessToken, org.ID.String(), fmt.Sprintf("%s.example.invalid", memberTestId.String()))
+	if err == nil {
+		t.Error("expected organization member without admin permissions to be forbidden from administering the organization")
+		return
+	}
+
+	outsiderTestId, _ := uuid.NewV4()
+	outsiderEmail := fmt.Sprintf("%s@prvd.local", outsiderTestId.String())
+	_, err = userFactory("org", "outsider", outsiderEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	outsiderAuth, err := provide.Authenticate(outsiderEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s; %s", outsiderEmail, err.Error())
+		return
+	}
+
+	_, err = orgTokenFactory(*outsiderAuth.Token.AccessToken, org.ID)
+	if err == nil {
+		t.Error("expected token scoped to an organization to be vended only to members of the organization")
+		return
+	}
+}
+
+func TestDeleteOrganization(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	_, err := userFactory("org", "owner", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	auth, err := provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s; %s", email, err.Error())
+		return
+	}
+
+	org, err := orgFactory(*auth.Token.AccessToken, "test org", "ABC Corp")
+	if err != nil {
+		t.Errorf("org creaton failed; %s", err.Error())
+		return
+	}
+
+	memberTestId, _ := uuid.NewV4()
+	memberEmail := fmt.Sprintf("%s@prvd.local", memberTestId.String())
+	member, err := userFactory("org", "member", memberEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	err = provide.CreateOrganizationUser(*auth.Token.AccessToken, org.ID.String(), map[string]interface{}{
+		"user_id": member.ID.String(),
+	})
+	if err != nil {
+		t.Errorf("failed to add user %s to organization %s; %s", member.ID, org.ID.String(), err.Error())
+		return
+	}
+
+	memberAuth, err := provide.Authenticate(memberEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s; %s", memberEmail, err.Error())
+		return
+	}
+
+	err = deleteOrganization(*memberAuth.Token.AccessToken, org.ID.String())
+	if err == nil {
+		t.Error("expected deletion of organization by a non-owner to be forbidden")
+		return
+	}
+
+	err = deleteOrganization(*auth.Token.AccessToken, org.ID.String())
+	if err != nil {
+		t.Errorf("failed to delete organization; %s", err.Error())
+		return
+	}
+
+	_, err = provide.GetOrganizationDetails(*auth.Token.AccessToken, org.ID.String(), map[string]interface{}{})
+	if err == nil {
+		t.Error("expected deleted organization to be unavailable")
+		return
+	}
+
+	err = deleteOrganization(*auth.Token.AccessToken, org.ID.String())
+	if err == nil {
+		t.Error("expected deletion of organization pending deletion to be rejected")
+		return
+	}
+}
+
+func TestDeletedOrganizationTokensRemainRevoked(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	_, err := userFactory("org", "owner", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	auth, err := provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s; %s", email, err.Error())
+		return
+	}
+
+	org, err := orgFactory(*auth.Token.AccessToken, "test org", "ABC Corp")
+	if err != nil {
+		t.Errorf("org creaton failed; %s", err.Error())
+		return
+	}
+
+	orgToken, err := orgTokenFactory(*auth.Token.AccessToken, org.ID)
+	if err != nil {
+		t.Errorf("failed to vend organization token; %s", err.Error())
+		return
+	}
+
+	_, err = provide.GetOrganizationDetails(*orgToken.AccessToken, org.ID.String(), map[string]interface{}{})
+	if err != nil {
+		t.Errorf("failed to fetch organization details using organization token; %s", err.Error())
+		return
+	}
+
+	deletedOrg := &identorganization.Organization{}
+	dbconf.DatabaseConnection().Where("id = ?", org.ID).Find(&deletedOrg)
+	if !deletedOrg.Delete() {
+		t.Errorf("failed to permanently delete organization; %s", *deletedOrg.Errors[0].Message)
+		return
+	}
+
+	var revocations uint64
+	dbconf.DatabaseConnection().Table("organization_token_revocations").Where("organization_id = ?", org.ID).Count(&revocations)
+	if revocations != 1 {
+		t.Error("expected token revocation of the deleted organization to be retained")
+		return
+	}
+
+	_, err = provide.GetOrganizationDetails(*orgToken.AccessToken, org.ID.String(), map[string]interface{}{})
+	if err == nil {
+		t.Error("expected token of the deleted organization to be unauthorized")
+		return
+	}
+}
+
+func TestTransferOrganizationOwnership(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	owner, err := userFactory("org", "owner", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	auth, err := provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s; %s", email, err.Error())
+		return
+	}
+
+	org, err := orgFactory(*auth.Token.AccessToken, "test org", "ABC Corp")
+	if err != nil {
+		t.Errorf("org creaton failed; %s", err.Error())
+		return
+	}
+
+	nomineeTestId, _ := uuid.NewV4()
+	nomineeEmail := fmt.Sprintf("%s@prvd.local", nomineeTestId.String())
+	nominee, err := userFactory("org", "nominee", nomineeEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	err = requestOrganizationOwnershipTransfer(*auth.Token.AccessToken, org.ID.String(), nominee.ID.String())
+	if err == nil {
+		t.Error("expected ownership transfer to a non-member to be rejected")
+		return
+	}
+
+	err = provide.CreateOrganizationUser(*auth.Token.AccessToken, org.ID.String(), map[string]interface{}{
+		"user_id": nominee.ID.String(),
+	})
+	if err != nil {
+		t.Errorf("failed to add user %s to organization %s; %s", nominee.ID, org.ID.String(), err.Error())
+		return
+	}
+
+	err = requestOrganizationOwnershipTransfer(*auth.Token.AccessToken, org.ID.String(), nominee.ID.String())
+	if err != nil {
+		t.Errorf("failed to request organization ownership transfer; %s", err.Error())
+		return
+	}
+
+	supersededTransferToken, err := ownershipTransferTokenFactory(org.ID)
+	if err != nil {
+		t.Errorf("failed to resolve ownership transfer token; %s", err.Error())
+		return
+	}
+
+	err = requestOrganizationOwnershipTransfer(*auth.Token.AccessToken, org.ID.String(), nominee.ID.String())
+	if err != nil {
+		t.Errorf("failed to request organization ownership transfer; %s", err.Error())
+		return
+	}
+
+	transferToken, err := ownershipTransferTokenFactory(org.ID)
+	if err != nil {
+		t.Errorf("failed to resolve ownership transfer token; %s", err.Error())
+		return
+	}
+
+	ownerOrgToken, err := orgTokenFactory(*auth.Token.AccessToken, org.ID)
+	if err != nil {
+		t.Errorf("failed to vend organization token; %s", err.Error())
+		return
+	}
+
+	err = acceptOrganizationOwnershipTransfer(*auth.Token.AccessToken, org.ID.String(), *transferToken)
+	if err == nil {
+		t.Error("expected ownership transfer to be accepted only by the nominee")
+		return
+	}
+
+	nomineeAuth, err := provide.Authenticate(nomineeEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s; %s", nomineeEmail, err.Error())
+		return
+	}
+
+	err = acceptOrganizationOwnershipTransfer(*nomineeAuth.Token.AccessToken, org.ID.String(), *supersededTransferToken)
+	if err == nil {
+		t.Error("expected ownership transfer token to be superseded by the subsequent nomination")
+		return
+	}
+
+	err = acceptOrganizationOwnershipTransfer(*nomineeAuth.Token.AccessToken, org.ID.String(), *transferToken)
+	if err != nil {
+		t.Errorf("failed to accept organization ownership transfer; %s", err.Error())
+		return
+	}
+
+	transferredOrg := &identorganization.Organization{}
+	dbconf.DatabaseConnection().Where("id = ?", org.ID).Find(&transferredOrg)
+	if transferredOrg.UserID == nil || *transferredOrg.UserID != nominee.ID {
+		t.Errorf("expected nominee to own the organization; owner: %v", transferredOrg.UserID)
+		return
+	}
+
+	var permissions []int64
+	dbconf.DatabaseConnection().Table("organizations_users").Where("organization_id = ? AND user_id = ?", org.ID, owner.ID).Pluck("permissions", &permissions)
+	if len(permissions) != 1 || identcommon.Permission(permissions[0]).Has(identcommon.DeleteResource) {
+		t.Errorf("expected previous owner to assume the permissions of the nominee; permissions: %v", permissions)
+		return
+	}
+
+	_, err = provide.GetOrganizationDetails(*ownerOrgToken.AccessToken, org.ID.String(), map[string]interface{}{})
+	if err == nil {
+		t.Error("expected organization token of the previous owner to be revoked")
+		return
+	}
+
+	err = acceptOrganizationOwnershipTransfer(*nomineeAuth.Token.AccessToken, org.ID.String(), *transferToken)
+	if err == nil {
+		t.Error("expected ownership transfer token to be single-use")
+		return
+	}
+}
+
+func TestHierarchicalOrganizations(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	_, err := userFactory("org", "owner", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	auth, err := provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s; %s", email, err.Error())
+		return
+	}
+
+	parent, err := orgFactory(*auth.Token.AccessToken, "parent org", "ABC Corp")
+	if err != nil {
+		t.Errorf("org creaton failed; %s", err.Error())
+		return
+	}
+
+	child, err := subOrgFactory(*auth.Token.AccessToken, parent.ID.String(), "child org", "ABC Corp Business Unit")
+	if err != nil {
+		t.Errorf("sub-organization creaton failed; %s", err.Error())
+		return
+	}
+
+	grandchild, err := subOrgFactory(*auth.Token.AccessToken, child.ID.String(), "grandchild org", "ABC Corp Team")
+	if err != nil {
+		t.Errorf("sub-organization creaton failed; %s", err.Error())
+		return
+	}
+
+	memberTestId, _ := uuid.NewV4()
+	memberEmail := fmt.Sprintf("%s@prvd.local", memberTestId.String())
+	member, err := userFactory("org", "member", memberEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	err = provide.CreateOrganizationUser(*auth.Token.AccessToken, parent.ID.String(), map[string]interface{}{
+		"user_id": member.ID.String(),
+	})
+	if err != nil {
+		t.Errorf("failed to add user %s to organization %s; %s", member.ID, parent.ID.String(), err.Error())
+		return
+	}
+
+	memberAuth, err := provide.Authenticate(memberEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s; %s", memberEmail, err.Error())
+		return
+	}
+
+	// membership in the parent organization is inherited by its descendants
+	orgs, err := provide.ListOrganizations(*memberAuth.Token.AccessToken, map[string]interface{}{})
+	if err != nil {
+		t.Errorf("failed to list organizations; %s", err.Error())
+		return
+	}
+	if len(orgs) != 3 {
+		t.Errorf("expected member of parent organization to be a member of 3 organizations; found %d", len(orgs))
+		return
+	}
+
+	_, err = provide.GetOrganizationDetails(*memberAuth.Token.AccessToken, grandchild.ID.String(), map[string]interface{}{})
+	if err != nil {
+		t.Errorf("failed to fetch details of descendant organization; %s", err.Error())
+		return
+	}
+
+	_, err = orgUserTokenFactory(*memberAuth.Token.AccessToken, child.ID, member.ID)
+	if err != nil {
+		t.Errorf("failed to vend token scoped to sub-organization; %s", err.Error())
+		return
+	}
+
+	descendants, err := listOrganizationDescendants(*auth.Token.AccessToken, parent.ID.String())
+	if err != nil {
+		t.Errorf("failed to list organization descendants; %s", err.Error())
+		return
+	}
+	if len(descendants) != 2 {
+		t.Errorf("expected 2 descendants of parent organization; found %d", len(descendants))
+		return
+	}
+
+	err = provide.UpdateOrganization(*auth.Token.AccessToken, parent.ID.String(), map[string]interface{}{
+		"parent_organization_id": grandchild.ID.String(),
+	})
+	if err == nil {
+		t.Error("expected organization hierarchy cycle to be rejected")
+		return
+	}
+
+	err = provide.UpdateOrganization(*auth.Token.AccessToken, child.ID.String(), map[string]interface{}{
+		"parent_organization_id": child.ID.String(),
+	})
+	if err == nil {
+		t.Error("expected organization to be rejected as its own parent")
+		return
+	}
+
+	_, err = subOrgFactory(*memberAuth.Token.AccessToken, parent.ID.String(), "rogue org", "ABC Corp Shadow IT")
+	if err == nil {
+		t.Error("expected creation of sub-organization by a non-administrator to be forbidden")
+		return
+	}
+
+	nonexistentID, _ := uuid.NewV4()
+	err = provide.UpdateOrganization(*auth.Token.AccessToken, grandchild.ID.String(), map[string]interface{}{
+		"parent_organization_id": nonexistentID.String(),
+	})
+	if err == nil {
+		t.Error("expected organization to be rejected beneath a nonexistent parent")
+		return
+	}
+
+	memberOrg, err := orgFactory(*memberAuth.Token.AccessToken, "member org", "ABC Corp Subsidiary")
+	if err != nil {
+		t.Errorf("org creaton failed; %s", err.Error())
+		return
+	}
+	dbconf.DatabaseConnection().Exec("UPDATE organizations SET parent_organization_id = ? WHERE id = ?", parent.ID, memberOrg.ID)
+
+	err = provide.UpdateOrganization(*memberAuth.Token.AccessToken, memberOrg.ID.String(), map[string]interface{}{
+		"parent_organization_id": nil,
+	})
+	if err == nil {
+		t.Error("expected detachment of sub-organization by a non-administrator of its parent to be forbidden")
+		return
+	}
+
+	err = provide.UpdateOrganization(*auth.Token.AccessToken, grandchild.ID.String(), map[string]interface{}{
+		"parent_organization_id": nil,
+	})
+	if err != nil {
+		t.Errorf("failed to detach sub-organization; %s", err.Error())
+		return
+	}
+}
@@ -0,0 +1,310 @@
+// +build integration ident
+
+package integration
+
+import (
+	"fmt"
+	"testing"
+
+	uuid "github.com/kthomas/go.uuid"
+	provide "github.com/provideplatform/provide-go/api/ident"
+)
+
+func scimUserFactory(t *testing.T, orgToken, email, externalID string) map[string]interface{} {
+	status, resp, err := scimService(orgToken).Post("Users", map[string]interface{}{
+		"schemas":    []string{"urn:ietf:params:scim:schemas:core:2.0:User"},
+		"userName":   email,
+		"externalId": externalID,
+		"name": map[string]interface{}{
+			"givenName":  "scim",
+			"familyName": "user",
+		},
+		"active": true,
+	})
+	if err != nil {
+		t.Fatalf("scim user provisioning failed. Error: %s", err.Error())
+	}
+	if status != 201 {
+		t.Fatalf("scim user provisioning failed; status: %d; %v", status, resp)
+	}
+	return resp.(map[string]interface{})
+}
+
+func TestSCIMProvisionUser(t *testing.T) {
+	t.Parallel()
+	_, _, orgToken := scimOrganizationFactory(t)
+
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+	scimUser := scimUserFactory(t, orgToken, email, testId.String())
+
+	if scimUser["userName"] != email {
+		t.Errorf("expected provisioned userName %s; got %v", email, scimUser["userName"])
+	}
+	if scimUser["externalId"] != testId.String() {
+		t.Errorf("expected provisioned externalId %s; got %v", testId.String(), scimUser["externalId"])
+	}
+	if scimUser["active"] != true {
+		t.Error("expected provisioned user to be active")
+	}
+
+	status, _, _ := scimService(orgToken).Post("Users", map[string]interface{}{
+		"userName": email,
+	})
+	if status != 409 {
+		t.Errorf("expected provisioning a duplicate user to fail with 409; got %d", status)
+	}
+
+	for _, filter := range []string{
+		fmt.Sprintf(`userName eq "%s"`, email),
+		fmt.Sprintf(`externalId eq "%s" and active eq true`, testId.String()),
+	} {
+		status, resp, err := scimService(orgToken).Get("Users", map[string]interface{}{
+			"filter": filter,
+		})
+		if err != nil || status != 200 {
+			t.Errorf("failed to list scim users with filter %s; status: %d", filter, status)
+			continue
+		}
+		if resp.(map[string]interface{})["totalResults"] != float64(1) {
+			t.Errorf("expected exactly one scim user to match filter %s; got %v", filter, resp)
+		}
+	}
+
+	status, _, _ = scimService(orgToken).Get("Users", map[string]interface{}{
+		"filter": `password eq "secret"`,
+	})
+	if status != 400 {
+		t.Errorf("expected unsupported filter attribute to fail with 400; got %d", status)
+	}
+}
+
+func TestSCIMDeprovisionUser(t *testing.T) {
+	t.Parallel()
+	_, _, orgToken := scimOrganizationFactory(t)
+
+	testId, _ := uuid.NewV4()
+	scimUser := scimUserFactory(t, orgToken, fmt.Sprintf("%s@prvd.local", testId.String()), testId.String())
+	userID := scimUser["id"].(string)
+
+	status, resp, err := scimService(orgToken).Patch(fmt.Sprintf("Users/%s", userID), map[string]interface{}{
+		"schemas": []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		"Operations": []map[string]interface{}{
+			{"op": "Replace", "path": "active", "value": "False"},
+		},
+	})
+	if err != nil || status != 200 {
+		t.Errorf("failed to deactivate scim user; status: %d", status)
+		return
+	}
+	if resp.(map[string]interface{})["active"] != false {
+		t.Error("expected scim user to be deactivated")
+	}
+
+	status, resp, err = scimService(orgToken).Patch(fmt.Sprintf("Users/%s", userID), map[string]interface{}{
+		"schemas": []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		"Operations": []map[string]interface{}{
+			{"op": "replace", "value": map[string]interface{}{"active": true}},
+		},
+	})
+	if err != nil || status != 200 {
+		t.Errorf("failed to reactivate scim user; status: %d", status)
+		return
+	}
+	if resp.(map[string]interface{})["active"] != true {
+		t.Error("expected scim user to be reactivated")
+	}
+
+	status, _, err = scimService(orgToken).Delete(fmt.Sprintf("Users/%s", userID))
+	if err != nil || status != 204 {
+		t.Errorf("failed to delete scim user; status: %d", status)
+		return
+	}
+
+	// deprovisioned users are disabled rather than deleted
+	status, resp, err = scimService(orgToken).Get(fmt.Sprintf("Users/%s", userID), map[string]interface{}{})
+	if err != nil || status != 200 {
+		t.Errorf("failed to fetch deprovisioned scim user; status: %d", status)
+		return
+	}
+	if resp.(map[string]interface{})["active"] != false {
+		t.Error("expected deprovisioned scim user to be inactive")
+	}
+}
+
+func TestSCIMCannotManageUnprovisionedUsers(t *testing.T) {
+	t.Parallel()
+	_, owner, orgToken := scimOrganizationFactory(t)
+
+	status, _, err := scimService(orgToken).Patch(fmt.Sprintf("Users/%s", owner.ID.String()), map[string]interface{}{
+		"schemas": []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		"Operations": []map[string]interface{}{
+			{"op": "replace", "path": "userName", "value": "takeover@prvd.local"},
+		},
+	})
+	if err != nil || status != 404 {
+		t.Errorf("expected update of a member not provisioned via scim to be rejected; status: %d", status)
+		return
+	}
+
+	status, _, err = scimService(orgToken).Delete(fmt.Sprintf("Users/%s", owner.ID.String()))
+	if err != nil || status != 404 {
+		t.Errorf("expected deprovisioning of a member not provisioned via scim to be rejected; status: %d", status)
+		return
+	}
+}
+
+func TestSCIMGroupMembership(t *testing.T) {
+	t.Parallel()
+	org, _, orgToken := scimOrganizationFactory(t)
+
+	testId, _ := uuid.NewV4()
+	scimUser := scimUserFactory(t, orgToken, fmt.Sprintf("%s@prvd.local", testId.String()), testId.String())
+	userID := scimUser["id"].(string)
+
+	status, resp, err := scimService(orgToken).Get("Groups", map[string]interface{}{})
+	if err != nil || status != 200 {
+		t.Errorf("failed to list scim groups; status: %d", status)
+		return
+	}
+	groups := resp.(map[string]interface{})["Resources"].([]interface{})
+	if len(groups) != 1 || groups[0].(map[string]interface{})["id"] != org.ID.String() {
+		t.Errorf("expected the organization to be the only scim group; got %v", groups)
+		return
+	}
+
+	status, _, err = scimService(orgToken).Patch(fmt.Sprintf("Groups/%s", org.ID), map[string]interface{}{
+		"schemas": []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		"Operations": []map[string]interface{}{
+			{"op": "remove", "path": fmt.Sprintf(`members[value eq "%s"]`, userID)},
+		},
+	})
+	if err != nil || status != 200 {
+		t.Errorf("failed to remove scim group member; status: %d", status)
+		return
+	}
+
+	status, _, _ = scimService(orgToken).Get(fmt.Sprintf("Users/%s", userID), map[string]interface{}{})
+	if status != 404 {
+		t.Errorf("expected user removed from the organization to no longer be provisioned; got %d", status)
+	}
+
+	status, _, err = scimService(orgToken).Patch(fmt.Sprintf("Groups/%s", org.ID), map[string]interface{}{
+		"schemas": []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		"Operations": []map[string]interface{}{
+			{"op": "add", "path": "members", "value": []map[string]interface{}{{"value": userID}}},
+		},
+	})
+	if err != nil || status != 200 {
+		t.Errorf("failed to add scim group member; status: %d", status)
+		return
+	}
+
+	status, _, _ = scimService(orgToken).Get(fmt.Sprintf("Users/%s", userID), map[string]interface{}{})
+	if status != 200 {
+		t.Errorf("expected user added to the organization to be provisioned; got %d", status)
+	}
+
+	// users which were not provisioned by the organization cannot be added to it
+	otherId, _ := uuid.NewV4()
+	other, err := userFactory("a", "user", fmt.Sprintf("%s@prvd.local", otherId.String()), "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	status, _, _ = scimService(orgToken).Patch(fmt.Sprintf("Groups/%s", org.ID), map[string]interface{}{
+		"schemas": []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		"Operations": []map[string]interface{}{
+			{"op": "add", "path": "members", "value": []map[string]interface{}{{"value": other.ID.String()}}},
+		},
+	})
+	if status != 400 {
+		t.Errorf("expected adding a user not provisioned by the organization to fail with 400; got %d", status)
+	}
+
+	// members which were not provisioned by the organization are unaffected by scim
+	err = provide.CreateOrganizationUser(orgToken, org.ID.String(), map[string]interface{}{
+		"user_id": other.ID.String(),
+	})
+	if err != nil {
+		t.Errorf("failed to add user %s to organization %s; %s", other.ID, org.ID, err.Error())
+		return
+	}
+
+	status, _, _ = scimService(orgToken).Patch(fmt.Sprintf("Groups/%s", org.ID), map[string]interface{}{
+		"schemas": []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		"Operations": []map[string]interface{}{
+			{"op": "remove", "path": fmt.Sprintf(`members[value eq "%s"]`, other.ID.String())},
+		},
+	})
+	if status != 400 {
+		t.Errorf("expected removing a user not provisioned by the organization to fail with 400; got %d", status)
+	}
+
+	status, _, err = scimService(orgToken).Patch(fmt.Sprintf("Groups/%s", org.ID), map[string]interface{}{
+		"schemas": []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		"Operations": []map[string]interface{}{
+			{"op": "replace", "path": "members", "value": []map[string]interface{}{{"value": userID}}},
+		},
+	})
+	if err != nil || status != 200 {
+		t.Errorf("failed to replace scim group members; status: %d", status)
+		return
+	}
+
+	users, err := provide.ListOrganizationUsers(orgToken, org.ID.String(), map[string]interface{}{})
+	if err != nil {
+		t.Errorf("failed to list organization users; %s", err.Error())
+		return
+	}
+
+	retained := false
+	for _, usr := range users {
+		if usr.ID == other.ID {
+			retained = true
+		}
+	}
+	if !retained {
+		t.Error("expected member not provisioned by the organization to be retained upon replacing scim group members")
+	}
+}
+
+func TestSCIMRequiresOrganizationToken(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	_, err := userFactory("a", "user", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	auth, err := provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed. Error: %s", err.Error())
+		return
+	}
+
+	status, _, _ := scimService(*auth.Token.AccessToken).Get("Users", map[string]interface{}{})
+	if status != 403 {
+		t.Errorf("expected scim request authorized by a user token to fail with 403; got %d", status)
+	}
+
+	org, _, _ := scimOrganizationFactory(t)
+	orgToken, err := orgTokenFactory(*auth.Token.AccessToken, org.ID)
+	if err != nil {
+		t.Errorf("error vending organization token. Error: %s", err.Error())
+		return
+	}
+
+	tkn := orgToken.Token
+	if tkn == nil {
+		tkn = orgToken.AccessToken
+	}
+	status, _, _ = scimService(*tkn).Get("Users", map[string]interface{}{})
+	if status != 403 {
+		t.Errorf("expected scim request authorized by an organization token of a non-owner to fail with 403; got %d", status)
+	}
+}
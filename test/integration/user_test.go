@@ -4,10 +4,17 @@ package integration
 
 import (
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
+	dbconf "github.com/kthomas/go-db-config"
 	uuid "github.com/kthomas/go.uuid"
+	identcommon "github.com/provideplatform/ident/common"
+	identuser "github.com/provideplatform/ident/user"
 	provide "github.com/provideplatform/provide-go/api/ident"
+	common "github.com/provideplatform/provide-go/common"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestCreateUser(t *testing.T) {
@@ -36,6 +43,86 @@ func TestCreateUser(t *testing.T) {
 	}
 }
 
+func TestCreateUserFailsWithShortPassword(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	_, err := userFactory("a", "user", email, "pw")
+	if err == nil {
+		t.Error("user creation should fail with a password shorter than the minimum length")
+		return
+	}
+}
+
+func TestUserUpdateFailsWithReusedPassword(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	user, err := userFactory("a", "user", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	auth, err := provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s. error: %s", email, err.Error())
+		return
+	}
+
+	err = provide.UpdateUser(*auth.Token.AccessToken, user.ID.String(), map[string]interface{}{
+		"password": "n3wpassw0rd",
+	})
+	if err != nil {
+		t.Errorf("error updating user password. Error: %s", err.Error())
+		return
+	}
+
+	err = provide.UpdateUser(*auth.Token.AccessToken, user.ID.String(), map[string]interface{}{
+		"password": "passw0rd",
+	})
+	if err == nil {
+		t.Error("updating user password should fail when reusing a previous password")
+		return
+	}
+}
+
+func TestAuthenticateUserUpgradesLegacyPasswordHash(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	user, err := userFactory("a", "user", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	legacyHash, _ := bcrypt.GenerateFromPassword([]byte("passw0rd"), bcrypt.DefaultCost)
+	db := dbconf.DatabaseConnection()
+	db.Model(&identuser.User{}).Where("id = ?", user.ID).Update("password", string(legacyHash))
+
+	_, err = provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s with legacy password hash. error: %s", email, err.Error())
+		return
+	}
+
+	usr := identuser.Find(user.ID)
+	if usr == nil || usr.Password == nil || !strings.HasPrefix(*usr.Password, fmt.Sprintf("$%s$", identuser.PasswordHashSchemeArgon2id)) {
+		t.Errorf("legacy password hash not upgraded to %s for user %s", identuser.PasswordHashSchemeArgon2id, email)
+		return
+	}
+
+	_, err = provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s with upgraded password hash. error: %s", email, err.Error())
+		return
+	}
+}
+
 func TestAuthenticateUser(t *testing.T) {
 	t.Parallel()
 	tt := []struct {
@@ -66,6 +153,559 @@ func TestAuthenticateUser(t *testing.T) {
 	}
 }
 
+func TestAuthenticateUserFailsGenerically(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	_, err := userFactory("a", "user", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	_, invalidPasswordErr := provide.Authenticate(email, "wr0ngpassw0rd")
+	if invalidPasswordErr == nil {
+		t.Error("user authentication should fail with invalid password")
+		return
+	}
+
+	_, unknownEmailErr := provide.Authenticate(fmt.Sprintf("unknown.%s", email), "passw0rd")
+	if unknownEmailErr == nil {
+		t.Error("user authentication should fail with unknown email address")
+		return
+	}
+
+	if invalidPasswordErr.Error() != unknownEmailErr.Error() {
+		t.Errorf("user authentication failures should not reveal account existence; got %s and %s", invalidPasswordErr.Error(), unknownEmailErr.Error())
+		return
+	}
+}
+
+func TestAuthenticateUserLockout(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	user, err := userFactory("a", "user", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	for failures := int64(0); failures < identcommon.AuthenticationMaxAccountFailures; {
+		status, err := authenticateStatus(email, "wr0ngpassw0rd")
+		if err != nil {
+			t.Errorf("failed to attempt user authentication; %s", err.Error())
+			return
+		}
+
+		// attempts are throttled following each failure rather than delayed
+		if status == 429 {
+			time.Sleep(time.Millisecond * 250)
+			continue
+		}
+		if status != 401 {
+			t.Errorf("user authentication should fail with invalid password; status: %d", status)
+			return
+		}
+		failures++
+	}
+
+	// the account should now be locked, even with the correct password
+	status, err := authenticateStatus(email, "passw0rd")
+	if err != nil || status != 429 {
+		t.Errorf("user authentication should be rejected for locked user %s; status: %d", email, status)
+		return
+	}
+
+	sudoerTestId, _ := uuid.NewV4()
+	sudoerEmail := fmt.Sprintf("%s@prvd.local", sudoerTestId.String())
+	_, err = permissionedUserFactory("sudo", "user", sudoerEmail, "passw0rd", identcommon.DefaultSudoerPermission)
+	if err != nil {
+		t.Errorf("sudoer creation failed. Error: %s", err.Error())
+		return
+	}
+
+	sudoerAuth, err := provide.Authenticate(sudoerEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("sudoer authentication failed for user %s. error: %s", sudoerEmail, err.Error())
+		return
+	}
+
+	err = unlockUser(*sudoerAuth.Token.AccessToken, user.ID.String())
+	if err != nil {
+		t.Errorf("failed to unlock user %s; %s", email, err.Error())
+		return
+	}
+
+	_, err = provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed after unlock for user %s. error: %s", email, err.Error())
+		return
+	}
+}
+
+func TestSuspendAndReactivateUser(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	user, err := userFactory("a", "user", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	auth, err := provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s. error: %s", email, err.Error())
+		return
+	}
+
+	sudoerTestId, _ := uuid.NewV4()
+	sudoerEmail := fmt.Sprintf("%s@prvd.local", sudoerTestId.String())
+	_, err = permissionedUserFactory("sudo", "user", sudoerEmail, "passw0rd", identcommon.DefaultSudoerPermission)
+	if err != nil {
+		t.Errorf("sudoer creation failed. Error: %s", err.Error())
+		return
+	}
+
+	sudoerAuth, err := provide.Authenticate(sudoerEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("sudoer authentication failed for user %s. error: %s", sudoerEmail, err.Error())
+		return
+	}
+
+	err = suspendUser(*auth.Token.AccessToken, user.ID.String(), "self")
+	if err == nil {
+		t.Error("user without the update user permission should not be able to suspend a user")
+		return
+	}
+
+	err = suspendUser(*sudoerAuth.Token.AccessToken, user.ID.String(), "policy violation")
+	if err != nil {
+		t.Errorf("failed to suspend user %s; %s", email, err.Error())
+		return
+	}
+
+	_, err = provide.Authenticate(email, "passw0rd")
+	if err == nil {
+		t.Errorf("user authentication should fail for suspended user %s", email)
+		return
+	}
+
+	// tokens issued prior to the suspension are revoked
+	status, _, _ := provide.InitIdentService(common.StringOrNil(*auth.Token.AccessToken)).Get(fmt.Sprintf("users/%s", user.ID), map[string]interface{}{})
+	if status != 401 {
+		t.Errorf("token issued to suspended user %s should be revoked; status: %d", email, status)
+		return
+	}
+
+	err = reactivateUser(*sudoerAuth.Token.AccessToken, user.ID.String())
+	if err != nil {
+		t.Errorf("failed to reactivate user %s; %s", email, err.Error())
+		return
+	}
+
+	reauth, err := provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed after reactivation for user %s. error: %s", email, err.Error())
+		return
+	}
+
+	// tokens vended after the reactivation are authorized, even within the second of the revocation
+	status, _, _ = provide.InitIdentService(common.StringOrNil(*reauth.Token.AccessToken)).Get(fmt.Sprintf("users/%s", user.ID), map[string]interface{}{})
+	if status != 200 {
+		t.Errorf("token issued to reactivated user %s should be authorized; status: %d", email, status)
+		return
+	}
+
+	err = reactivateUser(*sudoerAuth.Token.AccessToken, user.ID.String())
+	if err == nil {
+		t.Error("reactivating a user which is not suspended should fail")
+	}
+}
+
+func TestExportAndEraseUser(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	user, err := userFactory("a", "user", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	auth, err := provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s. error: %s", email, err.Error())
+		return
+	}
+
+	org, err := orgFactory(*auth.Token.AccessToken, fmt.Sprintf("Org %s", testId.String()), "export organization")
+	if err != nil {
+		t.Errorf("organization creation failed. Error: %s", err.Error())
+		return
+	}
+
+	otherId, _ := uuid.NewV4()
+	other, err := userFactory("another", "user", fmt.Sprintf("%s@prvd.local", otherId.String()), "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	_, err = exportUser(*auth.Token.AccessToken, other.ID.String())
+	if err == nil {
+		t.Error("user should not be able to export the data of another user")
+		return
+	}
+
+	export, err := exportUser(*auth.Token.AccessToken, user.ID.String())
+	if err != nil {
+		t.Errorf("failed to export user %s; %s", email, err.Error())
+		return
+	}
+
+	if export["user"].(map[string]interface{})["email"] != email {
+		t.Errorf("expected exported user email %s; got %v", email, export["user"])
+	}
+
+	organizations := export["organizations"].([]interface{})
+	if len(organizations) != 1 || organizations[0].(map[string]interface{})["id"] != org.ID.String() {
+		t.Errorf("expected exported membership of organization %s; got %v", org.ID, organizations)
+	}
+
+	if len(export["sessions"].([]interface{})) == 0 {
+		t.Error("expected exported sessions to include the authenticated session")
+	}
+
+	if len(export["audit_entries"].([]interface{})) == 0 {
+		t.Error("expected exported audit entries")
+	}
+
+	err = eraseUser(*auth.Token.AccessToken, user.ID.String())
+	if err != nil {
+		t.Errorf("failed to request erasure of user %s; %s", email, err.Error())
+		return
+	}
+
+	// the user is unable to authenticate as soon as the erasure has been requested
+	_, err = provide.Authenticate(email, "passw0rd")
+	if err == nil {
+		t.Errorf("user authentication should fail for user %s pending erasure", email)
+	}
+
+	db := dbconf.DatabaseConnection()
+	db.Exec("UPDATE users SET metadata = ? WHERE id = ?", `{"employee_id": "12345"}`, user.ID)
+	db.Exec("INSERT INTO invitations (created_at, organization_id, email, first_name, last_name, status) VALUES (now(), ?, ?, 'a', 'user', 'accepted')", org.ID, email)
+
+	usr := identuser.Find(user.ID)
+	if usr == nil || !usr.Erase() {
+		t.Errorf("failed to erase user %s", user.ID)
+		return
+	}
+
+	var metadata *string
+	db.Raw("SELECT metadata FROM users WHERE id = ?", user.ID).Row().Scan(&metadata)
+	if metadata != nil {
+		t.Errorf("expected attributes of erased user to be cleared; got %s", *metadata)
+	}
+
+	var invitations int
+	db.Table("invitations").Where("email = ?", email).Count(&invitations)
+	if invitations != 0 {
+		t.Errorf("expected invitations addressed to erased user to be removed; found %d", invitations)
+	}
+}
+
+func TestImpersonateUser(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	user, err := userFactory("a", "user", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	auth, err := provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s. error: %s", email, err.Error())
+		return
+	}
+
+	sudoerTestId, _ := uuid.NewV4()
+	sudoerEmail := fmt.Sprintf("%s@prvd.local", sudoerTestId.String())
+	sudoer, err := permissionedUserFactory("sudo", "user", sudoerEmail, "passw0rd", identcommon.DefaultSudoerPermission)
+	if err != nil {
+		t.Errorf("sudoer creation failed. Error: %s", err.Error())
+		return
+	}
+
+	sudoerAuth, err := provide.Authenticate(sudoerEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("sudoer authentication failed for user %s. error: %s", sudoerEmail, err.Error())
+		return
+	}
+
+	_, err = impersonateUser(*auth.Token.AccessToken, sudoer.ID.String(), "escalation")
+	if err == nil {
+		t.Error("user without the sudo permission should not be able to impersonate a user")
+		return
+	}
+
+	impersonationToken, err := impersonateUser(*sudoerAuth.Token.AccessToken, user.ID.String(), "support ticket")
+	if err != nil {
+		t.Errorf("failed to impersonate user %s; %s", email, err.Error())
+		return
+	}
+
+	status, _, _ := provide.InitIdentService(impersonationToken).Get(fmt.Sprintf("users/%s", user.ID), map[string]interface{}{})
+	if status != 200 {
+		t.Errorf("expected impersonation token to authorize user details request; status: %d", status)
+		return
+	}
+
+	status, _, _ = provide.InitIdentService(impersonationToken).Put(fmt.Sprintf("users/%s", user.ID), map[string]interface{}{
+		"password": "n3wpassw0rd",
+	})
+	if status != 403 {
+		t.Errorf("expected impersonation token to be forbidden from changing the password; status: %d", status)
+	}
+
+	status, _, _ = provide.InitIdentService(impersonationToken).Post("tokens", map[string]interface{}{})
+	if status != 403 {
+		t.Errorf("expected impersonation token to be forbidden from vending tokens; status: %d", status)
+	}
+
+	entries, err := listUserAuditLog(*auth.Token.AccessToken, user.ID.String())
+	if err != nil {
+		t.Errorf("failed to list audit log of user %s; %s", email, err.Error())
+		return
+	}
+
+	events := map[string]int{}
+	for _, entry := range entries {
+		entry := entry.(map[string]interface{})
+		if entry["actor_id"] != sudoer.ID.String() {
+			t.Errorf("expected audit log entry to identify the impersonating sudoer; got %v", entry["actor_id"])
+		}
+		events[entry["event"].(string)]++
+	}
+
+	if events["impersonation.started"] != 1 {
+		t.Errorf("expected impersonation to be recorded in the audit log; got %v", events)
+	}
+	if events["impersonation.request"] != 3 {
+		t.Errorf("expected each impersonated request to be recorded in the audit log; got %v", events)
+	}
+}
+
+func TestSetUserExpiration(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	user, err := userFactory("a", "contractor", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	auth, err := provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s. error: %s", email, err.Error())
+		return
+	}
+
+	sudoerTestId, _ := uuid.NewV4()
+	sudoerEmail := fmt.Sprintf("%s@prvd.local", sudoerTestId.String())
+	_, err = permissionedUserFactory("sudo", "user", sudoerEmail, "passw0rd", identcommon.DefaultSudoerPermission)
+	if err != nil {
+		t.Errorf("sudoer creation failed. Error: %s", err.Error())
+		return
+	}
+
+	sudoerAuth, err := provide.Authenticate(sudoerEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("sudoer authentication failed for user %s. error: %s", sudoerEmail, err.Error())
+		return
+	}
+
+	expiresAt := time.Now().Add(time.Hour * 24 * 30).UTC().Format(time.RFC3339)
+
+	_, err = setUserExpiration(*auth.Token.AccessToken, user.ID.String(), expiresAt)
+	if err == nil {
+		t.Error("expected user without the update user permission to be forbidden from setting their expiration")
+	}
+
+	_, err = setUserExpiration(*sudoerAuth.Token.AccessToken, user.ID.String(), time.Now().Add(-time.Hour).UTC().Format(time.RFC3339))
+	if err == nil {
+		t.Error("expected expiration in the past to be rejected")
+	}
+
+	resp, err := setUserExpiration(*sudoerAuth.Token.AccessToken, user.ID.String(), expiresAt)
+	if err != nil {
+		t.Errorf("failed to set expiration of user %s; %s", email, err.Error())
+		return
+	}
+
+	if resp["expires_at"] == nil {
+		t.Errorf("expected expiration of user %s to be set; got %v", email, resp)
+	}
+
+	_, err = provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("expected user %s to authenticate prior to expiration; %s", email, err.Error())
+	}
+
+	resp, err = setUserExpiration(*sudoerAuth.Token.AccessToken, user.ID.String(), nil)
+	if err != nil {
+		t.Errorf("failed to clear expiration of user %s; %s", email, err.Error())
+		return
+	}
+
+	if resp["expires_at"] != nil {
+		t.Errorf("expected expiration of user %s to be cleared; got %v", email, resp)
+	}
+}
+
+func TestUpdateUserAttributes(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	user, err := userFactory("a", "user", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	auth, err := provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s. error: %s", email, err.Error())
+		return
+	}
+
+	_, err = provide.CreateApplication(*auth.Token.AccessToken, map[string]interface{}{
+		"name": fmt.Sprintf("app %s", testId.String()),
+		"config": map[string]interface{}{
+			"user_attributes_schema": map[string]interface{}{
+				"department": map[string]interface{}{"type": "uuid"},
+			},
+		},
+	})
+	if err == nil {
+		t.Error("expected application with an invalid user attributes schema to be rejected")
+		return
+	}
+
+	app, err := provide.CreateApplication(*auth.Token.AccessToken, map[string]interface{}{
+		"name": fmt.Sprintf("app %s", testId.String()),
+		"config": map[string]interface{}{
+			"user_attributes_schema": map[string]interface{}{
+				"department": map[string]interface{}{"type": "string", "required": true, "max_length": 32},
+				"seniority":  map[string]interface{}{"type": "string", "enum": []string{"junior", "senior"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create application; %s", err.Error())
+		return
+	}
+
+	updateAttributes := func(token string, attributes map[string]interface{}) int {
+		status, _, _ := provide.InitIdentService(common.StringOrNil(token)).Put(fmt.Sprintf("users/%s", user.ID), map[string]interface{}{
+			"attributes": attributes,
+		})
+		return status
+	}
+
+	status := updateAttributes(*auth.Token.AccessToken, map[string]interface{}{
+		"platform": map[string]interface{}{"nickname": "ace"},
+	})
+	if status != 204 {
+		t.Errorf("expected platform attributes to be updated; status: %d", status)
+		return
+	}
+
+	status = updateAttributes(*auth.Token.AccessToken, map[string]interface{}{
+		app.ID.String(): map[string]interface{}{"seniority": "senior"},
+	})
+	if status != 422 {
+		t.Errorf("expected attributes missing a required attribute to be rejected; status: %d", status)
+	}
+
+	status = updateAttributes(*auth.Token.AccessToken, map[string]interface{}{
+		app.ID.String(): map[string]interface{}{"department": "engineering", "seniority": "principal"},
+	})
+	if status != 422 {
+		t.Errorf("expected attribute which is not one of the permitted values to be rejected; status: %d", status)
+	}
+
+	status = updateAttributes(*auth.Token.AccessToken, map[string]interface{}{
+		app.ID.String(): map[string]interface{}{"department": "engineering", "badge": 42},
+	})
+	if status != 422 {
+		t.Errorf("expected undeclared attribute to be rejected; status: %d", status)
+	}
+
+	status = updateAttributes(*auth.Token.AccessToken, map[string]interface{}{
+		app.ID.String(): map[string]interface{}{"department": "engineering", "seniority": "senior"},
+	})
+	if status != 204 {
+		t.Errorf("expected application attributes to be updated; status: %d", status)
+		return
+	}
+
+	otherTestId, _ := uuid.NewV4()
+	otherEmail := fmt.Sprintf("%s@prvd.local", otherTestId.String())
+	other, err := userFactory("another", "user", otherEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	otherAuth, err := provide.Authenticate(otherEmail, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s. error: %s", otherEmail, err.Error())
+		return
+	}
+
+	status, _, _ = provide.InitIdentService(otherAuth.Token.AccessToken).Put(fmt.Sprintf("users/%s", other.ID), map[string]interface{}{
+		"attributes": map[string]interface{}{
+			app.ID.String(): map[string]interface{}{"department": "sales"},
+		},
+	})
+	if status != 403 {
+		t.Errorf("expected user to be forbidden from writing the attributes of an application of which they are not a member; status: %d", status)
+	}
+
+	_, resp, err := provide.InitIdentService(auth.Token.AccessToken).Get(fmt.Sprintf("users/%s", user.ID), map[string]interface{}{})
+	if err != nil {
+		t.Errorf("failed to fetch user details; %s", err.Error())
+		return
+	}
+
+	attributes, attributesOk := resp.(map[string]interface{})["attributes"].(map[string]interface{})
+	if !attributesOk {
+		t.Errorf("expected user details to include attributes; got %v", resp)
+		return
+	}
+
+	if platform, _ := attributes["platform"].(map[string]interface{}); platform["nickname"] != "ace" {
+		t.Errorf("expected platform attributes to be retained; got %v", attributes)
+	}
+
+	if appAttributes, _ := attributes[app.ID.String()].(map[string]interface{}); appAttributes["department"] != "engineering" {
+		t.Errorf("expected application attributes to be persisted; got %v", attributes)
+	}
+}
+
 func TestUserDetails(t *testing.T) {
 	t.Parallel()
 	testId, err := uuid.NewV4()
@@ -201,8 +841,32 @@ func TestUserUpdate(t *testing.T) {
 			return
 		}
 
+		// the updated email address remains pending until it has been verified
+		if deets.Email != tc.email {
+			t.Errorf("user email not returned correctly. expected %s, got %s", tc.email, deets.Email)
+			return
+		}
+
+		verificationToken, err := emailVerificationTokenFactory(user.ID)
+		if err != nil {
+			t.Errorf("email verification token creation failed for user id %s. Error: %s", user.ID, err.Error())
+			return
+		}
+
+		err = verifyEmail(*verificationToken)
+		if err != nil {
+			t.Errorf("email verification failed for user id %s. Error: %s", user.ID, err.Error())
+			return
+		}
+
+		deets, err = provide.GetUserDetails(*auth.Token.AccessToken, user.ID.String(), map[string]interface{}{})
+		if err != nil {
+			t.Errorf("error getting details for user id %s. Error: %s", user.ID, err.Error())
+			return
+		}
+
 		if deets.Email != updatedEmail {
-			t.Errorf("user email not returned correctly. expected %s, got %s", updatedEmail, deets.Name)
+			t.Errorf("user email not returned correctly. expected %s, got %s", updatedEmail, deets.Email)
 			return
 		}
 
@@ -220,6 +884,278 @@ func TestUserUpdate(t *testing.T) {
 	}
 }
 
+func TestVerifyEmail(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	user, err := userFactory("a", "user", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	verificationToken, err := emailVerificationTokenFactory(user.ID)
+	if err != nil {
+		t.Errorf("email verification token creation failed for user id %s. Error: %s", user.ID, err.Error())
+		return
+	}
+
+	// the verification token should not authorize API requests
+	_, err = provide.GetUserDetails(*verificationToken, user.ID.String(), map[string]interface{}{})
+	if err == nil {
+		t.Error("email verification token should not authorize API requests")
+		return
+	}
+
+	err = verifyEmail(*verificationToken)
+	if err != nil {
+		t.Errorf("email verification failed for user id %s. Error: %s", user.ID, err.Error())
+		return
+	}
+
+	// the verification token should not be usable twice
+	err = verifyEmail(*verificationToken)
+	if err == nil {
+		t.Error("email verification with a previously-used verification token should fail")
+		return
+	}
+
+	usr := identuser.Find(user.ID)
+	if usr == nil || !usr.IsEmailVerified() {
+		t.Errorf("email address not verified for user id %s", user.ID)
+		return
+	}
+}
+
+func TestResetPasswordTokenIsSingleUse(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	user, err := userFactory("a", "user", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	staleToken, err := resetPasswordTokenFactory(email)
+	if err != nil || staleToken == nil {
+		t.Errorf("reset password request failed for user %s", email)
+		return
+	}
+
+	resetToken, err := resetPasswordTokenFactory(email)
+	if err != nil || resetToken == nil {
+		t.Errorf("reset password request failed for user %s", email)
+		return
+	}
+
+	// the token we vended first should have been invalidated by the second request
+	err = provide.ResetPassword(nil, *staleToken, "n3wpassw0rd")
+	if err == nil {
+		t.Error("resetting password with a superseded reset token should fail")
+		return
+	}
+
+	err = provide.ResetPassword(nil, *resetToken, "n3wpassw0rd")
+	if err != nil {
+		t.Errorf("resetting password failed for user %s; %s", email, err.Error())
+		return
+	}
+
+	// the reset token should not be usable twice
+	err = provide.ResetPassword(nil, *resetToken, "an0therpassw0rd")
+	if err == nil {
+		t.Error("resetting password with a previously-used reset token should fail")
+		return
+	}
+
+	// nor should it authorize API requests
+	_, err = provide.GetUserDetails(*resetToken, user.ID.String(), map[string]interface{}{})
+	if err == nil {
+		t.Error("reset token should not authorize API requests")
+		return
+	}
+
+	_, err = provide.Authenticate(email, "n3wpassw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed after password reset for user %s; %s", email, err.Error())
+		return
+	}
+}
+
+func TestDeleteUserSession(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	user, err := userFactory("a", "user", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	auth, err := provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s. error: %s", email, err.Error())
+		return
+	}
+
+	otherAuth, err := provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Errorf("user authentication failed for user %s. error: %s", email, err.Error())
+		return
+	}
+
+	sessions, err := listUserSessions(*auth.Token.AccessToken, user.ID.String())
+	if err != nil {
+		t.Errorf("error listing user sessions. Error: %s", err.Error())
+		return
+	}
+	if len(sessions) != 2 {
+		t.Errorf("expected 2 active sessions for user %s; found %d", email, len(sessions))
+		return
+	}
+
+	// sessions are listed most recent first
+	otherSession, _ := sessions[0].(map[string]interface{})
+	otherSessionID, _ := otherSession["id"].(string)
+
+	err = deleteUserSession(*auth.Token.AccessToken, user.ID.String(), otherSessionID)
+	if err != nil {
+		t.Errorf("error deleting user session. Error: %s", err.Error())
+		return
+	}
+
+	_, err = listUserSessions(*otherAuth.Token.AccessToken, user.ID.String())
+	if err == nil {
+		t.Error("access token for deleted session should no longer be authorized")
+		return
+	}
+
+	_, err = provide.CreateToken(*otherAuth.Token.RefreshToken, map[string]interface{}{
+		"grant_type": "refresh_token",
+	})
+	if err == nil {
+		t.Error("refresh token for deleted session should no longer authorize new access tokens")
+		return
+	}
+
+	sessions, err = listUserSessions(*auth.Token.AccessToken, user.ID.String())
+	if err != nil {
+		t.Errorf("error listing user sessions. Error: %s", err.Error())
+		return
+	}
+	if len(sessions) != 1 {
+		t.Errorf("expected 1 active session for user %s after deletion; found %d", email, len(sessions))
+		return
+	}
+}
+
+func TestMagicLinkIsSingleUse(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	user, err := userFactory("a", "user", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	err = requestMagicLink(email)
+	if err != nil {
+		t.Errorf("error requesting magic link. Error: %s", err.Error())
+		return
+	}
+
+	magicLinkToken, err := magicLinkTokenFactory(user.ID, nil)
+	if err != nil {
+		t.Errorf("error vending magic link token. Error: %s", err.Error())
+		return
+	}
+
+	auth, err := redeemMagicLink(*magicLinkToken, map[string]interface{}{})
+	if err != nil {
+		t.Errorf("error redeeming magic link. Error: %s", err.Error())
+		return
+	}
+	if auth.Token == nil || auth.Token.AccessToken == nil {
+		t.Error("redeeming magic link did not vend an access token")
+		return
+	}
+
+	_, err = redeemMagicLink(*magicLinkToken, map[string]interface{}{})
+	if err == nil {
+		t.Error("redeeming a previously redeemed magic link should fail")
+		return
+	}
+}
+
+func TestMagicLinkRejectedAfterEmailChange(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	user, err := userFactory("a", "user", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	magicLinkToken, err := magicLinkTokenFactory(user.ID, nil)
+	if err != nil {
+		t.Errorf("error vending magic link token. Error: %s", err.Error())
+		return
+	}
+
+	changedTestId, _ := uuid.NewV4()
+	changedEmail := fmt.Sprintf("%s@prvd.local", changedTestId.String())
+	dbconf.DatabaseConnection().Exec("UPDATE users SET email = ? WHERE id = ?", changedEmail, user.ID)
+
+	_, err = redeemMagicLink(*magicLinkToken, map[string]interface{}{})
+	if err == nil {
+		t.Error("redeeming a magic link sent to a previous email address should fail")
+		return
+	}
+}
+
+func TestMagicLinkDeviceBinding(t *testing.T) {
+	t.Parallel()
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	user, err := userFactory("a", "user", email, "passw0rd")
+	if err != nil {
+		t.Errorf("user creation failed. Error: %s", err.Error())
+		return
+	}
+
+	deviceSecret := identcommon.RandomString(32)
+	magicLinkToken, err := magicLinkTokenFactory(user.ID, &deviceSecret)
+	if err != nil {
+		t.Errorf("error vending magic link token. Error: %s", err.Error())
+		return
+	}
+
+	_, err = redeemMagicLink(*magicLinkToken, map[string]interface{}{
+		"device_secret": identcommon.RandomString(32),
+	})
+	if err == nil {
+		t.Error("redeeming a device-bound magic link from another device should fail")
+		return
+	}
+
+	_, err = redeemMagicLink(*magicLinkToken, map[string]interface{}{
+		"device_secret": deviceSecret,
+	})
+	if err != nil {
+		t.Errorf("error redeeming device-bound magic link. Error: %s", err.Error())
+		return
+	}
+}
+
 func TestDeleteUser(t *testing.T) {
 	t.Parallel()
 	t.Logf("TBD - might require soft delete code change?")
@@ -3,9 +3,15 @@
 package integration
 
 import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
 	dbconf "github.com/kthomas/go-db-config"
 	uuid "github.com/kthomas/go.uuid"
 	identcommon "github.com/provideplatform/ident/common"
+	identorganization "github.com/provideplatform/ident/organization"
+	common "github.com/provideplatform/provide-go/common"
 	identuser "github.com/provideplatform/ident/user"
 	provide "github.com/provideplatform/provide-go/api/ident"
 )
@@ -70,6 +76,14 @@ func orgFactory(token, name, desc string) (*provide.Organization, error) {
 	})
 }
 
+func subOrgFactory(token, parentOrganizationID, name, desc string) (*provide.Organization, error) {
+	return provide.CreateOrganization(token, map[string]interface{}{
+		"name":                   name,
+		"description":            desc,
+		"parent_organization_id": parentOrganizationID,
+	})
+}
+
 func apporgFactory(token, applicationID, organizationID string) error {
 	return provide.CreateApplicationOrganization(token, applicationID, map[string]interface{}{
 		"organization_id": organizationID,
@@ -94,3 +108,455 @@ func orgUserTokenFactory(auth string, organizationID, userID uuid.UUID) (*provid
 		"user_id":         userID,
 	})
 }
+
+func authenticateStatus(email, password string) (int, error) {
+	status, _, err := provide.InitIdentService(nil).Post("authenticate", map[string]interface{}{
+		"email":    email,
+		"password": password,
+	})
+	return status, err
+}
+
+func resetPasswordTokenFactory(email string) (*string, error) {
+	err := provide.RequestPasswordReset(nil, nil, email)
+	if err != nil {
+		return nil, err
+	}
+
+	usr := &identuser.User{}
+	db := dbconf.DatabaseConnection()
+	db.Where("email = ?", email).Find(&usr)
+	return usr.ResetPasswordToken, nil
+}
+
+func emailVerificationTokenFactory(userID uuid.UUID) (*string, error) {
+	usr := identuser.Find(userID)
+	if usr == nil {
+		return nil, fmt.Errorf("user not found: %s", userID)
+	}
+
+	tkn, err := usr.VendEmailVerificationToken()
+	if err != nil {
+		return nil, err
+	}
+	return tkn.Token, nil
+}
+
+func verifyEmail(verificationToken string) error {
+	status, _, err := provide.InitIdentService(nil).Post(fmt.Sprintf("users/verify_email/%s", verificationToken), map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	if status != 204 {
+		return fmt.Errorf("failed to verify email address; status: %d", status)
+	}
+	return nil
+}
+
+func unlockUser(token, userID string) error {
+	status, _, err := provide.InitIdentService(common.StringOrNil(token)).Post(fmt.Sprintf("users/%s/unlock", userID), map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	if status != 204 {
+		return fmt.Errorf("failed to unlock user; status: %d", status)
+	}
+	return nil
+}
+
+func suspendUser(token, userID, reason string) error {
+	status, _, err := provide.InitIdentService(common.StringOrNil(token)).Post(fmt.Sprintf("users/%s/suspend", userID), map[string]interface{}{
+		"reason": reason,
+	})
+	if err != nil {
+		return err
+	}
+	if status != 204 {
+		return fmt.Errorf("failed to suspend user; status: %d", status)
+	}
+	return nil
+}
+
+func reactivateUser(token, userID string) error {
+	status, _, err := provide.InitIdentService(common.StringOrNil(token)).Post(fmt.Sprintf("users/%s/reactivate", userID), map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	if status != 204 {
+		return fmt.Errorf("failed to reactivate user; status: %d", status)
+	}
+	return nil
+}
+
+func setUserExpiration(token, userID string, expiresAt interface{}) (map[string]interface{}, error) {
+	status, resp, err := provide.InitIdentService(common.StringOrNil(token)).Put(fmt.Sprintf("users/%s/expiration", userID), map[string]interface{}{
+		"expires_at": expiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("failed to set user expiration; status: %d", status)
+	}
+	return resp.(map[string]interface{}), nil
+}
+
+func exportUser(token, userID string) (map[string]interface{}, error) {
+	status, resp, err := provide.InitIdentService(common.StringOrNil(token)).Post(fmt.Sprintf("users/%s/export", userID), map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("failed to export user; status: %d", status)
+	}
+	return resp.(map[string]interface{}), nil
+}
+
+func eraseUser(token, userID string) error {
+	status, _, err := provide.InitIdentService(common.StringOrNil(token)).Post(fmt.Sprintf("users/%s/erase", userID), map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	if status != 202 {
+		return fmt.Errorf("failed to erase user; status: %d", status)
+	}
+	return nil
+}
+
+func impersonateUser(token, userID, reason string) (*string, error) {
+	status, resp, err := provide.InitIdentService(common.StringOrNil(token)).Post(fmt.Sprintf("users/%s/impersonate", userID), map[string]interface{}{
+		"reason": reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if status != 201 {
+		return nil, fmt.Errorf("failed to impersonate user; status: %d", status)
+	}
+	tkn, tknOk := resp.(map[string]interface{})["token"].(string)
+	if !tknOk {
+		tkn, _ = resp.(map[string]interface{})["access_token"].(string)
+	}
+	return common.StringOrNil(tkn), nil
+}
+
+func listUserAuditLog(token, userID string) ([]interface{}, error) {
+	status, resp, err := provide.InitIdentService(common.StringOrNil(token)).Get(fmt.Sprintf("users/%s/audit_log", userID), map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("failed to list user audit log; status: %d", status)
+	}
+	return resp.([]interface{}), nil
+}
+
+func listUserSessions(token, userID string) ([]interface{}, error) {
+	status, resp, err := provide.InitIdentService(common.StringOrNil(token)).Get(fmt.Sprintf("users/%s/sessions", userID), map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("failed to list user sessions; status: %d", status)
+	}
+	sessions, _ := resp.([]interface{})
+	return sessions, nil
+}
+
+func deleteUserSession(token, userID, sessionID string) error {
+	status, _, err := provide.InitIdentService(common.StringOrNil(token)).Delete(fmt.Sprintf("users/%s/sessions/%s", userID, sessionID))
+	if err != nil {
+		return err
+	}
+	if status != 204 {
+		return fmt.Errorf("failed to delete user session; status: %d", status)
+	}
+	return nil
+}
+
+func listInvitations(token string, params map[string]interface{}) ([]interface{}, error) {
+	status, resp, err := provide.InitIdentService(common.StringOrNil(token)).Get("invitations", params)
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("failed to list invitations; status: %d", status)
+	}
+	return resp.([]interface{}), nil
+}
+
+func invitationTokenFactory(invitorID uuid.UUID, organizationID *uuid.UUID, email string) (*string, error) {
+	invite := &identuser.Invite{
+		Email:          identcommon.StringOrNil(email),
+		InvitorID:      &invitorID,
+		OrganizationID: organizationID,
+	}
+	if !invite.Create() {
+		return nil, fmt.Errorf("failed to create invitation for %s", email)
+	}
+	return invite.Token.Token, nil
+}
+
+func resendInvitation(token, invitationID string) (map[string]interface{}, error) {
+	status, resp, err := provide.InitIdentService(common.StringOrNil(token)).Post(fmt.Sprintf("invitations/%s/resend", invitationID), map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("failed to resend invitation; status: %d", status)
+	}
+	return resp.(map[string]interface{}), nil
+}
+
+func revokeInvitation(token, invitationID string) error {
+	status, _, err := provide.InitIdentService(common.StringOrNil(token)).Delete(fmt.Sprintf("invitations/%s", invitationID))
+	if err != nil {
+		return err
+	}
+	if status != 204 {
+		return fmt.Errorf("failed to revoke invitation; status: %d", status)
+	}
+	return nil
+}
+
+func createOrganizationInvitationJob(token, organizationID string, rows []interface{}) (int, map[string]interface{}, error) {
+	status, resp, err := provide.InitIdentService(common.StringOrNil(token)).Post(fmt.Sprintf("organizations/%s/invitation_jobs", organizationID), map[string]interface{}{
+		"rows": rows,
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return status, resp.(map[string]interface{}), nil
+}
+
+func fetchOrganizationInvitationJob(token, organizationID, jobID string) (map[string]interface{}, error) {
+	status, resp, err := provide.InitIdentService(common.StringOrNil(token)).Get(fmt.Sprintf("organizations/%s/invitation_jobs/%s", organizationID, jobID), map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("failed to fetch invitation job; status: %d", status)
+	}
+	return resp.(map[string]interface{}), nil
+}
+
+func deleteOrganization(token, organizationID string) error {
+	status, _, err := provide.InitIdentService(common.StringOrNil(token)).Delete(fmt.Sprintf("organizations/%s", organizationID))
+	if err != nil {
+		return err
+	}
+	if status != 204 {
+		return fmt.Errorf("failed to delete organization; status: %d", status)
+	}
+	return nil
+}
+
+func requestOrganizationOwnershipTransfer(token, organizationID, userID string) error {
+	status, _, err := provide.InitIdentService(common.StringOrNil(token)).Post(fmt.Sprintf("organizations/%s/ownership_transfer", organizationID), map[string]interface{}{
+		"user_id": userID,
+	})
+	if err != nil {
+		return err
+	}
+	if status != 204 {
+		return fmt.Errorf("failed to request organization ownership transfer; status: %d", status)
+	}
+	return nil
+}
+
+func ownershipTransferTokenFactory(organizationID uuid.UUID) (*string, error) {
+	org := &identorganization.Organization{}
+	dbconf.DatabaseConnection().Where("id = ?", organizationID).Find(&org)
+	if org.ID == uuid.Nil {
+		return nil, fmt.Errorf("organization not found: %s", organizationID)
+	}
+
+	if org.OwnershipTransferToken == nil {
+		return nil, fmt.Errorf("no ownership transfer requested for organization: %s", organizationID)
+	}
+	return org.OwnershipTransferToken, nil
+}
+
+func acceptOrganizationOwnershipTransfer(token, organizationID, transferToken string) error {
+	status, _, err := provide.InitIdentService(common.StringOrNil(token)).Post(fmt.Sprintf("organizations/%s/ownership_transfer/accept", organizationID), map[string]interface{}{
+		"token": transferToken,
+	})
+	if err != nil {
+		return err
+	}
+	if status != 204 {
+		return fmt.Errorf("failed to accept organization ownership transfer; status: %d", status)
+	}
+	return nil
+}
+
+func listOrganizationDescendants(token, organizationID string) ([]interface{}, error) {
+	status, resp, err := provide.InitIdentService(common.StringOrNil(token)).Get(fmt.Sprintf("organizations/%s/descendants", organizationID), map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("failed to list organization descendants; status: %d", status)
+	}
+	return resp.([]interface{}), nil
+}
+
+func listOrganizationRoles(token, organizationID string) ([]interface{}, error) {
+	status, resp, err := provide.InitIdentService(common.StringOrNil(token)).Get(fmt.Sprintf("organizations/%s/roles", organizationID), map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("failed to list organization roles; status: %d", status)
+	}
+	return resp.([]interface{}), nil
+}
+
+func createOrganizationRole(token, organizationID string, params map[string]interface{}) (map[string]interface{}, error) {
+	status, resp, err := provide.InitIdentService(common.StringOrNil(token)).Post(fmt.Sprintf("organizations/%s/roles", organizationID), params)
+	if err != nil {
+		return nil, err
+	}
+	if status != 201 {
+		return nil, fmt.Errorf("failed to create organization role; status: %d", status)
+	}
+	return resp.(map[string]interface{}), nil
+}
+
+func deleteOrganizationRole(token, organizationID, roleID string) error {
+	status, _, err := provide.InitIdentService(common.StringOrNil(token)).Delete(fmt.Sprintf("organizations/%s/roles/%s", organizationID, roleID))
+	if err != nil {
+		return err
+	}
+	if status != 204 {
+		return fmt.Errorf("failed to delete organization role; status: %d", status)
+	}
+	return nil
+}
+
+func listOrganizationDomains(token, organizationID string) ([]interface{}, error) {
+	status, resp, err := provide.InitIdentService(common.StringOrNil(token)).Get(fmt.Sprintf("organizations/%s/domains", organizationID), map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("failed to list organization domains; status: %d", status)
+	}
+	return resp.([]interface{}), nil
+}
+
+func createOrganizationDomain(token, organizationID, domain string) (map[string]interface{}, error) {
+	status, resp, err := provide.InitIdentService(common.StringOrNil(token)).Post(fmt.Sprintf("organizations/%s/domains", organizationID), map[string]interface{}{
+		"domain": domain,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if status != 201 {
+		return nil, fmt.Errorf("failed to create organization domain; status: %d", status)
+	}
+	return resp.(map[string]interface{}), nil
+}
+
+func verifyOrganizationDomain(token, organizationID, domainID string) error {
+	status, _, err := provide.InitIdentService(common.StringOrNil(token)).Post(fmt.Sprintf("organizations/%s/domains/%s/verify", organizationID, domainID), map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	if status != 200 {
+		return fmt.Errorf("failed to verify organization domain; status: %d", status)
+	}
+	return nil
+}
+
+func deleteOrganizationDomain(token, organizationID, domainID string) error {
+	status, _, err := provide.InitIdentService(common.StringOrNil(token)).Delete(fmt.Sprintf("organizations/%s/domains/%s", organizationID, domainID))
+	if err != nil {
+		return err
+	}
+	if status != 204 {
+		return fmt.Errorf("failed to delete organization domain; status: %d", status)
+	}
+	return nil
+}
+
+func magicLinkTokenFactory(userID uuid.UUID, deviceSecret *string) (*string, error) {
+	usr := identuser.Find(userID)
+	if usr == nil {
+		return nil, fmt.Errorf("user not found: %s", userID)
+	}
+
+	tkn, err := usr.VendMagicLinkToken(deviceSecret)
+	if err != nil {
+		return nil, err
+	}
+	return tkn.Token, nil
+}
+
+func requestMagicLink(email string) error {
+	status, _, err := provide.InitIdentService(nil).Post("authenticate/magic_link", map[string]interface{}{
+		"email": email,
+	})
+	if err != nil {
+		return err
+	}
+	if status != 202 {
+		return fmt.Errorf("failed to request magic link; status: %d", status)
+	}
+	return nil
+}
+
+func redeemMagicLink(magicLinkToken string, params map[string]interface{}) (*provide.AuthenticationResponse, error) {
+	status, resp, err := provide.InitIdentService(nil).Post(fmt.Sprintf("authenticate/magic_link/%s", magicLinkToken), params)
+	if err != nil {
+		return nil, err
+	}
+	if status != 201 {
+		return nil, fmt.Errorf("failed to redeem magic link; status: %d", status)
+	}
+
+	authresp := &provide.AuthenticationResponse{}
+	raw, _ := json.Marshal(resp)
+	err = json.Unmarshal(raw, &authresp)
+	if err != nil {
+		return nil, err
+	}
+	return authresp, nil
+}
+
+func scimService(token string) *provide.Service {
+	svc := provide.InitIdentService(common.StringOrNil(token))
+	svc.Path = "scim/v2"
+	return svc
+}
+
+func scimOrganizationFactory(t *testing.T) (*provide.Organization, *provide.User, string) {
+	testId, _ := uuid.NewV4()
+	email := fmt.Sprintf("%s@prvd.local", testId.String())
+
+	owner, err := userFactory("scim", "owner", email, "passw0rd")
+	if err != nil {
+		t.Fatalf("user creation failed. Error: %s", err.Error())
+	}
+
+	auth, err := provide.Authenticate(email, "passw0rd")
+	if err != nil {
+		t.Fatalf("user authentication failed. Error: %s", err.Error())
+	}
+
+	org, err := orgFactory(*auth.Token.AccessToken, fmt.Sprintf("Org %s", testId.String()), "scim organization")
+	if err != nil {
+		t.Fatalf("organization creation failed. Error: %s", err.Error())
+	}
+
+	orgToken, err := orgTokenFactory(*auth.Token.AccessToken, org.ID)
+	if err != nil {
+		t.Fatalf("error vending organization token. Error: %s", err.Error())
+	}
+
+	tkn := orgToken.Token
+	if tkn == nil {
+		tkn = orgToken.AccessToken
+	}
+	return org, owner, *tkn
+}
@@ -0,0 +1,105 @@
+package token
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+// AuditEventImpersonationStarted is recorded when a sudoer is vended a token to impersonate a user
+const AuditEventImpersonationStarted = "impersonation.started"
+
+// AuditEventImpersonatedRequest is recorded for every request authorized by an impersonation token
+const AuditEventImpersonatedRequest = "impersonation.request"
+
+// AuditLogEntry records an action taken on behalf of a user by another actor; entries are
+// visible to the user on whose behalf the action was taken
+type AuditLogEntry struct {
+	provide.Model
+	UserID    *uuid.UUID `sql:"not null;type:uuid" json:"user_id"`
+	ActorID   *uuid.UUID `sql:"type:uuid" json:"actor_id,omitempty"`
+	Event     *string    `sql:"not null" json:"event"`
+	Method    *string    `json:"method,omitempty"`
+	Path      *string    `json:"path,omitempty"`
+	Status    *int       `json:"status,omitempty"`
+	IPAddress *string    `json:"ip_address,omitempty"`
+	UserAgent *string    `json:"user_agent,omitempty"`
+	Reason    *string    `json:"reason,omitempty"`
+}
+
+// TableName returns the db table name for gorm
+func (e *AuditLogEntry) TableName() string {
+	return "audit_log_entries"
+}
+
+// Create and persist an audit log entry
+func (e *AuditLogEntry) Create(tx *gorm.DB) bool {
+	if !e.validate() {
+		return false
+	}
+
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+	}
+
+	if db.NewRecord(e) {
+		result := db.Create(&e)
+		rowsAffected := result.RowsAffected
+		errors := result.GetErrors()
+		if len(errors) > 0 {
+			for _, err := range errors {
+				e.Errors = append(e.Errors, &provide.Error{
+					Message: common.StringOrNil(err.Error()),
+				})
+			}
+		}
+		return rowsAffected > 0 && len(e.Errors) == 0
+	}
+
+	return false
+}
+
+// validate the audit log entry
+func (e *AuditLogEntry) validate() bool {
+	e.Errors = make([]*provide.Error, 0)
+
+	if e.UserID == nil || *e.UserID == uuid.Nil {
+		e.Errors = append(e.Errors, &provide.Error{
+			Message: common.StringOrNil("audit log entry requires a user_id"),
+		})
+	}
+
+	if e.Event == nil {
+		e.Errors = append(e.Errors, &provide.Error{
+			Message: common.StringOrNil("audit log entry requires an event"),
+		})
+	}
+
+	return len(e.Errors) == 0
+}
+
+// auditImpersonatedRequest records the request in the given gin context, which has been
+// authorized by the given impersonation token, in the audit log of the impersonated user
+func auditImpersonatedRequest(c *gin.Context, token *Token) {
+	status := c.Writer.Status()
+	entry := &AuditLogEntry{
+		UserID:    token.UserID,
+		ActorID:   token.ActorID,
+		Event:     common.StringOrNil(AuditEventImpersonatedRequest),
+		Method:    common.StringOrNil(c.Request.Method),
+		Path:      common.StringOrNil(c.Request.URL.Path),
+		Status:    &status,
+		IPAddress: common.StringOrNil(c.ClientIP()),
+		UserAgent: common.StringOrNil(c.Request.UserAgent()),
+	}
+
+	if !entry.Create(nil) {
+		common.Log.Warningf("failed to audit request made by %s impersonating user: %s", token.ActorID, token.UserID)
+	}
+}
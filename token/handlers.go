@@ -10,6 +10,7 @@ import (
 
 	// "github.com/provideplatform/ident/application"
 	"github.com/provideplatform/ident/common"
+	"github.com/provideplatform/ident/role"
 	provide "github.com/provideplatform/provide-go/common"
 	util "github.com/provideplatform/provide-go/common/util"
 )
@@ -53,6 +54,10 @@ func tokensListHandler(c *gin.Context) {
 
 func createTokenHandler(c *gin.Context) {
 	bearer := InContext(c)
+	if bearer.IsImpersonation() {
+		provide.RenderError("impersonation tokens cannot be used to vend tokens", 403, c)
+		return
+	}
 
 	buf, err := c.GetRawData()
 	if err != nil {
@@ -85,9 +90,11 @@ func createTokenHandler(c *gin.Context) {
 	var appID *uuid.UUID
 	if applicationID, ok := params["application_id"].(string); ok {
 		appUUID, err := uuid.FromString(applicationID)
-		if err == nil {
-			appID = &appUUID
+		if err != nil {
+			provide.RenderError(fmt.Sprintf("invalid application_id: %s", applicationID), 422, c)
+			return
 		}
+		appID = &appUUID
 	} else if bearer.ApplicationID != nil && *bearer.ApplicationID != uuid.Nil {
 		appID = bearer.ApplicationID
 	}
@@ -95,9 +102,11 @@ func createTokenHandler(c *gin.Context) {
 	var orgID *uuid.UUID
 	if organizationID, ok := params["organization_id"].(string); ok {
 		orgUUID, err := uuid.FromString(organizationID)
-		if err == nil {
-			orgID = &orgUUID
+		if err != nil {
+			provide.RenderError(fmt.Sprintf("invalid organization_id: %s", organizationID), 422, c)
+			return
 		}
+		orgID = &orgUUID
 	} else if bearer.OrganizationID != nil && *bearer.OrganizationID != uuid.Nil {
 		orgID = bearer.OrganizationID
 	}
@@ -105,13 +114,27 @@ func createTokenHandler(c *gin.Context) {
 	var userID *uuid.UUID
 	if usrID, ok := params["user_id"].(string); ok {
 		userUUID, err := uuid.FromString(usrID)
-		if err == nil {
-			userID = &userUUID
+		if err != nil {
+			provide.RenderError(fmt.Sprintf("invalid user_id: %s", usrID), 422, c)
+			return
 		}
+		userID = &userUUID
 	} else if bearer.UserID != nil && *bearer.UserID != uuid.Nil {
 		userID = bearer.UserID
 	}
 
+	isSudo := bearer.HasPermission(common.Sudo)
+
+	if !isSudo && userID != nil && bearer.UserID != nil && *bearer.UserID != uuid.Nil && *userID != *bearer.UserID {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	if !isSudo && orgID != nil && !authorizesOrganization(bearer, *orgID, userID) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
 	var audience *string
 	if aud, audOk := params["aud"].(string); audOk {
 		altAudience, altAudienceOk := util.JWTAlternativeAuthorizationAudiences[aud].(string)
@@ -136,6 +159,44 @@ func createTokenHandler(c *gin.Context) {
 		}
 
 		permissions = common.Permission(out[0])
+
+		var suspended int
+		db.Table("users").Where("id = ? AND disabled_at IS NOT NULL", userID).Count(&suspended)
+		if suspended > 0 {
+			provide.RenderError("user has been suspended", 403, c)
+			return
+		}
+	}
+
+	if orgID != nil {
+		var suspended int
+		dbconf.DatabaseConnection().Table("organizations").Where("id = ? AND disabled_at IS NOT NULL", orgID).Count(&suspended)
+		if suspended > 0 {
+			provide.RenderError("organization has been suspended", 403, c)
+			return
+		}
+	}
+
+	if userID != nil && orgID != nil {
+		// organizations may require members to have verified their email address
+		var unverified int
+		dbconf.DatabaseConnection().Table("users").
+			Joins("JOIN organizations ON organizations.id = ?", orgID).
+			Where("users.id = ? AND users.email_verified_at IS NULL AND organizations.metadata->>'require_verified_email' = 'true'", userID).
+			Count(&unverified)
+		if unverified > 0 {
+			provide.RenderError("organization requires a verified email address", 403, c)
+			return
+		}
+	}
+
+	var extendedPermissions map[string]common.Permission
+	if userID != nil && orgID != nil {
+		// the resource permissions of a user are scoped by its role in the organization
+		if mask, extPermissions, ok := role.EffectiveOrganizationPermissions(dbconf.DatabaseConnection(), *orgID, *userID); ok {
+			permissions = (permissions &^ role.ResourcePermissions) | (mask & role.ResourcePermissions)
+			extendedPermissions = extPermissions
+		}
 	}
 
 	tkn := &Token{
@@ -147,12 +208,32 @@ func createTokenHandler(c *gin.Context) {
 		Scope:          scope,
 	}
 
+	if extendedPermissions != nil {
+		rawExtPermissions, _ := json.Marshal(extendedPermissions)
+		extPermissionsJSON := json.RawMessage(rawExtPermissions)
+		tkn.ExtendedPermissions = &extPermissionsJSON
+	}
+
 	offlineAccess := scope != nil && *scope == authorizationScopeOfflineAccess
 
 	if appID != nil && !offlineAccess {
 		// overwrite tkn
 		db := dbconf.DatabaseConnection()
-		tkn, err = VendApplicationToken(db, appID, orgID, userID, nil, audience) // FIXME-- support users and extended permissions
+
+		var appExtendedPermissions map[string]common.Permission
+		if userID != nil {
+			if _, extPermissions, ok := role.EffectiveApplicationPermissions(db, *appID, *userID); ok {
+				appExtendedPermissions = map[string]common.Permission{}
+				for resource, mask := range defaultApplicationExtendedPermissions {
+					appExtendedPermissions[resource] = mask
+				}
+				for resource, mask := range extPermissions {
+					appExtendedPermissions[resource] = mask
+				}
+			}
+		}
+
+		tkn, err = VendApplicationToken(db, appID, orgID, userID, appExtendedPermissions, audience)
 		if err != nil {
 			provide.RenderError(err.Error(), 401, c)
 			return
@@ -221,3 +302,28 @@ func deleteTokenHandler(c *gin.Context) {
 	tx.Commit()
 	provide.Render(nil, 204, c)
 }
+
+// authorizesOrganization returns true if a token scoped to the given organization may be vended
+// by the bearer; tokens vended on behalf of a user require the user to be a member of the
+// organization, otherwise the bearer must be scoped to the organization or to an application
+// associated with it
+func authorizesOrganization(bearer *Token, orgID uuid.UUID, userID *uuid.UUID) bool {
+	db := dbconf.DatabaseConnection()
+
+	if userID != nil && *userID != uuid.Nil {
+		_, _, isMember := role.EffectiveOrganizationPermissions(db, orgID, *userID)
+		return isMember
+	}
+
+	if bearer.OrganizationID != nil && *bearer.OrganizationID == orgID {
+		return true
+	}
+
+	if bearer.ApplicationID != nil && *bearer.ApplicationID != uuid.Nil {
+		var associations int
+		db.Table("applications_organizations").Where("application_id = ? AND organization_id = ?", bearer.ApplicationID, orgID).Count(&associations)
+		return associations > 0
+	}
+
+	return false
+}
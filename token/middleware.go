@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	dbconf "github.com/kthomas/go-db-config"
 	"github.com/provideplatform/ident/common"
 	provide "github.com/provideplatform/provide-go/common"
 )
@@ -51,6 +52,10 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 		c.Next()
+
+		if token.IsImpersonation() {
+			auditImpersonatedRequest(c, token)
+		}
 	}
 }
 
@@ -68,6 +73,26 @@ func authorize(c *gin.Context) *Token {
 		common.Log.Tracef("bearer token authorization failed; %s", err.Error())
 		return nil
 	}
+	if token.IsRestricted() {
+		common.Log.Tracef("bearer token authorization failed; restricted audience: %s", *token.Audience)
+		return nil
+	}
+	if token.SessionID != nil && !IsSessionActive(*token.SessionID) {
+		common.Log.Tracef("bearer token authorization failed; session is no longer active: %s", token.SessionID)
+		return nil
+	}
+	if token.UserID != nil && IsUserTokenRevoked(dbconf.DatabaseConnection(), token) {
+		common.Log.Tracef("bearer token authorization failed; tokens have been revoked for user: %s", token.UserID)
+		return nil
+	}
+	if token.OrganizationID != nil && IsOrganizationTokenRevoked(dbconf.DatabaseConnection(), token) {
+		common.Log.Tracef("bearer token authorization failed; tokens have been revoked for organization: %s", token.OrganizationID)
+		return nil
+	}
+	if token.UserID != nil && IsMembershipTokenRevoked(dbconf.DatabaseConnection(), token) {
+		common.Log.Tracef("bearer token authorization failed; tokens have been revoked for membership of user: %s", token.UserID)
+		return nil
+	}
 	if token.UserID == nil && token.ApplicationID == nil && token.OrganizationID == nil && !token.IsRefreshToken {
 		subject := "< not provided >"
 		if token.Subject != nil {
@@ -0,0 +1,205 @@
+package token
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+// Session represents a single authenticated login on behalf of a user (i.e., from a specific device);
+// every access and refresh token vended for the session asserts its id in the sid claim, so revoking
+// the session revokes its refresh token family along with any outstanding access tokens
+type Session struct {
+	provide.Model
+	UserID        *uuid.UUID `sql:"not null;type:uuid" json:"user_id"`
+	ApplicationID *uuid.UUID `sql:"type:uuid" json:"application_id,omitempty"`
+	UserAgent     *string    `json:"user_agent"`
+	IPAddress     *string    `json:"ip_address"`
+	RefreshedAt   *time.Time `json:"refreshed_at"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+	RevokedAt     *time.Time `json:"-"`
+
+	// RefreshTokenFamily is the jti of the refresh token vended when the session was
+	// established; all access tokens subsequently authorized by it descend from the family
+	RefreshTokenFamily *uuid.UUID `sql:"type:uuid" json:"-"`
+	RefreshTokenHash   *string    `json:"-"`
+}
+
+// FindSession returns the active session for the given id
+func FindSession(db *gorm.DB, sessionID uuid.UUID) *Session {
+	session := &Session{}
+	db.Where("id = ? AND revoked_at IS NULL", sessionID).Find(&session)
+	if session == nil || session.ID == uuid.Nil {
+		return nil
+	}
+	return session
+}
+
+// IsSessionActive returns true if the session with the given id exists and has not
+// been revoked or expired
+func IsSessionActive(sessionID uuid.UUID) bool {
+	session := FindSession(dbconf.DatabaseConnection(), sessionID)
+	return session != nil && !session.IsExpired()
+}
+
+// ActiveSessionsQuery returns a query scoped to the active sessions of the given user
+func ActiveSessionsQuery(db *gorm.DB, userID uuid.UUID) *gorm.DB {
+	return db.Where("user_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", userID, time.Now())
+}
+
+// IsExpired returns true if the session has expired
+func (s *Session) IsExpired() bool {
+	return s.ExpiresAt != nil && time.Now().After(*s.ExpiresAt)
+}
+
+// Create and persist a new session
+func (s *Session) Create(tx *gorm.DB) bool {
+	if !s.validate() {
+		return false
+	}
+
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+	}
+
+	if db.NewRecord(s) {
+		result := db.Create(&s)
+		rowsAffected := result.RowsAffected
+		errors := result.GetErrors()
+		if len(errors) > 0 {
+			for _, err := range errors {
+				s.Errors = append(s.Errors, &provide.Error{
+					Message: common.StringOrNil(err.Error()),
+				})
+			}
+		}
+		return rowsAffected > 0 && len(s.Errors) == 0
+	}
+
+	return false
+}
+
+// Vend the given token on behalf of the session, asserting the session id in its sid claim;
+// the session tracks the refresh token family and expiration of the vended token
+func (s *Session) Vend(tx *gorm.DB, t *Token) bool {
+	if s.ID == uuid.Nil {
+		t.Errors = append(t.Errors, &provide.Error{
+			Message: common.StringOrNil("failed to vend token for session without id"),
+		})
+		return false
+	}
+
+	t.SessionID = &s.ID
+	if !t.Vend() {
+		return false
+	}
+
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+	}
+
+	updates := map[string]interface{}{}
+	if t.refreshTokenID != nil && t.RefreshToken != nil {
+		s.RefreshTokenFamily = t.refreshTokenID
+		s.RefreshTokenHash = common.StringOrNil(common.SHA256(*t.RefreshToken))
+		s.ExpiresAt = t.refreshTokenExpiresAt
+		updates["refresh_token_family"] = s.RefreshTokenFamily
+		updates["refresh_token_hash"] = s.RefreshTokenHash
+	} else {
+		s.ExpiresAt = t.ExpiresAt
+	}
+	updates["expires_at"] = s.ExpiresAt
+
+	result := db.Model(&Session{}).Where("id = ?", s.ID).Updates(updates)
+	if result.Error != nil {
+		t.Errors = append(t.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("failed to update session: %s; %s", s.ID, result.Error.Error())),
+		})
+		return false
+	}
+
+	return true
+}
+
+// markSessionRefreshed records that a new access token has been authorized for the given session
+func markSessionRefreshed(db *gorm.DB, sessionID uuid.UUID) {
+	result := db.Model(&Session{}).Where("id = ?", sessionID).Update("refreshed_at", time.Now())
+	if result.Error != nil {
+		common.Log.Warningf("failed to mark session refreshed: %s; %s", sessionID, result.Error.Error())
+	}
+}
+
+// Revoke the session; its refresh token is revoked and access tokens asserting the
+// session in the sid claim are no longer authorized
+func (s *Session) Revoke(tx *gorm.DB) bool {
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+		db = db.Begin()
+		defer db.RollbackUnlessCommitted()
+	}
+
+	revokedAt := time.Now()
+	result := db.Model(&Session{}).Where("id = ? AND revoked_at IS NULL", s.ID).Update("revoked_at", revokedAt)
+	if result.Error != nil {
+		s.Errors = append(s.Errors, &provide.Error{
+			Message: common.StringOrNil(result.Error.Error()),
+		})
+		return false
+	}
+	if result.RowsAffected == 0 {
+		s.Errors = append(s.Errors, &provide.Error{
+			Message: common.StringOrNil("session has already been revoked"),
+		})
+		return false
+	}
+
+	if s.RefreshTokenHash != nil {
+		revocation := &Revocation{
+			Hash:      s.RefreshTokenHash,
+			ExpiresAt: s.ExpiresAt,
+			RevokedAt: &revokedAt,
+		}
+
+		result = db.Create(&revocation)
+		if result.Error != nil {
+			s.Errors = append(s.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("failed to revoke refresh token; %s", result.Error.Error())),
+			})
+			return false
+		}
+	}
+
+	if tx == nil {
+		db.Commit()
+	}
+
+	s.RevokedAt = &revokedAt
+	common.Log.Debugf("revoked session: %s", s.ID)
+	return true
+}
+
+func (s *Session) validate() bool {
+	s.Errors = make([]*provide.Error, 0)
+
+	if s.UserID == nil || *s.UserID == uuid.Nil {
+		s.Errors = append(s.Errors, &provide.Error{
+			Message: common.StringOrNil("session must belong to a user"),
+		})
+	}
+
+	return len(s.Errors) == 0
+}
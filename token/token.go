@@ -33,6 +33,18 @@ const authorizationSubjectUser = "user"
 const defaultRefreshTokenTTL = time.Hour * 24 * 30
 const defaultAccessTokenTTL = time.Minute * 60
 
+// EmailVerificationAudience is the audience asserted by single-use email address verification tokens
+const EmailVerificationAudience = "email_verification"
+
+// MagicLinkAudience is the audience asserted by single-use, passwordless magic link login tokens
+const MagicLinkAudience = "magic_link"
+
+// OwnershipTransferAudience is the audience asserted by single-use organization ownership transfer tokens
+const OwnershipTransferAudience = "ownership_transfer"
+
+// PasswordResetAudience is the audience asserted by single-use reset password tokens
+const PasswordResetAudience = "password_reset"
+
 const extendedApplicationClaimsKey = "extended"
 const wildcardApplicationResource = "*"
 
@@ -40,6 +52,15 @@ var defaultApplicationExtendedPermissions = map[string]common.Permission{
 	wildcardApplicationResource: common.DefaultApplicationResourcePermission,
 }
 
+// restrictedAudiences are audiences for tokens which authorize a single, specific action
+// and must never be accepted as bearer authorization for the API
+var restrictedAudiences = map[string]bool{
+	EmailVerificationAudience: true,
+	MagicLinkAudience:         true,
+	OwnershipTransferAudience: true,
+	PasswordResetAudience:     true,
+}
+
 // Token instances can be ephemeral (access/refresh style) or "legacy" -- in the sense that
 // a "legacy" token never expires and is persisted along with its hashed representation
 type Token struct {
@@ -70,6 +91,8 @@ type Token struct {
 	ExpiresAt *time.Time `sql:"-" json:"expires_at,omitempty"`
 	NotBefore *time.Time `sql:"-" json:"not_before_at,omitempty"`
 	Subject   *string    `sql:"-" json:"subject,omitempty"`
+	SessionID *uuid.UUID `sql:"-" json:"-"` // sid claim; present when the token was vended on behalf of a session
+	ActorID   *uuid.UUID `sql:"-" json:"-"` // act claim; present when the token was vended to a sudoer impersonating the subject
 
 	ApplicationClaimsKey *string           `sql:"-" json:"-"` // string key where application-specific claims are encoded
 	Permissions          common.Permission `sql:"-" json:"permissions,omitempty"`
@@ -80,6 +103,9 @@ type Token struct {
 	IsRevocable          bool              `sql:"-" json:"-"`
 
 	NatsClaims map[string]interface{} `sql:"-" json:"-"` // NATS claims
+
+	refreshTokenID        *uuid.UUID
+	refreshTokenExpiresAt *time.Time
 }
 
 // Response represents the token portion of the response to a successful authentication request
@@ -120,6 +146,198 @@ func IsRevoked(token *Token) bool {
 	return totalResults == 1
 }
 
+// UserRevocation records the time at which all tokens previously issued on behalf of a user were
+// revoked; tokens asserting the user which were issued before the revocation are no longer authorized
+type UserRevocation struct {
+	UserID    *uuid.UUID `sql:"not null;type:uuid" gorm:"primary_key" json:"user_id"`
+	RevokedAt *time.Time `sql:"not null" json:"revoked_at"`
+}
+
+// TableName returns the db table name for gorm
+func (r *UserRevocation) TableName() string {
+	return "user_token_revocations"
+}
+
+// IsUserTokenRevoked returns true if the given token asserts a user, or was vended to an
+// actor impersonating a user, whose tokens have been revoked since the token was issued
+func IsUserTokenRevoked(db *gorm.DB, token *Token) bool {
+	for _, userID := range []*uuid.UUID{token.UserID, token.ActorID} {
+		if userID == nil {
+			continue
+		}
+
+		revocation := &UserRevocation{}
+		db.Where("user_id = ?", userID).Find(&revocation)
+		if revocation.RevokedAt != nil && token.issuedBefore(*revocation.RevokedAt) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// issuedBefore returns true if the token was issued before the given time of revocation; the iat
+// claim has whole-second precision, so a token issued within the second of the revocation is only
+// authorized if it asserts the sub-second time of its issuance and was vended after the revocation
+func (t *Token) issuedBefore(revokedAt time.Time) bool {
+	if t.IssuedAt == nil {
+		return true
+	}
+
+	return t.IssuedAt.Before(revokedAt)
+}
+
+// RevokeUserTokens revokes every session and outstanding token issued on behalf of the given
+// user; the user must authenticate again to be authorized for subsequent requests
+func RevokeUserTokens(tx *gorm.DB, userID uuid.UUID) error {
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+		db = db.Begin()
+		defer db.RollbackUnlessCommitted()
+	}
+
+	var sessions []*Session
+	ActiveSessionsQuery(db, userID).Find(&sessions)
+	for _, session := range sessions {
+		if !session.Revoke(db) {
+			return fmt.Errorf("failed to revoke session: %s; %s", session.ID, *session.Errors[0].Message)
+		}
+	}
+
+	revokedAt := time.Now()
+	result := db.Exec("INSERT INTO user_token_revocations (user_id, revoked_at) VALUES (?, ?) ON CONFLICT (user_id) DO UPDATE SET revoked_at = EXCLUDED.revoked_at", userID, revokedAt)
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke tokens for user: %s; %s", userID, result.Error.Error())
+	}
+
+	if tx == nil {
+		db.Commit()
+	}
+
+	common.Log.Debugf("revoked %d session(s) and all outstanding tokens for user: %s", len(sessions), userID)
+	return nil
+}
+
+// OrganizationRevocation records the time at which all tokens previously issued on behalf of an organization
+// were revoked; tokens asserting the organization which were issued before the revocation are no longer authorized
+type OrganizationRevocation struct {
+	OrganizationID *uuid.UUID `sql:"not null;type:uuid" gorm:"primary_key" json:"organization_id"`
+	RevokedAt      *time.Time `sql:"not null" json:"revoked_at"`
+}
+
+// TableName returns the db table name for gorm
+func (r *OrganizationRevocation) TableName() string {
+	return "organization_token_revocations"
+}
+
+// IsOrganizationTokenRevoked returns true if the given token asserts an organization whose
+// tokens have been revoked since the token was issued
+func IsOrganizationTokenRevoked(db *gorm.DB, token *Token) bool {
+	if token.OrganizationID == nil {
+		return false
+	}
+
+	revocation := &OrganizationRevocation{}
+	db.Where("organization_id = ?", token.OrganizationID).Find(&revocation)
+	if revocation.RevokedAt == nil {
+		return false
+	}
+
+	return token.issuedBefore(*revocation.RevokedAt)
+}
+
+// RevokeOrganizationTokens revokes every outstanding token issued on behalf of the given organization
+func RevokeOrganizationTokens(tx *gorm.DB, organizationID uuid.UUID) error {
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+	}
+
+	result := db.Exec("INSERT INTO organization_token_revocations (organization_id, revoked_at) VALUES (?, ?) ON CONFLICT (organization_id) DO UPDATE SET revoked_at = EXCLUDED.revoked_at", organizationID, time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke tokens for organization: %s; %s", organizationID, result.Error.Error())
+	}
+
+	common.Log.Debugf("revoked all outstanding tokens for organization: %s", organizationID)
+	return nil
+}
+
+// MembershipRevocation records the time at which all tokens previously issued on behalf of a user
+// and scoped to an application or organization were revoked; tokens asserting both the user and
+// the application or organization which were issued before the revocation are no longer authorized
+type MembershipRevocation struct {
+	UserID     *uuid.UUID `sql:"not null;type:uuid" gorm:"primary_key" json:"user_id"`
+	ResourceID *uuid.UUID `sql:"not null;type:uuid" gorm:"primary_key" json:"resource_id"`
+	RevokedAt  *time.Time `sql:"not null" json:"revoked_at"`
+}
+
+// TableName returns the db table name for gorm
+func (r *MembershipRevocation) TableName() string {
+	return "membership_token_revocations"
+}
+
+// IsMembershipTokenRevoked returns true if the given token asserts a user whose tokens scoped to
+// the asserted application or organization have been revoked since the token was issued
+func IsMembershipTokenRevoked(db *gorm.DB, token *Token) bool {
+	if token.UserID == nil {
+		return false
+	}
+
+	resourceIDs := make([]uuid.UUID, 0)
+	for _, resourceID := range []*uuid.UUID{token.ApplicationID, token.OrganizationID} {
+		if resourceID != nil && *resourceID != uuid.Nil {
+			resourceIDs = append(resourceIDs, *resourceID)
+		}
+	}
+	if len(resourceIDs) == 0 {
+		return false
+	}
+
+	var revocations []*MembershipRevocation
+	db.Where("user_id = ? AND resource_id IN (?)", token.UserID, resourceIDs).Find(&revocations)
+	for _, revocation := range revocations {
+		if revocation.RevokedAt != nil && token.issuedBefore(*revocation.RevokedAt) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RevokeApplicationUserTokens revokes every outstanding token issued on behalf of the given user
+// and scoped to the given application
+func RevokeApplicationUserTokens(tx *gorm.DB, applicationID, userID uuid.UUID) error {
+	return revokeMembershipTokens(tx, applicationID, userID)
+}
+
+// RevokeOrganizationUserTokens revokes every outstanding token issued on behalf of the given user
+// and scoped to the given organization
+func RevokeOrganizationUserTokens(tx *gorm.DB, organizationID, userID uuid.UUID) error {
+	return revokeMembershipTokens(tx, organizationID, userID)
+}
+
+func revokeMembershipTokens(tx *gorm.DB, resourceID, userID uuid.UUID) error {
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+	}
+
+	result := db.Exec("INSERT INTO membership_token_revocations (user_id, resource_id, revoked_at) VALUES (?, ?, ?) ON CONFLICT (user_id, resource_id) DO UPDATE SET revoked_at = EXCLUDED.revoked_at", userID, resourceID, time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke tokens for user: %s; scoped to: %s; %s", userID, resourceID, result.Error.Error())
+	}
+
+	common.Log.Debugf("revoked all outstanding tokens for user: %s; scoped to: %s", userID, resourceID)
+	return nil
+}
+
 // Parse a previously signed token and initialize the Token representation
 func Parse(token string) (*Token, error) {
 	jwtToken, err := jwt.Parse(token, func(_jwtToken *jwt.Token) (interface{}, error) {
@@ -243,6 +461,11 @@ func Parse(token string) (*Token, error) {
 	var iat *time.Time
 	if claims["iat"] != nil {
 		iat = parseJWTTimestampClaim(claims, "iat")
+		if iatns, iatnsOk := claims["iat_ns"].(float64); iatnsOk && iat != nil && iatns >= 0 && iatns < float64(time.Second) {
+			// the iat claim has whole-second precision; the sub-second time of issuance is asserted separately
+			preciseIat := iat.Add(time.Duration(iatns))
+			iat = &preciseIat
+		}
 	}
 
 	var exp *time.Time
@@ -279,6 +502,27 @@ func Parse(token string) (*Token, error) {
 		tkn.Issuer = &iss
 	}
 
+	if sid, sidOk := claims["sid"].(string); sidOk {
+		sessionID, err := uuid.FromString(sid)
+		if err != nil {
+			return nil, fmt.Errorf("valid bearer authorization contained invalid sid claim: %s; %s", sid, err.Error())
+		}
+		tkn.SessionID = &sessionID
+	}
+
+	if act, actOk := claims["act"].(map[string]interface{}); actOk {
+		actsub, _ := act["sub"].(string)
+		actprts := strings.Split(actsub, ":")
+		if len(actprts) != 2 || actprts[0] != authorizationSubjectUser {
+			return nil, fmt.Errorf("valid bearer authorization contained invalid act claim: %s", actsub)
+		}
+		actorID, err := uuid.FromString(actprts[1])
+		if err != nil {
+			return nil, fmt.Errorf("valid bearer authorization contained invalid act claim: %s; %s", actsub, err.Error())
+		}
+		tkn.ActorID = &actorID
+	}
+
 	if appclaimsOk {
 		if appIDClaim, appIDClaimOk := appclaims["application_id"].(string); appIDClaimOk && tkn.ApplicationID == nil {
 			appUUID, err := uuid.FromString(appIDClaim)
@@ -370,6 +614,16 @@ func (t *Token) IsRevoked() bool {
 	return IsRevoked(t)
 }
 
+// IsImpersonation returns true if the token was vended to a sudoer impersonating the subject
+func (t *Token) IsImpersonation() bool {
+	return t != nil && t.ActorID != nil
+}
+
+// IsRestricted returns true if the token audience restricts it to a single, specific action
+func (t *Token) IsRestricted() bool {
+	return t.Audience != nil && restrictedAudiences[*t.Audience]
+}
+
 // ParseData parses and returns any data to be encoded within
 // application-specific claims in a bearer JWT
 func (t *Token) ParseData() map[string]interface{} {
@@ -519,6 +773,8 @@ func (t *Token) vendRefreshToken() bool {
 		Audience:            t.Audience,
 		Issuer:              t.Issuer,
 		Subject:             common.StringOrNil(fmt.Sprintf("token:%s", t.ID.String())),
+		SessionID:           t.SessionID,
+		ActorID:             t.ActorID,
 		Permissions:         t.Permissions,
 		ExtendedPermissions: t.ExtendedPermissions,
 		TTL:                 &ttl,
@@ -529,6 +785,8 @@ func (t *Token) vendRefreshToken() bool {
 		return false
 	}
 
+	t.refreshTokenID = &refreshToken.ID
+	t.refreshTokenExpiresAt = refreshToken.ExpiresAt
 	t.RefreshToken = refreshToken.Token
 	t.IsRefreshToken = true
 	return true
@@ -728,7 +986,7 @@ func (t *Token) Delete(tx *gorm.DB) bool {
 	return success
 }
 
-// Revoke the token; persist a revocation
+// Revoke the token; persist a revocation, which is only committed when no transaction is given
 func (t *Token) Revoke(tx *gorm.DB) bool {
 	var db *gorm.DB
 	if tx != nil {
@@ -763,7 +1021,9 @@ func (t *Token) Revoke(tx *gorm.DB) bool {
 	success := len(t.Errors) == 0
 	if success {
 		common.Log.Debugf("revoked token: %s", t.ID)
-		db.Commit()
+		if tx == nil {
+			db.Commit()
+		}
 	} else {
 		common.Log.Warningf("failed to revoke token with subject: %s; hash: %s", *t.Subject, *t.Hash)
 	}
@@ -772,11 +1032,12 @@ func (t *Token) Revoke(tx *gorm.DB) bool {
 
 func (t *Token) encodeJWT() error {
 	claims := map[string]interface{}{
-		"aud": t.Audience,
-		"iat": t.IssuedAt.Unix(),
-		"iss": t.Issuer,
-		"jti": t.ID,
-		"sub": t.Subject,
+		"aud":    t.Audience,
+		"iat":    t.IssuedAt.Unix(),
+		"iat_ns": t.IssuedAt.Nanosecond(),
+		"iss":    t.Issuer,
+		"jti":    t.ID,
+		"sub":    t.Subject,
 	}
 
 	if t.ExpiresAt != nil {
@@ -787,6 +1048,16 @@ func (t *Token) encodeJWT() error {
 		claims["nbf"] = t.NotBefore.Unix()
 	}
 
+	if t.SessionID != nil {
+		claims["sid"] = t.SessionID
+	}
+
+	if t.ActorID != nil {
+		claims["act"] = map[string]interface{}{
+			"sub": fmt.Sprintf("%s:%s", authorizationSubjectUser, t.ActorID.String()),
+		}
+	}
+
 	if t.IsRevocable {
 		// drop exp claim from revocable application token
 		delete(claims, "exp")
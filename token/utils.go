@@ -7,6 +7,7 @@ import (
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
+	dbconf "github.com/kthomas/go-db-config"
 	"github.com/provideplatform/ident/common"
 	provide "github.com/provideplatform/provide-go/common"
 )
@@ -45,12 +46,19 @@ func parseJWTTimestampClaim(claims jwt.MapClaims, claim string) *time.Time {
 // token is `token:<jti>`
 func refreshAccessToken(c *gin.Context) {
 	refreshToken := authorize(c)
+	if refreshToken != nil && refreshToken.IsRevoked() {
+		common.Log.Debugf("refusing to authorize access token using revoked refresh token; subject: %s", *refreshToken.Subject)
+		refreshToken = nil
+	}
+
 	if refreshToken != nil {
 		ttl := int(defaultAccessTokenTTL.Seconds())
 		accessToken := &Token{
 			ApplicationID:       refreshToken.ApplicationID,
 			UserID:              refreshToken.UserID,
 			OrganizationID:      refreshToken.OrganizationID,
+			SessionID:           refreshToken.SessionID,
+			ActorID:             refreshToken.ActorID,
 			Scope:               refreshToken.Scope,
 			Permissions:         refreshToken.Permissions,
 			ExtendedPermissions: refreshToken.ExtendedPermissions,
@@ -58,6 +66,9 @@ func refreshAccessToken(c *gin.Context) {
 		}
 
 		if accessToken.Vend() {
+			if accessToken.SessionID != nil {
+				markSessionRefreshed(dbconf.DatabaseConnection(), *accessToken.SessionID)
+			}
 			accessToken.Token = nil
 			provide.Render(accessToken.AsResponse(), 201, c)
 			return
@@ -0,0 +1,284 @@
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+)
+
+// PlatformAttributesNamespace is the namespace of user attributes which are not scoped to an application
+const PlatformAttributesNamespace = "platform"
+
+// UserAttributesSchemaKey is the application config key under which the schema of the
+// attributes the application requires of its users is declared
+const UserAttributesSchemaKey = "user_attributes_schema"
+
+const maxUserAttributesNamespaceSize = 8192
+
+var userAttributeTypes = map[string]bool{
+	"array":   true,
+	"boolean": true,
+	"integer": true,
+	"number":  true,
+	"object":  true,
+	"string":  true,
+}
+
+// AttributeDefinition declares a single attribute of an application user attributes schema
+type AttributeDefinition struct {
+	Type      string        `json:"type"`
+	Required  bool          `json:"required,omitempty"`
+	Enum      []interface{} `json:"enum,omitempty"`
+	MaxLength *int          `json:"max_length,omitempty"`
+}
+
+// AttributesSchema maps attribute names to their definitions; attributes which are
+// not declared by the schema are rejected
+type AttributesSchema map[string]*AttributeDefinition
+
+// ParseAttributesSchema parses and validates the given user attributes schema declaration
+func ParseAttributesSchema(raw interface{}) (AttributesSchema, error) {
+	rawJSON, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s; %s", UserAttributesSchemaKey, err.Error())
+	}
+
+	schema := AttributesSchema{}
+	err = json.Unmarshal(rawJSON, &schema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s; %s", UserAttributesSchemaKey, err.Error())
+	}
+
+	for name, definition := range schema {
+		if definition == nil || !userAttributeTypes[definition.Type] {
+			return nil, fmt.Errorf("invalid %s; unsupported type for attribute: %s", UserAttributesSchemaKey, name)
+		}
+		if definition.MaxLength != nil && (definition.Type != "string" || *definition.MaxLength < 1) {
+			return nil, fmt.Errorf("invalid %s; max_length must be positive and is only supported for string attributes: %s", UserAttributesSchemaKey, name)
+		}
+		if len(definition.Enum) > 0 && (definition.Type == "array" || definition.Type == "object") {
+			return nil, fmt.Errorf("invalid %s; enum is only supported for scalar attributes: %s", UserAttributesSchemaKey, name)
+		}
+		for _, val := range definition.Enum {
+			if !definition.accepts(val) {
+				return nil, fmt.Errorf("invalid %s; enum value %v is not of type %s: %s", UserAttributesSchemaKey, val, definition.Type, name)
+			}
+		}
+	}
+
+	return schema, nil
+}
+
+// accepts returns true if the given value is of the defined type
+func (d *AttributeDefinition) accepts(val interface{}) bool {
+	switch d.Type {
+	case "array":
+		_, ok := val.([]interface{})
+		return ok
+	case "boolean":
+		_, ok := val.(bool)
+		return ok
+	case "integer":
+		num, ok := val.(float64)
+		return ok && num == float64(int64(num))
+	case "number":
+		_, ok := val.(float64)
+		return ok
+	case "object":
+		_, ok := val.(map[string]interface{})
+		return ok
+	case "string":
+		_, ok := val.(string)
+		return ok
+	}
+	return false
+}
+
+// validate the given attributes against the schema
+func (s AttributesSchema) validate(attributes map[string]interface{}) error {
+	names := make([]string, 0)
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		val := attributes[name]
+		definition, definitionOk := s[name]
+		if !definitionOk {
+			return fmt.Errorf("undeclared attribute: %s", name)
+		}
+		if val == nil {
+			if definition.Required {
+				return fmt.Errorf("required attribute must not be null: %s", name)
+			}
+			continue
+		}
+		if !definition.accepts(val) {
+			return fmt.Errorf("attribute must be of type %s: %s", definition.Type, name)
+		}
+		if definition.MaxLength != nil && utf8.RuneCountInString(val.(string)) > *definition.MaxLength {
+			return fmt.Errorf("attribute exceeds max length of %d: %s", *definition.MaxLength, name)
+		}
+		if len(definition.Enum) > 0 {
+			permitted := false
+			for _, enumval := range definition.Enum {
+				permitted = permitted || enumval == val
+			}
+			if !permitted {
+				return fmt.Errorf("attribute is not one of the permitted values: %s", name)
+			}
+		}
+	}
+
+	required := make([]string, 0)
+	for name, definition := range s {
+		if _, ok := attributes[name]; definition.Required && !ok {
+			required = append(required, name)
+		}
+	}
+	if len(required) > 0 {
+		sort.Strings(required)
+		return fmt.Errorf("missing required attributes: %s", strings.Join(required, ", "))
+	}
+
+	return nil
+}
+
+// applicationAttributesSchema returns the user attributes schema declared by the application with the given id
+func applicationAttributesSchema(db *gorm.DB, applicationID uuid.UUID) (AttributesSchema, error) {
+	var id *string
+	var cfg *string
+	db.Raw("SELECT id, config FROM applications WHERE id = ?", applicationID).Row().Scan(&id, &cfg)
+	if id == nil {
+		return nil, fmt.Errorf("application not found: %s", applicationID)
+	}
+	if cfg == nil {
+		return nil, nil
+	}
+
+	params := map[string]interface{}{}
+	err := json.Unmarshal([]byte(*cfg), &params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config of application: %s; %s", applicationID, err.Error())
+	}
+
+	if raw, rawOk := params[UserAttributesSchemaKey]; rawOk && raw != nil {
+		return ParseAttributesSchema(raw)
+	}
+	return nil, nil
+}
+
+// ParseAttributes parses the persisted, namespaced attributes of the user
+func (u *User) ParseAttributes() map[string]map[string]interface{} {
+	attributes := map[string]map[string]interface{}{}
+	if u.Metadata != nil {
+		err := json.Unmarshal(*u.Metadata, &attributes)
+		if err != nil {
+			common.Log.Warningf("failed to unmarshal user metadata; %s", err.Error())
+			return nil
+		}
+	}
+	return attributes
+}
+
+func (u *User) setAttributes(attributes map[string]map[string]interface{}) {
+	metadataJSON, _ := json.Marshal(attributes)
+	_metadataJSON := json.RawMessage(metadataJSON)
+	u.Metadata = &_metadataJSON
+}
+
+// scopeAttributes omits all attribute namespaces other than that of the given application
+func (u *User) scopeAttributes(applicationID uuid.UUID) {
+	attributes := u.ParseAttributes()
+	if attributes == nil {
+		u.Metadata = nil
+		return
+	}
+
+	scoped := map[string]map[string]interface{}{}
+	if namespace, namespaceOk := attributes[applicationID.String()]; namespaceOk {
+		scoped[applicationID.String()] = namespace
+	}
+	u.setAttributes(scoped)
+}
+
+// updateAttributes validates and replaces the given attribute namespaces; application
+// namespaces are validated against the schema declared by the application, if any
+func (u *User) updateAttributes(db *gorm.DB, namespaces map[string]interface{}) error {
+	attributes := u.ParseAttributes()
+	if attributes == nil {
+		attributes = map[string]map[string]interface{}{}
+	}
+
+	for namespace, raw := range namespaces {
+		if raw == nil {
+			delete(attributes, namespace)
+			continue
+		}
+
+		namespaceAttributes, namespaceAttributesOk := raw.(map[string]interface{})
+		if !namespaceAttributesOk {
+			return fmt.Errorf("attributes namespace must be an object: %s", namespace)
+		}
+
+		rawJSON, _ := json.Marshal(namespaceAttributes)
+		if len(rawJSON) > maxUserAttributesNamespaceSize {
+			return fmt.Errorf("attributes namespace exceeds %d bytes: %s", maxUserAttributesNamespaceSize, namespace)
+		}
+
+		if namespace != PlatformAttributesNamespace {
+			applicationID, err := uuid.FromString(namespace)
+			if err != nil {
+				return fmt.Errorf("invalid attributes namespace: %s", namespace)
+			}
+
+			schema, err := applicationAttributesSchema(db, applicationID)
+			if err != nil {
+				return err
+			}
+			if schema != nil {
+				err = schema.validate(namespaceAttributes)
+				if err != nil {
+					return fmt.Errorf("invalid attributes for application %s; %s", namespace, err.Error())
+				}
+			}
+		}
+
+		attributes[namespace] = namespaceAttributes
+	}
+
+	u.setAttributes(attributes)
+	return nil
+}
+
+// isAttributesNamespaceWritable returns true if the given bearer subject may write the
+// given attributes namespace of the user
+func (u *User) isAttributesNamespaceWritable(db *gorm.DB, namespace string, bearerApplicationID *uuid.UUID) bool {
+	if bearerApplicationID != nil {
+		return namespace == bearerApplicationID.String()
+	}
+
+	if namespace == PlatformAttributesNamespace {
+		return true
+	}
+
+	applicationID, err := uuid.FromString(namespace)
+	if err != nil {
+		return false
+	}
+
+	if u.ApplicationID != nil && *u.ApplicationID == applicationID {
+		return true
+	}
+
+	var memberships int
+	db.Table("applications_users").Where("application_id = ? AND user_id = ?", applicationID, u.ID).Count(&memberships)
+	return memberships > 0
+}
@@ -0,0 +1,80 @@
+package user
+
+import (
+	"testing"
+)
+
+func TestParseAttributesSchemaRejectsNonScalarEnum(t *testing.T) {
+	for _, attributeType := range []string{"array", "object"} {
+		_, err := ParseAttributesSchema(map[string]interface{}{
+			"tags": map[string]interface{}{
+				"type": attributeType,
+				"enum": []interface{}{},
+			},
+		})
+		if err != nil {
+			t.Errorf("expected empty enum of %s attribute to be accepted; %s", attributeType, err.Error())
+		}
+	}
+
+	_, err := ParseAttributesSchema(map[string]interface{}{
+		"tags": map[string]interface{}{
+			"type": "array",
+			"enum": []interface{}{[]interface{}{"a"}, []interface{}{"b"}},
+		},
+	})
+	if err == nil {
+		t.Error("expected enum of array attribute to be rejected")
+	}
+
+	_, err = ParseAttributesSchema(map[string]interface{}{
+		"address": map[string]interface{}{
+			"type": "object",
+			"enum": []interface{}{map[string]interface{}{"city": "Austin"}},
+		},
+	})
+	if err == nil {
+		t.Error("expected enum of object attribute to be rejected")
+	}
+}
+
+func TestValidateAttributesEnum(t *testing.T) {
+	schema, err := ParseAttributesSchema(map[string]interface{}{
+		"tier": map[string]interface{}{
+			"type": "string",
+			"enum": []interface{}{"gold", "silver"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to parse attributes schema; %s", err.Error())
+	}
+
+	if err := schema.validate(map[string]interface{}{"tier": "gold"}); err != nil {
+		t.Errorf("expected permitted enum value to be accepted; %s", err.Error())
+	}
+	if err := schema.validate(map[string]interface{}{"tier": "bronze"}); err == nil {
+		t.Error("expected enum value which is not permitted to be rejected")
+	}
+	if err := schema.validate(map[string]interface{}{"tier": []interface{}{"gold"}}); err == nil {
+		t.Error("expected array value of string attribute to be rejected")
+	}
+}
+
+func TestValidateAttributesMaxLengthCountsCharacters(t *testing.T) {
+	schema, err := ParseAttributesSchema(map[string]interface{}{
+		"nickname": map[string]interface{}{
+			"type":       "string",
+			"max_length": 4,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to parse attributes schema; %s", err.Error())
+	}
+
+	if err := schema.validate(map[string]interface{}{"nickname": "ñoño"}); err != nil {
+		t.Errorf("expected multibyte value within max length to be accepted; %s", err.Error())
+	}
+	if err := schema.validate(map[string]interface{}{"nickname": "ñoños"}); err == nil {
+		t.Error("expected value exceeding max length to be rejected")
+	}
+}
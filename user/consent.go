@@ -0,0 +1,77 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/legal"
+)
+
+// errConsentRequired is returned when an authenticated user has not consented to the
+// most recently published versions of the legal documents
+var errConsentRequired = errors.New("authentication failed; consent to the latest legal documents is required")
+
+// pendingLegalDocuments resolves the published legal documents to which a user has yet to consent
+var pendingLegalDocuments = legal.PendingDocuments
+
+// consentRequiredResponse returns an authentication response listing the published legal
+// documents to which the user must consent prior to being vended a token, if any
+func (u *User) consentRequiredResponse(db *gorm.DB) *AuthenticationResponse {
+	pending := pendingLegalDocuments(db, u.ID)
+	if len(pending) == 0 {
+		return nil
+	}
+
+	return &AuthenticationResponse{
+		User:            u.AsResponse(),
+		ConsentRequired: pending,
+	}
+}
+
+// AcceptLegalDocuments records the consent of the user to the given published legal documents;
+// the privacy policy and terms of service agreement timestamps of the user are updated accordingly
+func (u *User) AcceptLegalDocuments(db *gorm.DB, documentIDs []uuid.UUID, ipAddress, userAgent *string) ([]*legal.Consent, error) {
+	consents, err := legal.Accept(db, u.ID, documentIDs, ipAddress, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, consent := range consents {
+		switch *consent.DocumentType {
+		case legal.DocumentTypePrivacyPolicy:
+			if u.PrivacyPolicyAgreedAt == nil || u.PrivacyPolicyAgreedAt.Before(consent.CreatedAt) {
+				u.PrivacyPolicyAgreedAt = &consent.CreatedAt
+				db.Model(u).Update("privacy_policy_agreed_at", consent.CreatedAt)
+			}
+		case legal.DocumentTypeTermsOfService:
+			if u.TermsOfServiceAgreedAt == nil || u.TermsOfServiceAgreedAt.Before(consent.CreatedAt) {
+				u.TermsOfServiceAgreedAt = &consent.CreatedAt
+				db.Model(u).Update("terms_of_service_agreed_at", consent.CreatedAt)
+			}
+		}
+	}
+
+	return consents, nil
+}
+
+// parseDocumentIDs parses the given list of legal document ids
+func parseDocumentIDs(raw interface{}) ([]uuid.UUID, error) {
+	ids, idsOk := raw.([]interface{})
+	if !idsOk || len(ids) == 0 {
+		return nil, errors.New("a non-empty list of legal document ids is required")
+	}
+
+	documentIDs := make([]uuid.UUID, 0)
+	for _, id := range ids {
+		idStr, _ := id.(string)
+		documentID, err := uuid.FromString(idStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid legal document id: %v", id)
+		}
+		documentIDs = append(documentIDs, documentID)
+	}
+
+	return documentIDs, nil
+}
@@ -0,0 +1,100 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+	"github.com/provideplatform/ident/legal"
+	"github.com/provideplatform/ident/token"
+)
+
+// stubPendingLegalDocuments stubs a published terms of service to which users have yet to consent
+func stubPendingLegalDocuments(t *testing.T) *legal.Document {
+	document := &legal.Document{
+		Type:    common.StringOrNil(legal.DocumentTypeTermsOfService),
+		Version: common.StringOrNil("2"),
+	}
+	document.ID, _ = uuid.NewV4()
+
+	pendingLegalDocuments = func(db *gorm.DB, userID uuid.UUID) []*legal.Document {
+		return []*legal.Document{document}
+	}
+	t.Cleanup(func() {
+		pendingLegalDocuments = legal.PendingDocuments
+	})
+
+	return document
+}
+
+func federatedUserFactory() *User {
+	user := &User{
+		Email:       common.StringOrNil("employee@example.com"),
+		FirstName:   common.StringOrNil("federated"),
+		LastName:    common.StringOrNil("user"),
+		Permissions: common.DefaultUserPermission,
+		federated:   true,
+	}
+	user.ID, _ = uuid.NewV4()
+	return user
+}
+
+func TestVendFederatedTokenRequiresConsent(t *testing.T) {
+	document := stubPendingLegalDocuments(t)
+
+	resp, err := federatedUserFactory().vendFederatedToken(nil, nil, nil, nil, nil)
+	if err != errConsentRequired {
+		t.Fatalf("expected federated authentication to require consent; got %v", err)
+	}
+
+	if resp.Token != nil {
+		t.Error("expected no token to be vended prior to consent")
+	}
+
+	if len(resp.ConsentRequired) != 1 || resp.ConsentRequired[0].ID != document.ID {
+		t.Errorf("expected consent to be required to pending document %s; got %v", document.ID, resp.ConsentRequired)
+	}
+}
+
+func TestVendFederatedTokenRetainsScopePendingConsent(t *testing.T) {
+	stubPendingLegalDocuments(t)
+
+	organizationID, _ := uuid.NewV4()
+	resp, err := federatedUserFactory().vendFederatedToken(nil, nil, &organizationID, nil, nil)
+	if err != errConsentRequired {
+		t.Fatalf("expected federated authentication to require consent; got %v", err)
+	}
+
+	if resp.organizationID == nil || *resp.organizationID != organizationID {
+		t.Errorf("expected token vended following consent to be scoped to organization %s; got %v", organizationID, resp.organizationID)
+	}
+}
+
+func TestRenderFederatedAuthenticationRequiresConsent(t *testing.T) {
+	stubPendingLegalDocuments(t)
+	gin.SetMode(gin.TestMode)
+
+	resp, err := federatedUserFactory().vendFederatedToken(nil, nil, nil, nil, nil)
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("POST", "/api/v1/oauth/callback", nil)
+	renderFederatedAuthentication(c, nil, resp, err, map[string]interface{}{}, nil, &token.Session{})
+
+	if recorder.Code != 403 {
+		t.Fatalf("expected federated authentication pending consent to be forbidden; status: %d", recorder.Code)
+	}
+
+	body := map[string]interface{}{}
+	json.Unmarshal(recorder.Body.Bytes(), &body)
+	if body["token"] != nil {
+		t.Error("expected no token to be rendered prior to consent")
+	}
+	if consentRequired, _ := body["consent_required"].([]interface{}); len(consentRequired) != 1 {
+		t.Errorf("expected pending legal document to be rendered; got %v", body["consent_required"])
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	natsutil "github.com/kthomas/go-natsutil"
+	uuid "github.com/kthomas/go.uuid"
 	"github.com/nats-io/nats.go"
 	"github.com/provideplatform/ident/common"
 	"github.com/provideplatform/ident/token"
@@ -14,11 +15,25 @@ import (
 
 const defaultNatsStream = "ident"
 
+const natsDispatchEmailVerificationSubject = "ident.user.email.verification.dispatch"
+const natsDispatchEmailVerificationMaxInFlight = 2048
+const dispatchEmailVerificationAckWait = time.Second * 30
+const dispatchEmailVerificationMaxDeliveries = 5
+
+const natsDispatchMagicLinkSubject = "ident.user.magic_link.dispatch"
+const natsDispatchMagicLinkMaxInFlight = 2048
+const dispatchMagicLinkAckWait = time.Second * 30
+const dispatchMagicLinkMaxDeliveries = 5
+
 const natsDispatchInvitationSubject = "ident.invitation.dispatch"
 const natsDispatchInvitationMaxInFlight = 2048
 const dispatchInvitationAckWait = time.Second * 30
 const dispatchInvitationMaxDeliveries = 5
 
+const natsUserErasureMaxInFlight = 256
+const userErasureAckWait = time.Second * 60
+const userErasureMaxDeliveries = 10
+
 func init() {
 	if !common.ConsumeNATSStreamingSubscriptions {
 		common.Log.Debug("user package consumer configured to skip NATS streaming subscription setup")
@@ -32,7 +47,30 @@ func init() {
 
 	var waitGroup sync.WaitGroup
 
+	createNatsDispatchEmailVerificationSubscriptions(&waitGroup)
 	createNatsDispatchInvitationSubscriptions(&waitGroup)
+	createNatsDispatchMagicLinkSubscriptions(&waitGroup)
+	createNatsUserErasureSubscriptions(&waitGroup)
+}
+
+func createNatsDispatchEmailVerificationSubscriptions(wg *sync.WaitGroup) {
+	for i := uint64(0); i < natsutil.GetNatsConsumerConcurrency(); i++ {
+		_, err := natsutil.RequireNatsJetstreamSubscription(wg,
+			dispatchEmailVerificationAckWait,
+			natsDispatchEmailVerificationSubject,
+			natsDispatchEmailVerificationSubject,
+			natsDispatchEmailVerificationSubject,
+			consumeDispatchEmailVerificationSubscriptionsMsg,
+			dispatchEmailVerificationAckWait,
+			natsDispatchEmailVerificationMaxInFlight,
+			dispatchEmailVerificationMaxDeliveries,
+			nil,
+		)
+
+		if err != nil {
+			common.Log.Panicf("failed to subscribe to NATS stream via subject: %s; %s", natsDispatchEmailVerificationSubject, err.Error())
+		}
+	}
 }
 
 func createNatsDispatchInvitationSubscriptions(wg *sync.WaitGroup) {
@@ -55,6 +93,46 @@ func createNatsDispatchInvitationSubscriptions(wg *sync.WaitGroup) {
 	}
 }
 
+func createNatsDispatchMagicLinkSubscriptions(wg *sync.WaitGroup) {
+	for i := uint64(0); i < natsutil.GetNatsConsumerConcurrency(); i++ {
+		_, err := natsutil.RequireNatsJetstreamSubscription(wg,
+			dispatchMagicLinkAckWait,
+			natsDispatchMagicLinkSubject,
+			natsDispatchMagicLinkSubject,
+			natsDispatchMagicLinkSubject,
+			consumeDispatchMagicLinkSubscriptionsMsg,
+			dispatchMagicLinkAckWait,
+			natsDispatchMagicLinkMaxInFlight,
+			dispatchMagicLinkMaxDeliveries,
+			nil,
+		)
+
+		if err != nil {
+			common.Log.Panicf("failed to subscribe to NATS stream via subject: %s; %s", natsDispatchMagicLinkSubject, err.Error())
+		}
+	}
+}
+
+func createNatsUserErasureSubscriptions(wg *sync.WaitGroup) {
+	for i := uint64(0); i < natsutil.GetNatsConsumerConcurrency(); i++ {
+		_, err := natsutil.RequireNatsJetstreamSubscription(wg,
+			userErasureAckWait,
+			natsUserErasureSubject,
+			natsUserErasureSubject,
+			natsUserErasureSubject,
+			consumeUserErasureSubscriptionsMsg,
+			userErasureAckWait,
+			natsUserErasureMaxInFlight,
+			userErasureMaxDeliveries,
+			nil,
+		)
+
+		if err != nil {
+			common.Log.Panicf("failed to subscribe to NATS stream via subject: %s; %s", natsUserErasureSubject, err.Error())
+		}
+	}
+}
+
 func consumeDispatchInvitationSubscriptionsMsg(msg *nats.Msg) {
 	common.Log.Debugf("consuming %d-byte NATS invitation dispatch message on subject: %s", len(msg.Data), msg.Subject)
 
@@ -84,3 +162,98 @@ func consumeDispatchInvitationSubscriptionsMsg(msg *nats.Msg) {
 	common.Log.Debugf("dispatch invitation: %s", *token.Token)
 	msg.Ack()
 }
+
+func consumeDispatchEmailVerificationSubscriptionsMsg(msg *nats.Msg) {
+	common.Log.Debugf("consuming %d-byte NATS email verification dispatch message on subject: %s", len(msg.Data), msg.Subject)
+
+	var params map[string]interface{}
+
+	err := json.Unmarshal(msg.Data, &params)
+	if err != nil {
+		common.Log.Warningf("failed to umarshal email verification dispatch message; %s", err.Error())
+		msg.Nak()
+		return
+	}
+
+	rawToken, rawTokenOk := params["token"].(string)
+	if !rawTokenOk {
+		common.Log.Warning("failed to umarshal token during email verification dispatch message")
+		msg.Nak()
+		return
+	}
+
+	token, err := token.Parse(rawToken)
+	if err != nil {
+		common.Log.Warningf("failed to parse token during attempted email verification dispatch; %s", err.Error())
+		msg.Nak()
+		return
+	}
+
+	common.Log.Debugf("dispatch email verification for user: %s", token.UserID)
+	msg.Ack()
+}
+
+func consumeDispatchMagicLinkSubscriptionsMsg(msg *nats.Msg) {
+	common.Log.Debugf("consuming %d-byte NATS magic link dispatch message on subject: %s", len(msg.Data), msg.Subject)
+
+	var params map[string]interface{}
+
+	err := json.Unmarshal(msg.Data, &params)
+	if err != nil {
+		common.Log.Warningf("failed to umarshal magic link dispatch message; %s", err.Error())
+		msg.Nak()
+		return
+	}
+
+	rawToken, rawTokenOk := params["token"].(string)
+	if !rawTokenOk {
+		common.Log.Warning("failed to umarshal token during magic link dispatch message")
+		msg.Nak()
+		return
+	}
+
+	token, err := token.Parse(rawToken)
+	if err != nil {
+		common.Log.Warningf("failed to parse token during attempted magic link dispatch; %s", err.Error())
+		msg.Nak()
+		return
+	}
+
+	common.Log.Debugf("dispatch magic link for user: %s", token.UserID)
+	msg.Ack()
+}
+
+func consumeUserErasureSubscriptionsMsg(msg *nats.Msg) {
+	common.Log.Debugf("consuming %d-byte NATS user erasure message on subject: %s", len(msg.Data), msg.Subject)
+
+	var params map[string]interface{}
+
+	err := json.Unmarshal(msg.Data, &params)
+	if err != nil {
+		common.Log.Warningf("failed to umarshal user erasure message; %s", err.Error())
+		msg.Nak()
+		return
+	}
+
+	userID, err := uuid.FromString(fmt.Sprintf("%v", params["user_id"]))
+	if err != nil {
+		common.Log.Warningf("failed to parse user_id during user erasure message handler; %s", err.Error())
+		msg.Nak()
+		return
+	}
+
+	user := Find(userID)
+	if user == nil {
+		common.Log.Warningf("failed to resolve user during user erasure message handler; user id: %s", userID)
+		msg.Nak()
+		return
+	}
+
+	if !user.Erase() {
+		common.Log.Warningf("failed to erase user: %s; %s", userID, *user.Errors[0].Message)
+		msg.Nak()
+		return
+	}
+
+	msg.Ack()
+}
@@ -0,0 +1,182 @@
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	natsutil "github.com/kthomas/go-natsutil"
+	"github.com/provideplatform/ident/common"
+	"github.com/provideplatform/ident/token"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+const natsUserErasureSubject = "ident.user.erasure"
+const natsUserErasedSubject = "ident.user.erased"
+
+// userErasureDisabledReason is recorded as the suspension reason of users pending or having completed erasure
+const userErasureDisabledReason = "erasure"
+
+// userErasureTables contain rows which are removed upon erasure of the user they reference
+var userErasureTables = []string{
+	"tokens",
+	"sessions",
+	"password_history",
+	"user_identities",
+	"saml_identities",
+	"scim_identities",
+	"applications_users",
+	"organizations_users",
+	"audit_log_entries",
+	"legal_consents",
+}
+
+// IsErasureRequested returns true if the user has been erased or is pending erasure
+func (u *User) IsErasureRequested() bool {
+	return u.ErasedAt != nil || (u.DisabledReason != nil && *u.DisabledReason == userErasureDisabledReason)
+}
+
+// RequestErasure suspends the user and dispatches an erasure job; the user is unable to
+// authenticate as soon as the erasure has been requested
+func (u *User) RequestErasure() bool {
+	if u.IsErasureRequested() {
+		u.Errors = append(u.Errors, &provide.Error{
+			Message: common.StringOrNil("user has been erased or is pending erasure"),
+		})
+		return false
+	}
+
+	if !u.Suspend(nil, common.StringOrNil(userErasureDisabledReason)) {
+		return false
+	}
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"user_id": u.ID.String(),
+	})
+	_, err := natsutil.NatsJetstreamPublish(natsUserErasureSubject, payload)
+	if err != nil {
+		u.Errors = append(u.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("failed to dispatch erasure of user: %s; %s", u.ID, err.Error())),
+		})
+		return false
+	}
+
+	common.Log.Debugf("dispatched erasure of user: %s", u.ID)
+	return true
+}
+
+// Erase removes the tokens, sessions, identities and memberships of the user and pseudonymizes
+// the user; the pseudonymized row is retained so references to the user (i.e., as the owner of an
+// application or organization) remain intact. Erasure is idempotent.
+func (u *User) Erase() bool {
+	if u.ErasedAt != nil {
+		return true
+	}
+
+	email := *u.Email
+	db := dbconf.DatabaseConnection()
+	tx := db.Begin()
+	defer tx.RollbackUnlessCommitted()
+
+	err := token.RevokeUserTokens(tx, u.ID)
+	if err != nil {
+		u.Errors = append(u.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+		return false
+	}
+
+	for _, table := range userErasureTables {
+		result := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE user_id = ?", table), u.ID)
+		if result.Error != nil {
+			u.Errors = append(u.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("failed to erase %s of user: %s; %s", table, u.ID, result.Error.Error())),
+			})
+			return false
+		}
+	}
+
+	erasedAt := time.Now()
+	err = u.eraseInvitations(tx, email, erasedAt)
+	if err != nil {
+		u.Errors = append(u.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+		return false
+	}
+
+	result := tx.Model(u).Updates(map[string]interface{}{
+		"first_name":                 "Erased",
+		"last_name":                  "User",
+		"email":                      fmt.Sprintf("erased+%s@ident.invalid", u.ID),
+		"email_verified_at":          gorm.Expr("NULL"),
+		"pending_email":              gorm.Expr("NULL"),
+		"password":                   gorm.Expr("NULL"),
+		"reset_password_token":       gorm.Expr("NULL"),
+		"privacy_policy_agreed_at":   gorm.Expr("NULL"),
+		"terms_of_service_agreed_at": gorm.Expr("NULL"),
+		"metadata":                   gorm.Expr("NULL"),
+		"permissions":                0,
+		"disabled_at":                erasedAt,
+		"disabled_reason":            userErasureDisabledReason,
+		"erased_at":                  erasedAt,
+	})
+	if result.Error != nil {
+		u.Errors = append(u.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("failed to pseudonymize user: %s; %s", u.ID, result.Error.Error())),
+		})
+		return false
+	}
+
+	tx.Commit()
+
+	if common.Auth0IntegrationEnabled {
+		err := deleteAuth0User(email)
+		if err != nil {
+			common.Log.Warningf("failed to delete auth0 user during erasure of user: %s; %s", u.ID, err.Error())
+		}
+	}
+
+	accountKey := authenticationAccountKey(email, u.ApplicationID)
+	redisDel(authenticationLockoutKey(accountKey), authenticationFailuresKey(accountKey))
+
+	u.ErasedAt = &erasedAt
+	common.Log.Debugf("erased user: %s", u.ID)
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"user_id":   u.ID.String(),
+		"erased_at": erasedAt,
+	})
+	natsutil.NatsJetstreamPublish(natsUserErasedSubject, payload)
+
+	return true
+}
+
+// eraseInvitations revokes and removes the invitations addressed to the user, and pseudonymizes
+// the name of the user on the invitations it sent
+func (u *User) eraseInvitations(tx *gorm.DB, email string, erasedAt time.Time) error {
+	var invitations []*Invitation
+	tx.Where("user_id = ? OR lower(email) = lower(?)", u.ID, email).Find(&invitations)
+	for _, invitation := range invitations {
+		if invitation.IsPending() {
+			err := invitation.revokeToken(tx, erasedAt)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	result := tx.Exec("DELETE FROM invitations WHERE user_id = ? OR lower(email) = lower(?)", u.ID, email)
+	if result.Error != nil {
+		return fmt.Errorf("failed to erase invitations of user: %s; %s", u.ID, result.Error.Error())
+	}
+
+	result = tx.Exec("UPDATE invitations SET invitor_name = ? WHERE invitor_id = ?", "Erased User", u.ID)
+	if result.Error != nil {
+		return fmt.Errorf("failed to pseudonymize invitations sent by user: %s; %s", u.ID, result.Error.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,293 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	natsutil "github.com/kthomas/go-natsutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+	"github.com/provideplatform/ident/token"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+const natsUserExpiringSubject = "ident.user.expiring"
+const natsUserExpiredSubject = "ident.user.expired"
+const natsApplicationUserExpiringSubject = "ident.application.user.expiring"
+const natsApplicationUserExpiredSubject = "ident.application.user.expired"
+
+// userExpiredDisabledReason is recorded as the suspension reason of users disabled upon expiration
+const userExpiredDisabledReason = "expired"
+
+// userExpirationNotificationLeadTime is how long prior to the expiration of a user or application
+// membership the expiring notification event is published
+const userExpirationNotificationLeadTime = time.Hour * 24 * 7
+
+// IsExpired returns true if the user account has expired
+func (u *User) IsExpired() bool {
+	return u.ExpiresAt != nil && time.Now().After(*u.ExpiresAt)
+}
+
+// ParseExpiration parses the expires_at param, which is required and must be an RFC3339
+// timestamp, or null to clear the expiration
+func ParseExpiration(params map[string]interface{}) (*time.Time, error) {
+	raw, rawOk := params["expires_at"]
+	if !rawOk {
+		return nil, errors.New("expires_at required")
+	}
+
+	if raw == nil {
+		return nil, nil
+	}
+
+	expiresAtStr, expiresAtStrOk := raw.(string)
+	if !expiresAtStrOk {
+		return nil, errors.New("expires_at must be an RFC3339 timestamp or null")
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, expiresAtStr)
+	if err != nil {
+		return nil, fmt.Errorf("expires_at must be an RFC3339 timestamp or null; %s", err.Error())
+	}
+
+	return &expiresAt, nil
+}
+
+// SetExpiration sets, extends or clears the expiration of the user account; a user which was
+// disabled upon expiration is reactivated when its expiration is extended or cleared
+func (u *User) SetExpiration(tx *gorm.DB, expiresAt *time.Time) bool {
+	u.Errors = make([]*provide.Error, 0)
+
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		u.Errors = append(u.Errors, &provide.Error{
+			Message: common.StringOrNil("expires_at must be in the future"),
+		})
+		return false
+	}
+
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+		db = db.Begin()
+		defer db.RollbackUnlessCommitted()
+	}
+
+	var expiration interface{} = gorm.Expr("NULL")
+	if expiresAt != nil {
+		expiration = *expiresAt
+	}
+
+	result := db.Model(u).Updates(map[string]interface{}{
+		"expires_at":             expiration,
+		"expiration_notified_at": gorm.Expr("NULL"),
+	})
+	if result.Error != nil {
+		u.Errors = append(u.Errors, &provide.Error{
+			Message: common.StringOrNil(result.Error.Error()),
+		})
+		return false
+	}
+
+	u.ExpiresAt = expiresAt
+	u.ExpirationNotifiedAt = nil
+
+	if u.DisabledReason != nil && *u.DisabledReason == userExpiredDisabledReason && !u.Reactivate(db) {
+		return false
+	}
+
+	if tx == nil {
+		db.Commit()
+	}
+
+	common.Log.Debugf("set expiration of user %s to %v", u.ID, expiresAt)
+	return true
+}
+
+// SweepExpirations publishes notification events on behalf of user accounts and application
+// memberships nearing expiration, disables expired user accounts and revokes their tokens,
+// removes expired application memberships and revokes their application tokens, and marks expired
+// invitations; each expiring or expired user or membership is claimed atomically, so concurrent
+// sweeps do not duplicate notifications
+func SweepExpirations(db *gorm.DB) {
+	notifyExpiringUsers(db)
+	expireUsers(db)
+	notifyExpiringApplicationUsers(db)
+	expireApplicationUsers(db)
+	expireInvitations(db)
+}
+
+// notifyExpiringUsers publishes an expiring event for each enabled user account which expires
+// within the notification lead time and on behalf of which no such event has been published
+func notifyExpiringUsers(db *gorm.DB) {
+	rows, err := db.Raw(
+		"UPDATE users SET expiration_notified_at = now() WHERE expires_at > now() AND expires_at <= ? AND expiration_notified_at IS NULL AND disabled_at IS NULL RETURNING id, application_id, expires_at",
+		time.Now().Add(userExpirationNotificationLeadTime),
+	).Rows()
+	if err != nil {
+		common.Log.Warningf("failed to resolve expiring users; %s", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID uuid.UUID
+		var applicationID *uuid.UUID
+		var expiresAt time.Time
+		err = rows.Scan(&userID, &applicationID, &expiresAt)
+		if err != nil {
+			common.Log.Warningf("failed to scan expiring user; %s", err.Error())
+			continue
+		}
+
+		payload, _ := json.Marshal(map[string]interface{}{
+			"user_id":        userID.String(),
+			"application_id": applicationID,
+			"expires_at":     expiresAt,
+		})
+		natsutil.NatsJetstreamPublish(natsUserExpiringSubject, payload)
+		common.Log.Debugf("published expiring notification on behalf of user: %s", userID)
+	}
+}
+
+// expireUsers disables each enabled user account which has expired and revokes its tokens
+func expireUsers(db *gorm.DB) {
+	tx := db.Begin()
+	defer tx.RollbackUnlessCommitted()
+
+	rows, err := tx.Raw(
+		"UPDATE users SET disabled_at = now(), disabled_reason = ? WHERE expires_at <= now() AND disabled_at IS NULL RETURNING id, application_id, expires_at",
+		userExpiredDisabledReason,
+	).Rows()
+	if err != nil {
+		common.Log.Warningf("failed to resolve expired users; %s", err.Error())
+		return
+	}
+
+	payloads := make([][]byte, 0)
+	userIDs := make([]uuid.UUID, 0)
+	for rows.Next() {
+		var userID uuid.UUID
+		var applicationID *uuid.UUID
+		var expiresAt time.Time
+		err = rows.Scan(&userID, &applicationID, &expiresAt)
+		if err != nil {
+			common.Log.Warningf("failed to scan expired user; %s", err.Error())
+			continue
+		}
+
+		userIDs = append(userIDs, userID)
+		payload, _ := json.Marshal(map[string]interface{}{
+			"user_id":        userID.String(),
+			"application_id": applicationID,
+			"expires_at":     expiresAt,
+		})
+		payloads = append(payloads, payload)
+	}
+	rows.Close()
+
+	for _, userID := range userIDs {
+		err = token.RevokeUserTokens(tx, userID)
+		if err != nil {
+			common.Log.Warningf("failed to revoke tokens of expired user: %s; %s", userID, err.Error())
+			return
+		}
+	}
+
+	tx.Commit()
+
+	for i, payload := range payloads {
+		natsutil.NatsJetstreamPublish(natsUserExpiredSubject, payload)
+		common.Log.Debugf("disabled expired user: %s", userIDs[i])
+	}
+}
+
+// notifyExpiringApplicationUsers publishes an expiring event for each application membership
+// which expires within the notification lead time and on behalf of which no such event has been published
+func notifyExpiringApplicationUsers(db *gorm.DB) {
+	rows, err := db.Raw(
+		"UPDATE applications_users SET expiration_notified_at = now() WHERE expires_at > now() AND expires_at <= ? AND expiration_notified_at IS NULL RETURNING application_id, user_id, expires_at",
+		time.Now().Add(userExpirationNotificationLeadTime),
+	).Rows()
+	if err != nil {
+		common.Log.Warningf("failed to resolve expiring application users; %s", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var applicationID uuid.UUID
+		var userID uuid.UUID
+		var expiresAt time.Time
+		err = rows.Scan(&applicationID, &userID, &expiresAt)
+		if err != nil {
+			common.Log.Warningf("failed to scan expiring application user; %s", err.Error())
+			continue
+		}
+
+		payload, _ := json.Marshal(map[string]interface{}{
+			"application_id": applicationID.String(),
+			"user_id":        userID.String(),
+			"expires_at":     expiresAt,
+		})
+		natsutil.NatsJetstreamPublish(natsApplicationUserExpiringSubject, payload)
+		common.Log.Debugf("published expiring notification on behalf of user %s for application: %s", userID, applicationID)
+	}
+}
+
+// expireApplicationUsers removes each application membership which has expired and revokes
+// the tokens issued on behalf of the user and scoped to the application
+func expireApplicationUsers(db *gorm.DB) {
+	tx := db.Begin()
+	defer tx.RollbackUnlessCommitted()
+
+	rows, err := tx.Raw("DELETE FROM applications_users WHERE expires_at <= now() RETURNING application_id, user_id, expires_at").Rows()
+	if err != nil {
+		common.Log.Warningf("failed to resolve expired application users; %s", err.Error())
+		return
+	}
+
+	payloads := make([][]byte, 0)
+	applicationIDs := make([]uuid.UUID, 0)
+	userIDs := make([]uuid.UUID, 0)
+	for rows.Next() {
+		var applicationID uuid.UUID
+		var userID uuid.UUID
+		var expiresAt time.Time
+		err = rows.Scan(&applicationID, &userID, &expiresAt)
+		if err != nil {
+			common.Log.Warningf("failed to scan expired application user; %s", err.Error())
+			continue
+		}
+
+		applicationIDs = append(applicationIDs, applicationID)
+		userIDs = append(userIDs, userID)
+		payload, _ := json.Marshal(map[string]interface{}{
+			"application_id": applicationID.String(),
+			"user_id":        userID.String(),
+			"expires_at":     expiresAt,
+		})
+		payloads = append(payloads, payload)
+	}
+	rows.Close()
+
+	for i, userID := range userIDs {
+		err = token.RevokeApplicationUserTokens(tx, applicationIDs[i], userID)
+		if err != nil {
+			common.Log.Warningf("failed to revoke application tokens of expired application user: %s; %s", userID, err.Error())
+			return
+		}
+	}
+
+	tx.Commit()
+
+	for i, payload := range payloads {
+		natsutil.NatsJetstreamPublish(natsApplicationUserExpiredSubject, payload)
+		common.Log.Debugf("removed expired user %s from application: %s", userIDs[i], applicationIDs[i])
+	}
+}
@@ -0,0 +1,162 @@
+package user
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+	"github.com/provideplatform/ident/legal"
+	"github.com/provideplatform/ident/token"
+)
+
+// Export is an archive of the data held by ident on behalf of a user; secrets such as
+// password hashes, raw tokens and refresh token hashes are never included
+type Export struct {
+	ExportedAt    time.Time           `json:"exported_at"`
+	User          *Response           `json:"user"`
+	Applications  []*ExportMembership `json:"applications"`
+	Organizations []*ExportMembership `json:"organizations"`
+	Identities    []*ExportIdentity   `json:"identities"`
+	Tokens        []*ExportToken      `json:"tokens"`
+	Sessions      []*ExportSession    `json:"sessions"`
+	Consents      []*legal.Consent    `json:"consents"`
+	AuditEntries  []*ExportAuditEntry `json:"audit_entries"`
+}
+
+// ExportMembership is an application or organization of which the user is a member
+type ExportMembership struct {
+	ID          uuid.UUID         `json:"id"`
+	Name        *string           `json:"name"`
+	Permissions common.Permission `json:"permissions"`
+	Owner       bool              `json:"owner"`
+}
+
+// ExportIdentity is an identity asserted on behalf of the user by an upstream identity provider
+type ExportIdentity struct {
+	Type           string     `json:"type"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ProviderID     *uuid.UUID `json:"provider_id,omitempty"`
+	OrganizationID *uuid.UUID `json:"organization_id,omitempty"`
+	Issuer         *string    `json:"issuer,omitempty"`
+	Subject        *string    `json:"subject,omitempty"`
+}
+
+// ExportToken is the metadata of a persisted token issued to the user
+type ExportToken struct {
+	ID             uuid.UUID  `json:"id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	IssuedAt       *time.Time `json:"issued_at"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	ApplicationID  *uuid.UUID `json:"application_id,omitempty"`
+	OrganizationID *uuid.UUID `json:"organization_id,omitempty"`
+}
+
+// ExportSession is a session established by the user
+type ExportSession struct {
+	ID            uuid.UUID  `json:"id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ApplicationID *uuid.UUID `json:"application_id,omitempty"`
+	UserAgent     *string    `json:"user_agent"`
+	IPAddress     *string    `json:"ip_address"`
+	RefreshedAt   *time.Time `json:"refreshed_at"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+	RevokedAt     *time.Time `json:"revoked_at"`
+}
+
+// ExportAuditEntry is a security-relevant event recorded for the user
+type ExportAuditEntry struct {
+	Event      string    `json:"event"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Detail     *string   `json:"detail,omitempty"`
+}
+
+// Export returns an archive of the data held on behalf of the user
+func (u *User) Export(db *gorm.DB) *Export {
+	export := &Export{
+		ExportedAt:    time.Now(),
+		User:          u.AsResponse(),
+		Applications:  make([]*ExportMembership, 0),
+		Organizations: make([]*ExportMembership, 0),
+		Identities:    make([]*ExportIdentity, 0),
+		Tokens:        make([]*ExportToken, 0),
+		Sessions:      make([]*ExportSession, 0),
+		Consents:      make([]*legal.Consent, 0),
+	}
+
+	db.Raw("SELECT a.id, a.name, au.permissions, a.user_id = au.user_id AS owner FROM applications_users au JOIN applications a ON a.id = au.application_id WHERE au.user_id = ? ORDER BY a.created_at", u.ID).Scan(&export.Applications)
+	db.Raw("SELECT o.id, o.name, ou.permissions, COALESCE(o.user_id = ou.user_id, false) AS owner FROM organizations_users ou JOIN organizations o ON o.id = ou.organization_id WHERE ou.user_id = ? ORDER BY o.created_at", u.ID).Scan(&export.Organizations)
+
+	oidcIdentities := make([]*ExportIdentity, 0)
+	db.Raw("SELECT 'oidc' AS type, ui.created_at, ui.provider_id, p.organization_id, p.issuer, ui.subject FROM user_identities ui JOIN oidc_providers p ON p.id = ui.provider_id WHERE ui.user_id = ?", u.ID).Scan(&oidcIdentities)
+	samlIdentities := make([]*ExportIdentity, 0)
+	db.Raw("SELECT 'saml' AS type, si.created_at, si.saml_provider_id AS provider_id, p.organization_id, si.name_id AS subject FROM saml_identities si JOIN saml_providers p ON p.id = si.saml_provider_id WHERE si.user_id = ?", u.ID).Scan(&samlIdentities)
+	scimIdentities := make([]*ExportIdentity, 0)
+	db.Raw("SELECT 'scim' AS type, created_at, organization_id, external_id AS subject FROM scim_identities WHERE user_id = ?", u.ID).Scan(&scimIdentities)
+	export.Identities = append(export.Identities, oidcIdentities...)
+	export.Identities = append(export.Identities, samlIdentities...)
+	export.Identities = append(export.Identities, scimIdentities...)
+
+	db.Raw("SELECT id, created_at, issued_at, expires_at, application_id, organization_id FROM tokens WHERE user_id = ? ORDER BY created_at", u.ID).Scan(&export.Tokens)
+	db.Raw("SELECT id, created_at, application_id, user_agent, ip_address, refreshed_at, expires_at, revoked_at FROM sessions WHERE user_id = ? ORDER BY created_at", u.ID).Scan(&export.Sessions)
+	db.Where("user_id = ?", u.ID).Order("created_at").Find(&export.Consents)
+
+	export.AuditEntries = u.auditEntries(db, export.Sessions)
+	return export
+}
+
+// auditEntries returns the security-relevant events recorded for the user in chronological order
+func (u *User) auditEntries(db *gorm.DB, sessions []*ExportSession) []*ExportAuditEntry {
+	entries := []*ExportAuditEntry{{Event: "user.created", OccurredAt: u.CreatedAt}}
+	record := func(event string, occurredAt *time.Time, detail *string) {
+		if occurredAt != nil {
+			entries = append(entries, &ExportAuditEntry{Event: event, OccurredAt: *occurredAt, Detail: detail})
+		}
+	}
+
+	record("user.email_verified", u.EmailVerifiedAt, nil)
+	record("user.privacy_policy_agreed", u.PrivacyPolicyAgreedAt, nil)
+	record("user.terms_of_service_agreed", u.TermsOfServiceAgreedAt, nil)
+	record("user.suspended", u.DisabledAt, u.DisabledReason)
+
+	var passwordChanges []time.Time
+	db.Table("password_history").Where("user_id = ?", u.ID).Pluck("created_at", &passwordChanges)
+	for i := range passwordChanges {
+		record("user.password_changed", &passwordChanges[i], nil)
+	}
+
+	var tokenRevocations []time.Time
+	db.Table("user_token_revocations").Where("user_id = ?", u.ID).Pluck("revoked_at", &tokenRevocations)
+	for i := range tokenRevocations {
+		record("user.tokens_revoked", &tokenRevocations[i], nil)
+	}
+
+	var auditLogEntries []*token.AuditLogEntry
+	db.Where("user_id = ?", u.ID).Find(&auditLogEntries)
+	for _, entry := range auditLogEntries {
+		detail := entry.Path
+		if entry.Event != nil && *entry.Event == token.AuditEventImpersonationStarted {
+			detail = entry.Reason
+		}
+		record(*entry.Event, &entry.CreatedAt, detail)
+	}
+
+	var consents []*legal.Consent
+	db.Where("user_id = ?", u.ID).Find(&consents)
+	for _, consent := range consents {
+		detail := fmt.Sprintf("%s %s", *consent.DocumentType, *consent.DocumentVersion)
+		record("legal.consented", &consent.CreatedAt, &detail)
+	}
+
+	for _, session := range sessions {
+		record("session.created", &session.CreatedAt, session.IPAddress)
+		record("session.revoked", session.RevokedAt, nil)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].OccurredAt.Before(entries[j].OccurredAt)
+	})
+	return entries
+}
@@ -4,14 +4,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
-	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/gorm"
 	dbconf "github.com/kthomas/go-db-config"
 	uuid "github.com/kthomas/go.uuid"
 	"github.com/provideplatform/ident/common"
+	"github.com/provideplatform/ident/legal"
+	"github.com/provideplatform/ident/role"
 	"github.com/provideplatform/ident/token"
 	api "github.com/provideplatform/provide-go/api"
 	provide "github.com/provideplatform/provide-go/common"
@@ -21,21 +24,73 @@ import (
 // InstallPublicUserAPI installs unauthenticated API handlers using the given gin Engine
 func InstallPublicUserAPI(r *gin.Engine) {
 	r.POST("/api/v1/authenticate", authenticationHandler)
+	r.POST("/api/v1/authenticate/magic_link", magicLinkRequestHandler)
+	r.POST("/api/v1/authenticate/magic_link/:token", magicLinkRedemptionHandler)
 	r.POST("/api/v1/users", createUserHandler)
 	r.POST("/api/v1/users/reset_password", userResetPasswordRequestHandler)
 	r.POST("/api/v1/users/reset_password/:token", userResetPasswordHandler)
+	r.POST("/api/v1/users/verify_email/:token", userVerifyEmailHandler)
 
+	r.GET("/api/v1/oauth/authorize", oauthAuthorizeHandler)
+	r.GET("/api/v1/oauth/callback", oauthCallbackHandler)
 	r.POST("/api/v1/oauth/callback", oauthCallbackHandler)
+
+	r.GET("/api/v1/saml/:id/metadata", samlMetadataHandler)
+	r.GET("/api/v1/saml/:id/login", samlLoginHandler)
+	r.POST("/api/v1/saml/:id/acs", samlAssertionConsumerServiceHandler)
 }
 
 // InstallUserAPI installs handlers using the given gin Engine which require API authorization
 func InstallUserAPI(r *gin.Engine) {
 	r.GET("/api/v1/users", usersListHandler)
+	r.GET("/api/v1/users/password_report", passwordHashReportHandler)
 	r.GET("/api/v1/users/:id", userDetailsHandler)
 	r.PUT("/api/v1/users/:id", updateUserHandler)
 	r.DELETE("/api/v1/users/:id", deleteUserHandler)
-
+	r.POST("/api/v1/users/:id/unlock", unlockUserHandler)
+	r.POST("/api/v1/users/:id/suspend", suspendUserHandler)
+	r.POST("/api/v1/users/:id/reactivate", reactivateUserHandler)
+	r.PUT("/api/v1/users/:id/expiration", userExpirationHandler)
+	r.POST("/api/v1/users/:id/export", exportUserHandler)
+	r.POST("/api/v1/users/:id/erase", eraseUserHandler)
+	r.POST("/api/v1/users/:id/impersonate", impersonateUserHandler)
+	r.GET("/api/v1/users/:id/audit_log", userAuditLogHandler)
+	r.GET("/api/v1/users/:id/consents", userConsentsListHandler)
+	r.POST("/api/v1/users/:id/consents", createUserConsentsHandler)
+	r.GET("/api/v1/users/:id/sessions", userSessionsListHandler)
+	r.DELETE("/api/v1/users/:id/sessions/:sessionId", deleteUserSessionHandler)
+	r.POST("/api/v1/users/:id/verify_email", userEmailVerificationRequestHandler)
+	r.POST("/api/v1/users/:id/identities", linkUserIdentityHandler)
+
+	r.GET("/api/v1/invitations", invitationsListHandler)
 	r.POST("/api/v1/invitations", vendInvitationTokenHandler)
+	r.GET("/api/v1/invitations/:id", invitationDetailsHandler)
+	r.DELETE("/api/v1/invitations/:id", revokeInvitationHandler)
+	r.POST("/api/v1/invitations/:id/resend", resendInvitationHandler)
+
+	r.GET("/api/v1/oidc_providers", oidcProvidersListHandler)
+	r.POST("/api/v1/oidc_providers", createOIDCProviderHandler)
+	r.DELETE("/api/v1/oidc_providers/:id", deleteOIDCProviderHandler)
+}
+
+// InstallSCIMAPI installs the SCIM 2.0 provisioning API using the given gin Engine; requests
+// must be authorized using an organization bearer token, to which all provisioning is scoped
+func InstallSCIMAPI(r *gin.Engine) {
+	r.GET("/scim/v2/ServiceProviderConfig", scimServiceProviderConfigHandler)
+
+	r.GET("/scim/v2/Users", scimUsersListHandler)
+	r.POST("/scim/v2/Users", scimCreateUserHandler)
+	r.GET("/scim/v2/Users/:id", scimUserDetailsHandler)
+	r.PUT("/scim/v2/Users/:id", scimReplaceUserHandler)
+	r.PATCH("/scim/v2/Users/:id", scimPatchUserHandler)
+	r.DELETE("/scim/v2/Users/:id", scimDeleteUserHandler)
+
+	r.GET("/scim/v2/Groups", scimGroupsListHandler)
+	r.POST("/scim/v2/Groups", scimUnsupportedGroupOperationHandler)
+	r.GET("/scim/v2/Groups/:id", scimGroupDetailsHandler)
+	r.PUT("/scim/v2/Groups/:id", scimReplaceGroupHandler)
+	r.PATCH("/scim/v2/Groups/:id", scimPatchGroupHandler)
+	r.DELETE("/scim/v2/Groups/:id", scimUnsupportedGroupOperationHandler)
 }
 
 func authenticationHandler(c *gin.Context) {
@@ -83,12 +138,47 @@ func authenticationHandler(c *gin.Context) {
 					appID = &appUUID
 				}
 
+				ip := c.ClientIP()
+				if retryAfter := authenticationRetryAfter(email, appID, ip); retryAfter > 0 {
+					c.Header("Retry-After", retryAfterSeconds(retryAfter))
+					provide.RenderError(errAuthenticationLocked.Error(), 429, c)
+					return
+				}
+
+				session := &token.Session{
+					UserAgent: common.StringOrNil(c.Request.UserAgent()),
+					IPAddress: common.StringOrNil(ip),
+				}
+
 				db := dbconf.DatabaseConnection()
-				resp, err := AuthenticateUser(db, email, pw, appID, scope)
-				if err != nil {
+				resp, err := AuthenticateUser(db, email, pw, appID, scope, session)
+				if err == errConsentRequired {
+					resetAuthenticationFailures(email, appID)
+					accepted, acceptErr := acceptRequiredConsents(c, db, resp, params)
+					if acceptErr != nil {
+						provide.RenderError(acceptErr.Error(), 422, c)
+						return
+					}
+					if accepted {
+						resp, err = AuthenticateUser(db, email, pw, appID, scope, session)
+					}
+				}
+				if err == errConsentRequired {
+					provide.Render(resp, 403, c)
+					return
+				} else if err == errLDAPUnavailable {
+					provide.RenderError(err.Error(), 503, c)
+					return
+				} else if err != nil {
+					if err == errInvalidCredentials {
+						if retryAfter := recordAuthenticationFailure(email, appID, ip); retryAfter > 0 {
+							c.Header("Retry-After", retryAfterSeconds(retryAfter))
+						}
+					}
 					provide.RenderError(err.Error(), 401, c)
 					return
 				}
+				resetAuthenticationFailures(email, appID)
 
 				if invitationToken, invitationTokenOk := params["invitation_token"].(string); invitationTokenOk {
 					invite, err := ParseInvite(invitationToken, false)
@@ -110,8 +200,26 @@ func authenticationHandler(c *gin.Context) {
 				provide.Render(resp, 201, c)
 				return
 			} else if bearerApplicationID != nil {
-				resp, err := AuthenticateApplicationUser(email, *bearerApplicationID, scope)
-				if err != nil {
+				session := &token.Session{
+					UserAgent: common.StringOrNil(c.Request.UserAgent()),
+					IPAddress: common.StringOrNil(c.ClientIP()),
+				}
+
+				resp, err := AuthenticateApplicationUser(email, *bearerApplicationID, scope, session)
+				if err == errConsentRequired {
+					accepted, acceptErr := acceptRequiredConsents(c, dbconf.DatabaseConnection(), resp, params)
+					if acceptErr != nil {
+						provide.RenderError(acceptErr.Error(), 422, c)
+						return
+					}
+					if accepted {
+						resp, err = AuthenticateApplicationUser(email, *bearerApplicationID, scope, session)
+					}
+				}
+				if err == errConsentRequired {
+					provide.Render(resp, 403, c)
+					return
+				} else if err != nil {
 					provide.RenderError(err.Error(), 401, c)
 					return
 				}
@@ -155,6 +263,12 @@ func usersListHandler(c *gin.Context) {
 		}
 	}
 
+	if bearer.ApplicationID != nil {
+		for _, usr := range users {
+			usr.scopeAttributes(*bearer.ApplicationID)
+		}
+	}
+
 	provide.Render(users, 200, c)
 }
 
@@ -178,6 +292,10 @@ func userDetailsHandler(c *gin.Context) {
 	}
 	user.Enrich()
 
+	if bearer.ApplicationID != nil {
+		user.scopeAttributes(*bearer.ApplicationID)
+	}
+
 	provide.Render(user.AsResponse(), 200, c)
 }
 
@@ -219,6 +337,15 @@ func createUserHandler(c *gin.Context) {
 		return
 	}
 
+	// email ownership can only be asserted by verification
+	user.EmailVerifiedAt = nil
+	user.PendingEmail = nil
+	user.DisabledAt = nil
+	user.DisabledReason = nil
+
+	// attributes are namespaced and validated upon update
+	user.Metadata = nil
+
 	var invite *Invite
 
 	if invitationToken, invitationTokenOk := params["invitation_token"].(string); invitationTokenOk {
@@ -246,6 +373,12 @@ func createUserHandler(c *gin.Context) {
 		return
 	}
 
+	if invite != nil && invite.Email != nil && strings.EqualFold(*invite.Email, *user.Email) {
+		// the invitation was delivered to this address, which proves ownership of it
+		verifiedAt := time.Now()
+		user.EmailVerifiedAt = &verifiedAt
+	}
+
 	if bearerApplicationID != nil {
 		user.ApplicationID = bearerApplicationID
 	} else if appID, appIDOk := params["application_id"].(string); appIDOk {
@@ -300,6 +433,9 @@ func createUserHandler(c *gin.Context) {
 
 	if success {
 		tx.Commit()
+		if !user.IsEmailVerified() {
+			user.requestEmailVerification()
+		}
 		provide.Render(user.AsResponse(), 201, c)
 	} else {
 		tx.Rollback()
@@ -312,12 +448,34 @@ func createUserHandler(c *gin.Context) {
 func processUserInvite(tx *gorm.DB, user User, invite Invite) error {
 	success := false
 
+	if user.IsDisabled() {
+		return errors.New("failed to process user invitation; user has been suspended")
+	}
+
+	if invite.OrganizationID != nil && isOrganizationSuspended(tx, *invite.OrganizationID) {
+		return errors.New("failed to process user invitation; organization has been suspended")
+	}
+
+	if !user.IsEmailVerified() && invite.Email != nil && user.Email != nil && strings.EqualFold(*invite.Email, *user.Email) {
+		if !user.markEmailVerified(tx) {
+			return errors.New("failed to process user invitation; email verification failed")
+		}
+	}
+
+	if !user.IsEmailVerified() {
+		if invite.OrganizationID != nil && OrganizationRequiresVerifiedEmail(tx, *invite.OrganizationID) {
+			return errors.New("failed to process user invitation; organization requires a verified email address")
+		} else if invite.ApplicationID != nil && ApplicationRequiresVerifiedEmail(tx, *invite.ApplicationID) {
+			return errors.New("failed to process user invitation; application requires a verified email address")
+		}
+	}
+
 	if invite.OrganizationID != nil {
 		orgPermissions := common.DefaultApplicationResourcePermission
 		if invite.Permissions != nil {
 			orgPermissions = *invite.Permissions
 		}
-		success = user.addOrganizationAssociation(tx, *invite.OrganizationID, orgPermissions)
+		success = user.addOrganizationAssociation(tx, *invite.OrganizationID, orgPermissions, invite.RoleID)
 		if !success {
 			return errors.New("failed to process user invitation; organization association failed")
 		}
@@ -326,7 +484,7 @@ func processUserInvite(tx *gorm.DB, user User, invite Invite) error {
 		if invite.Permissions != nil {
 			appPermissions = *invite.Permissions
 		}
-		success = user.addApplicationAssociation(tx, *invite.ApplicationID, appPermissions)
+		success = user.addApplicationAssociation(tx, *invite.ApplicationID, appPermissions, invite.RoleID)
 		if !success {
 			return errors.New("failed to process user invitation; application association failed")
 		}
@@ -339,7 +497,10 @@ func processUserInvite(tx *gorm.DB, user User, invite Invite) error {
 			return errors.New("failed to process user invitation; token revocation failed")
 		}
 
-		go invite.InvalidateCache()
+		err := invite.Accept(tx, &user.ID)
+		if err != nil {
+			return fmt.Errorf("failed to process user invitation; %s", err.Error())
+		}
 	}
 
 	return nil
@@ -370,6 +531,11 @@ func updateUserHandler(c *gin.Context) {
 		rehashPassword = true
 	}
 
+	if rehashPassword && bearer.IsImpersonation() {
+		provide.RenderError("impersonation tokens cannot be used to change passwords", 403, c)
+		return
+	}
+
 	user := &User{}
 	dbconf.DatabaseConnection().Where("id = ?", c.Param("id")).Find(&user)
 	if user.ID == uuid.Nil {
@@ -382,22 +548,83 @@ func updateUserHandler(c *gin.Context) {
 		return
 	}
 
+	if updatedEmail, updatedEmailOk := params["email"].(string); updatedEmailOk && bearer.IsImpersonation() && !strings.EqualFold(updatedEmail, *user.Email) {
+		provide.RenderError("impersonation tokens cannot be used to change email addresses", 403, c)
+		return
+	}
+
+	email := user.Email
+	emailVerifiedAt := user.EmailVerifiedAt
+	pendingEmail := user.PendingEmail
+	disabledAt := user.DisabledAt
+	disabledReason := user.DisabledReason
+	metadata := user.Metadata
+
 	err = json.Unmarshal(buf, user)
 	if err != nil {
 		provide.RenderError(err.Error(), 422, c)
 		return
 	}
 
+	// email ownership can only be asserted by verification; a changed email
+	// address remains pending until the new address has been verified
+	user.EmailVerifiedAt = emailVerifiedAt
+	user.PendingEmail = pendingEmail
+
+	// suspension is managed using the suspend and reactivate endpoints
+	user.DisabledAt = disabledAt
+	user.DisabledReason = disabledReason
+
+	// attributes are updated per namespace; omitted namespaces are retained
+	user.Metadata = metadata
+	if namespaces, namespacesOk := params["attributes"].(map[string]interface{}); namespacesOk {
+		for namespace := range namespaces {
+			if (bearer.ApplicationID != nil || !bearer.HasAnyPermission(common.UpdateUser, common.Sudo)) && !user.isAttributesNamespaceWritable(dbconf.DatabaseConnection(), namespace, bearer.ApplicationID) {
+				provide.RenderError(fmt.Sprintf("insufficient permissions to modify user attributes namespace: %s", namespace), 403, c)
+				return
+			}
+		}
+
+		err = user.updateAttributes(dbconf.DatabaseConnection(), namespaces)
+		if err != nil {
+			provide.RenderError(err.Error(), 422, c)
+			return
+		}
+	} else if _, attributesOk := params["attributes"]; attributesOk {
+		provide.RenderError("attributes must be an object keyed by namespace", 422, c)
+		return
+	}
+
+	requestEmailVerification := false
+	if user.Email != nil && email != nil && !strings.EqualFold(*user.Email, *email) {
+		if !user.requestEmailChange(*user.Email) {
+			obj := map[string]interface{}{}
+			obj["errors"] = user.Errors
+			provide.Render(obj, 422, c)
+			return
+		}
+		requestEmailVerification = true
+	}
+	user.Email = email
+
 	if bearer != nil && !bearer.HasAnyPermission(common.UpdateUser, common.Sudo) {
 		user.ApplicationID = bearer.ApplicationID
 	}
 
 	if rehashPassword {
 		user.Password = common.StringOrNil(params["password"].(string))
-		user.rehashPassword()
+		if !user.rehashPassword() {
+			obj := map[string]interface{}{}
+			obj["errors"] = user.Errors
+			provide.Render(obj, 422, c)
+			return
+		}
 	}
 
 	if user.Update() {
+		if requestEmailVerification {
+			user.requestEmailVerification()
+		}
 		provide.Render(nil, 204, c)
 	} else {
 		obj := map[string]interface{}{}
@@ -433,55 +660,32 @@ func deleteUserHandler(c *gin.Context) {
 	}
 }
 
-func userResetPasswordRequestHandler(c *gin.Context) {
+func unlockUserHandler(c *gin.Context) {
 	bearer := token.InContext(c)
-
-	buf, err := c.GetRawData()
-	if err != nil {
-		provide.RenderError(err.Error(), 400, c)
-		return
-	}
-
-	params := map[string]interface{}{}
-	err = json.Unmarshal(buf, &params)
-	if err != nil {
-		provide.RenderError(err.Error(), 400, c)
+	if bearer == nil {
+		provide.RenderError("unauthorized", 401, c)
 		return
 	}
 
-	email, emailOk := params["email"].(string)
-	if !emailOk {
-		provide.RenderError("email address is required", 422, c)
+	if !bearer.HasAnyPermission(common.UpdateUser, common.Sudo) {
+		provide.RenderError("forbidden", 403, c)
 		return
 	}
 
-	var appID *uuid.UUID
-	appIDStr, appIDStrOk := params["application_id"].(string)
+	user := &User{}
+	query := dbconf.DatabaseConnection().Where("id = ?", c.Param("id"))
 
-	if bearer != nil && bearer.ApplicationID != nil {
-		appID = bearer.ApplicationID
-		if appIDStrOk && appIDStr != appID.String() {
-			provide.RenderError(fmt.Sprintf("mismatched/ambiguous application_id; (%s provided in bearer token; %s as application_id param)", appID.String(), appIDStr), 400, c)
-			return
-		}
-	} else if appIDStrOk {
-		appUUID, err := uuid.FromString(appIDStr)
-		if err != nil {
-			provide.RenderError(fmt.Sprintf("invalid application_id; %s", err.Error()), 422, c)
-			return
-		}
-		appID = &appUUID
+	if bearer.ApplicationID != nil {
+		query = query.Where("application_id = ?", bearer.ApplicationID.String())
 	}
 
-	db := dbconf.DatabaseConnection()
-	user := FindByEmail(email, appID, nil)
-
-	if user == nil || user.ID == uuid.Nil {
+	query.Find(&user)
+	if user.ID == uuid.Nil {
 		provide.RenderError("user not found", 404, c)
 		return
 	}
 
-	if user.requestPasswordReset(db) {
+	if user.Unlock() {
 		provide.Render(nil, 204, c)
 	} else {
 		obj := map[string]interface{}{}
@@ -490,91 +694,62 @@ func userResetPasswordRequestHandler(c *gin.Context) {
 	}
 }
 
-func userResetPasswordHandler(c *gin.Context) {
+func suspendUserHandler(c *gin.Context) {
 	bearer := token.InContext(c)
+	if !bearer.HasAnyPermission(common.UpdateUser, common.Sudo) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
 
-	buf, err := c.GetRawData()
-	if err != nil {
-		provide.RenderError(err.Error(), 400, c)
+	if bearer.IsImpersonation() {
+		provide.RenderError("impersonation tokens cannot be used to suspend users", 403, c)
 		return
 	}
 
-	params := map[string]interface{}{}
-	err = json.Unmarshal(buf, &params)
+	buf, err := c.GetRawData()
 	if err != nil {
 		provide.RenderError(err.Error(), 400, c)
 		return
 	}
 
-	rawToken := c.Param("token")
-	jwtToken, err := jwt.Parse(rawToken, func(_jwtToken *jwt.Token) (interface{}, error) {
-		// if keyfunc != nil {
-		// 	fn := *keyfunc
-		// 	return fn(_jwtToken)
-		// }
-		return nil, nil
-	})
-
-	if jwtToken == nil {
-		// provide.RenderError(fmt.Sprintf("invalid jwt token; %s", err.Error()), 422, c)
-		provide.RenderError("invalid jwt token", 422, c)
-		return
-	}
-
-	var userID *uuid.UUID
-	if claims, ok := jwtToken.Claims.(jwt.MapClaims); ok {
-		if sub, subok := claims["sub"].(string); subok {
-			subprts := strings.Split(sub, ":")
-			if len(subprts) != 2 {
-				provide.RenderError(fmt.Sprintf("JWT subject malformed; %s", sub), 422, c)
-				return
-			}
-			if subprts[0] != "user" {
-				provide.RenderError(fmt.Sprintf("JWT claims specified non-user subject: %s", subprts[0]), 422, c)
-				return
-			}
-			id, err := uuid.FromString(subprts[1])
-			if err != nil {
-				provide.RenderError(fmt.Sprintf("invalid user id; %s", err.Error()), 422, c)
-				return
-			}
-			userID = &id
+	params := map[string]interface{}{}
+	if len(buf) > 0 {
+		err = json.Unmarshal(buf, &params)
+		if err != nil {
+			provide.RenderError(err.Error(), 400, c)
+			return
 		}
 	}
 
-	if userID == nil || *userID == uuid.Nil {
-		provide.RenderError("invalid user id", 422, c)
-		return
-	}
-
-	password, passwordOk := params["password"].(string)
-	if !passwordOk {
-		provide.RenderError("password is required", 422, c)
-		return
-	}
-
-	db := dbconf.DatabaseConnection()
 	user := &User{}
-	query := db.Where("id = ?", userID.String())
-	if bearer != nil && bearer.ApplicationID != nil {
+	query := dbconf.DatabaseConnection().Where("id = ?", c.Param("id"))
+
+	if bearer.ApplicationID != nil {
 		query = query.Where("application_id = ?", bearer.ApplicationID.String())
 	}
-	query.Find(&user)
 
-	if user == nil || user.ID == uuid.Nil {
+	query.Find(&user)
+	if user.ID == uuid.Nil {
 		provide.RenderError("user not found", 404, c)
 		return
 	}
 
-	if user.ResetPasswordToken == nil || *user.ResetPasswordToken != rawToken {
-		provide.RenderError("invalid reset token", 422, c)
+	if bearer.UserID != nil && *bearer.UserID == user.ID {
+		provide.RenderError("users cannot suspend themselves", 422, c)
 		return
 	}
 
-	user.Password = common.StringOrNil(password)
-	user.rehashPassword()
+	if user.IsDisabled() {
+		provide.RenderError("user has already been suspended", 422, c)
+		return
+	}
 
-	if user.Update() {
+	var reason *string
+	if disabledReason, disabledReasonOk := params["reason"].(string); disabledReasonOk {
+		reason = common.StringOrNil(disabledReason)
+	}
+
+	if user.Suspend(nil, reason) {
 		provide.Render(nil, 204, c)
 	} else {
 		obj := map[string]interface{}{}
@@ -583,14 +758,10 @@ func userResetPasswordHandler(c *gin.Context) {
 	}
 }
 
-func vendInvitationTokenHandler(c *gin.Context) {
+func userExpirationHandler(c *gin.Context) {
 	bearer := token.InContext(c)
-	userID := bearer.UserID
-	appID := bearer.ApplicationID
-	orgID := bearer.OrganizationID
-
-	if (userID == nil || *userID == uuid.Nil) && (appID == nil || *appID == uuid.Nil) && (orgID == nil || *orgID == uuid.Nil) {
-		provide.RenderError("unauthorized", 401, c)
+	if !bearer.HasAnyPermission(common.UpdateUser, common.Sudo) {
+		provide.RenderError("forbidden", 403, c)
 		return
 	}
 
@@ -607,69 +778,1720 @@ func vendInvitationTokenHandler(c *gin.Context) {
 		return
 	}
 
-	invite := &Invite{}
-	err = json.Unmarshal(buf, &invite)
+	expiresAt, err := ParseExpiration(params)
 	if err != nil {
 		provide.RenderError(err.Error(), 422, c)
 		return
 	}
 
-	if invite.Email == nil {
-		provide.RenderError("email address required", 422, c)
+	user := &User{}
+	query := dbconf.DatabaseConnection().Where("id = ?", c.Param("id"))
+
+	if bearer.ApplicationID != nil {
+		query = query.Where("application_id = ?", bearer.ApplicationID.String())
+	}
+
+	query.Find(&user)
+	if user.ID == uuid.Nil {
+		provide.RenderError("user not found", 404, c)
 		return
 	}
 
-	var invitor Invitor
-	if userID != nil {
-		invitor = Find(*userID)
-		invite.InvitorID = userID
-		invite.InvitorName = invitor.FullName()
+	if bearer.UserID != nil && *bearer.UserID == user.ID {
+		provide.RenderError("users cannot modify their own expiration", 422, c)
+		return
 	}
 
-	// FIXME -- implement Invitor interface on Org and App?
-	// else if orgID != nil {
-	// 	// TODO: organization.Find(*orgID)
-	// } else if appID != nil {
-	// 	// TODO: application.Find(*appID)
-	// }
+	if user.IsErasureRequested() {
+		provide.RenderError("user has been erased or is pending erasure", 422, c)
+		return
+	}
 
-	if appID != nil {
-		invite.ApplicationID = appID
-		// TODO: load invitor permissions in the appropriate context; i.e., in the ApplicationID context
+	if user.SetExpiration(nil, expiresAt) {
+		provide.Render(user.AsResponse(), 200, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = user.Errors
+		provide.Render(obj, 422, c)
 	}
+}
 
-	if orgID != nil {
-		invite.OrganizationID = orgID
-		// TODO: load invitor permissions in the appropriate context; i.e., in the OrganizationID context
+func reactivateUserHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	if !bearer.HasAnyPermission(common.UpdateUser, common.Sudo) {
+		provide.RenderError("forbidden", 403, c)
+		return
 	}
 
-	if _, permissionsOk := params["permissions"]; permissionsOk {
-		if invite.ApplicationID != nil {
-			common.Log.Warningf("arbitrary permissions specified for user application invitation: %s", *invite.Email)
-		} else if invite.OrganizationID != nil {
-			common.Log.Warningf("arbitrary permissions specified for user organization invitation: %s", *invite.Email)
-		} else if !bearer.HasAnyPermission(common.UpdateUser, common.Sudo) {
-			provide.RenderError("unable to assert arbitrary user permissions", 403, c)
-			return
-		}
+	user := &User{}
+	query := dbconf.DatabaseConnection().Where("id = ?", c.Param("id"))
+
+	if bearer.ApplicationID != nil {
+		query = query.Where("application_id = ?", bearer.ApplicationID.String())
 	}
 
-	if Exists(*invite.Email, invite.ApplicationID, invite.OrganizationID) {
-		// FIXME-- check existing user against organization_id if one is provided alongside an application_id -- CHECKME... this may be done be the above...?
-		msg := fmt.Sprintf("user exists: %s", *invite.Email)
-		provide.RenderError(msg, 409, c)
+	query.Find(&user)
+	if user.ID == uuid.Nil {
+		provide.RenderError("user not found", 404, c)
 		return
 	}
 
-	if invite.Create() {
+	if user.Reactivate(nil) {
 		provide.Render(nil, 204, c)
 	} else {
 		obj := map[string]interface{}{}
-		obj["errors"] = invite.Errors
+		obj["errors"] = user.Errors
 		provide.Render(obj, 422, c)
 	}
 }
 
-func oauthCallbackHandler(c *gin.Context) {
-	provide.RenderError("not implemented", 501, c)
+func exportUserHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	isSelf := bearer.UserID != nil && bearer.UserID.String() == c.Param("id")
+	if !isSelf && !bearer.HasAnyPermission(common.ListUsers, common.Sudo) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	user := &User{}
+	query := db.Where("id = ?", c.Param("id"))
+
+	if bearer.ApplicationID != nil {
+		query = query.Where("application_id = ?", bearer.ApplicationID.String())
+	}
+
+	query.Find(&user)
+	if user.ID == uuid.Nil {
+		provide.RenderError("user not found", 404, c)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"ident-user-%s.json\"", user.ID))
+	provide.Render(user.Export(db), 200, c)
+}
+
+func eraseUserHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	isSelf := bearer.UserID != nil && bearer.UserID.String() == c.Param("id")
+	if !isSelf && !bearer.HasAnyPermission(common.DeleteUser, common.Sudo) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	if bearer.IsImpersonation() {
+		provide.RenderError("impersonation tokens cannot be used to erase users", 403, c)
+		return
+	}
+
+	user := &User{}
+	query := dbconf.DatabaseConnection().Where("id = ?", c.Param("id"))
+
+	if bearer.ApplicationID != nil {
+		query = query.Where("application_id = ?", bearer.ApplicationID.String())
+	}
+
+	query.Find(&user)
+	if user.ID == uuid.Nil {
+		provide.RenderError("user not found", 404, c)
+		return
+	}
+
+	if user.RequestErasure() {
+		provide.Render(nil, 202, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = user.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+func impersonateUserHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	if bearer == nil || bearer.UserID == nil || bearer.IsImpersonation() || !bearer.HasPermission(common.Sudo) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	params := map[string]interface{}{}
+	if len(buf) > 0 {
+		err = json.Unmarshal(buf, &params)
+		if err != nil {
+			provide.RenderError(err.Error(), 400, c)
+			return
+		}
+	}
+
+	var reason *string
+	if impersonationReason, impersonationReasonOk := params["reason"].(string); impersonationReasonOk {
+		reason = common.StringOrNil(impersonationReason)
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	user := &User{}
+	db.Where("id = ?", c.Param("id")).Find(&user)
+	if user.ID == uuid.Nil {
+		provide.RenderError("user not found", 404, c)
+		return
+	}
+
+	tkn, err := user.Impersonate(db, *bearer.UserID, reason)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	provide.Render(tkn.AsResponse(), 201, c)
+}
+
+func userAuditLogHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	if bearer == nil || (!bearer.HasAnyPermission(common.ListUsers, common.Sudo) && (bearer.UserID == nil || bearer.UserID.String() != c.Param("id"))) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	user := &User{}
+	query := db.Where("id = ?", c.Param("id"))
+	if bearer.ApplicationID != nil {
+		query = query.Where("application_id = ?", bearer.ApplicationID)
+	}
+
+	query.Find(&user)
+	if user.ID == uuid.Nil {
+		provide.RenderError("user not found", 404, c)
+		return
+	}
+
+	var entries []*token.AuditLogEntry
+	query = db.Where("user_id = ?", user.ID).Order("created_at DESC")
+	provide.Paginate(c, query, &token.AuditLogEntry{}).Find(&entries)
+	provide.Render(entries, 200, c)
+}
+
+// acceptRequiredConsents records the consent of the user in the given consent-required authentication
+// response to the legal documents accepted in the given params; returns false if none were accepted
+func acceptRequiredConsents(c *gin.Context, db *gorm.DB, resp *AuthenticationResponse, params map[string]interface{}) (bool, error) {
+	rawDocumentIDs, rawDocumentIDsOk := params["accepted_document_ids"]
+	if !rawDocumentIDsOk {
+		return false, nil
+	}
+
+	documentIDs, err := parseDocumentIDs(rawDocumentIDs)
+	if err != nil {
+		return false, err
+	}
+
+	user := Find(resp.User.ID)
+	if user == nil {
+		return false, fmt.Errorf("user not found: %s", resp.User.ID)
+	}
+
+	_, err = user.AcceptLegalDocuments(db, documentIDs, common.StringOrNil(c.ClientIP()), common.StringOrNil(c.Request.UserAgent()))
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func userConsentsListHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	if bearer == nil || (!bearer.HasAnyPermission(common.ListUsers, common.Sudo) && (bearer.UserID == nil || bearer.UserID.String() != c.Param("id"))) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	user := &User{}
+	query := db.Where("id = ?", c.Param("id"))
+	if bearer.ApplicationID != nil {
+		query = query.Where("application_id = ?", bearer.ApplicationID)
+	}
+
+	query.Find(&user)
+	if user.ID == uuid.Nil {
+		provide.RenderError("user not found", 404, c)
+		return
+	}
+
+	var consents []*legal.Consent
+	query = db.Where("user_id = ?", user.ID).Order("created_at DESC")
+	provide.Paginate(c, query, &legal.Consent{}).Find(&consents)
+	provide.Render(consents, 200, c)
+}
+
+func createUserConsentsHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	if bearer == nil || bearer.UserID == nil || bearer.UserID.String() != c.Param("id") {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	if bearer.IsImpersonation() {
+		provide.RenderError("impersonation tokens cannot be used to consent to legal documents", 403, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	params := map[string]interface{}{}
+	err = json.Unmarshal(buf, &params)
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	documentIDs, err := parseDocumentIDs(params["document_ids"])
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+	user := &User{}
+	db.Where("id = ?", bearer.UserID).Find(&user)
+	if user.ID == uuid.Nil {
+		provide.RenderError("user not found", 404, c)
+		return
+	}
+
+	consents, err := user.AcceptLegalDocuments(db, documentIDs, common.StringOrNil(c.ClientIP()), common.StringOrNil(c.Request.UserAgent()))
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	provide.Render(consents, 201, c)
+}
+
+func userSessionsListHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	if bearer == nil || (!bearer.HasAnyPermission(common.ListUsers, common.Sudo) && (bearer.UserID == nil || bearer.UserID.String() != c.Param("id"))) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	user := &User{}
+	query := db.Where("id = ?", c.Param("id"))
+	if bearer.ApplicationID != nil {
+		query = query.Where("application_id = ?", bearer.ApplicationID)
+	}
+
+	query.Find(&user)
+	if user.ID == uuid.Nil {
+		provide.RenderError("user not found", 404, c)
+		return
+	}
+
+	var sessions []*token.Session
+	query = token.ActiveSessionsQuery(db, user.ID).Order("created_at DESC")
+	provide.Paginate(c, query, &token.Session{}).Find(&sessions)
+	provide.Render(sessions, 200, c)
+}
+
+func deleteUserSessionHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	if bearer == nil || (!bearer.HasAnyPermission(common.UpdateUser, common.Sudo) && (bearer.UserID == nil || bearer.UserID.String() != c.Param("id"))) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	if bearer.IsImpersonation() {
+		provide.RenderError("impersonation tokens cannot be used to revoke sessions", 403, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	user := &User{}
+	query := db.Where("id = ?", c.Param("id"))
+	if bearer.ApplicationID != nil {
+		query = query.Where("application_id = ?", bearer.ApplicationID)
+	}
+
+	query.Find(&user)
+	if user.ID == uuid.Nil {
+		provide.RenderError("user not found", 404, c)
+		return
+	}
+
+	session := &token.Session{}
+	db.Where("id = ? AND user_id = ? AND revoked_at IS NULL", c.Param("sessionId"), user.ID).Find(&session)
+	if session.ID == uuid.Nil {
+		provide.RenderError("session not found", 404, c)
+		return
+	}
+
+	if session.Revoke(nil) {
+		provide.Render(nil, 204, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = session.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+func passwordHashReportHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	if bearer == nil || !bearer.HasPermission(common.Sudo) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	report, err := PasswordHashReport(dbconf.DatabaseConnection())
+	if err != nil {
+		provide.RenderError(fmt.Sprintf("failed to generate password hash report; %s", err.Error()), 500, c)
+		return
+	}
+
+	provide.Render(report, 200, c)
+}
+
+func userResetPasswordRequestHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	params := map[string]interface{}{}
+	err = json.Unmarshal(buf, &params)
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	email, emailOk := params["email"].(string)
+	if !emailOk {
+		provide.RenderError("email address is required", 422, c)
+		return
+	}
+
+	var appID *uuid.UUID
+	appIDStr, appIDStrOk := params["application_id"].(string)
+
+	if bearer != nil && bearer.ApplicationID != nil {
+		appID = bearer.ApplicationID
+		if appIDStrOk && appIDStr != appID.String() {
+			provide.RenderError(fmt.Sprintf("mismatched/ambiguous application_id; (%s provided in bearer token; %s as application_id param)", appID.String(), appIDStr), 400, c)
+			return
+		}
+	} else if appIDStrOk {
+		appUUID, err := uuid.FromString(appIDStr)
+		if err != nil {
+			provide.RenderError(fmt.Sprintf("invalid application_id; %s", err.Error()), 422, c)
+			return
+		}
+		appID = &appUUID
+	}
+
+	db := dbconf.DatabaseConnection()
+	user := FindByEmail(email, appID, nil)
+
+	if user == nil || user.ID == uuid.Nil {
+		provide.RenderError("user not found", 404, c)
+		return
+	}
+
+	if user.requestPasswordReset(db) {
+		provide.Render(nil, 204, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = user.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+func userResetPasswordHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	params := map[string]interface{}{}
+	err = json.Unmarshal(buf, &params)
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	rawToken := c.Param("token")
+	resetToken, err := token.Parse(rawToken)
+	if err != nil {
+		provide.RenderError(fmt.Sprintf("invalid reset token; %s", err.Error()), 422, c)
+		return
+	}
+
+	if resetToken.Audience == nil || *resetToken.Audience != token.PasswordResetAudience {
+		provide.RenderError("invalid reset token", 422, c)
+		return
+	}
+
+	if resetToken.IsRevoked() {
+		provide.RenderError("reset token has been revoked", 422, c)
+		return
+	}
+
+	userID := resetToken.UserID
+	if userID == nil || *userID == uuid.Nil {
+		provide.RenderError("invalid user id", 422, c)
+		return
+	}
+
+	password, passwordOk := params["password"].(string)
+	if !passwordOk {
+		provide.RenderError("password is required", 422, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+	user := &User{}
+	query := db.Where("id = ?", userID.String())
+	if bearer != nil && bearer.ApplicationID != nil {
+		query = query.Where("application_id = ?", bearer.ApplicationID.String())
+	}
+	query.Find(&user)
+
+	if user == nil || user.ID == uuid.Nil {
+		provide.RenderError("user not found", 404, c)
+		return
+	}
+
+	if user.ResetPasswordToken == nil || *user.ResetPasswordToken != rawToken {
+		provide.RenderError("invalid reset token", 422, c)
+		return
+	}
+
+	user.Password = common.StringOrNil(password)
+	if !user.rehashPassword() {
+		obj := map[string]interface{}{}
+		obj["errors"] = user.Errors
+		provide.Render(obj, 422, c)
+		return
+	}
+
+	if user.Update() {
+		if !resetToken.Revoke(nil) {
+			common.Log.Warningf("failed to revoke reset password token for user: %s", user.ID)
+		}
+		provide.Render(nil, 204, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = user.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+func userVerifyEmailHandler(c *gin.Context) {
+	rawToken := c.Param("token")
+	verificationToken, err := token.Parse(rawToken)
+	if err != nil {
+		provide.RenderError(fmt.Sprintf("invalid verification token; %s", err.Error()), 422, c)
+		return
+	}
+
+	if verificationToken.Audience == nil || *verificationToken.Audience != token.EmailVerificationAudience {
+		provide.RenderError("invalid verification token", 422, c)
+		return
+	}
+
+	if verificationToken.IsRevoked() {
+		provide.RenderError("verification token has been revoked", 422, c)
+		return
+	}
+
+	email, emailOk := verificationToken.ParseData()["email"].(string)
+	if !emailOk || verificationToken.UserID == nil {
+		provide.RenderError("invalid verification token", 422, c)
+		return
+	}
+
+	user := Find(*verificationToken.UserID)
+	if user == nil || user.ID == uuid.Nil {
+		provide.RenderError("user not found", 404, c)
+		return
+	}
+
+	if user.verifyEmail(email) {
+		if !verificationToken.Revoke(nil) {
+			common.Log.Warningf("failed to revoke email verification token for user: %s", user.ID)
+		}
+		provide.Render(nil, 204, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = user.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+func userEmailVerificationRequestHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	if bearer == nil || (!bearer.HasAnyPermission(common.UpdateUser, common.Sudo) && (bearer.UserID == nil || bearer.UserID.String() != c.Param("id"))) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	user := &User{}
+	query := dbconf.DatabaseConnection().Where("id = ?", c.Param("id"))
+	if bearer.ApplicationID != nil {
+		query = query.Where("application_id = ?", bearer.ApplicationID)
+	}
+
+	query.Find(&user)
+	if user.ID == uuid.Nil {
+		provide.RenderError("user not found", 404, c)
+		return
+	}
+
+	if user.IsEmailVerified() && user.PendingEmail == nil {
+		provide.RenderError("email address has already been verified", 409, c)
+		return
+	}
+
+	if user.requestEmailVerification() {
+		provide.Render(nil, 204, c)
+	} else {
+		provide.RenderError("failed to dispatch email verification", 500, c)
+	}
+}
+
+// linkUserIdentityHandler confirms the link of an upstream identity to the authorized user; the
+// link is staged when an identity provider asserts the email address of the existing user
+func linkUserIdentityHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	if bearer == nil || bearer.IsImpersonation() || bearer.UserID == nil || bearer.UserID.String() != c.Param("id") {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	params := map[string]interface{}{}
+	err = json.Unmarshal(buf, &params)
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	linkToken, linkTokenOk := params["link_token"].(string)
+	if !linkTokenOk || linkToken == "" {
+		provide.RenderError("link_token required", 422, c)
+		return
+	}
+
+	user := Find(*bearer.UserID)
+	if user == nil {
+		provide.RenderError("user not found", 404, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+	tx := db.Begin()
+	defer tx.RollbackUnlessCommitted()
+
+	err = user.LinkIdentity(tx, linkToken)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	tx.Commit()
+	provide.Render(nil, 204, c)
+}
+
+func vendInvitationTokenHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	userID := bearer.UserID
+	appID := bearer.ApplicationID
+	orgID := bearer.OrganizationID
+
+	if (userID == nil || *userID == uuid.Nil) && (appID == nil || *appID == uuid.Nil) && (orgID == nil || *orgID == uuid.Nil) {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	params := map[string]interface{}{}
+	err = json.Unmarshal(buf, &params)
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	invite := &Invite{}
+	err = json.Unmarshal(buf, &invite)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	if invite.Email == nil {
+		provide.RenderError("email address required", 422, c)
+		return
+	}
+
+	var invitor Invitor
+	if userID != nil {
+		invitor = Find(*userID)
+		invite.InvitorID = userID
+		invite.InvitorName = invitor.FullName()
+	}
+
+	// FIXME -- implement Invitor interface on Org and App?
+	// else if orgID != nil {
+	// 	// TODO: organization.Find(*orgID)
+	// } else if appID != nil {
+	// 	// TODO: application.Find(*appID)
+	// }
+
+	if appID != nil {
+		invite.ApplicationID = appID
+		// TODO: load invitor permissions in the appropriate context; i.e., in the ApplicationID context
+	}
+
+	if orgID != nil {
+		invite.OrganizationID = orgID
+		// TODO: load invitor permissions in the appropriate context; i.e., in the OrganizationID context
+	}
+
+	invite.RoleID = nil
+	if roleParam, roleParamOk := params["role"].(string); roleParamOk {
+		if _, permissionsOk := params["permissions"]; permissionsOk {
+			provide.RenderError("only one of role or permissions may be given", 422, c)
+			return
+		}
+
+		var inviteRole *role.Role
+		if invite.OrganizationID != nil {
+			inviteRole = role.Resolve(dbconf.DatabaseConnection(), roleParam, nil, invite.OrganizationID)
+		} else if invite.ApplicationID != nil {
+			inviteRole = role.Resolve(dbconf.DatabaseConnection(), roleParam, invite.ApplicationID, nil)
+		} else {
+			provide.RenderError("role requires an application or organization invitation", 422, c)
+			return
+		}
+
+		if inviteRole == nil {
+			provide.RenderError(fmt.Sprintf("role not found: %s", roleParam), 422, c)
+			return
+		}
+
+		invite.RoleID = &inviteRole.ID
+		invite.Permissions = &inviteRole.Permissions
+	}
+
+	if _, permissionsOk := params["permissions"]; permissionsOk {
+		if invite.ApplicationID != nil {
+			common.Log.Warningf("arbitrary permissions specified for user application invitation: %s", *invite.Email)
+		} else if invite.OrganizationID != nil {
+			common.Log.Warningf("arbitrary permissions specified for user organization invitation: %s", *invite.Email)
+		} else if !bearer.HasAnyPermission(common.UpdateUser, common.Sudo) {
+			provide.RenderError("unable to assert arbitrary user permissions", 403, c)
+			return
+		}
+	}
+
+	if Exists(*invite.Email, invite.ApplicationID, invite.OrganizationID) {
+		// FIXME-- check existing user against organization_id if one is provided alongside an application_id -- CHECKME... this may be done be the above...?
+		msg := fmt.Sprintf("user exists: %s", *invite.Email)
+		provide.RenderError(msg, 409, c)
+		return
+	}
+
+	if invite.Create() {
+		c.Header("Location", fmt.Sprintf("/api/v1/invitations/%s", invite.Invitation.ID))
+		provide.Render(nil, 204, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = invite.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+// invitationsQuery scopes the invitations query to those sent by the bearer; invitations
+// vended by a user are sent by that user, otherwise by the bearer organization or application
+func invitationsQuery(db *gorm.DB, bearer *token.Token) *gorm.DB {
+	if bearer.UserID != nil && *bearer.UserID != uuid.Nil {
+		return db.Where("invitations.invitor_id = ?", bearer.UserID)
+	} else if bearer.OrganizationID != nil && *bearer.OrganizationID != uuid.Nil {
+		return db.Where("invitations.organization_id = ?", bearer.OrganizationID)
+	}
+	return db.Where("invitations.application_id = ?", bearer.ApplicationID)
+}
+
+// invitationInContext resolves the invitation sent by the bearer with the id given in the
+// request path; the appropriate error is rendered and nil returned if it cannot be resolved
+func invitationInContext(c *gin.Context, db *gorm.DB) *Invitation {
+	bearer := token.InContext(c)
+	if bearer == nil || ((bearer.UserID == nil || *bearer.UserID == uuid.Nil) && (bearer.ApplicationID == nil || *bearer.ApplicationID == uuid.Nil) && (bearer.OrganizationID == nil || *bearer.OrganizationID == uuid.Nil)) {
+		provide.RenderError("unauthorized", 401, c)
+		return nil
+	}
+
+	invitationID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError("invitation not found", 404, c)
+		return nil
+	}
+
+	query := db
+	if !bearer.HasAnyPermission(common.Sudo) {
+		query = invitationsQuery(db, bearer)
+	}
+
+	invitation := &Invitation{}
+	query.Where("invitations.id = ?", invitationID).Find(&invitation)
+	if invitation == nil || invitation.ID == uuid.Nil {
+		provide.RenderError("invitation not found", 404, c)
+		return nil
+	}
+
+	return invitation
+}
+
+func invitationsListHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	if bearer == nil || ((bearer.UserID == nil || *bearer.UserID == uuid.Nil) && (bearer.ApplicationID == nil || *bearer.ApplicationID == uuid.Nil) && (bearer.OrganizationID == nil || *bearer.OrganizationID == uuid.Nil)) {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	if c.Query("status") != "" && !IsValidInvitationStatus(c.Query("status")) {
+		provide.RenderError(fmt.Sprintf("invalid invitation status: %s", c.Query("status")), 422, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	var invitations []*Invitation
+	query := FilterInvitations(invitationsQuery(db, bearer), c.Query("status"), strings.ToLower(c.Query("email")))
+	provide.Paginate(c, query, &Invitation{}).Find(&invitations)
+	provide.Render(invitations, 200, c)
+}
+
+func invitationDetailsHandler(c *gin.Context) {
+	invitation := invitationInContext(c, dbconf.DatabaseConnection())
+	if invitation == nil {
+		return
+	}
+
+	provide.Render(invitation, 200, c)
+}
+
+func revokeInvitationHandler(c *gin.Context) {
+	invitation := invitationInContext(c, dbconf.DatabaseConnection())
+	if invitation == nil {
+		return
+	}
+
+	if !invitation.Revoke(nil) {
+		obj := map[string]interface{}{}
+		obj["errors"] = invitation.Errors
+		provide.Render(obj, 422, c)
+		return
+	}
+
+	provide.Render(nil, 204, c)
+}
+
+func resendInvitationHandler(c *gin.Context) {
+	invitation := invitationInContext(c, dbconf.DatabaseConnection())
+	if invitation == nil {
+		return
+	}
+
+	if !invitation.Resend(nil) {
+		obj := map[string]interface{}{}
+		obj["errors"] = invitation.Errors
+		provide.Render(obj, 422, c)
+		return
+	}
+
+	provide.Render(invitation, 200, c)
+}
+
+func oauthAuthorizeHandler(c *gin.Context) {
+	providerID, err := uuid.FromString(c.Query("provider_id"))
+	if err != nil {
+		provide.RenderError("valid provider_id required", 422, c)
+		return
+	}
+
+	redirectURI := c.Query("redirect_uri")
+	if redirectURI == "" {
+		provide.RenderError("redirect_uri required", 422, c)
+		return
+	}
+
+	provider := FindOIDCProvider(dbconf.DatabaseConnection(), providerID)
+	if provider == nil {
+		provide.RenderError("identity provider not found", 404, c)
+		return
+	}
+
+	authorizationURL, err := provider.AuthorizationURL(redirectURI)
+	if err != nil {
+		common.Log.Warningf("failed to resolve authorization url for identity provider: %s; %s", provider.ID, err.Error())
+		provide.RenderError("failed to resolve authorization url for identity provider", 502, c)
+		return
+	}
+
+	c.Redirect(302, *authorizationURL)
+}
+
+func oauthCallbackHandler(c *gin.Context) {
+	params := map[string]interface{}{}
+	if c.Request.Method == "POST" {
+		buf, err := c.GetRawData()
+		if err != nil {
+			provide.RenderError(err.Error(), 400, c)
+			return
+		}
+
+		err = json.Unmarshal(buf, &params)
+		if err != nil {
+			provide.RenderError(err.Error(), 400, c)
+			return
+		}
+	} else {
+		for key := range c.Request.URL.Query() {
+			params[key] = c.Query(key)
+		}
+		if documentIDs := c.QueryArray("accepted_document_ids"); len(documentIDs) > 0 {
+			params["accepted_document_ids"] = stringsToInterfaces(documentIDs)
+		}
+	}
+
+	if upstreamErr, upstreamErrOk := params["error"].(string); upstreamErrOk {
+		provide.RenderError(fmt.Sprintf("identity provider returned error: %s", upstreamErr), 401, c)
+		return
+	}
+
+	code, codeOk := params["code"].(string)
+	state, stateOk := params["state"].(string)
+	if !codeOk || !stateOk {
+		provide.RenderError("code and state required", 422, c)
+		return
+	}
+
+	var scope *string
+	if reqScope, reqScopeOk := params["scope"].(string); reqScopeOk {
+		scope = &reqScope
+	}
+
+	session := &token.Session{
+		UserAgent: common.StringOrNil(c.Request.UserAgent()),
+		IPAddress: common.StringOrNil(c.ClientIP()),
+	}
+
+	db := dbconf.DatabaseConnection()
+	resp, err := AuthenticateOIDCUser(db, code, state, scope, session)
+	renderFederatedAuthentication(c, db, resp, err, params, scope, session)
+}
+
+func oidcProvidersListHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	if bearer == nil || (bearer.ApplicationID == nil && bearer.OrganizationID == nil && !bearer.HasPermission(common.Sudo)) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	var providers []*OIDCProvider
+	query := ListOIDCProviders(bearer.ApplicationID, bearer.OrganizationID)
+	provide.Paginate(c, query, &OIDCProvider{}).Find(&providers)
+	provide.Render(providers, 200, c)
+}
+
+func createOIDCProviderHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	if bearer == nil || (bearer.ApplicationID == nil && bearer.OrganizationID == nil && !bearer.HasPermission(common.Sudo)) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	if !authorizesIdentityProviderAdministration(dbconf.DatabaseConnection(), bearer) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	params := map[string]interface{}{}
+	err = json.Unmarshal(buf, &params)
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	provider := &OIDCProvider{}
+	err = json.Unmarshal(buf, &provider)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	if clientSecret, clientSecretOk := params["client_secret"].(string); clientSecretOk && clientSecret != "" {
+		provider.ClientSecret = common.StringOrNil(clientSecret)
+	}
+
+	if _, enabledOk := params["enabled"].(bool); !enabledOk {
+		provider.Enabled = true
+	}
+
+	if !bearer.HasPermission(common.Sudo) {
+		// identity providers can only be configured for the application or organization of the bearer
+		provider.ApplicationID = bearer.ApplicationID
+		provider.OrganizationID = bearer.OrganizationID
+		if provider.ApplicationID != nil {
+			provider.OrganizationID = nil
+		}
+	}
+
+	if provider.Create(dbconf.DatabaseConnection()) {
+		provide.Render(provider, 201, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = provider.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+func deleteOIDCProviderHandler(c *gin.Context) {
+	bearer := token.InContext(c)
+	if bearer == nil || (bearer.ApplicationID == nil && bearer.OrganizationID == nil && !bearer.HasPermission(common.Sudo)) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	if !authorizesIdentityProviderAdministration(dbconf.DatabaseConnection(), bearer) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	provider := &OIDCProvider{}
+	query := db.Where("id = ?", c.Param("id"))
+	if bearer.ApplicationID != nil {
+		query = query.Where("application_id = ?", bearer.ApplicationID)
+	} else if bearer.OrganizationID != nil {
+		query = query.Where("organization_id = ?", bearer.OrganizationID)
+	}
+
+	query.Find(&provider)
+	if provider.ID == uuid.Nil {
+		provide.RenderError("identity provider not found", 404, c)
+		return
+	}
+
+	if provider.Delete(db) {
+		provide.Render(nil, 204, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = provider.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+// authorizesIdentityProviderAdministration returns true if the bearer may configure the identity
+// providers of its application or organization; a user must administer the application or
+// organization by way of its membership
+func authorizesIdentityProviderAdministration(db *gorm.DB, bearer *token.Token) bool {
+	if bearer.HasPermission(common.Sudo) {
+		return true
+	}
+
+	if bearer.UserID == nil || *bearer.UserID == uuid.Nil {
+		return false
+	}
+
+	var permissions common.Permission
+	var isMember bool
+	if bearer.ApplicationID != nil {
+		permissions, _, isMember = role.EffectiveApplicationPermissions(db, *bearer.ApplicationID, *bearer.UserID)
+	} else if bearer.OrganizationID != nil {
+		permissions, _, isMember = role.EffectiveOrganizationPermissions(db, *bearer.OrganizationID, *bearer.UserID)
+	}
+
+	return isMember && permissions&role.AdminPermission == role.AdminPermission
+}
+
+// samlProviderInContext resolves the enabled SAML provider of the organization referenced by the id param
+func samlProviderInContext(c *gin.Context) *SAMLProvider {
+	organizationID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		return nil
+	}
+	return FindSAMLProvider(dbconf.DatabaseConnection(), organizationID)
+}
+
+func samlMetadataHandler(c *gin.Context) {
+	provider := samlProviderInContext(c)
+	if provider == nil {
+		provide.RenderError("saml is not configured for organization", 404, c)
+		return
+	}
+
+	metadata, err := provider.Metadata()
+	if err != nil {
+		provide.RenderError(err.Error(), 500, c)
+		return
+	}
+
+	c.Data(200, "application/samlmetadata+xml", metadata)
+}
+
+func samlLoginHandler(c *gin.Context) {
+	provider := samlProviderInContext(c)
+	if provider == nil {
+		provide.RenderError("saml is not configured for organization", 404, c)
+		return
+	}
+
+	authnRequestURL, err := provider.AuthnRequestURL()
+	if err != nil {
+		common.Log.Warningf("failed to resolve saml authn request url for organization: %s; %s", provider.OrganizationID, err.Error())
+		provide.RenderError("failed to resolve saml authn request url", 500, c)
+		return
+	}
+
+	c.Redirect(302, *authnRequestURL)
+}
+
+func samlAssertionConsumerServiceHandler(c *gin.Context) {
+	organizationID, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		provide.RenderError("saml is not configured for organization", 404, c)
+		return
+	}
+
+	samlResponse := c.PostForm("SAMLResponse")
+	if samlResponse == "" {
+		provide.RenderError("SAMLResponse required", 422, c)
+		return
+	}
+
+	scope := common.StringOrNil(c.PostForm("scope"))
+	session := &token.Session{
+		UserAgent: common.StringOrNil(c.Request.UserAgent()),
+		IPAddress: common.StringOrNil(c.ClientIP()),
+	}
+
+	params := map[string]interface{}{}
+	if documentIDs := c.PostFormArray("accepted_document_ids"); len(documentIDs) > 0 {
+		params["accepted_document_ids"] = stringsToInterfaces(documentIDs)
+	}
+
+	db := dbconf.DatabaseConnection()
+	resp, err := AuthenticateSAMLUser(db, organizationID, samlResponse, scope, session)
+	renderFederatedAuthentication(c, db, resp, err, params, scope, session)
+}
+
+// renderFederatedAuthentication renders the result of authentication by an upstream identity
+// provider; the assertion of the identity provider cannot be replayed, so when consent is required,
+// the consent of the user to the legal documents accepted in the given params is recorded and a
+// token is vended for the user in the same request
+func renderFederatedAuthentication(c *gin.Context, db *gorm.DB, resp *AuthenticationResponse, err error, params map[string]interface{}, scope *string, session *token.Session) {
+	if err == errConsentRequired {
+		accepted, acceptErr := acceptRequiredConsents(c, db, resp, params)
+		if acceptErr != nil {
+			provide.RenderError(acceptErr.Error(), 422, c)
+			return
+		}
+		if accepted {
+			user := Find(resp.User.ID)
+			if user == nil {
+				provide.RenderError("user not found", 404, c)
+				return
+			}
+			resp, err = user.vendFederatedToken(db, resp.applicationID, resp.organizationID, scope, session)
+		}
+	}
+	if err == errConsentRequired {
+		provide.Render(resp, 403, c)
+		return
+	} else if linkErr, linkErrOk := err.(*identityLinkRequiredError); linkErrOk {
+		provide.Render(map[string]interface{}{
+			"errors": []*api.Error{
+				{Message: common.StringOrNil(linkErr.Error())},
+			},
+			"link_token": linkErr.LinkToken,
+		}, 409, c)
+		return
+	} else if err != nil {
+		provide.RenderError(err.Error(), 401, c)
+		return
+	}
+
+	provide.Render(resp, 201, c)
+}
+
+// stringsToInterfaces returns the given strings as a slice of interfaces, as unmarshaled from JSON
+func stringsToInterfaces(values []string) []interface{} {
+	ifaces := make([]interface{}, len(values))
+	for i, val := range values {
+		ifaces[i] = val
+	}
+	return ifaces
+}
+
+// magicLinkApplicationID resolves the application on behalf of which a magic link is
+// requested or redeemed, from either the bearer authorization or the given params
+func magicLinkApplicationID(c *gin.Context, params map[string]interface{}) (*uuid.UUID, error) {
+	if appID := util.AuthorizedSubjectID(c, "application"); appID != nil && *appID != uuid.Nil {
+		return appID, nil
+	}
+
+	if applicationID, applicationIDOk := params["application_id"].(string); applicationIDOk {
+		appUUID, err := uuid.FromString(applicationID)
+		if err != nil {
+			return nil, fmt.Errorf("malformed application_id provided; %s", err.Error())
+		}
+		return &appUUID, nil
+	}
+
+	return nil, nil
+}
+
+func magicLinkRequestHandler(c *gin.Context) {
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	params := map[string]interface{}{}
+	err = json.Unmarshal(buf, &params)
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	email, emailOk := params["email"].(string)
+	if !emailOk {
+		provide.RenderError("email address required to request magic link", 422, c)
+		return
+	}
+
+	appID, err := magicLinkApplicationID(c, params)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	var deviceSecret *string
+	if bindDevice, bindDeviceOk := params["bind_device"].(bool); bindDeviceOk && bindDevice {
+		secret, err := randomSecret(magicLinkDeviceSecretLength)
+		if err != nil {
+			provide.RenderError(fmt.Sprintf("failed to generate device secret; %s", err.Error()), 500, c)
+			return
+		}
+		deviceSecret = &secret
+	}
+
+	err = requestMagicLink(email, appID, deviceSecret)
+	if err != nil {
+		if err == errMagicLinkRateLimited {
+			provide.RenderError(err.Error(), 429, c)
+		} else {
+			provide.RenderError("failed to request magic link", 500, c)
+		}
+		return
+	}
+
+	resp := map[string]interface{}{}
+	if deviceSecret != nil {
+		// the device secret is set as an http-only cookie for browsers and also returned for other clients
+		c.SetCookie(magicLinkDeviceCookie, *deviceSecret, int(common.MagicLinkTokenTTL.Seconds()), "/api/v1/authenticate/magic_link", "", c.Request.TLS != nil, true)
+		resp["device_secret"] = deviceSecret
+	}
+
+	provide.Render(resp, 202, c)
+}
+
+func magicLinkRedemptionHandler(c *gin.Context) {
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	params := map[string]interface{}{}
+	if len(buf) > 0 {
+		err = json.Unmarshal(buf, &params)
+		if err != nil {
+			provide.RenderError(err.Error(), 400, c)
+			return
+		}
+	}
+
+	appID, err := magicLinkApplicationID(c, params)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	var scope *string
+	if reqScope, reqScopeOk := params["scope"].(string); reqScopeOk {
+		scope = &reqScope
+	}
+
+	var deviceSecret *string
+	if secret, secretOk := params["device_secret"].(string); secretOk {
+		deviceSecret = &secret
+	} else if secret, err := c.Cookie(magicLinkDeviceCookie); err == nil && secret != "" {
+		deviceSecret = &secret
+	}
+
+	session := &token.Session{
+		UserAgent: common.StringOrNil(c.Request.UserAgent()),
+		IPAddress: common.StringOrNil(c.ClientIP()),
+	}
+
+	db := dbconf.DatabaseConnection()
+	resp, err := RedeemMagicLink(db, c.Param("token"), appID, deviceSecret, scope, session)
+	if err == errConsentRequired {
+		accepted, acceptErr := acceptRequiredConsents(c, db, resp, params)
+		if acceptErr != nil {
+			provide.RenderError(acceptErr.Error(), 422, c)
+			return
+		}
+		if accepted {
+			resp, err = RedeemMagicLink(db, c.Param("token"), appID, deviceSecret, scope, session)
+		}
+	}
+	if err == errConsentRequired {
+		provide.Render(resp, 403, c)
+		return
+	} else if err != nil {
+		provide.RenderError(err.Error(), 401, c)
+		return
+	}
+
+	provide.Render(resp, 201, c)
+}
+
+func renderSCIM(obj interface{}, status int, c *gin.Context) {
+	buf, err := json.Marshal(obj)
+	if err != nil {
+		common.Log.Warningf("failed to marshal scim response; %s", err.Error())
+		status = 500
+		buf, _ = json.Marshal(newSCIMError(status, "", "failed to marshal response"))
+	}
+
+	// clients which explicitly accept only application/json are served it instead
+	contentType := scimContentType
+	if accept := c.GetHeader("Accept"); strings.Contains(accept, "application/json") && !strings.Contains(accept, scimContentType) {
+		contentType = "application/json"
+	}
+	c.Data(status, contentType, buf)
+}
+
+func renderSCIMError(err error, c *gin.Context) {
+	if scimErr, scimErrOk := err.(*SCIMError); scimErrOk {
+		renderSCIM(scimErr, scimErr.status, c)
+		return
+	}
+	renderSCIM(newSCIMError(500, "", err.Error()), 500, c)
+}
+
+// scimOrganizationInContext resolves the organization asserted by the organization bearer token
+// in the given context, provided the token has been granted the given permission
+func scimOrganizationInContext(c *gin.Context, permission common.Permission) *scimOrganization {
+	bearer := token.InContext(c)
+	if bearer == nil || bearer.OrganizationID == nil || *bearer.OrganizationID == uuid.Nil {
+		renderSCIM(newSCIMError(403, "", "scim provisioning requires an organization bearer token"), 403, c)
+		return nil
+	}
+	if !bearer.HasAnyPermission(permission, common.Sudo) {
+		renderSCIM(newSCIMError(403, "", "forbidden"), 403, c)
+		return nil
+	}
+
+	org := findSCIMOrganization(dbconf.DatabaseConnection(), *bearer.OrganizationID)
+	if org == nil {
+		renderSCIM(newSCIMError(403, "", "organization is not enabled"), 403, c)
+		return nil
+	}
+
+	// an organization token vended on behalf of a user only administers the organization if the user owns it
+	if bearer.UserID != nil && !bearer.HasPermission(common.Sudo) && (org.UserID == nil || *org.UserID != *bearer.UserID) {
+		renderSCIM(newSCIMError(403, "", "forbidden"), 403, c)
+		return nil
+	}
+
+	return org
+}
+
+// scimPagination parses the 1-based startIndex and count query params
+func scimPagination(c *gin.Context) (int, int, error) {
+	startIndex := 1
+	if c.Query("startIndex") != "" {
+		idx, err := strconv.Atoi(c.Query("startIndex"))
+		if err != nil {
+			return 0, 0, newSCIMError(400, scimErrorInvalidValue, "startIndex must be an integer")
+		}
+		if idx > 1 {
+			startIndex = idx
+		}
+	}
+
+	count := scimDefaultCount
+	if c.Query("count") != "" {
+		cnt, err := strconv.Atoi(c.Query("count"))
+		if err != nil {
+			return 0, 0, newSCIMError(400, scimErrorInvalidValue, "count must be an integer")
+		}
+		count = cnt
+		if count < 0 {
+			count = 0
+		} else if count > scimMaxCount {
+			count = scimMaxCount
+		}
+	}
+
+	return startIndex, count, nil
+}
+
+// scimIncludeMembers returns false if the members of a group were excluded from the requested attributes
+func scimIncludeMembers(c *gin.Context) bool {
+	for _, attr := range strings.Split(c.Query("excludedAttributes"), ",") {
+		if strings.EqualFold(strings.TrimSpace(attr), "members") {
+			return false
+		}
+	}
+	if attributes := c.Query("attributes"); attributes != "" {
+		for _, attr := range strings.Split(attributes, ",") {
+			if strings.EqualFold(strings.TrimSpace(attr), "members") {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// scimRequestBody unmarshals the request body into the given SCIM message
+func scimRequestBody(c *gin.Context, msg interface{}) error {
+	buf, err := c.GetRawData()
+	if err != nil {
+		return newSCIMError(400, scimErrorInvalidSyntax, err.Error())
+	}
+	err = json.Unmarshal(buf, msg)
+	if err != nil {
+		return newSCIMError(400, scimErrorInvalidSyntax, err.Error())
+	}
+	return nil
+}
+
+func scimServiceProviderConfigHandler(c *gin.Context) {
+	if scimOrganizationInContext(c, common.ReadResources) == nil {
+		return
+	}
+
+	renderSCIM(scimServiceProviderConfig(), 200, c)
+}
+
+func scimUsersListHandler(c *gin.Context) {
+	org := scimOrganizationInContext(c, common.ReadResources)
+	if org == nil {
+		return
+	}
+
+	startIndex, count, err := scimPagination(c)
+	if err != nil {
+		renderSCIMError(err, c)
+		return
+	}
+
+	resp, err := org.listUsers(dbconf.DatabaseConnection(), c.Query("filter"), startIndex, count)
+	if err != nil {
+		renderSCIMError(err, c)
+		return
+	}
+
+	renderSCIM(resp, 200, c)
+}
+
+func scimCreateUserHandler(c *gin.Context) {
+	org := scimOrganizationInContext(c, common.CreateResource)
+	if org == nil {
+		return
+	}
+
+	resource := &SCIMUser{}
+	err := scimRequestBody(c, resource)
+	if err != nil {
+		renderSCIMError(err, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+	user, err := org.createUser(db, resource)
+	if err != nil {
+		renderSCIMError(err, c)
+		return
+	}
+
+	renderSCIM(org.resource(db, user), 201, c)
+}
+
+func scimUserDetailsHandler(c *gin.Context) {
+	org := scimOrganizationInContext(c, common.ReadResources)
+	if org == nil {
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+	user, err := org.findUser(db, c.Param("id"))
+	if err != nil {
+		renderSCIMError(err, c)
+		return
+	}
+
+	renderSCIM(org.resource(db, user), 200, c)
+}
+
+func scimReplaceUserHandler(c *gin.Context) {
+	org := scimOrganizationInContext(c, common.UpdateResource)
+	if org == nil {
+		return
+	}
+
+	resource := &SCIMUser{}
+	err := scimRequestBody(c, resource)
+	if err != nil {
+		renderSCIMError(err, c)
+		return
+	}
+
+	changes, err := changesFromResource(resource)
+	if err != nil {
+		renderSCIMError(err, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+	user, err := org.findUser(db, c.Param("id"))
+	if err != nil {
+		renderSCIMError(err, c)
+		return
+	}
+
+	resp, err := org.updateUser(db, user, changes)
+	if err != nil {
+		renderSCIMError(err, c)
+		return
+	}
+
+	renderSCIM(resp, 200, c)
+}
+
+func scimPatchUserHandler(c *gin.Context) {
+	org := scimOrganizationInContext(c, common.UpdateResource)
+	if org == nil {
+		return
+	}
+
+	req := &SCIMPatchRequest{}
+	err := scimRequestBody(c, req)
+	if err != nil {
+		renderSCIMError(err, c)
+		return
+	}
+
+	changes, err := changesFromPatch(req.Operations)
+	if err != nil {
+		renderSCIMError(err, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+	user, err := org.findUser(db, c.Param("id"))
+	if err != nil {
+		renderSCIMError(err, c)
+		return
+	}
+
+	resp, err := org.updateUser(db, user, changes)
+	if err != nil {
+		renderSCIMError(err, c)
+		return
+	}
+
+	renderSCIM(resp, 200, c)
+}
+
+// scimDeleteUserHandler deprovisions the user; users are disabled rather than deleted
+func scimDeleteUserHandler(c *gin.Context) {
+	org := scimOrganizationInContext(c, common.DeleteResource)
+	if org == nil {
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+	user, err := org.findUser(db, c.Param("id"))
+	if err != nil {
+		renderSCIMError(err, c)
+		return
+	}
+
+	active := false
+	_, err = org.updateUser(db, user, &scimUserChanges{active: &active})
+	if err != nil {
+		renderSCIMError(err, c)
+		return
+	}
+
+	c.Status(204)
+}
+
+func scimGroupsListHandler(c *gin.Context) {
+	org := scimOrganizationInContext(c, common.ReadResources)
+	if org == nil {
+		return
+	}
+
+	startIndex, count, err := scimPagination(c)
+	if err != nil {
+		renderSCIMError(err, c)
+		return
+	}
+
+	resp, err := org.listGroups(dbconf.DatabaseConnection(), c.Query("filter"), startIndex, count, scimIncludeMembers(c))
+	if err != nil {
+		renderSCIMError(err, c)
+		return
+	}
+
+	renderSCIM(resp, 200, c)
+}
+
+func scimGroupDetailsHandler(c *gin.Context) {
+	org := scimOrganizationInContext(c, common.ReadResources)
+	if org == nil {
+		return
+	}
+
+	if c.Param("id") != org.ID.String() {
+		renderSCIM(newSCIMError(404, "", fmt.Sprintf("group not found: %s", c.Param("id"))), 404, c)
+		return
+	}
+
+	renderSCIM(org.group(dbconf.DatabaseConnection(), scimIncludeMembers(c)), 200, c)
+}
+
+func scimReplaceGroupHandler(c *gin.Context) {
+	org := scimOrganizationInContext(c, common.UpdateResource)
+	if org == nil {
+		return
+	}
+
+	if c.Param("id") != org.ID.String() {
+		renderSCIM(newSCIMError(404, "", fmt.Sprintf("group not found: %s", c.Param("id"))), 404, c)
+		return
+	}
+
+	resource := &SCIMGroup{}
+	err := scimRequestBody(c, resource)
+	if err != nil {
+		renderSCIMError(err, c)
+		return
+	}
+
+	if resource.DisplayName != "" && resource.DisplayName != org.Name {
+		renderSCIM(newSCIMError(400, scimErrorMutability, "the displayName of an organization cannot be modified"), 400, c)
+		return
+	}
+
+	memberIDs := make([]string, 0)
+	for _, member := range resource.Members {
+		memberIDs = append(memberIDs, member.Value)
+	}
+
+	db := dbconf.DatabaseConnection()
+	tx := db.Begin()
+	defer tx.RollbackUnlessCommitted()
+
+	err = org.replaceMembers(tx, memberIDs)
+	if err != nil {
+		renderSCIMError(err, c)
+		return
+	}
+	tx.Commit()
+
+	renderSCIM(org.group(db, true), 200, c)
+}
+
+func scimPatchGroupHandler(c *gin.Context) {
+	org := scimOrganizationInContext(c, common.UpdateResource)
+	if org == nil {
+		return
+	}
+
+	if c.Param("id") != org.ID.String() {
+		renderSCIM(newSCIMError(404, "", fmt.Sprintf("group not found: %s", c.Param("id"))), 404, c)
+		return
+	}
+
+	req := &SCIMPatchRequest{}
+	err := scimRequestBody(c, req)
+	if err != nil {
+		renderSCIMError(err, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+	err = org.patchGroup(db, req.Operations)
+	if err != nil {
+		renderSCIMError(err, c)
+		return
+	}
+
+	renderSCIM(org.group(db, scimIncludeMembers(c)), 200, c)
+}
+
+// scimUnsupportedGroupOperationHandler refuses to create or delete groups, which correspond to organizations
+func scimUnsupportedGroupOperationHandler(c *gin.Context) {
+	if scimOrganizationInContext(c, common.UpdateResource) == nil {
+		return
+	}
+
+	renderSCIM(newSCIMError(403, "", "groups correspond to organizations and cannot be created or deleted using scim"), 403, c)
 }
@@ -0,0 +1,96 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	redisutil "github.com/kthomas/go-redisutil"
+	uuid "github.com/kthomas/go.uuid"
+)
+
+const identityLinkKeyPrefix = "ident.federated.link"
+const identityLinkTTL = time.Minute * 15
+
+// identityLinkRequiredError is returned when an upstream identity provider asserts the email
+// address of an existing user which has not been linked to the asserted identity; existing
+// accounts are never linked on the say-so of an identity provider, so the user must confirm
+// the link using the returned token while authenticated with its existing credentials
+type identityLinkRequiredError struct {
+	LinkToken string
+}
+
+func (e *identityLinkRequiredError) Error() string {
+	return "federated authentication failed; a user with the asserted email address exists and must link the identity provider from its account"
+}
+
+// pendingIdentityLink is persisted until the user confirms or the link token expires
+type pendingIdentityLink struct {
+	UserID         uuid.UUID  `json:"user_id"`
+	OIDCProviderID *uuid.UUID `json:"oidc_provider_id,omitempty"`
+	SAMLProviderID *uuid.UUID `json:"saml_provider_id,omitempty"`
+	Subject        string     `json:"subject"`
+}
+
+// stageIdentityLink persists the given pending link and returns the error which is surfaced
+// to the user agent, along with the token used to confirm the link
+func stageIdentityLink(link *pendingIdentityLink) error {
+	linkToken, err := randomSecret(oidcSecretLength)
+	if err != nil {
+		return err
+	}
+
+	raw, _ := json.Marshal(link)
+	ttl := identityLinkTTL
+	err = redisutil.Set(fmt.Sprintf("%s.%s", identityLinkKeyPrefix, linkToken), string(raw), &ttl)
+	if err != nil {
+		return fmt.Errorf("failed to persist pending identity link; %s", err.Error())
+	}
+
+	return &identityLinkRequiredError{LinkToken: linkToken}
+}
+
+// consumeIdentityLink returns and removes the pending link persisted for the given token, so
+// that each pending link can only be confirmed once
+func consumeIdentityLink(linkToken string) (*pendingIdentityLink, error) {
+	raw, err := redisGetDel(fmt.Sprintf("%s.%s", identityLinkKeyPrefix, linkToken))
+	if err != nil || raw == nil {
+		return nil, errors.New("invalid or expired link token")
+	}
+
+	link := &pendingIdentityLink{}
+	err = json.Unmarshal([]byte(*raw), &link)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal pending identity link; %s", err.Error())
+	}
+	return link, nil
+}
+
+// LinkIdentity links the upstream identity staged using the given link token to the user; the
+// link can only be confirmed by the user to which the asserted email address belongs
+func (u *User) LinkIdentity(tx *gorm.DB, linkToken string) error {
+	link, err := consumeIdentityLink(linkToken)
+	if err != nil {
+		return err
+	}
+
+	if link.UserID != u.ID {
+		return errors.New("link token was not issued for the user")
+	}
+
+	var result *gorm.DB
+	if link.OIDCProviderID != nil {
+		result = tx.Exec("INSERT INTO user_identities (created_at, user_id, provider_id, subject) VALUES (?, ?, ?, ?)", time.Now(), u.ID, link.OIDCProviderID, link.Subject)
+	} else if link.SAMLProviderID != nil {
+		result = tx.Exec("INSERT INTO saml_identities (created_at, user_id, saml_provider_id, name_id) VALUES (?, ?, ?, ?)", time.Now(), u.ID, link.SAMLProviderID, link.Subject)
+	} else {
+		return errors.New("pending identity link has no identity provider")
+	}
+
+	if result.Error != nil {
+		return fmt.Errorf("failed to link user to identity provider; %s", result.Error.Error())
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/provideplatform/ident/token"
+)
+
+func TestRenderFederatedAuthenticationRequiresIdentityLink(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("POST", "/api/v1/saml/acs", nil)
+	renderFederatedAuthentication(c, nil, nil, &identityLinkRequiredError{LinkToken: "link-token"}, map[string]interface{}{}, nil, &token.Session{})
+
+	if recorder.Code != 409 {
+		t.Fatalf("expected federated authentication of an unlinked existing user to conflict; status: %d", recorder.Code)
+	}
+
+	body := map[string]interface{}{}
+	json.Unmarshal(recorder.Body.Bytes(), &body)
+	if body["token"] != nil {
+		t.Error("expected no token to be rendered prior to the identity being linked")
+	}
+	if body["link_token"] != "link-token" {
+		t.Errorf("expected link token to be rendered; got %v", body["link_token"])
+	}
+}
@@ -0,0 +1,61 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+	"github.com/provideplatform/ident/token"
+)
+
+// impersonationTokenTTL is the lifetime of tokens vended to sudoers impersonating a user;
+// impersonation tokens cannot be refreshed
+const impersonationTokenTTL = time.Minute * 15
+
+// Impersonate vends a short-lived token authorizing the given actor to act on behalf of the user;
+// the token asserts the actor in its act claim and every request it authorizes is audited
+func (u *User) Impersonate(db *gorm.DB, actorID uuid.UUID, reason *string) (*token.Token, error) {
+	if actorID == u.ID {
+		return nil, errors.New("users cannot impersonate themselves")
+	}
+
+	if u.hasPermission(common.Sudo) {
+		return nil, errors.New("sudoers cannot be impersonated")
+	}
+
+	if !u.hasPermission(common.Authenticate) || u.IsDisabled() {
+		return nil, fmt.Errorf("user is not permitted to authenticate: %s", u.ID)
+	}
+
+	ttl := int(impersonationTokenTTL.Seconds())
+	tkn := &token.Token{
+		UserID:      &u.ID,
+		ActorID:     &actorID,
+		Permissions: u.Permissions,
+		TTL:         &ttl,
+	}
+
+	if !tkn.Vend() {
+		err := fmt.Errorf("failed to vend impersonation token for user: %s", u.ID)
+		if len(tkn.Errors) > 0 {
+			err = fmt.Errorf("%s; %s", err.Error(), *tkn.Errors[0].Message)
+		}
+		return nil, err
+	}
+
+	entry := &token.AuditLogEntry{
+		UserID:  &u.ID,
+		ActorID: &actorID,
+		Event:   common.StringOrNil(token.AuditEventImpersonationStarted),
+		Reason:  reason,
+	}
+	if !entry.Create(db) {
+		return nil, fmt.Errorf("failed to audit impersonation of user: %s", u.ID)
+	}
+
+	common.Log.Debugf("vended impersonation token for user %s to actor: %s", u.ID, actorID)
+	return tkn, nil
+}
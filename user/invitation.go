@@ -0,0 +1,319 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	natsutil "github.com/kthomas/go-natsutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+// InvitationStatusPending is the status of an invitation which has yet to be accepted
+const InvitationStatusPending = "pending"
+
+// InvitationStatusAccepted is the status of an invitation which has been accepted
+const InvitationStatusAccepted = "accepted"
+
+// InvitationStatusRevoked is the status of an invitation which has been revoked by the invitor
+const InvitationStatusRevoked = "revoked"
+
+// InvitationStatusExpired is the status of an invitation which expired prior to being accepted
+const InvitationStatusExpired = "expired"
+
+// InvitationStatuses are the valid invitation statuses
+var InvitationStatuses = []string{
+	InvitationStatusPending,
+	InvitationStatusAccepted,
+	InvitationStatusRevoked,
+	InvitationStatusExpired,
+}
+
+// Invitation is the persisted record of an invitation to join an application or organization;
+// the signed invitation token itself is never persisted, only its hash
+type Invitation struct {
+	provide.Model
+	ApplicationID    *uuid.UUID         `sql:"type:uuid" json:"application_id,omitempty"`
+	OrganizationID   *uuid.UUID         `sql:"type:uuid" json:"organization_id,omitempty"`
+	OrganizationName *string            `json:"organization_name,omitempty"`
+	UserID           *uuid.UUID         `sql:"type:uuid" json:"user_id,omitempty"`
+	InvitorID        *uuid.UUID         `sql:"type:uuid" json:"invitor_id,omitempty"`
+	InvitorName      *string            `json:"invitor_name,omitempty"`
+	FirstName        *string            `json:"first_name,omitempty"`
+	LastName         *string            `json:"last_name,omitempty"`
+	Email            *string            `sql:"not null" json:"email"`
+	Permissions      *common.Permission `json:"permissions,omitempty"`
+	RoleID           *uuid.UUID         `sql:"type:uuid" json:"role_id,omitempty"`
+	Params           *json.RawMessage   `sql:"type:json" json:"-"`
+	Status           *string            `sql:"not null" json:"status"`
+	TokenHash        *string            `json:"-"`
+	ExpiresAt        *time.Time         `json:"expires_at,omitempty"`
+	AcceptedAt       *time.Time         `json:"accepted_at,omitempty"`
+	RevokedAt        *time.Time         `json:"revoked_at,omitempty"`
+}
+
+// TableName returns the db table name for gorm
+func (i *Invitation) TableName() string {
+	return "invitations"
+}
+
+// IsValidInvitationStatus returns true if the given status is a valid invitation status
+func IsValidInvitationStatus(status string) bool {
+	for _, invitationStatus := range InvitationStatuses {
+		if status == invitationStatus {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterInvitations applies the invitation status and email filters given in the query string
+// to the given invitations query; only pending invitations are returned when no status is given
+func FilterInvitations(query *gorm.DB, status, email string) *gorm.DB {
+	if status == "" {
+		status = InvitationStatusPending
+	}
+	query = query.Where("invitations.status = ?", status)
+
+	if email != "" {
+		query = query.Where("invitations.email = ?", email)
+	}
+
+	return query.Order("invitations.created_at DESC")
+}
+
+// Create and persist the invitation
+func (i *Invitation) Create(tx *gorm.DB) bool {
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+	}
+
+	if i.Status == nil {
+		i.Status = common.StringOrNil(InvitationStatusPending)
+	}
+
+	if db.NewRecord(i) {
+		result := db.Create(&i)
+		rowsAffected := result.RowsAffected
+		errors := result.GetErrors()
+		if len(errors) > 0 {
+			for _, err := range errors {
+				i.Errors = append(i.Errors, &provide.Error{
+					Message: common.StringOrNil(err.Error()),
+				})
+			}
+		}
+		return rowsAffected > 0 && len(i.Errors) == 0
+	}
+
+	return false
+}
+
+// FindInvitation returns the invitation with the given id, or nil if no such invitation exists
+func FindInvitation(db *gorm.DB, invitationID uuid.UUID) *Invitation {
+	invitation := &Invitation{}
+	db.Where("invitations.id = ?", invitationID).Find(&invitation)
+	if invitation == nil || invitation.ID == uuid.Nil {
+		return nil
+	}
+	return invitation
+}
+
+// IsPending returns true if the invitation has yet to be accepted, revoked or expire
+func (i *Invitation) IsPending() bool {
+	return i.Status != nil && *i.Status == InvitationStatusPending
+}
+
+// invite returns the Invite on behalf of which the invitation was created
+func (i *Invitation) invite() *Invite {
+	return &Invite{
+		ApplicationID:    i.ApplicationID,
+		UserID:           i.UserID,
+		FirstName:        i.FirstName,
+		LastName:         i.LastName,
+		Email:            i.Email,
+		InvitorID:        i.InvitorID,
+		InvitorName:      i.InvitorName,
+		OrganizationID:   i.OrganizationID,
+		OrganizationName: i.OrganizationName,
+		Permissions:      i.Permissions,
+		RoleID:           i.RoleID,
+		Params:           i.Params,
+	}
+}
+
+// revokeToken persists a revocation of the invitation token such that ParseInvite
+// rejects it when strict; invitations imported from the legacy invitations cache
+// have no token hash, in which case this is a no-op and ParseInvite rejects the
+// token by the status of the invitation
+func (i *Invitation) revokeToken(db *gorm.DB, revokedAt time.Time) error {
+	if i.TokenHash == nil {
+		return nil
+	}
+
+	result := db.Exec(
+		"INSERT INTO token_revocations (hash, expires_at, revoked_at) VALUES (?, ?, ?) ON CONFLICT (hash) DO NOTHING",
+		i.TokenHash,
+		i.ExpiresAt,
+		revokedAt,
+	)
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke invitation token; %s", result.Error.Error())
+	}
+
+	return nil
+}
+
+// Revoke the pending invitation and its token
+func (i *Invitation) Revoke(tx *gorm.DB) bool {
+	i.Errors = make([]*provide.Error, 0)
+
+	if !i.IsPending() {
+		i.Errors = append(i.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("invitation is %s", *i.Status)),
+		})
+		return false
+	}
+
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+		db = db.Begin()
+		defer db.RollbackUnlessCommitted()
+	}
+
+	revokedAt := time.Now()
+	result := db.Model(&Invitation{}).Where("id = ? AND status = ?", i.ID, InvitationStatusPending).Updates(map[string]interface{}{
+		"status":     InvitationStatusRevoked,
+		"revoked_at": revokedAt,
+	})
+	if result.Error != nil {
+		i.Errors = append(i.Errors, &provide.Error{
+			Message: common.StringOrNil(result.Error.Error()),
+		})
+		return false
+	}
+	if result.RowsAffected == 0 {
+		i.Errors = append(i.Errors, &provide.Error{
+			Message: common.StringOrNil("invitation is no longer pending"),
+		})
+		return false
+	}
+
+	err := i.revokeToken(db, revokedAt)
+	if err != nil {
+		i.Errors = append(i.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+		return false
+	}
+
+	if tx == nil {
+		db.Commit()
+	}
+
+	i.Status = common.StringOrNil(InvitationStatusRevoked)
+	i.RevokedAt = &revokedAt
+	common.Log.Debugf("revoked invitation: %s", i.ID)
+	return true
+}
+
+// Resend vends a new token on behalf of the pending or expired invitation and dispatches it;
+// the token previously vended on behalf of the invitation is revoked
+func (i *Invitation) Resend(tx *gorm.DB) bool {
+	i.Errors = make([]*provide.Error, 0)
+
+	if i.Status == nil || (*i.Status != InvitationStatusPending && *i.Status != InvitationStatusExpired) {
+		i.Errors = append(i.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("invitation is %s", *i.Status)),
+		})
+		return false
+	}
+
+	token, err := i.invite().vendToken()
+	if err == nil && token == nil {
+		err = errors.New("failed to vend invitation token")
+	}
+	if err != nil {
+		i.Errors = append(i.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+		return false
+	}
+	if token.Hash == nil {
+		token.CalculateHash()
+	}
+
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+		db = db.Begin()
+		defer db.RollbackUnlessCommitted()
+	}
+
+	err = i.revokeToken(db, time.Now())
+	if err != nil {
+		i.Errors = append(i.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+		return false
+	}
+
+	result := db.Model(&Invitation{}).Where("id = ? AND status = ?", i.ID, *i.Status).Updates(map[string]interface{}{
+		"status":     InvitationStatusPending,
+		"token_hash": token.Hash,
+		"expires_at": token.ExpiresAt,
+	})
+	if result.Error != nil {
+		i.Errors = append(i.Errors, &provide.Error{
+			Message: common.StringOrNil(result.Error.Error()),
+		})
+		return false
+	}
+	if result.RowsAffected == 0 {
+		i.Errors = append(i.Errors, &provide.Error{
+			Message: common.StringOrNil("invitation was modified concurrently"),
+		})
+		return false
+	}
+
+	if tx == nil {
+		db.Commit()
+	}
+
+	i.Status = common.StringOrNil(InvitationStatusPending)
+	i.TokenHash = token.Hash
+	i.ExpiresAt = token.ExpiresAt
+
+	payload, _ := json.Marshal(token)
+	natsutil.NatsJetstreamPublish(natsDispatchInvitationSubject, payload)
+
+	common.Log.Debugf("resent invitation: %s", i.ID)
+	return true
+}
+
+// expireInvitations marks pending invitations which have expired
+func expireInvitations(db *gorm.DB) {
+	result := db.Exec(
+		"UPDATE invitations SET status = ? WHERE status = ? AND expires_at <= now()",
+		InvitationStatusExpired,
+		InvitationStatusPending,
+	)
+	if result.Error != nil {
+		common.Log.Warningf("failed to expire invitations; %s", result.Error.Error())
+	} else if result.RowsAffected > 0 {
+		common.Log.Debugf("expired %d invitation(s)", result.RowsAffected)
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/badoux/checkmail"
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
 	natsutil "github.com/kthomas/go-natsutil"
-	"github.com/kthomas/go-redisutil"
 	uuid "github.com/kthomas/go.uuid"
 	"github.com/provideplatform/ident/common"
 	"github.com/provideplatform/ident/token"
@@ -35,17 +37,20 @@ type Invite struct {
 	OrganizationID   *uuid.UUID         `sql:"-" json:"organization_id,omitempty"`
 	OrganizationName *string            `sql:"-" json:"organization_name,omitempty"`
 	Permissions      *common.Permission `sql:"-" json:"permissions,omitempty"`
+	RoleID           *uuid.UUID         `sql:"-" json:"role_id,omitempty"`
 	Params           *json.RawMessage   `sql:"-" json:"params,omitempty"`
 
-	Errors []*provide.Error `sql:"-" json:"-"`
-	Token  *token.Token     `sql:"-" json:"-"`
+	Errors     []*provide.Error `sql:"-" json:"-"`
+	Invitation *Invitation      `sql:"-" json:"-"`
+	Token      *token.Token     `sql:"-" json:"-"`
 }
 
 // ParseInvite parses an invitation given the previously signed token; it doesn't actually
 // accept the invitation by creating a user or associating the user with an application or
 // organization at this time, rather it returns an Invite instance which has been verified
 // as capable of being accepted by the caller; the strict argument, when set to true, will
-// result in this method returning an error if the parsed invitation token has been revoked
+// result in this method returning an error if the parsed invitation token has been revoked;
+// an invitation which was revoked by its invitor is rejected regardless
 func ParseInvite(signedToken string, strict bool) (*Invite, error) {
 	token, err := token.Parse(signedToken)
 	if err != nil {
@@ -102,12 +107,22 @@ func ParseInvite(signedToken string, strict bool) (*Invite, error) {
 		organizationUUID = &orgUUID
 	}
 
+	var roleUUID *uuid.UUID
+	if roleID, roleIDOk := data["role_id"].(string); roleIDOk {
+		rUUID, err := uuid.FromString(roleID)
+		if err != nil {
+			common.Log.Warningf("failed to parse invitation token; invalid role_id; %s", err.Error())
+			return nil, err
+		}
+		roleUUID = &rUUID
+	}
+
 	var organizationName *string
 	if orgName, orgNameOk := data["organization_name"].(string); orgNameOk {
 		organizationName = &orgName
 	}
 
-	return &Invite{
+	invite := &Invite{
 		ApplicationID:    applicationUUID,
 		UserID:           token.UserID,
 		FirstName:        common.StringOrNil(firstName),
@@ -118,131 +133,93 @@ func ParseInvite(signedToken string, strict bool) (*Invite, error) {
 		OrganizationID:   organizationUUID,
 		OrganizationName: organizationName,
 		Permissions:      permissions,
+		RoleID:           roleUUID,
 		Token:            token,
-	}, nil
-}
-
-func (i *Invite) cache(key string) error {
-	common.Log.Debugf("attempting to cache invitation at key: %s", key)
-
-	rawinvites, err := redisutil.Get(key)
-	var invitations []*Invite
-
-	if rawinvites != nil {
-		err = json.Unmarshal([]byte(*rawinvites), &invitations)
-		if err != nil {
-			return fmt.Errorf("failed to unmarshal cached invitations from key: %s; %s", key, err.Error())
-		}
-	} else {
-		invitations = make([]*Invite, 0)
-	}
-
-	invitations = append(invitations, &Invite{
-		FirstName:   i.FirstName,
-		LastName:    i.LastName,
-		Email:       i.Email,
-		Permissions: i.Permissions,
-		Token: &token.Token{
-			ExpiresAt: i.Token.ExpiresAt,
-			Hash:      i.Token.Hash,
-		},
-	})
-
-	rawinvitesJSON, err := json.Marshal(&invitations)
-	if err != nil {
-		msg := fmt.Sprintf("failed to cache invitations at key: %s; %s", key, err.Error())
-		common.Log.Warning(msg)
-		return errors.New(msg)
 	}
 
-	var ttl *time.Duration
-	if i.Token != nil && i.Token.ExpiresAt != nil {
-		ttlval := time.Until(*i.Token.ExpiresAt)
-		ttl = &ttlval
+	invite.Invitation = invite.findInvitation(dbconf.DatabaseConnection())
+	if invite.Invitation == nil {
+		return nil, errors.New("invitation not found")
+	} else if *invite.Invitation.Status == InvitationStatusRevoked {
+		return nil, errors.New("invitation revoked")
 	}
 
-	err = redisutil.Set(key, string(rawinvitesJSON), ttl)
-	if err != nil {
-		common.Log.Warningf("failed to cache invitations at key: %s; %s", key, err.Error())
-	}
-	return err
+	return invite, nil
 }
 
-func (i *Invite) invalidateCache(key string) error {
-	common.Log.Debugf("attempting to purge cached invitation at key: %s", key)
-
-	rawinvites, err := redisutil.Get(key)
-	var cachedInvitations []*Invite
-
-	if rawinvites != nil {
-		err = json.Unmarshal([]byte(*rawinvites), &cachedInvitations)
-		if err != nil {
-			return fmt.Errorf("failed to unmarshal cached invitations from key: %s; %s", key, err.Error())
-		}
-	} else {
-		common.Log.Debugf("cached invitations already purged from key: %s", key)
+// findInvitation returns the persisted invitation on behalf of which the invite token was vended;
+// invitations imported from the legacy invitations cache have no token hash and are matched by
+// email address, in which case the most recent matching invitation is returned
+func (i *Invite) findInvitation(db *gorm.DB) *Invitation {
+	if i.Token == nil {
 		return nil
 	}
 
-	var expiresAt *time.Time
-
-	invitations := make([]*Invite, 0)
-	for _, cachedInvitation := range cachedInvitations {
-		if i.Token != nil && i.Token.Hash != nil && cachedInvitation.Token != nil && cachedInvitation.Token.Hash != nil && *cachedInvitation.Token.Hash != *i.Token.Hash {
-			invitations = append(invitations, cachedInvitation)
-		}
+	if i.Token.Hash == nil {
+		i.Token.CalculateHash()
+	}
 
-		if cachedInvitation.Token != nil && cachedInvitation.Token.ExpiresAt != nil {
-			if expiresAt == nil {
-				expiresAt = cachedInvitation.Token.ExpiresAt
-			} else if expiresAt.Before(*cachedInvitation.Token.ExpiresAt) {
-				expiresAt = cachedInvitation.Token.ExpiresAt
-			}
-		}
+	var email *string
+	if i.Email != nil {
+		email = common.StringOrNil(strings.ToLower(*i.Email))
 	}
 
-	rawinvitesJSON, err := json.Marshal(&invitations)
-	if err != nil {
-		msg := fmt.Sprintf("failed to cache invitations at key: %s; %s", key, err.Error())
-		common.Log.Warning(msg)
-		return errors.New(msg)
+	invitation := &Invitation{}
+	db.Where("token_hash = ?", i.Token.Hash).Find(&invitation)
+	if invitation.ID != uuid.Nil {
+		return invitation
 	}
 
-	var ttl *time.Duration
-	if expiresAt != nil {
-		ttlval := time.Until(*expiresAt)
-		ttl = &ttlval
+	query := db.Where("token_hash IS NULL AND email = ?", email)
+	if i.ApplicationID != nil {
+		query = query.Where("application_id = ?", i.ApplicationID)
+	}
+	if i.OrganizationID != nil {
+		query = query.Where("organization_id = ?", i.OrganizationID)
 	}
 
-	err = redisutil.Set(key, string(rawinvitesJSON), ttl)
-	if err != nil {
-		common.Log.Warningf("failed to cache invitations at key: %s; %s", key, err.Error())
+	invitation = &Invitation{}
+	query.Order("created_at DESC").Limit(1).Find(&invitation)
+	if invitation.ID == uuid.Nil {
+		return nil
 	}
-	return err
+	return invitation
 }
 
-// InvalidateCache invalidates cached pending tokens
-func (i *Invite) InvalidateCache() error {
-	var err error
+// Accept marks the persisted invitation as accepted by the user with the given id; an error is
+// returned if the invitation is no longer pending
+func (i *Invite) Accept(tx *gorm.DB, userID *uuid.UUID) error {
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+	}
 
-	if i.ApplicationID != nil {
-		key := fmt.Sprintf("application.%s.invitations", i.ApplicationID.String())
-		err = redisutil.WithRedlock(key, func() error { return i.invalidateCache(key) })
-		if err != nil {
-			common.Log.Warningf("failed to purge invitation from cache at key: %s; %s", key, err.Error())
-			return err
+	if i.Invitation == nil {
+		i.Invitation = i.findInvitation(db)
+		if i.Invitation == nil {
+			return errors.New("failed to mark invitation accepted; invitation not found")
 		}
 	}
 
-	if i.OrganizationID != nil {
-		key := fmt.Sprintf("organization.%s.invitations", i.OrganizationID.String())
-		err = redisutil.WithRedlock(key, func() error { return i.invalidateCache(key) })
-		if err != nil {
-			common.Log.Warningf("failed to purge invitation from cache at key: %s; %s", key, err.Error())
-			return err
-		}
+	acceptedAt := time.Now()
+	result := db.Model(&Invitation{}).Where("id = ? AND status = ?", i.Invitation.ID, InvitationStatusPending).Updates(map[string]interface{}{
+		"status":      InvitationStatusAccepted,
+		"accepted_at": acceptedAt,
+		"user_id":     userID,
+	})
+	if result.Error != nil {
+		return fmt.Errorf("failed to mark invitation accepted; %s", result.Error.Error())
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("failed to mark invitation accepted; invitation is no longer pending")
 	}
 
+	i.Invitation.Status = common.StringOrNil(InvitationStatusAccepted)
+	i.Invitation.AcceptedAt = &acceptedAt
+	i.Invitation.UserID = userID
+	common.Log.Debugf("marked invitation %s accepted by user: %s", i.Invitation.ID, userID)
 	return nil
 }
 
@@ -277,18 +254,41 @@ func (i *Invite) Create() bool {
 		return false
 	}
 	i.Token = token
-	success := i.Token != nil
-	if success {
-		if i.ApplicationID != nil {
-			key := fmt.Sprintf("application.%s.invitations", i.ApplicationID.String())
-			redisutil.WithRedlock(key, func() error { return i.cache(key) })
-		}
-		if i.OrganizationID != nil {
-			key := fmt.Sprintf("organization.%s.invitations", i.OrganizationID.String())
-			redisutil.WithRedlock(key, func() error { return i.cache(key) })
-		}
+	if i.Token == nil {
+		return false
+	}
+
+	if i.Token.Hash == nil {
+		i.Token.CalculateHash()
+	}
+
+	invitation := &Invitation{
+		ApplicationID:    i.ApplicationID,
+		OrganizationID:   i.OrganizationID,
+		OrganizationName: i.OrganizationName,
+		UserID:           i.UserID,
+		InvitorID:        i.InvitorID,
+		InvitorName:      i.InvitorName,
+		FirstName:        i.FirstName,
+		LastName:         i.LastName,
+		Email:            common.StringOrNil(strings.ToLower(*i.Email)),
+		Permissions:      i.Permissions,
+		RoleID:           i.RoleID,
+		Params:           i.Params,
+		TokenHash:        i.Token.Hash,
+		ExpiresAt:        i.Token.ExpiresAt,
+	}
+
+	if !invitation.Create(nil) {
+		i.Errors = append(i.Errors, invitation.Errors...)
+		return false
 	}
-	return success
+	i.Invitation = invitation
+
+	payload, _ := json.Marshal(i.Token)
+	natsutil.NatsJetstreamPublish(natsDispatchInvitationSubject, payload)
+
+	return true
 }
 
 func (i *Invite) authorizesNewApplicationOrganization() bool {
@@ -318,6 +318,7 @@ func (i *Invite) vendToken() (*token.Token, error) {
 		"invitor_name":      i.InvitorName,
 		"organization_id":   i.OrganizationID,
 		"organization_name": i.OrganizationName,
+		"role_id":           i.RoleID,
 		"params":            i.parseParams(),
 	})
 	data := json.RawMessage(dataJSON)
@@ -338,8 +339,5 @@ func (i *Invite) vendToken() (*token.Token, error) {
 		}
 	}
 
-	payload, _ := json.Marshal(token)
-	natsutil.NatsJetstreamPublish(natsDispatchInvitationSubject, payload)
-
 	return token, nil
 }
@@ -0,0 +1,337 @@
+package user
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+	"github.com/provideplatform/ident/role"
+	"github.com/provideplatform/ident/token"
+)
+
+var errLDAPInvalidCredentials = errors.New("ldap bind failed; invalid credentials")
+
+// errLDAPUnavailable is returned when the directory cannot be reached or searched, in which case
+// authentication fails without falling back to the locally-stored password
+var errLDAPUnavailable = errors.New("ldap directory unavailable")
+
+// errLDAPUserNotFound is returned when no directory entry matches the given email address,
+// in which case authentication falls back to the locally-stored password
+var errLDAPUserNotFound = errors.New("ldap user not found")
+
+// ldapRootCAs are the certificate authorities trusted to verify the directory; the system
+// roots are used when nil; overridden in tests
+var ldapRootCAs *x509.CertPool
+
+// ldapEntry is a directory entry returned by a search; attribute names are lowercased
+type ldapEntry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// ldapConn is a connection to an LDAP directory
+type ldapConn struct {
+	conn *ldap.Conn
+}
+
+// dialLDAP connects to the directory at the given ldap:// or ldaps:// url; ldap:// connections
+// are upgraded using StartTLS before any credentials are sent, and refused if the upgrade fails
+func dialLDAP(rawURL string, timeout time.Duration) (*ldapConn, error) {
+	ldapURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ldap url; %s", err.Error())
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: ldapURL.Hostname(),
+		RootCAs:    ldapRootCAs,
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var conn *ldap.Conn
+	switch ldapURL.Scheme {
+	case "ldap":
+		conn, err = ldap.DialURL(rawURL, ldap.DialWithDialer(dialer))
+		if err != nil {
+			return nil, err
+		}
+		conn.SetTimeout(timeout)
+
+		err = conn.StartTLS(tlsConfig)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to establish tls using starttls; %s", err.Error())
+		}
+	case "ldaps":
+		conn, err = ldap.DialURL(rawURL, ldap.DialWithDialer(dialer), ldap.DialWithTLSConfig(tlsConfig))
+		if err != nil {
+			return nil, err
+		}
+		conn.SetTimeout(timeout)
+	default:
+		return nil, fmt.Errorf("unsupported ldap url scheme: %s", ldapURL.Scheme)
+	}
+
+	return &ldapConn{
+		conn: conn,
+	}, nil
+}
+
+// bind authenticates the connection using a simple bind; an empty password is refused
+// because the directory would treat it as an unauthenticated bind
+func (c *ldapConn) bind(dn, password string) error {
+	if password == "" {
+		return errLDAPInvalidCredentials
+	}
+
+	err := c.conn.Bind(dn, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return errLDAPInvalidCredentials
+	} else if err != nil {
+		return fmt.Errorf("ldap bind failed; %s", err.Error())
+	}
+
+	return nil
+}
+
+// search returns the entries beneath the given base DN having the given attribute value
+func (c *ldapConn) search(baseDN, attribute, value string, attributes []string) ([]*ldapEntry, error) {
+	result, err := c.conn.Search(ldap.NewSearchRequest(
+		baseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2, // only a single entry is expected
+		int(common.LDAPTimeout.Seconds()),
+		false,
+		fmt.Sprintf("(%s=%s)", ldap.EscapeFilter(attribute), ldap.EscapeFilter(value)),
+		attributes,
+		nil,
+	))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return []*ldapEntry{}, nil
+	} else if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("ldap search failed; %s", err.Error())
+	}
+
+	entries := make([]*ldapEntry, 0)
+	if result == nil {
+		return entries, nil
+	}
+
+	for _, resultEntry := range result.Entries {
+		entry := &ldapEntry{
+			DN:         resultEntry.DN,
+			Attributes: map[string][]string{},
+		}
+		for _, attr := range resultEntry.Attributes {
+			name := strings.ToLower(attr.Name)
+			entry.Attributes[name] = append(entry.Attributes[name], attr.Values...)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// close unbinds and closes the connection
+func (c *ldapConn) close() {
+	if c.conn.Unbind() != nil {
+		c.conn.Close()
+	}
+}
+
+// attr returns the first value of the given attribute
+func (e *ldapEntry) attr(name string) *string {
+	if vals := e.Attributes[strings.ToLower(name)]; len(vals) > 0 {
+		return common.StringOrNil(strings.TrimSpace(vals[0]))
+	}
+	return nil
+}
+
+// groups returns the normalized DNs of the groups of which the entry is a member
+func (e *ldapEntry) groups() []string {
+	groups := make([]string, 0)
+	for _, group := range e.Attributes[strings.ToLower(common.LDAPGroupAttribute)] {
+		groups = append(groups, common.NormalizeDN(group))
+	}
+	return groups
+}
+
+// permissions returns the permissions granted to the entry by the configured group-to-permission mapping
+func (e *ldapEntry) permissions() common.Permission {
+	permissions := common.Permission(0)
+	for _, group := range e.groups() {
+		if groupPermissions, groupPermissionsOk := common.LDAPGroupPermissions[group]; groupPermissionsOk {
+			permissions = permissions | groupPermissions
+		}
+	}
+	return permissions
+}
+
+// organizations returns the ids of the organizations mapped to the groups of the entry
+func (e *ldapEntry) organizations() []uuid.UUID {
+	organizationIDs := make([]uuid.UUID, 0)
+	for _, group := range e.groups() {
+		if organizationID, organizationIDOk := common.LDAPGroupOrganizations[group]; organizationIDOk {
+			if orgID, err := uuid.FromString(organizationID); err == nil {
+				organizationIDs = append(organizationIDs, orgID)
+			}
+		}
+	}
+	return organizationIDs
+}
+
+// isLDAPAuthenticationEnabled returns true if users of the given application authenticate using
+// the configured directory; only platform users are authenticated using LDAP
+func isLDAPAuthenticationEnabled(applicationID *uuid.UUID) bool {
+	return common.LDAPURL != nil && (applicationID == nil || *applicationID == uuid.Nil)
+}
+
+// authenticateLDAP searches the configured directory for the entry having the given email
+// address and binds as the entry using the given password
+func authenticateLDAP(email, password string) (*ldapEntry, error) {
+	conn, err := dialLDAP(*common.LDAPURL, common.LDAPTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("%w; failed to connect to ldap directory; %s", errLDAPUnavailable, err.Error())
+	}
+	defer conn.close()
+
+	if common.LDAPBindDN != nil {
+		bindPassword := ""
+		if common.LDAPBindPassword != nil {
+			bindPassword = *common.LDAPBindPassword
+		}
+		err = conn.bind(*common.LDAPBindDN, bindPassword)
+		if err != nil {
+			return nil, fmt.Errorf("%w; failed to bind ldap service account; %s", errLDAPUnavailable, err.Error())
+		}
+	}
+
+	attributes := []string{"mail", "givenName", "sn", common.LDAPUserAttribute, common.LDAPGroupAttribute}
+	entries, err := conn.search(common.LDAPBaseDN, common.LDAPUserAttribute, email, attributes)
+	if err != nil {
+		return nil, fmt.Errorf("%w; %s", errLDAPUnavailable, err.Error())
+	}
+
+	if len(entries) == 0 {
+		return nil, errLDAPUserNotFound
+	} else if len(entries) > 1 {
+		return nil, fmt.Errorf("ldap search for %s matched multiple entries", email)
+	}
+
+	entry := entries[0]
+	err = conn.bind(entry.DN, password)
+	if err == errLDAPInvalidCredentials {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("%w; %s", errLDAPUnavailable, err.Error())
+	}
+
+	return entry, nil
+}
+
+// authenticateLDAPUser authenticates using the configured directory and returns the local user
+// for the directory entry; the user is provisioned on first login, and its name, organization
+// memberships and the permissions mapped to its directory groups are refreshed on each login
+func authenticateLDAPUser(db *gorm.DB, email, password string) (*User, error) {
+	email = strings.ToLower(email)
+	entry, err := authenticateLDAP(email, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if mail := entry.attr("mail"); mail != nil {
+		email = strings.ToLower(*mail)
+	}
+
+	firstName := entry.attr("givenName")
+	lastName := entry.attr("sn")
+	user := &User{}
+	db.Where("email = ? AND application_id IS NULL", email).Find(&user)
+	if user == nil || user.ID == uuid.Nil {
+		user, err = provisionFederatedUser(db, nil, email, firstName, lastName)
+		if err != nil {
+			return nil, err
+		}
+		common.Log.Debugf("provisioned user %s from ldap directory entry: %s", user.ID, entry.DN)
+	}
+
+	permissions, ldapPermissions := ldapUserPermissions(user.Permissions, user.LDAPPermissions, entry.permissions())
+
+	updates := map[string]interface{}{
+		"permissions":      permissions,
+		"ldap_permissions": ldapPermissions,
+	}
+	if firstName != nil {
+		updates["first_name"] = *firstName
+	}
+	if lastName != nil {
+		updates["last_name"] = *lastName
+	}
+
+	result := db.Model(&User{}).Where("id = ?", user.ID).Updates(updates)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to refresh ldap user: %s; %s", user.ID, result.Error.Error())
+	}
+	user.Permissions = permissions
+	user.LDAPPermissions = ldapPermissions
+	if firstName != nil {
+		user.FirstName = firstName
+	}
+	if lastName != nil {
+		user.LastName = lastName
+	}
+
+	user.syncLDAPOrganizations(db, entry.organizations())
+	return user, nil
+}
+
+// ldapUserPermissions returns the permissions of a user and the subset of them granted by its
+// directory groups; the permissions previously granted by directory groups are recomputed, so
+// they are revoked when the user leaves a group, while permissions granted locally are retained
+// and permissions revoked locally (e.g., authenticate) are never restored by the directory
+func ldapUserPermissions(permissions, ldapPermissions, groupPermissions common.Permission) (common.Permission, common.Permission) {
+	localPermissions := permissions &^ ldapPermissions
+	ldapPermissions = groupPermissions &^ localPermissions
+	return localPermissions | ldapPermissions, ldapPermissions
+}
+
+// syncLDAPOrganizations adds the user to the given organizations and removes it from the organizations
+// to which it was added by a previous sync but which are no longer mapped to its directory groups;
+// memberships granted other than by the directory are left untouched
+func (u *User) syncLDAPOrganizations(db *gorm.DB, organizationIDs []uuid.UUID) {
+	for _, organizationID := range organizationIDs {
+		var memberships int
+		db.Table("organizations_users").Where("organization_id = ? AND user_id = ?", organizationID, u.ID).Count(&memberships)
+		if memberships == 0 && u.addOrganizationAssociation(db, organizationID, role.MemberPermission, role.BuiltinID(db, role.Member)) {
+			db.Exec("UPDATE organizations_users SET ldap = true WHERE organization_id = ? AND user_id = ?", organizationID, u.ID)
+		}
+	}
+
+	query := db.Table("organizations_users").Where("user_id = ? AND ldap = true", u.ID)
+	if len(organizationIDs) > 0 {
+		query = query.Where("organization_id NOT IN (?)", organizationIDs)
+	}
+
+	var staleOrganizationIDs []uuid.UUID
+	query.Pluck("organization_id", &staleOrganizationIDs)
+	for _, orgID := range staleOrganizationIDs {
+		result := db.Exec("DELETE FROM organizations_users WHERE organization_id = ? AND user_id = ? AND ldap = true", orgID, u.ID)
+		if result.RowsAffected > 0 {
+			common.Log.Debugf("removed user %s from organization: %s; no longer a member of mapped ldap group", u.ID, orgID)
+			err := token.RevokeOrganizationUserTokens(db, orgID, u.ID)
+			if err != nil {
+				common.Log.Warningf("failed to revoke organization tokens of user %s removed from organization: %s; %s", u.ID, orgID, err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,456 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+)
+
+const mockLDAPBaseDN = "dc=example,dc=com"
+const mockLDAPBindDN = "cn=ident,ou=services,dc=example,dc=com"
+const mockLDAPBindPassword = "service-secret"
+
+const ldapStartTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// mockLDAPServer is an in-process directory supporting StartTLS, simple bind and equality searches
+type mockLDAPServer struct {
+	listener    net.Listener
+	entries     map[string]*mockLDAPEntry
+	certificate *x509.Certificate
+	tlsConfig   *tls.Config
+
+	mutex    sync.Mutex
+	binds    []string
+	startTLS bool     // false if the server refuses to upgrade connections using StartTLS
+	insecure []string // dns bound prior to establishing tls
+}
+
+type mockLDAPEntry struct {
+	password   string
+	attributes map[string][]string
+}
+
+func newMockLDAPServer(t *testing.T) *mockLDAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen for mock ldap connections; %s", err.Error())
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate mock ldap server key; %s", err.Error())
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to generate mock ldap server certificate; %s", err.Error())
+	}
+	certificate, _ := x509.ParseCertificate(der)
+
+	server := &mockLDAPServer{
+		listener:    listener,
+		certificate: certificate,
+		tlsConfig: &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		},
+		startTLS: true,
+		entries: map[string]*mockLDAPEntry{
+			mockLDAPBindDN: {
+				password:   mockLDAPBindPassword,
+				attributes: map[string][]string{},
+			},
+			"uid=alice,ou=people,dc=example,dc=com": {
+				password: "alice-secret",
+				attributes: map[string][]string{
+					"mail":      {"Alice@Example.com"},
+					"givenName": {"Alice"},
+					"sn":        {"Liddell"},
+					"memberOf":  {"CN=Admins, OU=Groups, DC=example, DC=com", "cn=engineering,ou=groups,dc=example,dc=com"},
+				},
+			},
+			"uid=bob,ou=people,dc=example,dc=com": {
+				password: "bob-secret",
+				attributes: map[string][]string{
+					"mail":      {"bob@example.com"},
+					"givenName": {"Bob"},
+					"sn":        {"Builder"},
+				},
+			},
+		},
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	return server
+}
+
+func (s *mockLDAPServer) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *mockLDAPServer) serve(conn net.Conn) {
+	defer func() {
+		conn.Close()
+	}()
+
+	encrypted := false
+	bound := false
+	for {
+		msg, err := ber.ReadPacket(conn)
+		if err != nil || len(msg.Children) < 2 {
+			return
+		}
+		messageID := msg.Children[0].Value.(int64)
+		op := msg.Children[1]
+
+		respond := func(op *ber.Packet) {
+			packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+			packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+			packet.AppendChild(op)
+			conn.Write(packet.Bytes())
+		}
+		result := func(tag ber.Tag, code int64) *ber.Packet {
+			op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+			op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"))
+			op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+			op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+			return op
+		}
+
+		switch op.Tag {
+		case ldap.ApplicationExtendedRequest:
+			if encrypted || !s.startTLS || len(op.Children) == 0 || op.Children[0].Data.String() != ldapStartTLSOID {
+				respond(result(ldap.ApplicationExtendedResponse, ldap.LDAPResultProtocolError))
+				continue
+			}
+			respond(result(ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess))
+
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn = tlsConn
+			encrypted = true
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Data.String()
+			password := op.Children[2].Data.String()
+
+			s.mutex.Lock()
+			s.binds = append(s.binds, dn)
+			if !encrypted {
+				s.insecure = append(s.insecure, dn)
+			}
+			s.mutex.Unlock()
+
+			entry, entryOk := s.entries[dn]
+			if !entryOk || entry.password != password {
+				bound = false
+				respond(result(ldap.ApplicationBindResponse, ldap.LDAPResultInvalidCredentials))
+				continue
+			}
+			bound = true
+			respond(result(ldap.ApplicationBindResponse, ldap.LDAPResultSuccess))
+		case ldap.ApplicationSearchRequest:
+			if !bound {
+				respond(result(ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights))
+				continue
+			}
+
+			filter := op.Children[6]
+			attribute := filter.Children[0].Data.String()
+			value := filter.Children[1].Data.String()
+
+			for dn, entry := range s.entries {
+				matched := false
+				for name, vals := range entry.attributes {
+					if strings.EqualFold(name, attribute) {
+						for _, val := range vals {
+							matched = matched || strings.EqualFold(val, value)
+						}
+					}
+				}
+				if !matched {
+					continue
+				}
+
+				attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+				for name, vals := range entry.attributes {
+					attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+					attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Name"))
+					values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+					for _, val := range vals {
+						values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, val, "Value"))
+					}
+					attr.AppendChild(values)
+					attributes.AppendChild(attr)
+				}
+
+				entryOp := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+				entryOp.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "DN"))
+				entryOp.AppendChild(attributes)
+				respond(entryOp)
+			}
+			respond(result(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+func (s *mockLDAPServer) boundAs(dn string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, bound := range s.binds {
+		if bound == dn {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *mockLDAPServer) boundInsecurely() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.insecure) > 0
+}
+
+// configureMockLDAP points the LDAP configuration at the given server for the duration of the test
+func configureMockLDAP(t *testing.T, server *mockLDAPServer, organizationID uuid.UUID) {
+	ldapURL := common.LDAPURL
+	baseDN := common.LDAPBaseDN
+	bindDN := common.LDAPBindDN
+	bindPassword := common.LDAPBindPassword
+	groupAttribute := common.LDAPGroupAttribute
+	groupOrganizations := common.LDAPGroupOrganizations
+	groupPermissions := common.LDAPGroupPermissions
+	timeout := common.LDAPTimeout
+	userAttribute := common.LDAPUserAttribute
+	rootCAs := ldapRootCAs
+
+	t.Cleanup(func() {
+		common.LDAPURL = ldapURL
+		common.LDAPBaseDN = baseDN
+		common.LDAPBindDN = bindDN
+		common.LDAPBindPassword = bindPassword
+		common.LDAPGroupAttribute = groupAttribute
+		common.LDAPGroupOrganizations = groupOrganizations
+		common.LDAPGroupPermissions = groupPermissions
+		common.LDAPTimeout = timeout
+		common.LDAPUserAttribute = userAttribute
+		ldapRootCAs = rootCAs
+	})
+
+	ldapRootCAs = x509.NewCertPool()
+	ldapRootCAs.AddCert(server.certificate)
+
+	common.LDAPURL = common.StringOrNil(server.url())
+	common.LDAPBaseDN = mockLDAPBaseDN
+	common.LDAPBindDN = common.StringOrNil(mockLDAPBindDN)
+	common.LDAPBindPassword = common.StringOrNil(mockLDAPBindPassword)
+	common.LDAPGroupAttribute = "memberOf"
+	common.LDAPGroupOrganizations = map[string]string{
+		common.NormalizeDN("cn=engineering,ou=groups,dc=example,dc=com"): organizationID.String(),
+	}
+	common.LDAPGroupPermissions = map[string]common.Permission{
+		common.NormalizeDN("cn=admins,ou=groups,dc=example,dc=com"): common.Sudo,
+	}
+	common.LDAPTimeout = time.Second * 5
+	common.LDAPUserAttribute = "mail"
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	server := newMockLDAPServer(t)
+	organizationID, _ := uuid.NewV4()
+	configureMockLDAP(t, server, organizationID)
+
+	entry, err := authenticateLDAP("alice@example.com", "alice-secret")
+	if err != nil {
+		t.Fatalf("failed to authenticate ldap user; %s", err.Error())
+	}
+
+	if !server.boundAs(mockLDAPBindDN) {
+		t.Error("expected search to be performed using the configured service account")
+	}
+
+	if entry.DN != "uid=alice,ou=people,dc=example,dc=com" {
+		t.Errorf("expected entry dn uid=alice,ou=people,dc=example,dc=com; got %s", entry.DN)
+	}
+	if givenName := entry.attr("givenName"); givenName == nil || *givenName != "Alice" {
+		t.Errorf("expected given name Alice; got %v", givenName)
+	}
+
+	if server.boundInsecurely() {
+		t.Error("expected credentials to be sent only after establishing tls")
+	}
+
+	if permissions := entry.permissions(); permissions != common.Sudo {
+		t.Errorf("expected only the mapped group permissions to be granted; got %d", permissions)
+	}
+
+	organizations := entry.organizations()
+	if len(organizations) != 1 || organizations[0] != organizationID {
+		t.Errorf("expected membership of mapped organization %s; got %v", organizationID, organizations)
+	}
+}
+
+func TestLDAPAuthenticateWithoutMappedGroups(t *testing.T) {
+	server := newMockLDAPServer(t)
+	organizationID, _ := uuid.NewV4()
+	configureMockLDAP(t, server, organizationID)
+
+	entry, err := authenticateLDAP("bob@example.com", "bob-secret")
+	if err != nil {
+		t.Fatalf("failed to authenticate ldap user; %s", err.Error())
+	}
+
+	if entry.permissions() != 0 {
+		t.Errorf("expected no permissions to be granted without mapped groups; got %d", entry.permissions())
+	}
+	if len(entry.organizations()) != 0 {
+		t.Errorf("expected no mapped organizations; got %v", entry.organizations())
+	}
+}
+
+func TestLDAPAuthenticateFailures(t *testing.T) {
+	server := newMockLDAPServer(t)
+	organizationID, _ := uuid.NewV4()
+	configureMockLDAP(t, server, organizationID)
+
+	_, err := authenticateLDAP("alice@example.com", "wrong")
+	if err != errLDAPInvalidCredentials {
+		t.Errorf("expected invalid credentials for wrong password; got %v", err)
+	}
+
+	_, err = authenticateLDAP("alice@example.com", "")
+	if err != errLDAPInvalidCredentials {
+		t.Errorf("expected invalid credentials for empty password; got %v", err)
+	}
+
+	_, err = authenticateLDAP("nobody@example.com", "secret")
+	if err != errLDAPUserNotFound {
+		t.Errorf("expected user not found; got %v", err)
+	}
+
+	common.LDAPBindPassword = common.StringOrNil("wrong")
+	_, err = authenticateLDAP("alice@example.com", "alice-secret")
+	if !errors.Is(err, errLDAPUnavailable) {
+		t.Errorf("expected directory unavailable when the service account bind fails; got %v", err)
+	}
+
+	common.LDAPURL = common.StringOrNil("ldap://127.0.0.1:1")
+	_, err = authenticateLDAP("alice@example.com", "alice-secret")
+	if !errors.Is(err, errLDAPUnavailable) {
+		t.Errorf("expected directory unavailable when the directory cannot be reached; got %v", err)
+	}
+}
+
+func TestLDAPAuthenticateRequiresStartTLS(t *testing.T) {
+	server := newMockLDAPServer(t)
+	server.startTLS = false
+	organizationID, _ := uuid.NewV4()
+	configureMockLDAP(t, server, organizationID)
+
+	_, err := authenticateLDAP("alice@example.com", "alice-secret")
+	if err == nil {
+		t.Fatal("expected authentication to fail when the directory does not support starttls")
+	}
+
+	if server.boundInsecurely() {
+		t.Error("expected no credentials to be sent without tls")
+	}
+}
+
+func TestLDAPAuthenticateRequiresTrustedCertificate(t *testing.T) {
+	server := newMockLDAPServer(t)
+	organizationID, _ := uuid.NewV4()
+	configureMockLDAP(t, server, organizationID)
+	ldapRootCAs = x509.NewCertPool()
+
+	_, err := authenticateLDAP("alice@example.com", "alice-secret")
+	if err == nil {
+		t.Fatal("expected authentication to fail when the directory certificate is untrusted")
+	}
+
+	if server.boundAs(mockLDAPBindDN) {
+		t.Error("expected no credentials to be sent to an untrusted directory")
+	}
+}
+
+func TestLDAPUserPermissions(t *testing.T) {
+	// group permissions are granted in addition to the local permissions of the user
+	permissions, ldapPermissions := ldapUserPermissions(common.DefaultUserPermission, 0, common.Sudo)
+	if permissions != common.DefaultUserPermission|common.Sudo || ldapPermissions != common.Sudo {
+		t.Errorf("expected group permissions to be granted; permissions: %d; ldap permissions: %d", permissions, ldapPermissions)
+	}
+
+	// group permissions are revoked when the user leaves the group
+	permissions, ldapPermissions = ldapUserPermissions(permissions, ldapPermissions, 0)
+	if permissions != common.DefaultUserPermission || ldapPermissions != 0 {
+		t.Errorf("expected group permissions to be revoked; permissions: %d; ldap permissions: %d", permissions, ldapPermissions)
+	}
+
+	// locally-granted permissions are never revoked by the directory
+	permissions, ldapPermissions = ldapUserPermissions(common.DefaultSudoerPermission, 0, common.Sudo)
+	permissions, _ = ldapUserPermissions(permissions, ldapPermissions, 0)
+	if permissions != common.DefaultSudoerPermission {
+		t.Errorf("expected local permissions to be retained; permissions: %d", permissions)
+	}
+
+	// locally-revoked permissions are never restored by the directory
+	revoked := common.DefaultUserPermission &^ common.Authenticate
+	permissions, _ = ldapUserPermissions(revoked, 0, 0)
+	if permissions.Has(common.Authenticate) {
+		t.Errorf("expected revoked authenticate permission not to be restored; permissions: %d", permissions)
+	}
+}
+
+func TestIsLDAPAuthenticationEnabled(t *testing.T) {
+	server := newMockLDAPServer(t)
+	organizationID, _ := uuid.NewV4()
+	configureMockLDAP(t, server, organizationID)
+
+	applicationID, _ := uuid.NewV4()
+	if !isLDAPAuthenticationEnabled(nil) {
+		t.Error("expected ldap authentication to be enabled for platform users")
+	}
+	if isLDAPAuthenticationEnabled(&applicationID) {
+		t.Error("expected ldap authentication to be disabled for application users")
+	}
+}
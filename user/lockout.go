@@ -0,0 +1,288 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	natsutil "github.com/kthomas/go-natsutil"
+	redisutil "github.com/kthomas/go-redisutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+const authenticationFailuresKeyPrefix = "ident.authentication.failures"
+const authenticationLockoutKeyPrefix = "ident.authentication.lockout"
+const authenticationThrottleKeyPrefix = "ident.authentication.throttle"
+const authenticationLockoutReasonFailedAttempts = "failed_authentication_attempts"
+const natsUserLockedSubject = "ident.user.locked"
+
+// errInvalidCredentials is intentionally generic so that a failed authentication
+// attempt does not reveal whether or not an account exists for the given email
+var errInvalidCredentials = errors.New("authentication failed with given credentials")
+
+// errAuthenticationLocked is returned when authentication attempts are locked out
+var errAuthenticationLocked = errors.New("too many failed authentication attempts; try again later")
+
+var dummyPasswordHash string
+var dummyPasswordHashOnce sync.Once
+
+// compareDummyPassword spends roughly the same amount of time as verifying a real
+// password, so the timing of a failed attempt does not reveal if the account exists
+func compareDummyPassword(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = hashPassword(common.RandomString(32))
+	})
+	comparePassword(dummyPasswordHash, password)
+}
+
+func authenticationAccountKey(email string, applicationID *uuid.UUID) string {
+	account := strings.ToLower(email)
+	if applicationID != nil && *applicationID != uuid.Nil {
+		account = fmt.Sprintf("%s:%s", applicationID.String(), account)
+	}
+	return fmt.Sprintf("account.%s", common.SHA256(account))
+}
+
+func authenticationIPKey(ip string) string {
+	return fmt.Sprintf("ip.%s", ip)
+}
+
+func authenticationFailuresKey(key string) string {
+	return fmt.Sprintf("%s.%s", authenticationFailuresKeyPrefix, key)
+}
+
+func authenticationLockoutKey(key string) string {
+	return fmt.Sprintf("%s.%s", authenticationLockoutKeyPrefix, key)
+}
+
+func authenticationThrottleKey(key string) string {
+	return fmt.Sprintf("%s.%s", authenticationThrottleKeyPrefix, key)
+}
+
+// authenticationRetryAfter returns the duration for which authentication attempts for the given
+// account or from the given IP address are locked out or throttled, or zero if attempts are permitted
+func authenticationRetryAfter(email string, applicationID *uuid.UUID, ip string) time.Duration {
+	accountKey := authenticationAccountKey(email, applicationID)
+
+	var retryAfter time.Duration
+	for _, key := range []string{
+		authenticationLockoutKey(accountKey),
+		authenticationLockoutKey(authenticationIPKey(ip)),
+		authenticationThrottleKey(accountKey),
+	} {
+		if ttl := redisTTL(key); ttl > retryAfter {
+			retryAfter = ttl
+		}
+	}
+	return retryAfter
+}
+
+// retryAfterSeconds formats the given duration as the value of a Retry-After header, which is
+// expressed in whole seconds and rounded up such that a retry is not attempted prematurely
+func retryAfterSeconds(retryAfter time.Duration) string {
+	return fmt.Sprintf("%d", int64(math.Ceil(retryAfter.Seconds())))
+}
+
+// recordAuthenticationFailure increments the failed attempt counters for the given account
+// and IP address, locking either out when its threshold is reached, and throttles subsequent
+// attempts for the account for a progressive delay; the returned duration is the delay after
+// which authentication may be attempted again
+func recordAuthenticationFailure(email string, applicationID *uuid.UUID, ip string) time.Duration {
+	accountKey := authenticationAccountKey(email, applicationID)
+	accountFailures := incrementAuthenticationFailures(accountKey)
+	if accountFailures >= common.AuthenticationMaxAccountFailures {
+		lockAuthentication(accountKey)
+
+		usr := FindByEmail(email, applicationID, nil)
+		if usr != nil {
+			usr.dispatchLocked(authenticationLockoutReasonFailedAttempts)
+		}
+	}
+
+	ipKey := authenticationIPKey(ip)
+	ipFailures := incrementAuthenticationFailures(ipKey)
+	if ipFailures >= common.AuthenticationMaxIPFailures {
+		common.Log.Warningf("locking out authentication attempts from IP address: %s; %d failed attempts", ip, ipFailures)
+		lockAuthentication(ipKey)
+	}
+
+	delay := authenticationFailureDelay(accountFailures)
+	if delay > 0 {
+		redisutil.Set(authenticationThrottleKey(accountKey), time.Now().Add(delay).Unix(), &delay)
+	}
+	return delay
+}
+
+// resetAuthenticationFailures clears the failed attempt counter and throttle for the given account
+func resetAuthenticationFailures(email string, applicationID *uuid.UUID) {
+	accountKey := authenticationAccountKey(email, applicationID)
+	redisDel(authenticationFailuresKey(accountKey), authenticationThrottleKey(accountKey))
+}
+
+// authenticationFailureDelay returns the progressive throttle delay for the given number of failures
+func authenticationFailureDelay(failures int64) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	delay := float64(common.AuthenticationFailureDelay) * math.Pow(2, float64(failures-1))
+	if delay > float64(common.AuthenticationFailureMaxDelay) {
+		return common.AuthenticationFailureMaxDelay
+	}
+	return time.Duration(delay)
+}
+
+func incrementAuthenticationFailures(key string) int64 {
+	failuresKey := authenticationFailuresKey(key)
+	failures, err := redisutil.Increment(failuresKey)
+	if err != nil || failures == nil {
+		return 0
+	}
+	if *failures == 1 {
+		redisExpire(failuresKey, common.AuthenticationFailureWindow)
+	}
+	return *failures
+}
+
+func lockAuthentication(key string) {
+	ttl := common.AuthenticationLockoutDuration
+	redisutil.Set(authenticationLockoutKey(key), time.Now().Add(ttl).Unix(), &ttl)
+	redisDel(authenticationFailuresKey(key))
+}
+
+// dispatchLocked publishes a message indicating the user has been locked out
+func (u *User) dispatchLocked(reason string) {
+	common.Log.Debugf("dispatching user locked message for user: %s", u.ID)
+	payload, _ := json.Marshal(map[string]interface{}{
+		"user_id":        u.ID.String(),
+		"application_id": u.ApplicationID,
+		"reason":         reason,
+		"locked_until":   time.Now().Add(common.AuthenticationLockoutDuration),
+	})
+	natsutil.NatsJetstreamPublish(natsUserLockedSubject, payload)
+}
+
+// IsLocked returns true if authentication attempts for the user are currently locked out
+func (u *User) IsLocked() bool {
+	if u.Email == nil {
+		return false
+	}
+	return redisExists(authenticationLockoutKey(authenticationAccountKey(*u.Email, u.ApplicationID)))
+}
+
+// Unlock clears any authentication lockout and failed attempt counter for the user
+func (u *User) Unlock() bool {
+	if u.Email == nil {
+		u.Errors = append(u.Errors, &provide.Error{
+			Message: common.StringOrNil("unable to unlock user without email address"),
+		})
+		return false
+	}
+
+	accountKey := authenticationAccountKey(*u.Email, u.ApplicationID)
+	err := redisDel(authenticationLockoutKey(accountKey), authenticationFailuresKey(accountKey), authenticationThrottleKey(accountKey))
+	if err != nil {
+		u.Errors = append(u.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("failed to unlock user; %s", err.Error())),
+		})
+		return false
+	}
+
+	common.Log.Debugf("unlocked user: %s", u.ID)
+	return true
+}
+
+// redisExists returns true if the given key exists; go-redisutil does not expose EXISTS
+func redisExists(key string) bool {
+	var n int64
+	if redisutil.RedisClusterClient != nil {
+		n, _ = redisutil.RedisClusterClient.Exists(key).Result()
+	} else if redisutil.RedisClient != nil {
+		n, _ = redisutil.RedisClient.Exists(key).Result()
+	}
+	return n > 0
+}
+
+// redisTTL returns the remaining ttl of the given key, or zero if the key does not exist or does
+// not expire; go-redisutil does not expose TTL
+func redisTTL(key string) time.Duration {
+	var ttl time.Duration
+	if redisutil.RedisClusterClient != nil {
+		ttl, _ = redisutil.RedisClusterClient.PTTL(key).Result()
+	} else if redisutil.RedisClient != nil {
+		ttl, _ = redisutil.RedisClient.PTTL(key).Result()
+	}
+	if ttl < 0 {
+		return 0
+	}
+	return ttl
+}
+
+// redisExpire sets the ttl for the given key; go-redisutil does not expose EXPIRE
+func redisExpire(key string, ttl time.Duration) error {
+	if redisutil.RedisClusterClient != nil {
+		return redisutil.RedisClusterClient.Expire(key, ttl).Err()
+	} else if redisutil.RedisClient != nil {
+		return redisutil.RedisClient.Expire(key, ttl).Err()
+	}
+	return nil
+}
+
+// redisDel deletes the given keys; go-redisutil does not expose DEL
+func redisDel(keys ...string) error {
+	if redisutil.RedisClusterClient != nil {
+		for _, key := range keys {
+			// keys may hash to different slots, so they are deleted one at a time
+			err := redisutil.RedisClusterClient.Del(key).Err()
+			if err != nil {
+				return err
+			}
+		}
+	} else if redisutil.RedisClient != nil {
+		return redisutil.RedisClient.Del(keys...).Err()
+	}
+	return nil
+}
+
+// redisGetDelScript returns and deletes the value of a key in a single atomic step; GETDEL is only
+// available as of redis 6.2
+const redisGetDelScript = `local val = redis.call("GET", KEYS[1]); if val then redis.call("DEL", KEYS[1]) end; return val`
+
+// redisGetDel returns and deletes the value of the given key atomically, such that the value is
+// returned to at most one caller; go-redisutil does not expose GETDEL
+func redisGetDel(key string) (*string, error) {
+	var val interface{}
+	var err error
+	if redisutil.RedisClusterClient != nil {
+		val, err = redisutil.RedisClusterClient.Eval(redisGetDelScript, []string{key}).Result()
+	} else if redisutil.RedisClient != nil {
+		val, err = redisutil.RedisClient.Eval(redisGetDelScript, []string{key}).Result()
+	} else {
+		return nil, errors.New("redis is not configured")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	str, strOk := val.(string)
+	if !strOk {
+		return nil, fmt.Errorf("unexpected value for key: %s", key)
+	}
+	return &str, nil
+}
+
+// redisSetNX sets the given key only if it does not already exist, returning true if the key
+// was set; go-redisutil does not expose SETNX
+func redisSetNX(key, val string, ttl time.Duration) (bool, error) {
+	if redisutil.RedisClusterClient != nil {
+		return redisutil.RedisClusterClient.SetNX(key, val, ttl).Result()
+	} else if redisutil.RedisClient != nil {
+		return redisutil.RedisClient.SetNX(key, val, ttl).Result()
+	}
+	return false, errors.New("redis is not configured")
+}
@@ -0,0 +1,38 @@
+package user
+
+import (
+	"testing"
+	"time"
+
+	"github.com/provideplatform/ident/common"
+)
+
+func TestAuthenticationFailureDelay(t *testing.T) {
+	common.AuthenticationFailureDelay = time.Millisecond * 250
+	common.AuthenticationFailureMaxDelay = time.Second * 5
+
+	for failures, expected := range map[int64]time.Duration{
+		0:  0,
+		1:  time.Millisecond * 250,
+		2:  time.Millisecond * 500,
+		4:  time.Second * 2,
+		10: time.Second * 5,
+	} {
+		if delay := authenticationFailureDelay(failures); delay != expected {
+			t.Errorf("expected throttle delay of %s after %d failure(s); got %s", expected, failures, delay)
+		}
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	for retryAfter, expected := range map[time.Duration]string{
+		time.Millisecond * 250:  "1",
+		time.Second:             "1",
+		time.Millisecond * 1500: "2",
+		time.Minute * 15:        "900",
+	} {
+		if seconds := retryAfterSeconds(retryAfter); seconds != expected {
+			t.Errorf("expected Retry-After of %s seconds for %s; got %s", expected, retryAfter, seconds)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package user
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	natsutil "github.com/kthomas/go-natsutil"
+	redisutil "github.com/kthomas/go-redisutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+	"github.com/provideplatform/ident/token"
+)
+
+const magicLinkDeviceCookie = "ident_magic_link_device"
+const magicLinkDeviceSecretLength = 32
+const magicLinkRequestsKeyPrefix = "ident.magic_link.requests"
+
+// errMagicLinkRateLimited is returned when too many magic links have been requested for an address
+var errMagicLinkRateLimited = errors.New("too many magic link requests; try again later")
+
+// errInvalidMagicLink is intentionally generic so that a failed redemption does not
+// reveal why the given magic link was refused
+var errInvalidMagicLink = errors.New("magic link is invalid or has expired")
+
+// randomSecret returns a url-safe, base64-encoded secret read from a cryptographically
+// secure source; common.RandomString is not suitable for secrets
+func randomSecret(length int) (string, error) {
+	buf := make([]byte, length)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// isMagicLinkRateLimited increments the magic link request counter for the given address
+// and returns true if the configured number of requests within the window is exceeded
+func isMagicLinkRateLimited(email string, applicationID *uuid.UUID) bool {
+	key := fmt.Sprintf("%s.%s", magicLinkRequestsKeyPrefix, authenticationAccountKey(email, applicationID))
+	requests, err := redisutil.Increment(key)
+	if err != nil || requests == nil {
+		common.Log.Warningf("failed to increment magic link request counter; %s", err)
+		return false
+	}
+	if *requests == 1 {
+		redisExpire(key, common.MagicLinkRequestWindow)
+	}
+	return *requests > common.MagicLinkMaxRequests
+}
+
+// requestMagicLink vends a magic link login token for the user with the given address, if one
+// exists, and dispatches it to the address out-of-band; when a device secret is given, the magic
+// link can only be redeemed by presenting the same secret (i.e., from the requesting browser)
+func requestMagicLink(email string, applicationID *uuid.UUID, deviceSecret *string) error {
+	email = strings.ToLower(email)
+	if isMagicLinkRateLimited(email, applicationID) {
+		return errMagicLinkRateLimited
+	}
+
+	user := FindByEmail(email, applicationID, nil)
+	if user == nil {
+		// the request is acknowledged so as not to reveal whether or not an account exists
+		common.Log.Debugf("magic link not dispatched; no user exists for requested address")
+		return nil
+	}
+
+	if !user.hasPermission(common.Authenticate) || user.IsDisabled() {
+		common.Log.Debugf("magic link not dispatched for user: %s; user is not permitted to authenticate", user.ID)
+		return nil
+	}
+
+	magicLinkToken, err := user.VendMagicLinkToken(deviceSecret)
+	if err != nil {
+		common.Log.Warningf("failed to request magic link for user: %s; %s", user.ID, err.Error())
+		return err
+	}
+
+	payload, _ := json.Marshal(magicLinkToken)
+	natsutil.NatsJetstreamPublish(natsDispatchMagicLinkSubject, payload)
+
+	common.Log.Debugf("dispatched magic link for user: %s", user.ID)
+	return nil
+}
+
+// VendMagicLinkToken vends a signed, single-use, short-lived login token bound
+// to the user's application and, optionally, the requesting device
+func (u *User) VendMagicLinkToken(deviceSecret *string) (*token.Token, error) {
+	claims := map[string]interface{}{
+		"email": u.Email,
+	}
+	if deviceSecret != nil {
+		claims["device"] = common.SHA256(*deviceSecret)
+	}
+	dataJSON, _ := json.Marshal(claims)
+	data := json.RawMessage(dataJSON)
+
+	ttl := int(common.MagicLinkTokenTTL.Seconds())
+	magicLinkToken := &token.Token{
+		ApplicationID: u.ApplicationID,
+		UserID:        &u.ID,
+		Audience:      common.StringOrNil(token.MagicLinkAudience),
+		Subject:       common.StringOrNil(fmt.Sprintf("user:%s", u.ID.String())),
+		Data:          &data,
+		TTL:           &ttl,
+	}
+
+	if !magicLinkToken.Vend() {
+		msg := "failed to vend magic link token"
+		if len(magicLinkToken.Errors) > 0 {
+			msg = fmt.Sprintf("%s; %s", msg, *magicLinkToken.Errors[0].Message)
+		}
+		return nil, errors.New(msg)
+	}
+
+	return magicLinkToken, nil
+}
+
+// RedeemMagicLink authenticates the user to whom the given magic link token was issued;
+// the token is revoked upon redemption, so a magic link can only be redeemed once
+func RedeemMagicLink(db *gorm.DB, rawToken string, applicationID *uuid.UUID, deviceSecret *string, scope *string, session *token.Session) (*AuthenticationResponse, error) {
+	magicLinkToken, err := token.Parse(rawToken)
+	if err != nil {
+		common.Log.Debugf("failed to parse magic link token; %s", err.Error())
+		return nil, errInvalidMagicLink
+	}
+
+	if magicLinkToken.Audience == nil || *magicLinkToken.Audience != token.MagicLinkAudience || magicLinkToken.UserID == nil {
+		return nil, errInvalidMagicLink
+	}
+
+	if magicLinkToken.IsRevoked() {
+		common.Log.Debugf("refusing to redeem previously redeemed magic link for user: %s", magicLinkToken.UserID)
+		return nil, errInvalidMagicLink
+	}
+
+	if applicationID != nil && *applicationID != uuid.Nil && (magicLinkToken.ApplicationID == nil || *magicLinkToken.ApplicationID != *applicationID) {
+		common.Log.Debugf("refusing to redeem magic link for user: %s; application mismatch", magicLinkToken.UserID)
+		return nil, errInvalidMagicLink
+	}
+
+	if device, deviceOk := magicLinkToken.ParseData()["device"].(string); deviceOk {
+		if deviceSecret == nil || common.SHA256(*deviceSecret) != device {
+			common.Log.Debugf("refusing to redeem magic link for user: %s; device mismatch", magicLinkToken.UserID)
+			return nil, errInvalidMagicLink
+		}
+	}
+
+	user := &User{}
+	db.Where("id = ?", magicLinkToken.UserID).Find(&user)
+	if user == nil || user.ID == uuid.Nil {
+		return nil, errInvalidMagicLink
+	}
+
+	if (user.ApplicationID == nil) != (magicLinkToken.ApplicationID == nil) ||
+		(user.ApplicationID != nil && *user.ApplicationID != *magicLinkToken.ApplicationID) {
+		return nil, errInvalidMagicLink
+	}
+
+	if email, emailOk := magicLinkToken.ParseData()["email"].(string); !emailOk || user.Email == nil || !strings.EqualFold(email, *user.Email) {
+		// the email address of the user has changed since the magic link was sent
+		common.Log.Debugf("refusing to redeem magic link for user: %s; email mismatch", user.ID)
+		return nil, errInvalidMagicLink
+	}
+
+	if !user.hasPermission(common.Authenticate) {
+		common.Log.Debugf("magic link authentication failed for user: %s; revoked authenticate permission", user.ID)
+		return nil, errInvalidMagicLink
+	}
+
+	if user.IsDisabled() {
+		common.Log.Debugf("magic link authentication failed for user: %s; user has been suspended", user.ID)
+		return nil, errUserSuspended
+	}
+
+	if user.IsExpired() {
+		return nil, errUserExpired
+	}
+
+	if resp := user.consentRequiredResponse(db); resp != nil {
+		// the magic link remains redeemable until the user has consented
+		return resp, errConsentRequired
+	}
+
+	if !magicLinkToken.Revoke(nil) {
+		// the token was concurrently redeemed
+		return nil, errInvalidMagicLink
+	}
+
+	if !user.IsEmailVerified() {
+		// redeeming the magic link proves ownership of the address to which it was sent
+		user.markEmailVerified(db)
+	}
+
+	accessToken := &token.Token{
+		UserID:      &user.ID,
+		Scope:       scope,
+		Permissions: user.Permissions,
+	}
+
+	if !user.vendToken(db, accessToken, session) {
+		var err error
+		if len(accessToken.Errors) > 0 {
+			err = fmt.Errorf("failed to create token for magic link-authenticated user: %s; %s", *user.Email, *accessToken.Errors[0].Message)
+			common.Log.Warningf(err.Error())
+		}
+		return &AuthenticationResponse{
+			User:  user.AsResponse(),
+			Token: nil,
+		}, err
+	}
+
+	return &AuthenticationResponse{
+		User:  user.AsResponse(),
+		Token: accessToken.AsResponse(),
+	}, nil
+}
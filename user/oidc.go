@@ -0,0 +1,810 @@
+package user
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	"github.com/kthomas/go-pgputil"
+	redisutil "github.com/kthomas/go-redisutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+	"github.com/provideplatform/ident/token"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+const defaultOIDCScope = "openid email profile"
+const oidcDiscoveryPath = "/.well-known/openid-configuration"
+const oidcDiscoveryTTL = time.Hour
+const oidcHTTPTimeout = time.Second * 10
+const oidcSecretLength = 32
+const oidcStateKeyPrefix = "ident.oidc.state"
+const oidcStateTTL = time.Minute * 10
+
+// errOIDCAuthenticationFailed is intentionally generic so that a failed federated
+// authentication attempt does not reveal details about the upstream response
+var errOIDCAuthenticationFailed = errors.New("federated authentication failed")
+
+var oidcHTTPClient = &http.Client{Timeout: oidcHTTPTimeout}
+
+var oidcDiscoveryCache = map[string]*oidcDiscovery{}
+var oidcDiscoveryCacheMutex sync.Mutex
+
+var oidcKeyCache = map[string]map[string]interface{}{}
+var oidcKeyCacheMutex sync.Mutex
+
+// OIDCProvider is an upstream OpenID Connect identity provider configured for an application
+// or organization; users authenticate using the authorization code flow, and are linked to an
+// existing user by verified email address or provisioned just-in-time
+type OIDCProvider struct {
+	provide.Model
+	ApplicationID  *uuid.UUID `sql:"type:uuid" json:"application_id,omitempty"`
+	OrganizationID *uuid.UUID `sql:"type:uuid" json:"organization_id,omitempty"`
+	Name           *string    `sql:"not null" json:"name"`
+	Issuer         *string    `sql:"not null" json:"issuer"`
+	ClientID       *string    `sql:"not null" json:"client_id"`
+	ClientSecret   *string    `sql:"-" json:"-"`
+	Scope          *string    `json:"scope"`
+	Enabled        bool       `sql:"not null" json:"enabled"`
+
+	EncryptedClientSecret *string `sql:"type:bytea" json:"-"`
+}
+
+// TableName returns the db table name for gorm
+func (p *OIDCProvider) TableName() string {
+	return "oidc_providers"
+}
+
+// oidcDiscovery is the subset of the provider's openid configuration used by ident
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+
+	fetchedAt time.Time
+}
+
+// oidcState is persisted between the authorization redirect and the callback
+type oidcState struct {
+	ProviderID   uuid.UUID `json:"provider_id"`
+	Nonce        string    `json:"nonce"`
+	CodeVerifier string    `json:"code_verifier"`
+	RedirectURI  string    `json:"redirect_uri"`
+}
+
+// oidcIdentity is the upstream identity asserted by a verified id token
+type oidcIdentity struct {
+	Subject       string
+	Email         *string
+	EmailVerified bool
+	FirstName     *string
+	LastName      *string
+}
+
+// FindOIDCProvider returns the enabled upstream identity provider for the given id
+func FindOIDCProvider(db *gorm.DB, providerID uuid.UUID) *OIDCProvider {
+	provider := &OIDCProvider{}
+	db.Where("id = ? AND enabled = true", providerID).Find(&provider)
+	if provider == nil || provider.ID == uuid.Nil {
+		return nil
+	}
+	return provider
+}
+
+// Create and persist a new upstream identity provider; the client secret is encrypted at rest
+func (p *OIDCProvider) Create(db *gorm.DB) bool {
+	if !p.validate() {
+		return false
+	}
+
+	if !p.encryptClientSecret() {
+		return false
+	}
+
+	if db.NewRecord(p) {
+		result := db.Create(&p)
+		rowsAffected := result.RowsAffected
+		errors := result.GetErrors()
+		if len(errors) > 0 {
+			for _, err := range errors {
+				p.Errors = append(p.Errors, &provide.Error{
+					Message: common.StringOrNil(err.Error()),
+				})
+			}
+		}
+		return rowsAffected > 0 && len(p.Errors) == 0
+	}
+
+	return false
+}
+
+// Delete the upstream identity provider; linked identities are removed by cascade
+func (p *OIDCProvider) Delete(db *gorm.DB) bool {
+	result := db.Delete(&p)
+	errors := result.GetErrors()
+	if len(errors) > 0 {
+		for _, err := range errors {
+			p.Errors = append(p.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+		}
+	}
+	return len(p.Errors) == 0
+}
+
+// decryptedClientSecret returns the client secret of the provider, or nil for public clients
+func (p *OIDCProvider) decryptedClientSecret() (*string, error) {
+	if p.ClientSecret != nil {
+		return p.ClientSecret, nil
+	}
+
+	if p.EncryptedClientSecret != nil {
+		clientSecret, err := pgputil.PGPPubDecrypt([]byte(*p.EncryptedClientSecret))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt client secret of identity provider: %s; %s", p.ID, err.Error())
+		}
+		p.ClientSecret = common.StringOrNil(string(clientSecret))
+	}
+
+	return p.ClientSecret, nil
+}
+
+func (p *OIDCProvider) encryptClientSecret() bool {
+	if p.ClientSecret != nil {
+		encryptedClientSecret, err := pgputil.PGPPubEncrypt([]byte(*p.ClientSecret))
+		if err != nil {
+			common.Log.Warningf("failed to encrypt client secret of identity provider; %s", err.Error())
+			p.Errors = append(p.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+			return false
+		}
+		p.EncryptedClientSecret = common.StringOrNil(string(encryptedClientSecret))
+	}
+	return true
+}
+
+func (p *OIDCProvider) validate() bool {
+	p.Errors = make([]*provide.Error, 0)
+
+	if p.Name == nil || *p.Name == "" {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil("name is required"),
+		})
+	}
+
+	if p.Issuer == nil || *p.Issuer == "" {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil("issuer is required"),
+		})
+	} else if issuerURL, err := url.Parse(*p.Issuer); err != nil || issuerURL.Scheme == "" || issuerURL.Host == "" {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("invalid issuer: %s", *p.Issuer)),
+		})
+	}
+
+	if p.ClientID == nil || *p.ClientID == "" {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil("client_id is required"),
+		})
+	}
+
+	if (p.ApplicationID == nil) == (p.OrganizationID == nil) {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil("identity provider must belong to either an application or an organization"),
+		})
+	}
+
+	return len(p.Errors) == 0
+}
+
+// discover resolves the provider's openid configuration, which is cached for a period of time
+func (p *OIDCProvider) discover() (*oidcDiscovery, error) {
+	issuer := strings.TrimRight(*p.Issuer, "/")
+
+	oidcDiscoveryCacheMutex.Lock()
+	defer oidcDiscoveryCacheMutex.Unlock()
+
+	if discovery, discoveryOk := oidcDiscoveryCache[issuer]; discoveryOk && time.Since(discovery.fetchedAt) < oidcDiscoveryTTL {
+		return discovery, nil
+	}
+
+	discovery := &oidcDiscovery{}
+	err := oidcGetJSON(fmt.Sprintf("%s%s", issuer, oidcDiscoveryPath), nil, discovery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve openid configuration for issuer: %s; %s", issuer, err.Error())
+	}
+
+	if strings.TrimRight(discovery.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("openid configuration issuer mismatch; expected %s, got %s", issuer, discovery.Issuer)
+	}
+
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("incomplete openid configuration for issuer: %s", issuer)
+	}
+
+	discovery.fetchedAt = time.Now()
+	oidcDiscoveryCache[issuer] = discovery
+	return discovery, nil
+}
+
+// AuthorizationURL returns the upstream authorization endpoint to which the user agent should
+// be redirected; the state, nonce and PKCE code verifier are persisted until the callback
+func (p *OIDCProvider) AuthorizationURL(redirectURI string) (*string, error) {
+	state, err := randomSecret(oidcSecretLength)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := randomSecret(oidcSecretLength)
+	if err != nil {
+		return nil, err
+	}
+
+	codeVerifier, err := randomSecret(oidcSecretLength)
+	if err != nil {
+		return nil, err
+	}
+
+	authorizationURL, err := p.authorizationURL(redirectURI, state, nonce, codeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, _ := json.Marshal(&oidcState{
+		ProviderID:   p.ID,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		RedirectURI:  redirectURI,
+	})
+
+	ttl := oidcStateTTL
+	err = redisutil.Set(fmt.Sprintf("%s.%s", oidcStateKeyPrefix, state), string(raw), &ttl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to persist authorization state; %s", err.Error())
+	}
+
+	return authorizationURL, nil
+}
+
+func (p *OIDCProvider) authorizationURL(redirectURI, state, nonce, codeVerifier string) (*string, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	scope := defaultOIDCScope
+	if p.Scope != nil && *p.Scope != "" {
+		scope = *p.Scope
+	}
+
+	authorizationURL, err := url.Parse(discovery.AuthorizationEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid authorization endpoint: %s; %s", discovery.AuthorizationEndpoint, err.Error())
+	}
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+
+	query := authorizationURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", *p.ClientID)
+	query.Set("redirect_uri", redirectURI)
+	query.Set("scope", scope)
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	authorizationURL.RawQuery = query.Encode()
+
+	return common.StringOrNil(authorizationURL.String()), nil
+}
+
+// consumeOIDCState returns and removes the authorization state persisted for the given
+// state parameter, so that each authorization redirect can only be completed once
+func consumeOIDCState(state string) (*oidcState, error) {
+	raw, err := redisGetDel(fmt.Sprintf("%s.%s", oidcStateKeyPrefix, state))
+	if err != nil || raw == nil {
+		return nil, errors.New("invalid or expired authorization state")
+	}
+
+	oidcState := &oidcState{}
+	err = json.Unmarshal([]byte(*raw), &oidcState)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal authorization state; %s", err.Error())
+	}
+	return oidcState, nil
+}
+
+// exchange redeems the given authorization code at the upstream token endpoint and
+// returns the identity asserted by the verified id token
+func (p *OIDCProvider) exchange(code, redirectURI, codeVerifier, nonce string) (*oidcIdentity, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("code_verifier", codeVerifier)
+
+	clientSecret, err := p.decryptedClientSecret()
+	if err != nil {
+		return nil, err
+	}
+	if clientSecret == nil {
+		// public clients identify themselves in the request body
+		form.Set("client_id", *p.ClientID)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange authorization code; %s", err.Error())
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if clientSecret != nil {
+		req.SetBasicAuth(url.QueryEscape(*p.ClientID), url.QueryEscape(*clientSecret))
+	}
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange authorization code; %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to exchange authorization code; token endpoint returned status: %d", resp.StatusCode)
+	}
+
+	tokenResponse := struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+	}{}
+	err = json.Unmarshal(body, &tokenResponse)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal token response; %s", err.Error())
+	}
+
+	if tokenResponse.IDToken == "" {
+		return nil, errors.New("token response did not include an id token")
+	}
+
+	claims, err := p.verifyIDToken(discovery, tokenResponse.IDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, emailOk := claims["email"].(string); !emailOk && discovery.UserinfoEndpoint != "" && tokenResponse.AccessToken != "" {
+		userinfo := map[string]interface{}{}
+		err = oidcGetJSON(discovery.UserinfoEndpoint, &tokenResponse.AccessToken, &userinfo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch userinfo; %s", err.Error())
+		}
+
+		if sub, _ := userinfo["sub"].(string); sub != claims["sub"] {
+			return nil, errors.New("userinfo subject does not match id token subject")
+		}
+
+		for k, v := range userinfo {
+			if _, exists := claims[k]; !exists {
+				claims[k] = v
+			}
+		}
+	}
+
+	return identityFromClaims(claims)
+}
+
+// verifyIDToken verifies the signature of the given id token using the provider's published
+// keys, along with its issuer, audience, expiration and nonce
+func (p *OIDCProvider) verifyIDToken(discovery *oidcDiscovery, rawIDToken, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, claims, func(idToken *jwt.Token) (interface{}, error) {
+		switch idToken.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unsupported id token signing alg: %s", idToken.Method.Alg())
+		}
+
+		kid, _ := idToken.Header["kid"].(string)
+		return resolveOIDCKey(discovery.JWKSURI, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify id token; %s", err.Error())
+	}
+
+	if !claims.VerifyIssuer(discovery.Issuer, true) {
+		return nil, errors.New("failed to verify id token; issuer mismatch")
+	}
+
+	if !verifyOIDCAudience(claims, *p.ClientID) {
+		return nil, errors.New("failed to verify id token; audience mismatch")
+	}
+
+	if _, expOk := claims["exp"]; !expOk {
+		return nil, errors.New("failed to verify id token; missing exp claim")
+	}
+
+	if claimedNonce, _ := claims["nonce"].(string); claimedNonce != nonce {
+		return nil, errors.New("failed to verify id token; nonce mismatch")
+	}
+
+	return claims, nil
+}
+
+// verifyOIDCAudience returns true if the given client id is the audience, or one of the
+// audiences, of the id token; when there are multiple audiences, azp must be the client
+func verifyOIDCAudience(claims jwt.MapClaims, clientID string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		found := false
+		for _, a := range aud {
+			if a == clientID {
+				found = true
+				break
+			}
+		}
+		if found && len(aud) > 1 {
+			azp, _ := claims["azp"].(string)
+			return azp == clientID
+		}
+		return found
+	}
+	return false
+}
+
+// resolveOIDCKey returns the public key for the given kid from the key set published at
+// the given uri; the key set is refreshed when a kid is not found, to allow for rotation
+func resolveOIDCKey(jwksURI, kid string) (interface{}, error) {
+	oidcKeyCacheMutex.Lock()
+	defer oidcKeyCacheMutex.Unlock()
+
+	keys, keysOk := oidcKeyCache[jwksURI]
+	if keysOk {
+		if key, keyOk := keys[kid]; keyOk {
+			return key, nil
+		}
+	}
+
+	jwks := struct {
+		Keys []map[string]interface{} `json:"keys"`
+	}{}
+	err := oidcGetJSON(jwksURI, nil, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch jwks; %s", err.Error())
+	}
+
+	keys = map[string]interface{}{}
+	for _, jwk := range jwks.Keys {
+		if use, useOk := jwk["use"].(string); useOk && use != "sig" {
+			continue
+		}
+
+		key, err := parseJWK(jwk)
+		if err != nil {
+			common.Log.Debugf("skipping unsupported jwk; %s", err.Error())
+			continue
+		}
+
+		jwkKid, _ := jwk["kid"].(string)
+		keys[jwkKid] = key
+	}
+	oidcKeyCache[jwksURI] = keys
+
+	if key, keyOk := keys[kid]; keyOk {
+		return key, nil
+	}
+	return nil, fmt.Errorf("no jwk resolved for kid: %s", kid)
+}
+
+// parseJWK parses the RSA or EC public key represented by the given jwk
+func parseJWK(jwk map[string]interface{}) (interface{}, error) {
+	decode := func(param string) (*big.Int, error) {
+		val, valOk := jwk[param].(string)
+		if !valOk {
+			return nil, fmt.Errorf("jwk missing %s parameter", param)
+		}
+		raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(val, "="))
+		if err != nil {
+			return nil, fmt.Errorf("malformed jwk %s parameter; %s", param, err.Error())
+		}
+		return new(big.Int).SetBytes(raw), nil
+	}
+
+	switch jwk["kty"] {
+	case "RSA":
+		n, err := decode("n")
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode("e")
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk["crv"] {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported jwk curve: %v", jwk["crv"])
+		}
+		x, err := decode("x")
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode("y")
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported jwk kty: %v", jwk["kty"])
+}
+
+// identityFromClaims returns the identity asserted by the given verified claims
+func identityFromClaims(claims jwt.MapClaims) (*oidcIdentity, error) {
+	sub, subOk := claims["sub"].(string)
+	if !subOk || sub == "" {
+		return nil, errors.New("id token did not include a sub claim")
+	}
+
+	identity := &oidcIdentity{
+		Subject: sub,
+	}
+
+	if email, emailOk := claims["email"].(string); emailOk && email != "" {
+		identity.Email = common.StringOrNil(strings.ToLower(email))
+	}
+
+	switch emailVerified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = emailVerified
+	case string:
+		identity.EmailVerified = strings.ToLower(emailVerified) == "true"
+	}
+
+	if givenName, givenNameOk := claims["given_name"].(string); givenNameOk && givenName != "" {
+		identity.FirstName = common.StringOrNil(givenName)
+	}
+
+	if familyName, familyNameOk := claims["family_name"].(string); familyNameOk && familyName != "" {
+		identity.LastName = common.StringOrNil(familyName)
+	}
+
+	if identity.FirstName == nil || identity.LastName == nil {
+		if name, nameOk := claims["name"].(string); nameOk && name != "" {
+			nameParts := strings.SplitN(strings.TrimSpace(name), " ", 2)
+			if identity.FirstName == nil {
+				identity.FirstName = common.StringOrNil(nameParts[0])
+			}
+			if identity.LastName == nil && len(nameParts) == 2 {
+				identity.LastName = common.StringOrNil(nameParts[1])
+			}
+		}
+	}
+
+	return identity, nil
+}
+
+// oidcGetJSON fetches and unmarshals the JSON resource at the given uri, optionally
+// presenting the given bearer token
+func oidcGetJSON(uri string, bearerToken *string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearerToken != nil {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *bearerToken))
+	}
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status: %d", uri, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// AuthenticateOIDCUser completes federated authentication on behalf of the given upstream
+// identity provider; the user previously linked to the upstream identity is authenticated,
+// otherwise a new user is provisioned just-in-time, or a link to the existing user with the
+// asserted email address is staged for confirmation by the user
+func AuthenticateOIDCUser(db *gorm.DB, code, state string, scope *string, session *token.Session) (*AuthenticationResponse, error) {
+	authorizationState, err := consumeOIDCState(state)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := FindOIDCProvider(db, authorizationState.ProviderID)
+	if provider == nil {
+		return nil, errors.New("identity provider not found")
+	}
+
+	identity, err := provider.exchange(code, authorizationState.RedirectURI, authorizationState.CodeVerifier, authorizationState.Nonce)
+	if err != nil {
+		common.Log.Warningf("federated authentication failed using identity provider: %s; %s", provider.ID, err.Error())
+		return nil, errOIDCAuthenticationFailed
+	}
+
+	tx := db.Begin()
+	defer tx.RollbackUnlessCommitted()
+
+	user, err := provider.resolveUser(tx, identity)
+	if err != nil {
+		common.Log.Warningf("federated authentication failed using identity provider: %s; %s", provider.ID, err.Error())
+		return nil, err
+	}
+
+	if !user.hasPermission(common.Authenticate) {
+		common.Log.Debugf("federated authentication failed for user: %s; revoked authenticate permission", user.ID)
+		return nil, errOIDCAuthenticationFailed
+	}
+
+	if user.IsDisabled() {
+		common.Log.Debugf("federated authentication failed for user: %s; user has been suspended", user.ID)
+		return nil, errUserSuspended
+	}
+
+	if user.IsExpired() {
+		return nil, errUserExpired
+	}
+
+	tx.Commit()
+
+	return user.vendFederatedToken(db, provider.ApplicationID, provider.OrganizationID, scope, session)
+}
+
+// resolveUser returns the user linked to the given upstream identity, provisioning the user when
+// the identity has not previously been used to authenticate; an existing user with the asserted
+// email address is never linked implicitly, and must confirm the staged link from its account,
+// and an organization's identity provider only provisions users within its verified domains
+func (p *OIDCProvider) resolveUser(tx *gorm.DB, identity *oidcIdentity) (*User, error) {
+	user := &User{}
+	tx.Joins("JOIN user_identities ON user_identities.user_id = users.id").
+		Where("user_identities.provider_id = ? AND user_identities.subject = ?", p.ID, identity.Subject).
+		Find(&user)
+	if user != nil && user.ID != uuid.Nil {
+		return user, nil
+	}
+
+	if identity.Email == nil || !identity.EmailVerified {
+		return nil, errors.New("federated authentication failed; identity provider did not assert a verified email address")
+	}
+
+	user = FindByEmail(*identity.Email, p.ApplicationID, nil)
+	if user != nil {
+		// an organization's identity provider cannot take over accounts outside of the organization
+		if p.OrganizationID != nil && FindByEmail(*identity.Email, p.ApplicationID, p.OrganizationID) == nil {
+			return nil, errors.New("federated authentication failed; a user with the asserted email address exists but is not a member of the organization")
+		}
+		common.Log.Debugf("staging link of user %s to identity provider: %s", user.ID, p.ID)
+		return nil, stageIdentityLink(&pendingIdentityLink{
+			UserID:         user.ID,
+			OIDCProviderID: &p.ID,
+			Subject:        identity.Subject,
+		})
+	}
+
+	if p.OrganizationID != nil && !organizationHasVerifiedEmailDomain(tx, *p.OrganizationID, *identity.Email) {
+		return nil, errors.New("federated authentication failed; the organization has not verified the domain of the asserted email address")
+	}
+
+	user, err := provisionFederatedUser(tx, p.ApplicationID, *identity.Email, identity.FirstName, identity.LastName)
+	if err != nil {
+		return nil, err
+	}
+	common.Log.Debugf("provisioned user %s using identity provider: %s", user.ID, p.ID)
+
+	result := tx.Exec("INSERT INTO user_identities (created_at, user_id, provider_id, subject) VALUES (?, ?, ?, ?)", time.Now(), user.ID, p.ID, identity.Subject)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to link user to identity provider; %s", result.Error.Error())
+	}
+
+	if p.OrganizationID != nil {
+		var memberships int
+		tx.Table("organizations_users").Where("organization_id = ? AND user_id = ?", p.OrganizationID, user.ID).Count(&memberships)
+		if memberships == 0 && !user.addOrganizationAssociation(tx, *p.OrganizationID, common.DefaultApplicationResourcePermission, nil) {
+			return nil, errors.New("failed to associate federated user with organization")
+		}
+	}
+
+	return user, nil
+}
+
+// provisionFederatedUser creates a user on behalf of an upstream identity provider which has
+// asserted ownership of the given email address; the user has no password, and the address is
+// not considered verified until the user verifies it
+func provisionFederatedUser(tx *gorm.DB, applicationID *uuid.UUID, email string, firstName, lastName *string) (*User, error) {
+	user := &User{
+		ApplicationID: applicationID,
+		Email:         common.StringOrNil(email),
+		FirstName:     firstName,
+		LastName:      lastName,
+		federated:     true,
+	}
+
+	if user.FirstName == nil {
+		user.FirstName = common.StringOrNil(strings.Split(email, "@")[0])
+	}
+	if user.LastName == nil {
+		// last_name is not nullable; common.StringOrNil would return nil for the empty string
+		emptyLastName := ""
+		user.LastName = &emptyLastName
+	}
+
+	if !user.Create(tx, false) {
+		msg := "failed to provision federated user"
+		if len(user.Errors) > 0 {
+			msg = fmt.Sprintf("%s; %s", msg, *user.Errors[0].Message)
+		}
+		return nil, errors.New(msg)
+	}
+
+	return user, nil
+}
+
+// organizationHasVerifiedEmailDomain returns true if the given organization has verified control
+// of the domain of the given email address
+func organizationHasVerifiedEmailDomain(db *gorm.DB, organizationID uuid.UUID, email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at == -1 {
+		return false
+	}
+
+	var domains uint64
+	db.Table("organization_domains").
+		Where("organization_id = ? AND domain = ? AND verified_at IS NOT NULL", organizationID, strings.ToLower(email[at+1:])).
+		Count(&domains)
+	return domains > 0
+}
+
+// ListOIDCProviders returns a query scoped to the identity providers of the given application or organization
+func ListOIDCProviders(applicationID, organizationID *uuid.UUID) *gorm.DB {
+	query := dbconf.DatabaseConnection().Model(&OIDCProvider{})
+	if applicationID != nil {
+		query = query.Where("application_id = ?", applicationID)
+	}
+	if organizationID != nil {
+		query = query.Where("organization_id = ?", organizationID)
+	}
+	return query
+}
@@ -0,0 +1,239 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/provideplatform/ident/common"
+)
+
+const mockOIDCClientID = "ident-test"
+const mockOIDCClientSecret = "s3cr3t"
+const mockOIDCCode = "mock-authorization-code"
+const mockOIDCCodeVerifier = "mock-code-verifier"
+const mockOIDCKid = "mock-kid"
+const mockOIDCRedirectURI = "https://app.local/callback"
+
+// mockOIDCProvider is an in-process upstream OpenID Connect provider which issues an id token
+// asserting the configured claims upon redemption of the mock authorization code
+type mockOIDCProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+	claims jwt.MapClaims
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate mock provider signing key; %s", err.Error())
+	}
+
+	provider := &mockOIDCProvider{
+		key: key,
+		kid: mockOIDCKid,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(oidcDiscoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                 provider.server.URL,
+			"authorization_endpoint": provider.server.URL + "/authorize",
+			"token_endpoint":         provider.server.URL + "/token",
+			"userinfo_endpoint":      provider.server.URL + "/userinfo",
+			"jwks_uri":               provider.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]interface{}{
+				{
+					"kty": "RSA",
+					"use": "sig",
+					"kid": mockOIDCKid,
+					"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, _ := r.BasicAuth()
+		if clientID != mockOIDCClientID || clientSecret != mockOIDCClientSecret {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		r.ParseForm()
+		if r.Form.Get("grant_type") != "authorization_code" || r.Form.Get("code") != mockOIDCCode || r.Form.Get("redirect_uri") != mockOIDCRedirectURI || r.Form.Get("code_verifier") != mockOIDCCodeVerifier {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, provider.claims)
+		idToken.Header["kid"] = provider.kid
+		signed, _ := idToken.SignedString(provider.key)
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "mock-access-token",
+			"token_type":   "Bearer",
+			"id_token":     signed,
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer mock-access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"sub":            provider.claims["sub"],
+			"email":          "userinfo@prvd.local",
+			"email_verified": true,
+		})
+	})
+
+	provider.server = httptest.NewServer(mux)
+	provider.claims = jwt.MapClaims{
+		"iss":            provider.server.URL,
+		"sub":            "upstream-subject",
+		"aud":            mockOIDCClientID,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"nonce":          "mock-nonce",
+		"email":          "Federated.User@prvd.local",
+		"email_verified": true,
+		"given_name":     "Federated",
+		"family_name":    "User",
+	}
+
+	t.Cleanup(provider.server.Close)
+	return provider
+}
+
+func (m *mockOIDCProvider) oidcProvider() *OIDCProvider {
+	return &OIDCProvider{
+		Name:         common.StringOrNil("mock"),
+		Issuer:       common.StringOrNil(m.server.URL),
+		ClientID:     common.StringOrNil(mockOIDCClientID),
+		ClientSecret: common.StringOrNil(mockOIDCClientSecret),
+		Enabled:      true,
+	}
+}
+
+func TestOIDCAuthorizationURL(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+
+	authorizationURL, err := mock.oidcProvider().authorizationURL(mockOIDCRedirectURI, "mock-state", "mock-nonce", mockOIDCCodeVerifier)
+	if err != nil {
+		t.Fatalf("failed to resolve authorization url; %s", err.Error())
+	}
+
+	parsedURL, _ := url.Parse(*authorizationURL)
+	query := parsedURL.Query()
+	challenge := sha256.Sum256([]byte(mockOIDCCodeVerifier))
+
+	expected := map[string]string{
+		"response_type":         "code",
+		"client_id":             mockOIDCClientID,
+		"redirect_uri":          mockOIDCRedirectURI,
+		"scope":                 defaultOIDCScope,
+		"state":                 "mock-state",
+		"nonce":                 "mock-nonce",
+		"code_challenge":        base64.RawURLEncoding.EncodeToString(challenge[:]),
+		"code_challenge_method": "S256",
+	}
+	for param, val := range expected {
+		if query.Get(param) != val {
+			t.Errorf("expected authorization url %s param to be %s; got %s", param, val, query.Get(param))
+		}
+	}
+
+	if parsedURL.Path != "/authorize" {
+		t.Errorf("expected authorization url to use the discovered authorization endpoint; got %s", parsedURL.Path)
+	}
+}
+
+func TestOIDCExchange(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+
+	identity, err := mock.oidcProvider().exchange(mockOIDCCode, mockOIDCRedirectURI, mockOIDCCodeVerifier, "mock-nonce")
+	if err != nil {
+		t.Fatalf("failed to exchange authorization code; %s", err.Error())
+	}
+
+	if identity.Subject != "upstream-subject" {
+		t.Errorf("expected subject upstream-subject; got %s", identity.Subject)
+	}
+	if identity.Email == nil || *identity.Email != "federated.user@prvd.local" {
+		t.Errorf("expected normalized email address federated.user@prvd.local; got %v", identity.Email)
+	}
+	if !identity.EmailVerified {
+		t.Error("expected email address to be verified")
+	}
+	if identity.FirstName == nil || *identity.FirstName != "Federated" || identity.LastName == nil || *identity.LastName != "User" {
+		t.Errorf("expected name Federated User; got %v %v", identity.FirstName, identity.LastName)
+	}
+}
+
+func TestOIDCExchangeFallsBackToUserinfo(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	delete(mock.claims, "email")
+	delete(mock.claims, "email_verified")
+
+	identity, err := mock.oidcProvider().exchange(mockOIDCCode, mockOIDCRedirectURI, mockOIDCCodeVerifier, "mock-nonce")
+	if err != nil {
+		t.Fatalf("failed to exchange authorization code; %s", err.Error())
+	}
+
+	if identity.Email == nil || *identity.Email != "userinfo@prvd.local" || !identity.EmailVerified {
+		t.Errorf("expected verified email address from userinfo; got %v", identity.Email)
+	}
+}
+
+func TestOIDCExchangeRejectsInvalidIDTokens(t *testing.T) {
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	tt := []struct {
+		name   string
+		mutate func(m *mockOIDCProvider)
+		nonce  string
+	}{
+		{"nonce mismatch", func(m *mockOIDCProvider) {}, "other-nonce"},
+		{"audience mismatch", func(m *mockOIDCProvider) { m.claims["aud"] = "other-client" }, "mock-nonce"},
+		{"issuer mismatch", func(m *mockOIDCProvider) { m.claims["iss"] = "https://other.local" }, "mock-nonce"},
+		{"expired", func(m *mockOIDCProvider) { m.claims["exp"] = time.Now().Add(-time.Minute).Unix() }, "mock-nonce"},
+		{"untrusted signing key", func(m *mockOIDCProvider) { m.key = otherKey }, "mock-nonce"},
+		{"unknown kid", func(m *mockOIDCProvider) { m.kid = "other-kid" }, "mock-nonce"},
+	}
+
+	for _, tc := range tt {
+		mock := newMockOIDCProvider(t)
+		tc.mutate(mock)
+
+		_, err := mock.oidcProvider().exchange(mockOIDCCode, mockOIDCRedirectURI, mockOIDCCodeVerifier, tc.nonce)
+		if err == nil {
+			t.Errorf("expected exchange to fail with %s", tc.name)
+		}
+	}
+}
+
+func TestOIDCExchangeRejectsInvalidClientCredentials(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	provider := mock.oidcProvider()
+	provider.ClientSecret = common.StringOrNil("wrong")
+
+	_, err := provider.exchange(mockOIDCCode, mockOIDCRedirectURI, mockOIDCCodeVerifier, "mock-nonce")
+	if err == nil {
+		t.Error("expected exchange to fail with invalid client credentials")
+	}
+}
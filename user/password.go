@@ -0,0 +1,437 @@
+package user
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+	"github.com/provideplatform/ident/common"
+	provide "github.com/provideplatform/provide-go/api"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHashSchemeArgon2id is the scheme of argon2id password hashes, encoded as PHC strings
+const PasswordHashSchemeArgon2id = "argon2id"
+
+// PasswordHashSchemeBcrypt is the scheme of legacy bcrypt password hashes
+const PasswordHashSchemeBcrypt = "bcrypt"
+
+const passwordHashSchemeNone = "none"
+const passwordHashSchemeUnknown = "unknown"
+
+const bcryptMaxPasswordLength = 72 // bcrypt only considers the first 72 bytes
+const breachedPasswordHashLength = 40
+const breachedPasswordRangePrefixLength = 5
+
+var breachedPasswordHashes map[string]bool
+var breachedPasswordHashesOnce sync.Once
+
+// validatePassword validates the given plaintext password against the configured password
+// policy; an error is appended to the user for each violation of the policy
+func (u *User) validatePassword(password string) bool {
+	valid := true
+	invalidate := func(msg string) {
+		u.Errors = append(u.Errors, &provide.Error{
+			Message: common.StringOrNil(msg),
+		})
+		valid = false
+	}
+
+	if utf8.RuneCountInString(password) < common.PasswordMinLength {
+		invalidate(fmt.Sprintf("password must be at least %d characters", common.PasswordMinLength))
+	}
+
+	if common.PasswordMaxLength > 0 && utf8.RuneCountInString(password) > common.PasswordMaxLength {
+		invalidate(fmt.Sprintf("password must be at most %d characters", common.PasswordMaxLength))
+	}
+	if common.PasswordHashAlgorithm == PasswordHashSchemeBcrypt && len(password) > bcryptMaxPasswordLength {
+		invalidate(fmt.Sprintf("password must be at most %d bytes", bcryptMaxPasswordLength))
+	}
+
+	var hasDigit, hasLower, hasSymbol, hasUpper bool
+	for _, r := range password {
+		switch {
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case !unicode.IsLetter(r):
+			hasSymbol = true
+		}
+	}
+
+	if common.PasswordRequireLowercase && !hasLower {
+		invalidate("password must contain a lowercase letter")
+	}
+
+	if common.PasswordRequireUppercase && !hasUpper {
+		invalidate("password must contain an uppercase letter")
+	}
+
+	if common.PasswordRequireDigit && !hasDigit {
+		invalidate("password must contain a digit")
+	}
+
+	if common.PasswordRequireSymbol && !hasSymbol {
+		invalidate("password must contain a symbol")
+	}
+
+	if isBreachedPassword(password) {
+		invalidate("password has appeared in a known data breach")
+	}
+
+	return valid
+}
+
+// hashPassword hashes the given plaintext password using the configured password hash algorithm;
+// the returned hash is self-describing, so the scheme and parameters can be recovered from it
+func hashPassword(password string) (string, error) {
+	if common.PasswordHashAlgorithm == PasswordHashSchemeBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), common.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+
+	salt := make([]byte, common.Argon2SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate password salt; %s", err.Error())
+	}
+
+	params := &argon2idParams{
+		memory:      common.Argon2Memory,
+		iterations:  common.Argon2Iterations,
+		parallelism: common.Argon2Parallelism,
+		keyLength:   common.Argon2KeyLength,
+	}
+	key := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, params.keyLength)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		PasswordHashSchemeArgon2id,
+		argon2.Version,
+		params.memory,
+		params.iterations,
+		params.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// comparePassword returns true if the given plaintext password matches the given hash
+func comparePassword(hash, password string) bool {
+	switch passwordHashScheme(hash) {
+	case PasswordHashSchemeArgon2id:
+		params, salt, key, err := decodeArgon2idHash(hash)
+		if err != nil {
+			common.Log.Warningf("failed to decode argon2id password hash; %s", err.Error())
+			return false
+		}
+		derivedKey := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, derivedKey) == 1
+	case PasswordHashSchemeBcrypt:
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+	return false
+}
+
+// passwordHashScheme returns the scheme of the given password hash
+func passwordHashScheme(hash string) string {
+	if strings.HasPrefix(hash, fmt.Sprintf("$%s$", PasswordHashSchemeArgon2id)) {
+		return PasswordHashSchemeArgon2id
+	}
+	if strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$") {
+		return PasswordHashSchemeBcrypt
+	}
+	return passwordHashSchemeUnknown
+}
+
+// passwordNeedsRehash returns true if the given password hash was not produced using the
+// configured password hash algorithm and parameters
+func passwordNeedsRehash(hash string) bool {
+	scheme := passwordHashScheme(hash)
+	if scheme != common.PasswordHashAlgorithm {
+		return true
+	}
+
+	switch scheme {
+	case PasswordHashSchemeArgon2id:
+		params, salt, key, err := decodeArgon2idHash(hash)
+		if err != nil {
+			return true
+		}
+		return params.memory != common.Argon2Memory ||
+			params.iterations != common.Argon2Iterations ||
+			params.parallelism != common.Argon2Parallelism ||
+			len(salt) != common.Argon2SaltLength ||
+			uint32(len(key)) != common.Argon2KeyLength
+	case PasswordHashSchemeBcrypt:
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != common.BcryptCost
+	}
+	return true
+}
+
+// upgradePasswordHash transparently rehashes the given plaintext password, which has just been
+// verified against the user's current hash, using the configured algorithm and parameters; the
+// password policy and history are not applied since the password itself is unchanged
+func (u *User) upgradePasswordHash(db *gorm.DB, password string) bool {
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		common.Log.Warningf("failed to upgrade password hash for user: %s; %s", u.ID, err.Error())
+		return false
+	}
+
+	result := db.Model(&User{}).Where("id = ? AND password = ?", u.ID, *u.Password).Update("password", hashedPassword)
+	if result.Error != nil || result.RowsAffected != 1 {
+		common.Log.Warningf("failed to upgrade password hash for user: %s", u.ID)
+		return false
+	}
+
+	common.Log.Debugf("upgraded %s password hash to %s for user: %s", passwordHashScheme(*u.Password), common.PasswordHashAlgorithm, u.ID)
+	u.Password = common.StringOrNil(hashedPassword)
+	return true
+}
+
+type argon2idParams struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	keyLength   uint32
+}
+
+// decodeArgon2idHash decodes the parameters, salt and key from the given argon2id PHC string
+func decodeArgon2idHash(hash string) (*argon2idParams, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return nil, nil, nil, errors.New("malformed argon2id hash")
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("malformed argon2id hash version; %s", err.Error())
+	}
+	if version != argon2.Version {
+		return nil, nil, nil, fmt.Errorf("unsupported argon2id version: %d", version)
+	}
+
+	params := &argon2idParams{}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("malformed argon2id hash parameters; %s", err.Error())
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("malformed argon2id hash salt; %s", err.Error())
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("malformed argon2id hash key; %s", err.Error())
+	}
+	params.keyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
+
+// PasswordHashReport returns the number of users whose password is hashed using each scheme;
+// users without a password are counted under the none scheme
+func PasswordHashReport(db *gorm.DB) (map[string]int64, error) {
+	rows, err := db.Raw(`SELECT CASE
+		WHEN password IS NULL THEN ?
+		WHEN password LIKE ? THEN ?
+		WHEN password LIKE '$2a$%' OR password LIKE '$2b$%' OR password LIKE '$2y$%' THEN ?
+		ELSE ? END AS scheme, COUNT(*) FROM users GROUP BY scheme`,
+		passwordHashSchemeNone,
+		fmt.Sprintf("$%s$%%", PasswordHashSchemeArgon2id),
+		PasswordHashSchemeArgon2id,
+		PasswordHashSchemeBcrypt,
+		passwordHashSchemeUnknown,
+	).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := map[string]int64{
+		PasswordHashSchemeArgon2id: 0,
+		PasswordHashSchemeBcrypt:   0,
+		passwordHashSchemeNone:     0,
+	}
+	for rows.Next() {
+		var scheme string
+		var count int64
+		err = rows.Scan(&scheme, &count)
+		if err != nil {
+			return nil, err
+		}
+		report[scheme] = count
+	}
+	return report, nil
+}
+
+// currentPasswordHash returns the persisted password hash for the user
+func (u *User) currentPasswordHash(db *gorm.DB) *string {
+	var passwords []*string
+	db.Table("users").Where("id = ?", u.ID).Pluck("password", &passwords)
+	if len(passwords) == 0 {
+		return nil
+	}
+	return passwords[0]
+}
+
+// isPasswordReused returns true if the given plaintext password matches the current password
+// hash or any of the previous password hashes retained in the user's password history
+func (u *User) isPasswordReused(db *gorm.DB, currentPasswordHash *string, password string) bool {
+	if common.PasswordHistorySize <= 0 {
+		return false
+	}
+
+	hashes := make([]string, 0)
+	if currentPasswordHash != nil {
+		hashes = append(hashes, *currentPasswordHash)
+	}
+
+	if common.PasswordHistorySize > 1 {
+		var previous []string
+		db.Table("password_history").Where("user_id = ?", u.ID).Order("created_at DESC").Limit(common.PasswordHistorySize-1).Pluck("password", &previous)
+		hashes = append(hashes, previous...)
+	}
+
+	for _, hash := range hashes {
+		if comparePassword(hash, password) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordPasswordHistory retains the given (replaced) password hash in the user's password history
+func (u *User) recordPasswordHistory(tx *gorm.DB, passwordHash string) error {
+	if common.PasswordHistorySize <= 1 {
+		return nil
+	}
+
+	result := tx.Exec("INSERT INTO password_history (created_at, user_id, password) VALUES (?, ?, ?)", time.Now(), u.ID, passwordHash)
+	if result.Error != nil {
+		return fmt.Errorf("failed to record password history for user: %s; %s", u.ID, result.Error.Error())
+	}
+
+	result = tx.Exec("DELETE FROM password_history WHERE user_id = ? AND id NOT IN (SELECT id FROM password_history WHERE user_id = ? ORDER BY created_at DESC LIMIT ?)", u.ID, u.ID, common.PasswordHistorySize-1)
+	if result.Error != nil {
+		return fmt.Errorf("failed to prune password history for user: %s; %s", u.ID, result.Error.Error())
+	}
+
+	return nil
+}
+
+// isBreachedPassword returns true if the given plaintext password appears in the configured
+// breached password hash list
+func isBreachedPassword(password string) bool {
+	if common.BreachedPasswordsPath == nil {
+		return false
+	}
+
+	digest := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(digest[:]))
+
+	path := *common.BreachedPasswordsPath
+	info, err := os.Stat(path)
+	if err != nil {
+		common.Log.Warningf("failed to screen password against breached password hash list: %s; %s", path, err.Error())
+		return false
+	}
+
+	if info.IsDir() {
+		return breachedPasswordRangeContains(path, hash)
+	}
+
+	breachedPasswordHashesOnce.Do(func() {
+		breachedPasswordHashes = loadBreachedPasswordHashes(path)
+	})
+	return breachedPasswordHashes[hash]
+}
+
+// breachedPasswordRangeContains looks up the given SHA-1 hash in the k-anonymity range file named
+// by its 5-character prefix within the given directory; each line of a range file is the hash
+// suffix and the number of times it has been seen, separated by a colon
+func breachedPasswordRangeContains(dir, hash string) bool {
+	prefix := hash[0:breachedPasswordRangePrefixLength]
+	suffix := hash[breachedPasswordRangePrefixLength:]
+
+	var file *os.File
+	for _, name := range []string{prefix, strings.ToLower(prefix), fmt.Sprintf("%s.txt", prefix), fmt.Sprintf("%s.txt", strings.ToLower(prefix))} {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err == nil {
+			file = f
+			break
+		}
+	}
+
+	if file == nil {
+		return false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.Split(strings.TrimSpace(scanner.Text()), ":")
+		if !strings.EqualFold(parts[0], suffix) {
+			continue
+		}
+		// padded range responses contain entries with a count of zero
+		return len(parts) < 2 || parts[1] != "0"
+	}
+
+	if err := scanner.Err(); err != nil {
+		common.Log.Warningf("failed to read breached password range file for prefix: %s; %s", prefix, err.Error())
+	}
+	return false
+}
+
+// loadBreachedPasswordHashes loads a file of full SHA-1 hashes, optionally suffixed by a colon
+// and the number of times each has been seen, into memory
+func loadBreachedPasswordHashes(path string) map[string]bool {
+	hashes := map[string]bool{}
+
+	file, err := os.Open(path)
+	if err != nil {
+		common.Log.Warningf("failed to load breached password hash list: %s; %s", path, err.Error())
+		return hashes
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		hash := strings.Split(strings.TrimSpace(scanner.Text()), ":")[0]
+		if len(hash) == breachedPasswordHashLength {
+			hashes[strings.ToUpper(hash)] = true
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		common.Log.Warningf("failed to read breached password hash list: %s; %s", path, err.Error())
+	}
+
+	common.Log.Debugf("loaded %d breached password hashes from %s", len(hashes), path)
+	return hashes
+}
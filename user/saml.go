@@ -0,0 +1,811 @@
+package user
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	redisutil "github.com/kthomas/go-redisutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+	"github.com/provideplatform/ident/role"
+	"github.com/provideplatform/ident/token"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+const samlAssertionNamespace = "urn:oasis:names:tc:SAML:2.0:assertion"
+const samlMetadataNamespace = "urn:oasis:names:tc:SAML:2.0:metadata"
+const samlProtocolNamespace = "urn:oasis:names:tc:SAML:2.0:protocol"
+
+const samlBearerConfirmationMethod = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+const samlHTTPPostBinding = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+const samlHTTPRedirectBinding = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+const samlNameIDFormatEmailAddress = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+const samlStatusSuccess = "urn:oasis:names:tc:SAML:2.0:status:Success"
+
+const samlAssertionKeyPrefix = "ident.saml.assertion"
+const samlClockSkew = time.Minute * 3
+const samlRequestIDLength = 20
+const samlRequestKeyPrefix = "ident.saml.request"
+const samlRequestTTL = time.Minute * 10
+const samlTimestampFormat = "2006-01-02T15:04:05Z"
+
+// errSAMLAuthenticationFailed is intentionally generic so that a refused assertion does
+// not reveal why it was refused
+var errSAMLAuthenticationFailed = errors.New("saml authentication failed")
+
+// samlEmailAttributes, samlFirstNameAttributes and samlLastNameAttributes are the commonly
+// used attribute names from which user fields are read when no attribute mapping is configured
+var samlEmailAttributes = []string{"email", "mail", "emailAddress", "urn:oid:0.9.2342.19200300.100.1.3", "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress"}
+var samlFirstNameAttributes = []string{"first_name", "firstName", "givenName", "urn:oid:2.5.4.42", "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/givenname"}
+var samlLastNameAttributes = []string{"last_name", "lastName", "sn", "surname", "urn:oid:2.5.4.4", "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/surname"}
+
+// SAMLProvider is the SAML 2.0 service provider configuration of an organization; members of the
+// organization sign in using the organization's identity provider, and are linked to an existing
+// member by email address or provisioned just-in-time and added to the organization
+type SAMLProvider struct {
+	provide.Model
+	OrganizationID   *uuid.UUID       `sql:"not null;type:uuid" json:"organization_id"`
+	IDPMetadata      *string          `sql:"not null" json:"idp_metadata"`
+	Certificate      *string          `json:"certificate,omitempty"`
+	AttributeMapping *json.RawMessage `sql:"type:json" json:"attribute_mapping,omitempty"`
+	Enabled          bool             `sql:"not null" json:"enabled"`
+
+	EntityID                    *string `sql:"-" json:"entity_id,omitempty"`
+	AssertionConsumerServiceURL *string `sql:"-" json:"acs_url,omitempty"`
+	MetadataURL                 *string `sql:"-" json:"metadata_url,omitempty"`
+}
+
+// TableName returns the db table name for gorm
+func (p *SAMLProvider) TableName() string {
+	return "saml_providers"
+}
+
+// samlAttributeMapping maps user fields to the names of the assertion attributes from which they are read
+type samlAttributeMapping struct {
+	Email     *string `json:"email"`
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+}
+
+// samlIDPMetadata is the subset of the identity provider's metadata used by ident
+type samlIDPMetadata struct {
+	EntityID     string
+	SSOURL       string
+	Certificates []*x509.Certificate
+}
+
+// samlEntityDescriptor is an identity provider metadata document
+type samlEntityDescriptor struct {
+	XMLName          xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID         string   `xml:"entityID,attr"`
+	IDPSSODescriptor *struct {
+		KeyDescriptors []struct {
+			Use          string   `xml:"use,attr"`
+			Certificates []string `xml:"KeyInfo>X509Data>X509Certificate"`
+		} `xml:"KeyDescriptor"`
+		SingleSignOnServices []struct {
+			Binding  string `xml:"Binding,attr"`
+			Location string `xml:"Location,attr"`
+		} `xml:"SingleSignOnService"`
+	} `xml:"IDPSSODescriptor"`
+}
+
+// samlResponse is a protocol response delivered to the assertion consumer service
+type samlResponse struct {
+	XMLName      xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol Response"`
+	ID           string   `xml:"ID,attr"`
+	InResponseTo string   `xml:"InResponseTo,attr"`
+	Destination  string   `xml:"Destination,attr"`
+	Issuer       string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	Status       struct {
+		StatusCode struct {
+			Value string `xml:"Value,attr"`
+		} `xml:"StatusCode"`
+	} `xml:"Status"`
+	Assertions []*samlAssertion `xml:"urn:oasis:names:tc:SAML:2.0:assertion Assertion"`
+}
+
+// samlAssertion is an assertion issued by the identity provider
+type samlAssertion struct {
+	XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:assertion Assertion"`
+	ID      string   `xml:"ID,attr"`
+	Issuer  string   `xml:"Issuer"`
+	Subject struct {
+		NameID struct {
+			Format string `xml:"Format,attr"`
+			Value  string `xml:",chardata"`
+		} `xml:"NameID"`
+		SubjectConfirmations []struct {
+			Method string `xml:"Method,attr"`
+			Data   struct {
+				InResponseTo string     `xml:"InResponseTo,attr"`
+				NotOnOrAfter *time.Time `xml:"NotOnOrAfter,attr"`
+				Recipient    string     `xml:"Recipient,attr"`
+			} `xml:"SubjectConfirmationData"`
+		} `xml:"SubjectConfirmation"`
+	} `xml:"Subject"`
+	Conditions *struct {
+		NotBefore            *time.Time `xml:"NotBefore,attr"`
+		NotOnOrAfter         *time.Time `xml:"NotOnOrAfter,attr"`
+		AudienceRestrictions []struct {
+			Audiences []string `xml:"Audience"`
+		} `xml:"AudienceRestriction"`
+	} `xml:"Conditions"`
+	Attributes []struct {
+		Name         string   `xml:"Name,attr"`
+		FriendlyName string   `xml:"FriendlyName,attr"`
+		Values       []string `xml:"AttributeValue"`
+	} `xml:"AttributeStatement>Attribute"`
+
+	// inResponseTo and expiresAt are resolved from the bearer subject confirmation
+	inResponseTo string
+	expiresAt    time.Time
+}
+
+// samlIdentity is the identity asserted by a verified assertion
+type samlIdentity struct {
+	NameID    string
+	Email     *string
+	FirstName *string
+	LastName  *string
+}
+
+// samlAuthnRequest is an authentication request delivered to the identity provider
+type samlAuthnRequest struct {
+	XMLName                     xml.Name `xml:"samlp:AuthnRequest"`
+	SAMLP                       string   `xml:"xmlns:samlp,attr"`
+	SAML                        string   `xml:"xmlns:saml,attr"`
+	ID                          string   `xml:"ID,attr"`
+	Version                     string   `xml:"Version,attr"`
+	IssueInstant                string   `xml:"IssueInstant,attr"`
+	Destination                 string   `xml:"Destination,attr"`
+	AssertionConsumerServiceURL string   `xml:"AssertionConsumerServiceURL,attr"`
+	ProtocolBinding             string   `xml:"ProtocolBinding,attr"`
+	Issuer                      string   `xml:"saml:Issuer"`
+	NameIDPolicy                struct {
+		AllowCreate bool `xml:"AllowCreate,attr"`
+	} `xml:"samlp:NameIDPolicy"`
+}
+
+// samlSPEntityDescriptor is the service provider metadata document of an organization
+type samlSPEntityDescriptor struct {
+	XMLName         xml.Name `xml:"md:EntityDescriptor"`
+	MD              string   `xml:"xmlns:md,attr"`
+	EntityID        string   `xml:"entityID,attr"`
+	SPSSODescriptor struct {
+		AuthnRequestsSigned        bool   `xml:"AuthnRequestsSigned,attr"`
+		WantAssertionsSigned       bool   `xml:"WantAssertionsSigned,attr"`
+		ProtocolSupportEnumeration string `xml:"protocolSupportEnumeration,attr"`
+		NameIDFormat               string `xml:"md:NameIDFormat"`
+		AssertionConsumerService   struct {
+			Binding   string `xml:"Binding,attr"`
+			Location  string `xml:"Location,attr"`
+			Index     int    `xml:"index,attr"`
+			IsDefault bool   `xml:"isDefault,attr"`
+		} `xml:"md:AssertionConsumerService"`
+	} `xml:"md:SPSSODescriptor"`
+}
+
+// FindSAMLProvider returns the enabled SAML service provider configuration of the given organization
+func FindSAMLProvider(db *gorm.DB, organizationID uuid.UUID) *SAMLProvider {
+	provider := &SAMLProvider{}
+	db.Where("organization_id = ? AND enabled = true", organizationID).Find(&provider)
+	if provider == nil || provider.ID == uuid.Nil {
+		return nil
+	}
+	provider.Enrich()
+	return provider
+}
+
+// Enrich the SAML provider with the service provider urls of its organization
+func (p *SAMLProvider) Enrich() {
+	p.EntityID = common.StringOrNil(p.entityID())
+	p.AssertionConsumerServiceURL = common.StringOrNil(p.acsURL())
+	p.MetadataURL = common.StringOrNil(p.entityID())
+}
+
+// entityID is the service provider entity id, which is also the url of its metadata
+func (p *SAMLProvider) entityID() string {
+	return fmt.Sprintf("%s/api/v1/saml/%s/metadata", common.SAMLServiceProviderBaseURL, p.OrganizationID)
+}
+
+// acsURL is the url of the assertion consumer service
+func (p *SAMLProvider) acsURL() string {
+	return fmt.Sprintf("%s/api/v1/saml/%s/acs", common.SAMLServiceProviderBaseURL, p.OrganizationID)
+}
+
+// Create and persist a new SAML provider
+func (p *SAMLProvider) Create(db *gorm.DB) bool {
+	if !p.validate() {
+		return false
+	}
+
+	if db.NewRecord(p) {
+		result := db.Create(&p)
+		rowsAffected := result.RowsAffected
+		errors := result.GetErrors()
+		if len(errors) > 0 {
+			for _, err := range errors {
+				p.Errors = append(p.Errors, &provide.Error{
+					Message: common.StringOrNil(err.Error()),
+				})
+			}
+		}
+		if rowsAffected > 0 && len(p.Errors) == 0 {
+			p.Enrich()
+			return true
+		}
+	}
+
+	return false
+}
+
+// Update an existing SAML provider
+func (p *SAMLProvider) Update(db *gorm.DB) bool {
+	if !p.validate() {
+		return false
+	}
+
+	result := db.Save(&p)
+	errors := result.GetErrors()
+	if len(errors) > 0 {
+		for _, err := range errors {
+			p.Errors = append(p.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+		}
+	}
+
+	if len(p.Errors) == 0 {
+		p.Enrich()
+		return true
+	}
+	return false
+}
+
+// Delete the SAML provider; linked identities are removed by cascade
+func (p *SAMLProvider) Delete(db *gorm.DB) bool {
+	result := db.Delete(&p)
+	errors := result.GetErrors()
+	if len(errors) > 0 {
+		for _, err := range errors {
+			p.Errors = append(p.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+		}
+	}
+	return len(p.Errors) == 0
+}
+
+func (p *SAMLProvider) validate() bool {
+	p.Errors = make([]*provide.Error, 0)
+
+	if p.OrganizationID == nil || *p.OrganizationID == uuid.Nil {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil("saml provider must belong to an organization"),
+		})
+	}
+
+	if p.IDPMetadata == nil || *p.IDPMetadata == "" {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil("idp_metadata is required"),
+		})
+	} else if _, err := p.idpMetadata(); err != nil {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("invalid idp_metadata; %s", err.Error())),
+		})
+	}
+
+	if p.AttributeMapping != nil {
+		_, err := p.attributeMapping()
+		if err != nil {
+			p.Errors = append(p.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("invalid attribute_mapping; %s", err.Error())),
+			})
+		}
+	}
+
+	return len(p.Errors) == 0
+}
+
+// idpMetadata parses the configured identity provider metadata; the configured certificate,
+// if any, is trusted in lieu of the signing certificates advertised in the metadata
+func (p *SAMLProvider) idpMetadata() (*samlIDPMetadata, error) {
+	if p.IDPMetadata == nil {
+		return nil, errors.New("identity provider metadata is not configured")
+	}
+
+	descriptor := &samlEntityDescriptor{}
+	err := xml.Unmarshal([]byte(*p.IDPMetadata), &descriptor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse identity provider metadata; %s", err.Error())
+	}
+
+	if descriptor.EntityID == "" {
+		return nil, errors.New("identity provider metadata has no entity id")
+	}
+	if descriptor.IDPSSODescriptor == nil {
+		return nil, errors.New("identity provider metadata has no IDPSSODescriptor")
+	}
+
+	metadata := &samlIDPMetadata{
+		EntityID:     descriptor.EntityID,
+		Certificates: make([]*x509.Certificate, 0),
+	}
+
+	for _, sso := range descriptor.IDPSSODescriptor.SingleSignOnServices {
+		if sso.Binding == samlHTTPRedirectBinding {
+			metadata.SSOURL = sso.Location
+			break
+		}
+	}
+
+	if p.Certificate != nil && *p.Certificate != "" {
+		certificate, err := parseX509Certificate(*p.Certificate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate; %s", err.Error())
+		}
+		metadata.Certificates = append(metadata.Certificates, certificate)
+	} else {
+		for _, keyDescriptor := range descriptor.IDPSSODescriptor.KeyDescriptors {
+			if keyDescriptor.Use != "" && keyDescriptor.Use != "signing" {
+				continue
+			}
+			for _, encodedCertificate := range keyDescriptor.Certificates {
+				certificate, err := parseX509Certificate(encodedCertificate)
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse identity provider signing certificate; %s", err.Error())
+				}
+				metadata.Certificates = append(metadata.Certificates, certificate)
+			}
+		}
+	}
+
+	if len(metadata.Certificates) == 0 {
+		return nil, errors.New("no identity provider signing certificate is configured")
+	}
+
+	return metadata, nil
+}
+
+// attributeMapping returns the configured attribute mapping
+func (p *SAMLProvider) attributeMapping() (*samlAttributeMapping, error) {
+	mapping := &samlAttributeMapping{}
+	if p.AttributeMapping != nil {
+		err := json.Unmarshal(*p.AttributeMapping, &mapping)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return mapping, nil
+}
+
+// Metadata returns the service provider metadata document of the organization
+func (p *SAMLProvider) Metadata() ([]byte, error) {
+	descriptor := &samlSPEntityDescriptor{
+		MD:       samlMetadataNamespace,
+		EntityID: p.entityID(),
+	}
+	descriptor.SPSSODescriptor.AuthnRequestsSigned = false
+	descriptor.SPSSODescriptor.WantAssertionsSigned = true
+	descriptor.SPSSODescriptor.ProtocolSupportEnumeration = samlProtocolNamespace
+	descriptor.SPSSODescriptor.NameIDFormat = samlNameIDFormatEmailAddress
+	descriptor.SPSSODescriptor.AssertionConsumerService.Binding = samlHTTPPostBinding
+	descriptor.SPSSODescriptor.AssertionConsumerService.Location = p.acsURL()
+	descriptor.SPSSODescriptor.AssertionConsumerService.IsDefault = true
+
+	metadata, err := xml.MarshalIndent(descriptor, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), metadata...), nil
+}
+
+// AuthnRequestURL returns the url to which the user is redirected to authenticate with the identity
+// provider (i.e., using the HTTP-Redirect binding); the request id is persisted so the response can
+// only be consumed once
+func (p *SAMLProvider) AuthnRequestURL() (*string, error) {
+	metadata, err := p.idpMetadata()
+	if err != nil {
+		return nil, err
+	}
+	if metadata.SSOURL == "" {
+		return nil, errors.New("identity provider metadata has no HTTP-Redirect single sign-on service")
+	}
+
+	secret, err := randomSecret(samlRequestIDLength)
+	if err != nil {
+		return nil, err
+	}
+	requestID := fmt.Sprintf("_%s", secret)
+
+	authnRequestURL, err := p.authnRequestURL(metadata, requestID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := samlRequestTTL
+	err = redisutil.Set(fmt.Sprintf("%s.%s", samlRequestKeyPrefix, requestID), p.ID.String(), &ttl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to persist saml request; %s", err.Error())
+	}
+
+	return authnRequestURL, nil
+}
+
+func (p *SAMLProvider) authnRequestURL(metadata *samlIDPMetadata, requestID string, issueInstant time.Time) (*string, error) {
+	authnRequest := &samlAuthnRequest{
+		SAMLP:                       samlProtocolNamespace,
+		SAML:                        samlAssertionNamespace,
+		ID:                          requestID,
+		Version:                     "2.0",
+		IssueInstant:                issueInstant.UTC().Format(samlTimestampFormat),
+		Destination:                 metadata.SSOURL,
+		AssertionConsumerServiceURL: p.acsURL(),
+		ProtocolBinding:             samlHTTPPostBinding,
+		Issuer:                      p.entityID(),
+	}
+	authnRequest.NameIDPolicy.AllowCreate = true
+
+	raw, err := xml.Marshal(authnRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	deflated := &bytes.Buffer{}
+	writer, _ := flate.NewWriter(deflated, flate.DefaultCompression)
+	writer.Write(raw)
+	writer.Close()
+
+	ssoURL, err := url.Parse(metadata.SSOURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity provider single sign-on service url; %s", err.Error())
+	}
+
+	query := ssoURL.Query()
+	query.Set("SAMLRequest", base64.StdEncoding.EncodeToString(deflated.Bytes()))
+	ssoURL.RawQuery = query.Encode()
+
+	return common.StringOrNil(ssoURL.String()), nil
+}
+
+// consumeSAMLRequest returns true if the given request was issued on behalf of the provider
+// and has not previously been consumed
+func (p *SAMLProvider) consumeSAMLRequest(requestID string) bool {
+	providerID, err := redisGetDel(fmt.Sprintf("%s.%s", samlRequestKeyPrefix, requestID))
+	if err != nil || providerID == nil {
+		return false
+	}
+	return *providerID == p.ID.String()
+}
+
+// verifyResponse verifies the given base64-encoded response and returns its assertion; either the
+// response or the assertion must be signed by the identity provider, and only the signed content
+// is consumed, so content injected alongside a signed element is never trusted
+func (p *SAMLProvider) verifyResponse(encodedResponse string, now time.Time) (*samlAssertion, error) {
+	metadata, err := p.idpMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := decodeXMLBase64(encodedResponse)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode saml response; %s", err.Error())
+	}
+
+	root, err := parseXMLDocument(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse saml response; %s", err.Error())
+	}
+
+	if !xmlElementIs(root, samlProtocolNamespace, "Response") {
+		return nil, errors.New("saml response is not a protocol response")
+	}
+	if len(xmlChildElements(root, samlAssertionNamespace, "EncryptedAssertion")) > 0 {
+		return nil, errors.New("encrypted saml assertions are not supported")
+	}
+
+	assertions := xmlChildElements(root, samlAssertionNamespace, "Assertion")
+	if len(assertions) != 1 {
+		return nil, errors.New("saml response must contain exactly one assertion")
+	}
+
+	response := &samlResponse{}
+	verifiedResponse, err := verifyXMLSignature(root, metadata.Certificates)
+	if err == nil {
+		err = xml.Unmarshal(verifiedResponse, &response)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal saml response; %s", err.Error())
+		}
+	} else if err == errXMLSignatureMissing {
+		verifiedAssertion, err := verifyXMLSignature(assertions[0], metadata.Certificates)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify saml assertion signature; %s", err.Error())
+		}
+
+		err = xml.Unmarshal(raw, &response)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal saml response; %s", err.Error())
+		}
+
+		assertion := &samlAssertion{}
+		err = xml.Unmarshal(verifiedAssertion, &assertion)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal saml assertion; %s", err.Error())
+		}
+		response.Assertions = []*samlAssertion{assertion}
+	} else {
+		return nil, fmt.Errorf("failed to verify saml response signature; %s", err.Error())
+	}
+
+	if len(response.Assertions) != 1 {
+		return nil, errors.New("saml response must contain exactly one assertion")
+	}
+	assertion := response.Assertions[0]
+
+	if response.Status.StatusCode.Value != samlStatusSuccess {
+		return nil, fmt.Errorf("identity provider returned saml status: %s", response.Status.StatusCode.Value)
+	}
+
+	if response.Destination != "" && response.Destination != p.acsURL() {
+		return nil, fmt.Errorf("saml response destination mismatch: %s", response.Destination)
+	}
+
+	if response.Issuer != "" && response.Issuer != metadata.EntityID {
+		return nil, fmt.Errorf("saml response issuer mismatch: %s", response.Issuer)
+	}
+
+	err = p.validateAssertion(assertion, metadata, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.InResponseTo != "" && assertion.inResponseTo != "" && response.InResponseTo != assertion.inResponseTo {
+		return nil, errors.New("saml response InResponseTo mismatch")
+	}
+	if assertion.inResponseTo == "" {
+		assertion.inResponseTo = response.InResponseTo
+	}
+
+	return assertion, nil
+}
+
+// validateAssertion validates the issuer, conditions and subject confirmation of the given assertion
+func (p *SAMLProvider) validateAssertion(assertion *samlAssertion, metadata *samlIDPMetadata, now time.Time) error {
+	if assertion.ID == "" {
+		return errors.New("saml assertion has no id")
+	}
+
+	if strings.TrimSpace(assertion.Issuer) != metadata.EntityID {
+		return fmt.Errorf("saml assertion issuer mismatch: %s", assertion.Issuer)
+	}
+
+	if strings.TrimSpace(assertion.Subject.NameID.Value) == "" {
+		return errors.New("saml assertion has no subject")
+	}
+
+	if assertion.Conditions == nil {
+		return errors.New("saml assertion has no conditions")
+	}
+
+	if assertion.Conditions.NotBefore != nil && now.Add(samlClockSkew).Before(*assertion.Conditions.NotBefore) {
+		return errors.New("saml assertion is not yet valid")
+	}
+
+	if assertion.Conditions.NotOnOrAfter != nil && !now.Add(-samlClockSkew).Before(*assertion.Conditions.NotOnOrAfter) {
+		return errors.New("saml assertion has expired")
+	}
+
+	audienceOk := false
+	for _, restriction := range assertion.Conditions.AudienceRestrictions {
+		for _, audience := range restriction.Audiences {
+			if strings.TrimSpace(audience) == p.entityID() {
+				audienceOk = true
+			}
+		}
+	}
+	if !audienceOk {
+		return errors.New("saml assertion audience mismatch")
+	}
+
+	for _, confirmation := range assertion.Subject.SubjectConfirmations {
+		if confirmation.Method != samlBearerConfirmationMethod {
+			continue
+		}
+		if confirmation.Data.Recipient != p.acsURL() {
+			continue
+		}
+		if confirmation.Data.NotOnOrAfter == nil || !now.Add(-samlClockSkew).Before(*confirmation.Data.NotOnOrAfter) {
+			continue
+		}
+
+		assertion.inResponseTo = confirmation.Data.InResponseTo
+		assertion.expiresAt = confirmation.Data.NotOnOrAfter.Add(samlClockSkew)
+		return nil
+	}
+
+	return errors.New("saml assertion has no valid bearer subject confirmation")
+}
+
+// consumeAssertion returns true if the assertion has not previously been consumed; consumed assertion
+// ids are retained until the assertion expires, so an intercepted response cannot be replayed
+func (p *SAMLProvider) consumeAssertion(assertion *samlAssertion) bool {
+	key := fmt.Sprintf("%s.%s.%s", samlAssertionKeyPrefix, p.ID, common.SHA256(assertion.ID))
+	ttl := time.Until(assertion.expiresAt)
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+
+	consumed, err := redisSetNX(key, time.Now().String(), ttl)
+	if err != nil {
+		common.Log.Warningf("failed to record consumption of saml assertion; %s", err.Error())
+		return false
+	}
+	return consumed
+}
+
+// identity resolves the identity asserted by the given verified assertion using the attribute mapping
+func (p *SAMLProvider) identity(assertion *samlAssertion) (*samlIdentity, error) {
+	mapping, err := p.attributeMapping()
+	if err != nil {
+		return nil, err
+	}
+
+	attribute := func(mapped *string, defaults []string) *string {
+		names := defaults
+		if mapped != nil && *mapped != "" {
+			names = []string{*mapped}
+		}
+		for _, name := range names {
+			for _, attr := range assertion.Attributes {
+				if (attr.Name == name || attr.FriendlyName == name) && len(attr.Values) > 0 {
+					if val := strings.TrimSpace(attr.Values[0]); val != "" {
+						return common.StringOrNil(val)
+					}
+				}
+			}
+		}
+		return nil
+	}
+
+	nameID := strings.TrimSpace(assertion.Subject.NameID.Value)
+	identity := &samlIdentity{
+		NameID:    nameID,
+		Email:     attribute(mapping.Email, samlEmailAttributes),
+		FirstName: attribute(mapping.FirstName, samlFirstNameAttributes),
+		LastName:  attribute(mapping.LastName, samlLastNameAttributes),
+	}
+
+	if identity.Email == nil && (assertion.Subject.NameID.Format == samlNameIDFormatEmailAddress || strings.Contains(nameID, "@")) {
+		identity.Email = common.StringOrNil(nameID)
+	}
+
+	if identity.Email != nil {
+		identity.Email = common.StringOrNil(strings.ToLower(*identity.Email))
+	}
+
+	return identity, nil
+}
+
+// AuthenticateSAMLUser authenticates the member of the given organization using the given
+// base64-encoded SAML response delivered to the organization's assertion consumer service
+func AuthenticateSAMLUser(db *gorm.DB, organizationID uuid.UUID, encodedResponse string, scope *string, session *token.Session) (*AuthenticationResponse, error) {
+	provider := FindSAMLProvider(db, organizationID)
+	if provider == nil {
+		return nil, errors.New("saml is not configured for organization")
+	}
+
+	assertion, err := provider.verifyResponse(encodedResponse, time.Now())
+	if err != nil {
+		common.Log.Warningf("saml authentication failed for organization: %s; %s", organizationID, err.Error())
+		return nil, errSAMLAuthenticationFailed
+	}
+
+	if assertion.inResponseTo != "" && !provider.consumeSAMLRequest(assertion.inResponseTo) {
+		common.Log.Warningf("saml authentication failed for organization: %s; unsolicited or previously consumed request: %s", organizationID, assertion.inResponseTo)
+		return nil, errSAMLAuthenticationFailed
+	}
+
+	if !provider.consumeAssertion(assertion) {
+		common.Log.Warningf("saml authentication failed for organization: %s; assertion replayed: %s", organizationID, assertion.ID)
+		return nil, errSAMLAuthenticationFailed
+	}
+
+	identity, err := provider.identity(assertion)
+	if err != nil {
+		common.Log.Warningf("saml authentication failed for organization: %s; %s", organizationID, err.Error())
+		return nil, errSAMLAuthenticationFailed
+	}
+
+	tx := db.Begin()
+	defer tx.RollbackUnlessCommitted()
+
+	user, err := provider.resolveUser(tx, identity)
+	if err != nil {
+		common.Log.Warningf("saml authentication failed for organization: %s; %s", organizationID, err.Error())
+		return nil, err
+	}
+
+	if !user.hasPermission(common.Authenticate) {
+		common.Log.Debugf("saml authentication failed for user: %s; revoked authenticate permission", user.ID)
+		return nil, errSAMLAuthenticationFailed
+	}
+
+	if user.IsDisabled() {
+		common.Log.Debugf("saml authentication failed for user: %s; user has been suspended", user.ID)
+		return nil, errUserSuspended
+	}
+
+	if user.IsExpired() {
+		return nil, errUserExpired
+	}
+
+	tx.Commit()
+
+	return user.vendFederatedToken(db, nil, &organizationID, scope, session)
+}
+
+// resolveUser returns the user linked to the given asserted identity, provisioning the user when
+// the identity has not previously been used to authenticate; an existing user with the asserted
+// email address is never linked implicitly, and must confirm the staged link from its account,
+// so an organization's identity provider cannot take over existing accounts
+func (p *SAMLProvider) resolveUser(tx *gorm.DB, identity *samlIdentity) (*User, error) {
+	user := &User{}
+	tx.Joins("JOIN saml_identities ON saml_identities.user_id = users.id").
+		Where("saml_identities.saml_provider_id = ? AND saml_identities.name_id = ?", p.ID, identity.NameID).
+		Find(&user)
+
+	if user == nil || user.ID == uuid.Nil {
+		if identity.Email == nil {
+			return nil, errors.New("saml authentication failed; identity provider did not assert an email address")
+		}
+
+		user = FindByEmail(*identity.Email, nil, nil)
+		if user != nil {
+			if FindByEmail(*identity.Email, nil, p.OrganizationID) == nil {
+				return nil, errors.New("saml authentication failed; a user with the asserted email address exists but is not a member of the organization")
+			}
+			common.Log.Debugf("staging link of user %s to saml provider: %s", user.ID, p.ID)
+			return nil, stageIdentityLink(&pendingIdentityLink{
+				UserID:         user.ID,
+				SAMLProviderID: &p.ID,
+				Subject:        identity.NameID,
+			})
+		}
+
+		if !organizationHasVerifiedEmailDomain(tx, *p.OrganizationID, *identity.Email) {
+			return nil, errors.New("saml authentication failed; the organization has not verified the domain of the asserted email address")
+		}
+
+		var err error
+		user, err = provisionFederatedUser(tx, nil, *identity.Email, identity.FirstName, identity.LastName)
+		if err != nil {
+			return nil, err
+		}
+		common.Log.Debugf("provisioned user %s using saml provider: %s", user.ID, p.ID)
+
+		result := tx.Exec("INSERT INTO saml_identities (created_at, user_id, saml_provider_id, name_id) VALUES (?, ?, ?, ?)", time.Now(), user.ID, p.ID, identity.NameID)
+		if result.Error != nil {
+			return nil, fmt.Errorf("failed to link user to saml provider; %s", result.Error.Error())
+		}
+	}
+
+	// the identity provider is authoritative for membership of the organization
+	var memberships int
+	tx.Table("organizations_users").Where("organization_id = ? AND user_id = ?", p.OrganizationID, user.ID).Count(&memberships)
+	if memberships == 0 && !user.addOrganizationAssociation(tx, *p.OrganizationID, role.MemberPermission, role.BuiltinID(tx, role.Member)) {
+		return nil, errors.New("failed to associate saml user with organization")
+	}
+
+	return user, nil
+}
@@ -0,0 +1,365 @@
+package user
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const mockSAMLIDPEntityID = "https://idp.example.com/saml"
+const mockSAMLIDPSSOURL = "https://idp.example.com/saml/sso?tenant=acme"
+
+// mockSAMLIDP is an identity provider which issues responses signed with a self-signed certificate
+type mockSAMLIDP struct {
+	key         *rsa.PrivateKey
+	certificate []byte
+}
+
+func newMockSAMLIDP(t *testing.T) *mockSAMLIDP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate mock identity provider signing key; %s", err.Error())
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to generate mock identity provider certificate; %s", err.Error())
+	}
+
+	return &mockSAMLIDP{
+		key:         key,
+		certificate: certificate,
+	}
+}
+
+func (m *mockSAMLIDP) metadata() string {
+	return fmt.Sprintf(`<?xml version="1.0"?>
+<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" xmlns:ds="http://www.w3.org/2000/09/xmldsig#" entityID="%s">
+  <md:IDPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <md:KeyDescriptor use="signing">
+      <ds:KeyInfo><ds:X509Data><ds:X509Certificate>%s</ds:X509Certificate></ds:X509Data></ds:KeyInfo>
+    </md:KeyDescriptor>
+    <md:SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="%s"/>
+  </md:IDPSSODescriptor>
+</md:EntityDescriptor>`, mockSAMLIDPEntityID, base64.StdEncoding.EncodeToString(m.certificate), strings.Replace(mockSAMLIDPSSOURL, "&", "&amp;", -1))
+}
+
+func (m *mockSAMLIDP) samlProvider() *SAMLProvider {
+	organizationID, _ := uuid.NewV4()
+	providerID, _ := uuid.NewV4()
+	provider := &SAMLProvider{
+		OrganizationID: &organizationID,
+		IDPMetadata:    common.StringOrNil(m.metadata()),
+		Enabled:        true,
+	}
+	provider.ID = providerID
+	return provider
+}
+
+// assertion returns an assertion issued to the given provider; the signature placeholder
+// is where an enveloped signature is inserted
+func (m *mockSAMLIDP) assertion(provider *SAMLProvider, id, audience, recipient string, notOnOrAfter time.Time) string {
+	return fmt.Sprintf(`<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" ID="%s" Version="2.0" IssueInstant="%s">
+    <saml:Issuer>%s</saml:Issuer>{{signature}}
+    <saml:Subject>
+      <saml:NameID Format="urn:oasis:names:tc:SAML:2.0:nameid-format:persistent">employee-1234</saml:NameID>
+      <saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">
+        <saml:SubjectConfirmationData InResponseTo="_request" NotOnOrAfter="%s" Recipient="%s"/>
+      </saml:SubjectConfirmation>
+    </saml:Subject>
+    <saml:Conditions NotBefore="%s" NotOnOrAfter="%s">
+      <saml:AudienceRestriction><saml:Audience>%s</saml:Audience></saml:AudienceRestriction>
+    </saml:Conditions>
+    <saml:AttributeStatement>
+      <saml:Attribute Name="urn:oid:0.9.2342.19200300.100.1.3" FriendlyName="mail"><saml:AttributeValue xsi:type="xs:string">Employee@Example.com</saml:AttributeValue></saml:Attribute>
+      <saml:Attribute Name="givenName"><saml:AttributeValue xsi:type="xs:string">Jo &amp; Co</saml:AttributeValue></saml:Attribute>
+      <saml:Attribute Name="department"><saml:AttributeValue xsi:type="xs:string">Engineering</saml:AttributeValue></saml:Attribute>
+    </saml:AttributeStatement>
+  </saml:Assertion>`,
+		id,
+		time.Now().UTC().Format(samlTimestampFormat),
+		mockSAMLIDPEntityID,
+		notOnOrAfter.UTC().Format(samlTimestampFormat),
+		recipient,
+		time.Now().Add(-time.Minute).UTC().Format(samlTimestampFormat),
+		notOnOrAfter.UTC().Format(samlTimestampFormat),
+		audience,
+	)
+}
+
+// response wraps the given assertion(s) in a protocol response
+func (m *mockSAMLIDP) response(provider *SAMLProvider, assertions ...string) string {
+	return fmt.Sprintf(`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_response" InResponseTo="_request" Version="2.0" Destination="%s">
+  <saml:Issuer>%s</saml:Issuer>{{signature}}
+  <samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>
+  %s
+</samlp:Response>`, provider.acsURL(), mockSAMLIDPEntityID, strings.Join(assertions, "\n  "))
+}
+
+// GetKeyPair returns the signing key and certificate of the identity provider
+func (m *mockSAMLIDP) GetKeyPair() (*rsa.PrivateKey, []byte, error) {
+	return m.key, m.certificate, nil
+}
+
+// sign signs the element having the given id within the given document using an enveloped signature
+func (m *mockSAMLIDP) sign(t *testing.T, doc, id string) string {
+	return m.signWithSignatureMethod(t, doc, id, dsig.RSASHA256SignatureMethod)
+}
+
+func (m *mockSAMLIDP) signWithSignatureMethod(t *testing.T, doc, id, signatureMethod string) string {
+	document := etree.NewDocument()
+	err := document.ReadFromString(strings.Replace(doc, "{{signature}}", "", -1))
+	if err != nil {
+		t.Fatalf("failed to parse document to sign; %s", err.Error())
+	}
+
+	el := document.FindElement(fmt.Sprintf("//[@ID='%s']", id))
+	if el == nil {
+		t.Fatalf("failed to find element to sign: %s", id)
+	}
+
+	ctx := dsig.NewDefaultSigningContext(m)
+	ctx.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	err = ctx.SetSignatureMethod(signatureMethod)
+	if err != nil {
+		t.Fatalf("failed to set signature method; %s", err.Error())
+	}
+	signed, err := ctx.SignEnveloped(el)
+	if err != nil {
+		t.Fatalf("failed to sign document; %s", err.Error())
+	}
+
+	if parent := el.Parent(); parent != nil && parent != &document.Element {
+		parent.InsertChildAt(el.Index(), signed)
+		parent.RemoveChild(el)
+	} else {
+		document.SetRoot(signed)
+	}
+
+	signedDoc, err := document.WriteToString()
+	if err != nil {
+		t.Fatalf("failed to serialize signed document; %s", err.Error())
+	}
+	return signedDoc
+}
+
+func encodeSAMLResponse(response string) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Replace(response, "{{signature}}", "", -1)))
+}
+
+func TestParseXMLDocumentRefusesDirectives(t *testing.T) {
+	_, err := parseXMLDocument([]byte(`<!DOCTYPE root [<!ENTITY x "y">]><root>&x;</root>`))
+	if err == nil {
+		t.Error("expected document with a DTD to be refused")
+	}
+}
+
+func TestSAMLVerifySignedAssertion(t *testing.T) {
+	idp := newMockSAMLIDP(t)
+	provider := idp.samlProvider()
+
+	assertion := idp.sign(t, idp.assertion(provider, "_assertion", provider.entityID(), provider.acsURL(), time.Now().Add(time.Minute*5)), "_assertion")
+	verified, err := provider.verifyResponse(encodeSAMLResponse(idp.response(provider, assertion)), time.Now())
+	if err != nil {
+		t.Fatalf("failed to verify signed assertion; %s", err.Error())
+	}
+
+	if verified.inResponseTo != "_request" {
+		t.Errorf("expected assertion in response to _request; got %s", verified.inResponseTo)
+	}
+
+	identity, err := provider.identity(verified)
+	if err != nil {
+		t.Fatalf("failed to resolve identity; %s", err.Error())
+	}
+
+	if identity.NameID != "employee-1234" {
+		t.Errorf("expected name id employee-1234; got %s", identity.NameID)
+	}
+	if identity.Email == nil || *identity.Email != "employee@example.com" {
+		t.Errorf("expected normalized email address employee@example.com; got %v", identity.Email)
+	}
+	if identity.FirstName == nil || *identity.FirstName != "Jo & Co" {
+		t.Errorf("expected first name Jo & Co; got %v", identity.FirstName)
+	}
+}
+
+func TestSAMLVerifyAssertionIgnoresInjectedComments(t *testing.T) {
+	idp := newMockSAMLIDP(t)
+	provider := idp.samlProvider()
+
+	// comments are excluded from the signed canonical form, so a comment injected into a signed
+	// value must not truncate the value consumed
+	assertion := strings.Replace(idp.assertion(provider, "_assertion", provider.entityID(), provider.acsURL(), time.Now().Add(time.Minute*5)), "Employee@Example.com", "Employee@Example.com.attacker.io", 1)
+	signed := strings.Replace(idp.sign(t, assertion, "_assertion"), "Employee@Example.com.attacker.io", "Employee@Example.com<!---->.attacker.io", 1)
+
+	verified, err := provider.verifyResponse(encodeSAMLResponse(idp.response(provider, signed)), time.Now())
+	if err != nil {
+		t.Fatalf("failed to verify signed assertion; %s", err.Error())
+	}
+
+	identity, _ := provider.identity(verified)
+	if identity.Email == nil || *identity.Email != "employee@example.com.attacker.io" {
+		t.Errorf("expected email address employee@example.com.attacker.io; got %v", identity.Email)
+	}
+}
+
+func TestSAMLVerifySignedResponse(t *testing.T) {
+	idp := newMockSAMLIDP(t)
+	provider := idp.samlProvider()
+
+	assertion := idp.assertion(provider, "_assertion", provider.entityID(), provider.acsURL(), time.Now().Add(time.Minute*5))
+	response := idp.sign(t, idp.response(provider, assertion), "_response")
+
+	_, err := provider.verifyResponse(encodeSAMLResponse(response), time.Now())
+	if err != nil {
+		t.Fatalf("failed to verify signed response; %s", err.Error())
+	}
+}
+
+func TestSAMLAttributeMapping(t *testing.T) {
+	idp := newMockSAMLIDP(t)
+	provider := idp.samlProvider()
+	mapping := []byte(`{"last_name": "department"}`)
+	provider.AttributeMapping = (*json.RawMessage)(&mapping)
+
+	assertion := idp.sign(t, idp.assertion(provider, "_assertion", provider.entityID(), provider.acsURL(), time.Now().Add(time.Minute*5)), "_assertion")
+	verified, err := provider.verifyResponse(encodeSAMLResponse(idp.response(provider, assertion)), time.Now())
+	if err != nil {
+		t.Fatalf("failed to verify signed assertion; %s", err.Error())
+	}
+
+	identity, _ := provider.identity(verified)
+	if identity.LastName == nil || *identity.LastName != "Engineering" {
+		t.Errorf("expected mapped last name Engineering; got %v", identity.LastName)
+	}
+}
+
+func TestSAMLVerifyResponseRejectsInvalidAssertions(t *testing.T) {
+	idp := newMockSAMLIDP(t)
+	otherIDP := newMockSAMLIDP(t)
+	provider := idp.samlProvider()
+	valid := time.Now().Add(time.Minute * 5)
+
+	signedAssertion := idp.sign(t, idp.assertion(provider, "_assertion", provider.entityID(), provider.acsURL(), valid), "_assertion")
+
+	// the signature of the signed assertion is copied into a forged assertion having the same id,
+	// and the signed assertion is hidden elsewhere in the response
+	signature := signedAssertion[strings.Index(signedAssertion, "<ds:Signature") : strings.Index(signedAssertion, "</ds:Signature>")+len("</ds:Signature>")]
+	wrappedAssertion := strings.Replace(idp.assertion(provider, "_assertion", provider.entityID(), provider.acsURL(), valid), "{{signature}}", signature, 1)
+	wrappedAssertion = strings.Replace(wrappedAssertion, "Employee@Example.com", "ceo@example.com", 1)
+
+	tt := []struct {
+		name     string
+		response string
+	}{
+		{"unsigned", idp.response(provider, idp.assertion(provider, "_assertion", provider.entityID(), provider.acsURL(), valid))},
+		{"untrusted signing certificate", idp.response(provider, otherIDP.sign(t, idp.assertion(provider, "_assertion", provider.entityID(), provider.acsURL(), valid), "_assertion"))},
+		{"tampered", idp.response(provider, strings.Replace(signedAssertion, "Employee@Example.com", "ceo@example.com", 1))},
+		{"audience mismatch", idp.response(provider, idp.sign(t, idp.assertion(provider, "_assertion", "https://other.example.com", provider.acsURL(), valid), "_assertion"))},
+		{"recipient mismatch", idp.response(provider, idp.sign(t, idp.assertion(provider, "_assertion", provider.entityID(), "https://other.example.com/acs", valid), "_assertion"))},
+		{"expired", idp.response(provider, idp.sign(t, idp.assertion(provider, "_assertion", provider.entityID(), provider.acsURL(), time.Now().Add(-time.Minute*5)), "_assertion"))},
+		{"injected assertion", idp.response(provider, idp.assertion(provider, "_injected", provider.entityID(), provider.acsURL(), valid), signedAssertion)},
+		{"wrapped", idp.response(provider, fmt.Sprintf("<samlp:Extensions>%s</samlp:Extensions>", signedAssertion), wrappedAssertion)},
+		{"namespace injected", idp.response(provider, strings.Replace(signedAssertion, "<saml:Subject>", `<saml:Subject xmlns:saml="urn:injected">`, 1))},
+		{"sha1 signature", idp.response(provider, idp.signWithSignatureMethod(t, idp.assertion(provider, "_assertion", provider.entityID(), provider.acsURL(), valid), "_assertion", dsig.RSASHA1SignatureMethod))},
+	}
+
+	for _, tc := range tt {
+		_, err := provider.verifyResponse(encodeSAMLResponse(tc.response), time.Now())
+		if err == nil {
+			t.Errorf("expected verification to fail with %s assertion", tc.name)
+		}
+	}
+}
+
+func TestSAMLAuthnRequestURL(t *testing.T) {
+	idp := newMockSAMLIDP(t)
+	provider := idp.samlProvider()
+
+	metadata, err := provider.idpMetadata()
+	if err != nil {
+		t.Fatalf("failed to parse identity provider metadata; %s", err.Error())
+	}
+
+	authnRequestURL, err := provider.authnRequestURL(metadata, "_request", time.Now())
+	if err != nil {
+		t.Fatalf("failed to resolve authn request url; %s", err.Error())
+	}
+
+	parsedURL, _ := url.Parse(*authnRequestURL)
+	if parsedURL.Host != "idp.example.com" || parsedURL.Query().Get("tenant") != "acme" {
+		t.Errorf("expected authn request url to use the single sign-on service url; got %s", *authnRequestURL)
+	}
+
+	deflated, _ := base64.StdEncoding.DecodeString(parsedURL.Query().Get("SAMLRequest"))
+	raw, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		t.Fatalf("failed to inflate authn request; %s", err.Error())
+	}
+
+	authnRequest := struct {
+		XMLName                     xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+		ID                          string   `xml:"ID,attr"`
+		AssertionConsumerServiceURL string   `xml:"AssertionConsumerServiceURL,attr"`
+		Issuer                      string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	}{}
+	err = xml.Unmarshal(raw, &authnRequest)
+	if err != nil {
+		t.Fatalf("failed to unmarshal authn request; %s", err.Error())
+	}
+
+	if authnRequest.ID != "_request" || authnRequest.AssertionConsumerServiceURL != provider.acsURL() || authnRequest.Issuer != provider.entityID() {
+		t.Errorf("unexpected authn request: %s", string(raw))
+	}
+}
+
+func TestSAMLMetadata(t *testing.T) {
+	idp := newMockSAMLIDP(t)
+	provider := idp.samlProvider()
+
+	metadata, err := provider.Metadata()
+	if err != nil {
+		t.Fatalf("failed to generate service provider metadata; %s", err.Error())
+	}
+
+	descriptor := struct {
+		XMLName  xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+		EntityID string   `xml:"entityID,attr"`
+		ACS      struct {
+			Location string `xml:"Location,attr"`
+		} `xml:"SPSSODescriptor>AssertionConsumerService"`
+	}{}
+	err = xml.Unmarshal(metadata, &descriptor)
+	if err != nil {
+		t.Fatalf("failed to unmarshal service provider metadata; %s", err.Error())
+	}
+
+	if descriptor.EntityID != provider.entityID() || descriptor.ACS.Location != provider.acsURL() {
+		t.Errorf("unexpected service provider metadata: %s", string(metadata))
+	}
+}
@@ -0,0 +1,1265 @@
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/badoux/checkmail"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+	"github.com/provideplatform/ident/role"
+	"github.com/provideplatform/ident/token"
+)
+
+const scimAPIPath = "/scim/v2"
+const scimContentType = "application/scim+json"
+const scimDefaultCount = 100
+const scimMaxCount = 1000
+const scimMaxFilterLength = 1024
+
+// scimDeprovisionedReason is the reason recorded for users suspended by a provisioning client;
+// only such suspensions can be lifted by the organization
+const scimDeprovisionedReason = "scim_deprovisioned"
+
+const scimErrorSchema = "urn:ietf:params:scim:api:messages:2.0:Error"
+const scimGroupSchema = "urn:ietf:params:scim:schemas:core:2.0:Group"
+const scimListResponseSchema = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+const scimServiceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+const scimUserSchema = "urn:ietf:params:scim:schemas:core:2.0:User"
+
+const scimErrorInvalidFilter = "invalidFilter"
+const scimErrorInvalidPath = "invalidPath"
+const scimErrorInvalidSyntax = "invalidSyntax"
+const scimErrorInvalidValue = "invalidValue"
+const scimErrorMutability = "mutability"
+const scimErrorUniqueness = "uniqueness"
+
+// scimMembersFilterPath matches the value filter used to address a single group member
+var scimMembersFilterPath = regexp.MustCompile(`(?i)^members\[\s*value\s+eq\s+"([^"]+)"\s*\]$`)
+
+// SCIMError is the SCIM 2.0 representation of an error response
+type SCIMError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType *string  `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+
+	status int
+}
+
+// Error implements the error interface
+func (e *SCIMError) Error() string {
+	return e.Detail
+}
+
+func newSCIMError(status int, scimType, detail string) *SCIMError {
+	return &SCIMError{
+		Schemas:  []string{scimErrorSchema},
+		Status:   fmt.Sprintf("%d", status),
+		ScimType: common.StringOrNil(scimType),
+		Detail:   detail,
+		status:   status,
+	}
+}
+
+// SCIMMeta is the SCIM 2.0 resource metadata
+type SCIMMeta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	Location     string     `json:"location"`
+}
+
+// SCIMName is the SCIM 2.0 representation of the components of a user's name
+type SCIMName struct {
+	Formatted  *string `json:"formatted,omitempty"`
+	GivenName  *string `json:"givenName,omitempty"`
+	FamilyName *string `json:"familyName,omitempty"`
+}
+
+// SCIMMultiValuedAttribute is the SCIM 2.0 representation of a single value of
+// a multi-valued attribute (i.e., emails, groups or members)
+type SCIMMultiValuedAttribute struct {
+	Value   string  `json:"value"`
+	Display *string `json:"display,omitempty"`
+	Type    *string `json:"type,omitempty"`
+	Primary *bool   `json:"primary,omitempty"`
+	Ref     *string `json:"$ref,omitempty"`
+}
+
+// SCIMUser is the SCIM 2.0 representation of a user provisioned into an organization
+type SCIMUser struct {
+	Schemas     []string                    `json:"schemas"`
+	ID          string                      `json:"id,omitempty"`
+	ExternalID  *string                     `json:"externalId,omitempty"`
+	UserName    string                      `json:"userName"`
+	Name        *SCIMName                   `json:"name,omitempty"`
+	DisplayName *string                     `json:"displayName,omitempty"`
+	Emails      []*SCIMMultiValuedAttribute `json:"emails,omitempty"`
+	Active      *bool                       `json:"active,omitempty"`
+	Groups      []*SCIMMultiValuedAttribute `json:"groups,omitempty"`
+	Meta        *SCIMMeta                   `json:"meta,omitempty"`
+}
+
+// SCIMGroup is the SCIM 2.0 representation of an organization and its members
+type SCIMGroup struct {
+	Schemas     []string                    `json:"schemas"`
+	ID          string                      `json:"id,omitempty"`
+	DisplayName string                      `json:"displayName"`
+	Members     []*SCIMMultiValuedAttribute `json:"members,omitempty"`
+	Meta        *SCIMMeta                   `json:"meta,omitempty"`
+}
+
+// SCIMListResponse is the SCIM 2.0 representation of a page of query results
+type SCIMListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+// SCIMPatchRequest is the SCIM 2.0 PATCH request message
+type SCIMPatchRequest struct {
+	Schemas    []string              `json:"schemas"`
+	Operations []*SCIMPatchOperation `json:"Operations"`
+}
+
+// SCIMPatchOperation is a single add, replace or remove operation of a PATCH request
+type SCIMPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  *string     `json:"path,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// scimServiceProviderConfig advertises the SCIM features supported by ident
+func scimServiceProviderConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"schemas":        []string{scimServiceProviderConfigSchema},
+		"patch":          map[string]interface{}{"supported": true},
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": scimMaxCount},
+		"changePassword": map[string]interface{}{"supported": false},
+		"sort":           map[string]interface{}{"supported": false},
+		"etag":           map[string]interface{}{"supported": false},
+		"authenticationSchemes": []map[string]interface{}{
+			{
+				"type":        "oauthbearertoken",
+				"name":        "OAuth Bearer Token",
+				"description": "Authentication using an organization-scoped bearer token",
+				"primary":     true,
+			},
+		},
+		"meta": &SCIMMeta{
+			ResourceType: "ServiceProviderConfig",
+			Location:     fmt.Sprintf("%s/ServiceProviderConfig", scimAPIPath),
+		},
+	}
+}
+
+// scimOrganization scopes SCIM provisioning to the organization asserted by an organization bearer token;
+// the users of the organization are its members and the organization itself is exposed as a single group
+type scimOrganization struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	Name      string
+	UserID    *uuid.UUID
+}
+
+// findSCIMOrganization returns the enabled organization with the given id
+func findSCIMOrganization(db *gorm.DB, organizationID uuid.UUID) *scimOrganization {
+	org := &scimOrganization{}
+	db.Table("organizations").
+		Select("id, created_at, name, user_id").
+		Where("id = ? AND enabled = true", organizationID).
+		Scan(org)
+	if org.ID == uuid.Nil {
+		return nil
+	}
+	return org
+}
+
+// usersQuery returns a query scoped to the members of the organization
+func (o *scimOrganization) usersQuery(db *gorm.DB) *gorm.DB {
+	return db.Table("users").
+		Joins("JOIN organizations_users ON organizations_users.user_id = users.id AND organizations_users.organization_id = ?", o.ID).
+		Joins("LEFT OUTER JOIN scim_identities ON scim_identities.user_id = users.id AND scim_identities.organization_id = ?", o.ID)
+}
+
+// findUser returns the member of the organization with the given id
+func (o *scimOrganization) findUser(db *gorm.DB, id string) (*User, error) {
+	userID, err := uuid.FromString(id)
+	if err != nil {
+		return nil, newSCIMError(404, "", fmt.Sprintf("user not found: %s", id))
+	}
+
+	user := &User{}
+	o.usersQuery(db).Select("users.*").Where("users.id = ?", userID).Scan(user)
+	if user.ID == uuid.Nil {
+		return nil, newSCIMError(404, "", fmt.Sprintf("user not found: %s", id))
+	}
+	return user, nil
+}
+
+// isProvisioned returns true if the user was provisioned by the organization; only such
+// users are managed by the provisioning client
+func (o *scimOrganization) isProvisioned(db *gorm.DB, user *User) bool {
+	var identities int
+	db.Table("scim_identities").Where("organization_id = ? AND user_id = ?", o.ID, user.ID).Count(&identities)
+	return identities > 0
+}
+
+// externalIDs returns the external ids assigned by the provisioning client to the given users
+func (o *scimOrganization) externalIDs(db *gorm.DB, userIDs []uuid.UUID) map[uuid.UUID]string {
+	externalIDs := map[uuid.UUID]string{}
+	if len(userIDs) == 0 {
+		return externalIDs
+	}
+
+	rows, err := db.Table("scim_identities").
+		Select("user_id, external_id").
+		Where("organization_id = ? AND user_id IN (?) AND external_id IS NOT NULL", o.ID, userIDs).
+		Rows()
+	if err != nil {
+		common.Log.Warningf("failed to resolve scim external ids for organization: %s; %s", o.ID, err.Error())
+		return externalIDs
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID uuid.UUID
+		var externalID string
+		if rows.Scan(&userID, &externalID) == nil {
+			externalIDs[userID] = externalID
+		}
+	}
+	return externalIDs
+}
+
+// isMemberOfOtherOrganizations returns true if the user is a member of any organization
+// other than this one; such users are not managed exclusively by this organization
+func (o *scimOrganization) isMemberOfOtherOrganizations(db *gorm.DB, user *User) bool {
+	var memberships int
+	db.Table("organizations_users").Where("user_id = ? AND organization_id <> ?", user.ID, o.ID).Count(&memberships)
+	return memberships > 0
+}
+
+// userResource returns the SCIM representation of the given member
+func (o *scimOrganization) userResource(user *User, externalID *string, active bool) *SCIMUser {
+	primary := true
+	emailType := "work"
+	createdAt := user.CreatedAt
+
+	return &SCIMUser{
+		Schemas:    []string{scimUserSchema},
+		ID:         user.ID.String(),
+		ExternalID: externalID,
+		UserName:   *user.Email,
+		Name: &SCIMName{
+			Formatted:  user.FullName(),
+			GivenName:  user.FirstName,
+			FamilyName: user.LastName,
+		},
+		DisplayName: user.FullName(),
+		Emails: []*SCIMMultiValuedAttribute{
+			{
+				Value:   *user.Email,
+				Type:    &emailType,
+				Primary: &primary,
+			},
+		},
+		Active: &active,
+		Groups: []*SCIMMultiValuedAttribute{
+			{
+				Value:   o.ID.String(),
+				Display: common.StringOrNil(o.Name),
+				Ref:     common.StringOrNil(fmt.Sprintf("%s/Groups/%s", scimAPIPath, o.ID)),
+			},
+		},
+		Meta: &SCIMMeta{
+			ResourceType: "User",
+			Created:      &createdAt,
+			Location:     fmt.Sprintf("%s/Users/%s", scimAPIPath, user.ID),
+		},
+	}
+}
+
+// resource returns the SCIM representation of the given member
+func (o *scimOrganization) resource(db *gorm.DB, user *User) *SCIMUser {
+	var externalID *string
+	if id, ok := o.externalIDs(db, []uuid.UUID{user.ID})[user.ID]; ok {
+		externalID = &id
+	}
+	return o.userResource(user, externalID, !user.IsDisabled())
+}
+
+// listUsers returns a page of the members of the organization matching the given filter
+func (o *scimOrganization) listUsers(db *gorm.DB, filter string, startIndex, count int) (*SCIMListResponse, error) {
+	query := o.usersQuery(db)
+	if filter != "" {
+		clause, args, err := parseSCIMFilter(filter, scimUserFilterAttributes)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where(clause, args...)
+	}
+
+	var totalResults int
+	query.Count(&totalResults)
+
+	users := make([]*User, 0)
+	query.Select("users.*").
+		Order("users.created_at ASC, users.id ASC").
+		Offset(startIndex - 1).
+		Limit(count).
+		Scan(&users)
+
+	userIDs := make([]uuid.UUID, 0)
+	for _, user := range users {
+		userIDs = append(userIDs, user.ID)
+	}
+	externalIDs := o.externalIDs(db, userIDs)
+
+	resources := make([]interface{}, 0)
+	for _, user := range users {
+		var externalID *string
+		if id, ok := externalIDs[user.ID]; ok {
+			externalID = common.StringOrNil(id)
+		}
+		resources = append(resources, o.userResource(user, externalID, !user.IsDisabled()))
+	}
+
+	return scimListResponse(resources, totalResults, startIndex), nil
+}
+
+// scimUserChanges are the attributes of a member modified by a SCIM request
+type scimUserChanges struct {
+	email      *string
+	firstName  *string
+	lastName   *string
+	externalID *string
+	active     *bool
+
+	externalIDRemoved bool
+}
+
+// changesFromResource returns the changes needed to replace the attributes of a member with the given resource
+func changesFromResource(resource *SCIMUser) (*scimUserChanges, error) {
+	changes := &scimUserChanges{
+		email:      common.StringOrNil(resource.UserName),
+		externalID: resource.ExternalID,
+		active:     resource.Active,
+	}
+
+	if changes.email == nil {
+		for _, email := range resource.Emails {
+			if changes.email == nil || (email.Primary != nil && *email.Primary) {
+				changes.email = common.StringOrNil(email.Value)
+			}
+		}
+	}
+	if changes.email == nil {
+		return nil, newSCIMError(400, scimErrorInvalidValue, "userName is required")
+	}
+
+	if resource.Name != nil {
+		changes.firstName = resource.Name.GivenName
+		changes.lastName = resource.Name.FamilyName
+	}
+	if changes.externalID == nil {
+		changes.externalIDRemoved = true
+	}
+
+	return changes, nil
+}
+
+// changesFromPatch returns the changes described by the given PATCH operations
+func changesFromPatch(operations []*SCIMPatchOperation) (*scimUserChanges, error) {
+	changes := &scimUserChanges{}
+
+	for _, operation := range operations {
+		op := strings.ToLower(operation.Op)
+		if op != "add" && op != "replace" && op != "remove" {
+			return nil, newSCIMError(400, scimErrorInvalidSyntax, fmt.Sprintf("unsupported patch operation: %s", operation.Op))
+		}
+
+		if operation.Path == nil || *operation.Path == "" {
+			if op == "remove" {
+				return nil, newSCIMError(400, scimErrorInvalidPath, "remove operation requires a path")
+			}
+
+			values, valuesOk := operation.Value.(map[string]interface{})
+			if !valuesOk {
+				return nil, newSCIMError(400, scimErrorInvalidValue, "patch operation without a path requires an object value")
+			}
+			for path, value := range values {
+				if err := changes.apply(path, value, false); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		if err := changes.apply(*operation.Path, operation.Value, op == "remove"); err != nil {
+			return nil, err
+		}
+	}
+
+	return changes, nil
+}
+
+// apply the given value to the attribute at the given path
+func (c *scimUserChanges) apply(path string, value interface{}, remove bool) error {
+	attribute := strings.ToLower(strings.TrimPrefix(path, scimUserSchema+":"))
+	if strings.HasPrefix(attribute, "emails[") {
+		attribute = "emails.value"
+	}
+
+	switch attribute {
+	case "username", "emails.value":
+		if remove {
+			return newSCIMError(400, scimErrorMutability, "userName is required and cannot be removed")
+		}
+		email, err := scimStringValue(attribute, value)
+		if err != nil {
+			return err
+		}
+		c.email = email
+	case "emails":
+		if remove {
+			return newSCIMError(400, scimErrorMutability, "emails are required and cannot be removed")
+		}
+		emails, emailsOk := value.([]interface{})
+		if !emailsOk {
+			return newSCIMError(400, scimErrorInvalidValue, "emails must be an array")
+		}
+		for _, email := range emails {
+			attr, attrOk := email.(map[string]interface{})
+			if !attrOk {
+				return newSCIMError(400, scimErrorInvalidValue, "invalid email")
+			}
+			primary, _ := scimBoolValue("primary", attr["primary"])
+			if c.email == nil || (primary != nil && *primary) {
+				val, err := scimStringValue("emails.value", attr["value"])
+				if err != nil {
+					return err
+				}
+				c.email = val
+			}
+		}
+	case "name":
+		name, nameOk := value.(map[string]interface{})
+		if remove || !nameOk {
+			return newSCIMError(400, scimErrorInvalidValue, "name must be an object")
+		}
+		for subattr, val := range name {
+			if strings.EqualFold(subattr, "formatted") {
+				continue
+			}
+			if err := c.apply(fmt.Sprintf("name.%s", subattr), val, false); err != nil {
+				return err
+			}
+		}
+	case "name.givenname":
+		if remove {
+			return newSCIMError(400, scimErrorMutability, "name.givenName is required and cannot be removed")
+		}
+		firstName, err := scimStringValue(attribute, value)
+		if err != nil {
+			return err
+		}
+		c.firstName = firstName
+	case "name.familyname":
+		if remove {
+			emptyLastName := ""
+			c.lastName = &emptyLastName
+			return nil
+		}
+		lastName, err := scimStringValue(attribute, value)
+		if err != nil {
+			return err
+		}
+		c.lastName = lastName
+	case "externalid":
+		if remove {
+			c.externalID = nil
+			c.externalIDRemoved = true
+			return nil
+		}
+		externalID, err := scimStringValue(attribute, value)
+		if err != nil {
+			return err
+		}
+		c.externalID = externalID
+		c.externalIDRemoved = false
+	case "active":
+		if remove {
+			return newSCIMError(400, scimErrorMutability, "active cannot be removed")
+		}
+		active, err := scimBoolValue(attribute, value)
+		if err != nil {
+			return err
+		}
+		c.active = active
+	case "displayname", "name.formatted":
+		// derived from the given and family names
+	default:
+		return newSCIMError(400, scimErrorInvalidPath, fmt.Sprintf("unsupported attribute: %s", path))
+	}
+
+	return nil
+}
+
+// createUser provisions a new user as a member of the organization
+func (o *scimOrganization) createUser(db *gorm.DB, resource *SCIMUser) (*User, error) {
+	changes, err := changesFromResource(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	email := strings.ToLower(*changes.email)
+	if err := checkmail.ValidateFormat(email); err != nil {
+		return nil, newSCIMError(400, scimErrorInvalidValue, fmt.Sprintf("invalid userName: %s", email))
+	}
+
+	// existing users are never linked to the organization; doing so would allow
+	// the organization to manage an account it did not provision
+	if FindByEmail(email, nil, nil) != nil {
+		return nil, newSCIMError(409, scimErrorUniqueness, fmt.Sprintf("user already exists: %s", email))
+	}
+
+	tx := db.Begin()
+	defer tx.RollbackUnlessCommitted()
+
+	user, err := provisionFederatedUser(tx, nil, email, changes.firstName, changes.lastName)
+	if err != nil {
+		return nil, newSCIMError(400, scimErrorInvalidValue, err.Error())
+	}
+
+	if !user.addOrganizationAssociation(tx, o.ID, role.MemberPermission, role.BuiltinID(tx, role.Member)) {
+		return nil, newSCIMError(500, "", fmt.Sprintf("failed to add user %s to organization: %s", user.ID, o.ID))
+	}
+
+	result := tx.Exec("INSERT INTO scim_identities (created_at, organization_id, user_id, external_id) VALUES (?, ?, ?, ?)", time.Now(), o.ID, user.ID, changes.externalID)
+	if result.Error != nil {
+		return nil, newSCIMError(409, scimErrorUniqueness, fmt.Sprintf("failed to provision user: %s; %s", email, result.Error.Error()))
+	}
+
+	if changes.active != nil && !*changes.active && !user.Suspend(tx, common.StringOrNil(scimDeprovisionedReason)) {
+		return nil, newSCIMError(500, "", fmt.Sprintf("failed to suspend user: %s", user.ID))
+	}
+
+	tx.Commit()
+	common.Log.Debugf("provisioned user %s via scim on behalf of organization: %s", user.ID, o.ID)
+	return user, nil
+}
+
+// updateUser applies the given changes to a member of the organization; the returned
+// resource reflects the member as seen by the organization after the changes are applied.
+// Members which were not provisioned by the organization cannot be modified or deprovisioned
+// by it, as doing so would allow the organization to manage an account it did not provision.
+func (o *scimOrganization) updateUser(db *gorm.DB, user *User, changes *scimUserChanges) (*SCIMUser, error) {
+	if !o.isProvisioned(db, user) {
+		return nil, newSCIMError(404, "", fmt.Sprintf("user not found: %s", user.ID))
+	}
+
+	sharedUser := o.isMemberOfOtherOrganizations(db, user)
+	updates := map[string]interface{}{}
+
+	if changes.email != nil && !strings.EqualFold(*changes.email, *user.Email) {
+		if sharedUser {
+			return nil, newSCIMError(400, scimErrorMutability, "userName of a user who is a member of other organizations cannot be changed")
+		}
+
+		email := strings.ToLower(*changes.email)
+		if err := checkmail.ValidateFormat(email); err != nil {
+			return nil, newSCIMError(400, scimErrorInvalidValue, fmt.Sprintf("invalid userName: %s", email))
+		}
+		if FindByEmail(email, user.ApplicationID, nil) != nil {
+			return nil, newSCIMError(409, scimErrorUniqueness, fmt.Sprintf("user already exists: %s", email))
+		}
+
+		// the provisioning client is authoritative for the addresses of the users it manages
+		verifiedAt := time.Now()
+		user.Email = &email
+		user.EmailVerifiedAt = &verifiedAt
+		user.PendingEmail = nil
+		updates["email"] = user.Email
+		updates["email_verified_at"] = user.EmailVerifiedAt
+		updates["pending_email"] = gorm.Expr("NULL")
+	}
+	if changes.firstName != nil && *changes.firstName != "" {
+		user.FirstName = changes.firstName
+		updates["first_name"] = *user.FirstName
+	}
+	if changes.lastName != nil {
+		user.LastName = changes.lastName
+		updates["last_name"] = *user.LastName
+	}
+
+	deprovision := changes.active != nil && !*changes.active
+	reactivate := changes.active != nil && *changes.active && user.IsDisabled()
+	if reactivate && (user.DisabledReason == nil || *user.DisabledReason != scimDeprovisionedReason) {
+		return nil, newSCIMError(400, scimErrorMutability, "a user suspended by an administrator cannot be reactivated")
+	}
+
+	tx := db.Begin()
+	defer tx.RollbackUnlessCommitted()
+
+	if len(updates) > 0 {
+		result := tx.Model(user).Updates(updates)
+		if result.Error != nil {
+			return nil, newSCIMError(400, scimErrorInvalidValue, result.Error.Error())
+		}
+	}
+
+	if changes.externalID != nil || changes.externalIDRemoved {
+		result := tx.Exec("INSERT INTO scim_identities (created_at, organization_id, user_id, external_id) VALUES (?, ?, ?, ?) ON CONFLICT (organization_id, user_id) DO UPDATE SET external_id = EXCLUDED.external_id", time.Now(), o.ID, user.ID, changes.externalID)
+		if result.Error != nil {
+			return nil, newSCIMError(409, scimErrorUniqueness, fmt.Sprintf("failed to update externalId; %s", result.Error.Error()))
+		}
+	}
+
+	if reactivate && !user.Reactivate(tx) {
+		return nil, newSCIMError(500, "", fmt.Sprintf("failed to reactivate user: %s", user.ID))
+	}
+
+	active := !user.IsDisabled()
+	if deprovision {
+		err := o.deprovisionUser(tx, user, sharedUser)
+		if err != nil {
+			return nil, err
+		}
+		active = false
+	}
+
+	tx.Commit()
+
+	var externalID *string
+	if id, ok := o.externalIDs(db, []uuid.UUID{user.ID})[user.ID]; ok {
+		externalID = &id
+	}
+	return o.userResource(user, externalID, active), nil
+}
+
+// deprovisionUser revokes the access of the given member; users managed exclusively by the
+// organization are suspended, which revokes all outstanding tokens issued to the user, whereas
+// users who are also members of other organizations are only removed from this one, in which
+// case only the tokens scoped to the organization are revoked. Users are never deleted, so a
+// suspended user can be reactivated by the organization.
+func (o *scimOrganization) deprovisionUser(tx *gorm.DB, user *User, sharedUser bool) error {
+	if o.UserID != nil && *o.UserID == user.ID {
+		return newSCIMError(400, scimErrorMutability, "the owner of the organization cannot be deprovisioned")
+	}
+
+	if sharedUser {
+		result := tx.Exec("DELETE FROM organizations_users WHERE organization_id = ? AND user_id = ?", o.ID, user.ID)
+		if result.Error != nil {
+			return newSCIMError(500, "", fmt.Sprintf("failed to remove user %s from organization: %s; %s", user.ID, o.ID, result.Error.Error()))
+		}
+		common.Log.Debugf("removed user %s from organization %s via scim; user is a member of other organizations", user.ID, o.ID)
+
+		err := token.RevokeOrganizationUserTokens(tx, o.ID, user.ID)
+		if err != nil {
+			return newSCIMError(500, "", err.Error())
+		}
+	} else if !user.IsDisabled() {
+		if !user.Suspend(tx, common.StringOrNil(scimDeprovisionedReason)) {
+			return newSCIMError(500, "", fmt.Sprintf("failed to suspend user: %s", user.ID))
+		}
+		common.Log.Debugf("suspended user %s via scim on behalf of organization: %s", user.ID, o.ID)
+	}
+
+	return nil
+}
+
+// group returns the SCIM representation of the organization
+func (o *scimOrganization) group(db *gorm.DB, includeMembers bool) *SCIMGroup {
+	group := &SCIMGroup{
+		Schemas:     []string{scimGroupSchema},
+		ID:          o.ID.String(),
+		DisplayName: o.Name,
+		Meta: &SCIMMeta{
+			ResourceType: "Group",
+			Created:      &o.CreatedAt,
+			Location:     fmt.Sprintf("%s/Groups/%s", scimAPIPath, o.ID),
+		},
+	}
+
+	if includeMembers {
+		users := make([]*User, 0)
+		o.usersQuery(db).Select("users.*").Order("users.created_at ASC").Scan(&users)
+
+		group.Members = make([]*SCIMMultiValuedAttribute, 0)
+		for _, user := range users {
+			group.Members = append(group.Members, &SCIMMultiValuedAttribute{
+				Value:   user.ID.String(),
+				Display: user.Email,
+				Ref:     common.StringOrNil(fmt.Sprintf("%s/Users/%s", scimAPIPath, user.ID)),
+			})
+		}
+	}
+
+	return group
+}
+
+// listGroups returns the organization if it matches the given filter
+func (o *scimOrganization) listGroups(db *gorm.DB, filter string, startIndex, count int, includeMembers bool) (*SCIMListResponse, error) {
+	query := db.Table("organizations").Where("organizations.id = ?", o.ID)
+	if filter != "" {
+		clause, args, err := parseSCIMFilter(filter, scimGroupFilterAttributes)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where(clause, args...)
+	}
+
+	var totalResults int
+	query.Count(&totalResults)
+
+	resources := make([]interface{}, 0)
+	if totalResults > 0 && startIndex == 1 && count > 0 {
+		resources = append(resources, o.group(db, includeMembers))
+	}
+
+	return scimListResponse(resources, totalResults, startIndex), nil
+}
+
+// patchGroup applies the given PATCH operations to the membership of the organization
+func (o *scimOrganization) patchGroup(db *gorm.DB, operations []*SCIMPatchOperation) error {
+	tx := db.Begin()
+	defer tx.RollbackUnlessCommitted()
+
+	for _, operation := range operations {
+		op := strings.ToLower(operation.Op)
+		path := ""
+		if operation.Path != nil {
+			path = strings.TrimPrefix(*operation.Path, scimGroupSchema+":")
+		}
+
+		if path == "" && op != "remove" {
+			values, valuesOk := operation.Value.(map[string]interface{})
+			if !valuesOk {
+				return newSCIMError(400, scimErrorInvalidValue, "patch operation without a path requires an object value")
+			}
+			for attr, value := range values {
+				err := o.patchGroupAttribute(tx, op, attr, value)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if matches := scimMembersFilterPath.FindStringSubmatch(path); len(matches) == 2 {
+			if op != "remove" {
+				return newSCIMError(400, scimErrorInvalidPath, fmt.Sprintf("unsupported patch operation on path: %s", path))
+			}
+			err := o.removeMembers(tx, []string{matches[1]})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := o.patchGroupAttribute(tx, op, path, operation.Value)
+		if err != nil {
+			return err
+		}
+	}
+
+	tx.Commit()
+	return nil
+}
+
+func (o *scimOrganization) patchGroupAttribute(tx *gorm.DB, op, attr string, value interface{}) error {
+	switch strings.ToLower(attr) {
+	case "members":
+		var memberIDs []string
+		if value != nil {
+			ids, err := scimMemberIDs(value)
+			if err != nil {
+				return err
+			}
+			memberIDs = ids
+		}
+
+		switch op {
+		case "add":
+			return o.addMembers(tx, memberIDs)
+		case "remove":
+			if value == nil {
+				return newSCIMError(400, scimErrorMutability, "all members of an organization cannot be removed")
+			}
+			return o.removeMembers(tx, memberIDs)
+		case "replace":
+			return o.replaceMembers(tx, memberIDs)
+		}
+		return newSCIMError(400, scimErrorInvalidSyntax, fmt.Sprintf("unsupported patch operation: %s", op))
+	case "displayname":
+		displayName, err := scimStringValue(attr, value)
+		if err != nil {
+			return err
+		}
+		if displayName == nil || *displayName != o.Name {
+			return newSCIMError(400, scimErrorMutability, "the displayName of an organization cannot be modified")
+		}
+		return nil
+	case "externalid":
+		// groups correspond to organizations, which are not assigned external ids
+		return nil
+	}
+
+	return newSCIMError(400, scimErrorInvalidPath, fmt.Sprintf("unsupported attribute: %s", attr))
+}
+
+// addMembers restores the membership of previously-provisioned users; users which were not
+// provisioned by the organization cannot be added to it
+func (o *scimOrganization) addMembers(tx *gorm.DB, memberIDs []string) error {
+	for _, memberID := range memberIDs {
+		userID, err := uuid.FromString(memberID)
+		if err != nil {
+			return newSCIMError(400, scimErrorInvalidValue, fmt.Sprintf("invalid member: %s", memberID))
+		}
+
+		var memberships int
+		tx.Table("organizations_users").Where("organization_id = ? AND user_id = ?", o.ID, userID).Count(&memberships)
+		if memberships > 0 {
+			continue
+		}
+
+		var identities int
+		tx.Table("scim_identities").Where("organization_id = ? AND user_id = ?", o.ID, userID).Count(&identities)
+		if identities == 0 {
+			return newSCIMError(400, scimErrorInvalidValue, fmt.Sprintf("user was not provisioned by the organization: %s", memberID))
+		}
+
+		user := &User{}
+		user.ID = userID
+		if !user.addOrganizationAssociation(tx, o.ID, role.MemberPermission, role.BuiltinID(tx, role.Member)) {
+			return newSCIMError(500, "", fmt.Sprintf("failed to add user %s to organization: %s", userID, o.ID))
+		}
+	}
+
+	return nil
+}
+
+// removeMembers removes the given users from the organization and revokes their outstanding tokens
+// scoped to the organization; users which were not provisioned by the organization cannot be removed
+func (o *scimOrganization) removeMembers(tx *gorm.DB, memberIDs []string) error {
+	for _, memberID := range memberIDs {
+		userID, err := uuid.FromString(memberID)
+		if err != nil {
+			return newSCIMError(400, scimErrorInvalidValue, fmt.Sprintf("invalid member: %s", memberID))
+		}
+		if o.UserID != nil && *o.UserID == userID {
+			return newSCIMError(400, scimErrorMutability, "the owner of the organization cannot be removed")
+		}
+
+		var identities int
+		tx.Table("scim_identities").Where("organization_id = ? AND user_id = ?", o.ID, userID).Count(&identities)
+		if identities == 0 {
+			return newSCIMError(400, scimErrorInvalidValue, fmt.Sprintf("user was not provisioned by the organization: %s", memberID))
+		}
+
+		result := tx.Exec("DELETE FROM organizations_users WHERE organization_id = ? AND user_id = ?", o.ID, userID)
+		if result.Error != nil {
+			return newSCIMError(500, "", fmt.Sprintf("failed to remove user %s from organization: %s; %s", userID, o.ID, result.Error.Error()))
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		err = token.RevokeOrganizationUserTokens(tx, o.ID, userID)
+		if err != nil {
+			return newSCIMError(500, "", err.Error())
+		}
+		common.Log.Debugf("removed user %s from organization %s via scim", userID, o.ID)
+	}
+
+	return nil
+}
+
+// replaceMembers sets the users provisioned by the organization which are members to exactly the
+// given users; members which were not provisioned by the organization, including its owner, are
+// unaffected
+func (o *scimOrganization) replaceMembers(tx *gorm.DB, memberIDs []string) error {
+	err := o.addMembers(tx, memberIDs)
+	if err != nil {
+		return err
+	}
+
+	members := map[string]bool{}
+	for _, memberID := range memberIDs {
+		members[strings.ToLower(memberID)] = true
+	}
+	if o.UserID != nil {
+		members[o.UserID.String()] = true
+	}
+
+	var currentMemberIDs []uuid.UUID
+	tx.Table("organizations_users").
+		Joins("JOIN scim_identities ON scim_identities.organization_id = organizations_users.organization_id AND scim_identities.user_id = organizations_users.user_id").
+		Where("organizations_users.organization_id = ?", o.ID).
+		Pluck("organizations_users.user_id", &currentMemberIDs)
+
+	removed := make([]string, 0)
+	for _, memberID := range currentMemberIDs {
+		if !members[memberID.String()] {
+			removed = append(removed, memberID.String())
+		}
+	}
+
+	return o.removeMembers(tx, removed)
+}
+
+func scimListResponse(resources []interface{}, totalResults, startIndex int) *SCIMListResponse {
+	return &SCIMListResponse{
+		Schemas:      []string{scimListResponseSchema},
+		TotalResults: totalResults,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+// scimMemberIDs parses the user ids from the given array of member values
+func scimMemberIDs(value interface{}) ([]string, error) {
+	members, membersOk := value.([]interface{})
+	if !membersOk {
+		return nil, newSCIMError(400, scimErrorInvalidValue, "members must be an array")
+	}
+
+	memberIDs := make([]string, 0)
+	for _, member := range members {
+		attr, attrOk := member.(map[string]interface{})
+		if !attrOk {
+			return nil, newSCIMError(400, scimErrorInvalidValue, "invalid member")
+		}
+		memberID, memberIDOk := attr["value"].(string)
+		if !memberIDOk {
+			return nil, newSCIMError(400, scimErrorInvalidValue, "member value is required")
+		}
+		memberIDs = append(memberIDs, memberID)
+	}
+	return memberIDs, nil
+}
+
+func scimStringValue(attribute string, value interface{}) (*string, error) {
+	val, valOk := value.(string)
+	if !valOk {
+		return nil, newSCIMError(400, scimErrorInvalidValue, fmt.Sprintf("%s must be a string", attribute))
+	}
+	return &val, nil
+}
+
+// scimBoolValue parses a boolean value; some provisioning clients represent booleans as strings
+func scimBoolValue(attribute string, value interface{}) (*bool, error) {
+	switch val := value.(type) {
+	case bool:
+		return &val, nil
+	case string:
+		if strings.EqualFold(val, "true") {
+			b := true
+			return &b, nil
+		} else if strings.EqualFold(val, "false") {
+			b := false
+			return &b, nil
+		}
+	case nil:
+		return nil, nil
+	}
+	return nil, newSCIMError(400, scimErrorInvalidValue, fmt.Sprintf("%s must be a boolean", attribute))
+}
+
+// scimFilterAttribute maps a filterable SCIM attribute to the sql expression it is compared against
+type scimFilterAttribute struct {
+	expr      string
+	boolean   bool
+	caseExact bool
+}
+
+var scimUserFilterAttributes = map[string]*scimFilterAttribute{
+	"id":              {expr: "CAST(users.id AS text)", caseExact: true},
+	"username":        {expr: "users.email"},
+	"emails":          {expr: "users.email"},
+	"emails.value":    {expr: "users.email"},
+	"externalid":      {expr: "scim_identities.external_id", caseExact: true},
+	"name.givenname":  {expr: "users.first_name"},
+	"name.familyname": {expr: "users.last_name"},
+	"active":          {expr: "users.disabled_at IS NULL", boolean: true},
+	"meta.created":    {expr: "users.created_at", caseExact: true},
+}
+
+var scimGroupFilterAttributes = map[string]*scimFilterAttribute{
+	"id":           {expr: "CAST(organizations.id AS text)", caseExact: true},
+	"displayname":  {expr: "organizations.name"},
+	"meta.created": {expr: "organizations.created_at", caseExact: true},
+}
+
+// scimFilterParser translates a SCIM filter expression (RFC 7644 section 3.4.2.2) into a
+// parameterized sql where clause; attributes are restricted to the given mapping, so user
+// input is only ever bound as a query parameter
+type scimFilterParser struct {
+	tokens     []string
+	pos        int
+	attributes map[string]*scimFilterAttribute
+	args       []interface{}
+}
+
+// parseSCIMFilter returns the sql where clause and its arguments for the given filter
+func parseSCIMFilter(filter string, attributes map[string]*scimFilterAttribute) (string, []interface{}, error) {
+	if len(filter) > scimMaxFilterLength {
+		return "", nil, newSCIMError(400, scimErrorInvalidFilter, "filter is too long")
+	}
+
+	tokens, err := tokenizeSCIMFilter(filter)
+	if err != nil {
+		return "", nil, err
+	}
+
+	parser := &scimFilterParser{
+		tokens:     tokens,
+		attributes: attributes,
+		args:       make([]interface{}, 0),
+	}
+
+	clause, err := parser.parseOr()
+	if err != nil {
+		return "", nil, err
+	}
+	if parser.pos != len(parser.tokens) {
+		return "", nil, newSCIMError(400, scimErrorInvalidFilter, fmt.Sprintf("unexpected token in filter: %s", parser.tokens[parser.pos]))
+	}
+
+	return clause, parser.args, nil
+}
+
+// tokenizeSCIMFilter splits the given filter into parentheses, quoted strings and words
+func tokenizeSCIMFilter(filter string) ([]string, error) {
+	tokens := make([]string, 0)
+	runes := []rune(filter)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, string(r))
+			i++
+		case r == '"':
+			j := i + 1
+			for ; j < len(runes) && runes[j] != '"'; j++ {
+				if runes[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(runes) {
+				return nil, newSCIMError(400, scimErrorInvalidFilter, "unterminated string in filter")
+			}
+			tokens = append(tokens, string(runes[i:j+1]))
+			i = j + 1
+		default:
+			j := i
+			for ; j < len(runes) && !unicode.IsSpace(runes[j]) && runes[j] != '(' && runes[j] != ')'; j++ {
+				if runes[j] == '[' || runes[j] == '"' {
+					return nil, newSCIMError(400, scimErrorInvalidFilter, "complex attribute filters are not supported")
+				}
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		}
+	}
+
+	if len(tokens) == 0 {
+		return nil, newSCIMError(400, scimErrorInvalidFilter, "empty filter")
+	}
+	return tokens, nil
+}
+
+func (p *scimFilterParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *scimFilterParser) next() string {
+	token := p.peek()
+	if p.pos < len(p.tokens) {
+		p.pos++
+	}
+	return token
+}
+
+func (p *scimFilterParser) parseOr() (string, error) {
+	clause, err := p.parseAnd()
+	if err != nil {
+		return "", err
+	}
+	for strings.EqualFold(p.peek(), "or") {
+		p.next()
+		rhs, err := p.parseAnd()
+		if err != nil {
+			return "", err
+		}
+		clause = fmt.Sprintf("(%s OR %s)", clause, rhs)
+	}
+	return clause, nil
+}
+
+func (p *scimFilterParser) parseAnd() (string, error) {
+	clause, err := p.parseTerm()
+	if err != nil {
+		return "", err
+	}
+	for strings.EqualFold(p.peek(), "and") {
+		p.next()
+		rhs, err := p.parseTerm()
+		if err != nil {
+			return "", err
+		}
+		clause = fmt.Sprintf("(%s AND %s)", clause, rhs)
+	}
+	return clause, nil
+}
+
+func (p *scimFilterParser) parseTerm() (string, error) {
+	negate := false
+	if strings.EqualFold(p.peek(), "not") {
+		p.next()
+		negate = true
+		if p.peek() != "(" {
+			return "", newSCIMError(400, scimErrorInvalidFilter, "not must be followed by a parenthesized expression")
+		}
+	}
+
+	var clause string
+	if p.peek() == "(" {
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return "", err
+		}
+		if p.next() != ")" {
+			return "", newSCIMError(400, scimErrorInvalidFilter, "unbalanced parentheses in filter")
+		}
+		clause = fmt.Sprintf("(%s)", expr)
+	} else {
+		expr, err := p.parseComparison()
+		if err != nil {
+			return "", err
+		}
+		clause = expr
+	}
+
+	if negate {
+		clause = fmt.Sprintf("NOT %s", clause)
+	}
+	return clause, nil
+}
+
+func (p *scimFilterParser) parseComparison() (string, error) {
+	path := p.next()
+	attribute, attributeOk := p.attributes[strings.ToLower(path)]
+	if !attributeOk {
+		return "", newSCIMError(400, scimErrorInvalidFilter, fmt.Sprintf("unsupported filter attribute: %s", path))
+	}
+
+	op := strings.ToLower(p.next())
+	if op == "pr" {
+		if attribute.boolean {
+			return "TRUE", nil
+		}
+		return fmt.Sprintf("(%s IS NOT NULL AND CAST(%s AS text) <> '')", attribute.expr, attribute.expr), nil
+	}
+
+	if p.pos >= len(p.tokens) {
+		return "", newSCIMError(400, scimErrorInvalidFilter, fmt.Sprintf("missing comparison value for attribute: %s", path))
+	}
+	value, err := parseSCIMFilterValue(p.next())
+	if err != nil {
+		return "", err
+	}
+
+	if value == nil {
+		switch op {
+		case "eq":
+			return fmt.Sprintf("%s IS NULL", attribute.expr), nil
+		case "ne":
+			return fmt.Sprintf("%s IS NOT NULL", attribute.expr), nil
+		}
+		return "", newSCIMError(400, scimErrorInvalidFilter, fmt.Sprintf("invalid comparison with null: %s", op))
+	}
+
+	if attribute.boolean {
+		b, boolOk := value.(bool)
+		if !boolOk || (op != "eq" && op != "ne") {
+			return "", newSCIMError(400, scimErrorInvalidFilter, fmt.Sprintf("invalid comparison for boolean attribute: %s", path))
+		}
+		p.args = append(p.args, b)
+		if op == "ne" {
+			return fmt.Sprintf("(%s) <> ?", attribute.expr), nil
+		}
+		return fmt.Sprintf("(%s) = ?", attribute.expr), nil
+	}
+
+	str, strOk := value.(string)
+	if !strOk {
+		return "", newSCIMError(400, scimErrorInvalidFilter, fmt.Sprintf("invalid comparison value for attribute: %s", path))
+	}
+
+	expr := attribute.expr
+	if !attribute.caseExact {
+		expr = fmt.Sprintf("LOWER(%s)", expr)
+		str = strings.ToLower(str)
+	}
+
+	switch op {
+	case "eq", "ne", "gt", "ge", "lt", "le":
+		operators := map[string]string{"eq": "=", "ne": "<>", "gt": ">", "ge": ">=", "lt": "<", "le": "<="}
+		p.args = append(p.args, str)
+		return fmt.Sprintf("%s %s ?", expr, operators[op]), nil
+	case "co", "sw", "ew":
+		pattern := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(str)
+		switch op {
+		case "co":
+			pattern = "%" + pattern + "%"
+		case "sw":
+			pattern = pattern + "%"
+		case "ew":
+			pattern = "%" + pattern
+		}
+		p.args = append(p.args, pattern)
+		return fmt.Sprintf("CAST(%s AS text) LIKE ?", expr), nil
+	}
+
+	return "", newSCIMError(400, scimErrorInvalidFilter, fmt.Sprintf("unsupported filter operator: %s", op))
+}
+
+// parseSCIMFilterValue parses a JSON string, boolean or null comparison value
+func parseSCIMFilterValue(token string) (interface{}, error) {
+	switch strings.ToLower(token) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+
+	if strings.HasPrefix(token, `"`) {
+		var str string
+		if err := json.Unmarshal([]byte(token), &str); err != nil {
+			return nil, newSCIMError(400, scimErrorInvalidFilter, fmt.Sprintf("invalid string in filter: %s", token))
+		}
+		return str, nil
+	}
+
+	return nil, newSCIMError(400, scimErrorInvalidFilter, fmt.Sprintf("invalid comparison value in filter: %s", token))
+}
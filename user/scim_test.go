@@ -0,0 +1,175 @@
+package user
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/provideplatform/ident/common"
+)
+
+func TestParseSCIMFilter(t *testing.T) {
+	tt := []struct {
+		filter string
+		clause string
+		args   []interface{}
+	}{
+		{
+			`userName eq "Alice@Example.com"`,
+			"LOWER(users.email) = ?",
+			[]interface{}{"alice@example.com"},
+		},
+		{
+			`externalId eq "A-1" and active eq true`,
+			"(scim_identities.external_id = ? AND (users.disabled_at IS NULL) = ?)",
+			[]interface{}{"A-1", true},
+		},
+		{
+			`name.familyName sw "o'b" or not (emails co "100%_\\")`,
+			"(CAST(LOWER(users.last_name) AS text) LIKE ? OR NOT (CAST(LOWER(users.email) AS text) LIKE ?))",
+			[]interface{}{"o'b%", `%100\%\_\\%`},
+		},
+		{
+			`externalId pr`,
+			"(scim_identities.external_id IS NOT NULL AND CAST(scim_identities.external_id AS text) <> '')",
+			[]interface{}{},
+		},
+		{
+			`(meta.created gt "2021-01-01T00:00:00Z") and externalId eq null`,
+			"((users.created_at > ?) AND scim_identities.external_id IS NULL)",
+			[]interface{}{"2021-01-01T00:00:00Z"},
+		},
+	}
+
+	for _, tc := range tt {
+		clause, args, err := parseSCIMFilter(tc.filter, scimUserFilterAttributes)
+		if err != nil {
+			t.Errorf("failed to parse filter: %s; %s", tc.filter, err.Error())
+			continue
+		}
+		if clause != tc.clause {
+			t.Errorf("expected filter %s to be translated to %s; got %s", tc.filter, tc.clause, clause)
+		}
+		if !reflect.DeepEqual(args, tc.args) {
+			t.Errorf("expected filter %s to bind args %v; got %v", tc.filter, tc.args, args)
+		}
+	}
+}
+
+func TestParseSCIMFilterRejectsInvalidFilters(t *testing.T) {
+	for _, filter := range []string{
+		``,
+		`password eq "secret"`,
+		`userName eq`,
+		`userName eq "unterminated`,
+		`userName eq unquoted`,
+		`userName regex "^a"`,
+		`active gt true`,
+		`active eq "true"`,
+		`(userName eq "a"`,
+		`userName eq "a")`,
+		`userName eq "a" and`,
+		`not userName eq "a"`,
+		`emails[type eq "work"] eq "a"`,
+		`users.email eq "a"; DROP TABLE users`,
+	} {
+		_, _, err := parseSCIMFilter(filter, scimUserFilterAttributes)
+		if err == nil {
+			t.Errorf("expected invalid filter to be rejected: %s", filter)
+			continue
+		}
+		if scimErr, scimErrOk := err.(*SCIMError); !scimErrOk || scimErr.status != 400 {
+			t.Errorf("expected invalid filter %s to be rejected with a 400 scim error; got %v", filter, err)
+		}
+	}
+}
+
+func TestSCIMChangesFromPatch(t *testing.T) {
+	var operations []*SCIMPatchOperation
+	err := json.Unmarshal([]byte(`[
+		{"op": "Replace", "path": "active", "value": "False"},
+		{"op": "replace", "path": "name.givenName", "value": "Alice"},
+		{"op": "add", "value": {"name": {"familyName": "Liddell"}, "externalId": "A-1"}},
+		{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "alice@example.com"}
+	]`), &operations)
+	if err != nil {
+		t.Fatalf("failed to unmarshal patch operations; %s", err.Error())
+	}
+
+	changes, err := changesFromPatch(operations)
+	if err != nil {
+		t.Fatalf("failed to parse patch operations; %s", err.Error())
+	}
+
+	if changes.active == nil || *changes.active {
+		t.Error("expected string boolean to deactivate user")
+	}
+	if changes.firstName == nil || *changes.firstName != "Alice" {
+		t.Errorf("expected given name Alice; got %v", changes.firstName)
+	}
+	if changes.lastName == nil || *changes.lastName != "Liddell" {
+		t.Errorf("expected family name Liddell; got %v", changes.lastName)
+	}
+	if changes.externalID == nil || *changes.externalID != "A-1" {
+		t.Errorf("expected external id A-1; got %v", changes.externalID)
+	}
+	if changes.email == nil || *changes.email != "alice@example.com" {
+		t.Errorf("expected email alice@example.com; got %v", changes.email)
+	}
+
+	operations = []*SCIMPatchOperation{{Op: "remove", Path: common.StringOrNil("externalId")}}
+	changes, err = changesFromPatch(operations)
+	if err != nil || !changes.externalIDRemoved {
+		t.Error("expected external id to be removed")
+	}
+}
+
+func TestSCIMChangesFromPatchRejectsInvalidOperations(t *testing.T) {
+	for _, ops := range []string{
+		`[{"op": "move", "path": "active", "value": true}]`,
+		`[{"op": "remove", "path": "userName"}]`,
+		`[{"op": "remove"}]`,
+		`[{"op": "replace", "path": "permissions", "value": 536870912}]`,
+		`[{"op": "replace", "value": {"password": "secret"}}]`,
+		`[{"op": "replace", "path": "active", "value": "maybe"}]`,
+		`[{"op": "replace", "path": "userName", "value": 1}]`,
+	} {
+		var operations []*SCIMPatchOperation
+		err := json.Unmarshal([]byte(ops), &operations)
+		if err != nil {
+			t.Fatalf("failed to unmarshal patch operations; %s", err.Error())
+		}
+
+		_, err = changesFromPatch(operations)
+		if err == nil {
+			t.Errorf("expected invalid patch operations to be rejected: %s", ops)
+		}
+	}
+}
+
+func TestSCIMChangesFromResource(t *testing.T) {
+	primary := true
+	changes, err := changesFromResource(&SCIMUser{
+		Emails: []*SCIMMultiValuedAttribute{
+			{Value: "home@example.com"},
+			{Value: "work@example.com", Primary: &primary},
+		},
+		Name: &SCIMName{
+			GivenName: common.StringOrNil("Alice"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to parse scim user; %s", err.Error())
+	}
+	if changes.email == nil || *changes.email != "work@example.com" {
+		t.Errorf("expected primary email to be used when userName is not given; got %v", changes.email)
+	}
+	if !changes.externalIDRemoved {
+		t.Error("expected replacing a user without an external id to remove it")
+	}
+
+	_, err = changesFromResource(&SCIMUser{})
+	if err == nil {
+		t.Error("expected scim user without a userName to be rejected")
+	}
+}
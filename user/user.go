@@ -8,24 +8,28 @@ import (
 	"time"
 
 	"github.com/badoux/checkmail"
-	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/jinzhu/gorm"
 	dbconf "github.com/kthomas/go-db-config"
 	natsutil "github.com/kthomas/go-natsutil"
 	uuid "github.com/kthomas/go.uuid"
 	trumail "github.com/kthomas/trumail/verifier"
 	"github.com/provideplatform/ident/common"
+	"github.com/provideplatform/ident/legal"
+	"github.com/provideplatform/ident/role"
 	"github.com/provideplatform/ident/token"
 	provide "github.com/provideplatform/provide-go/api"
-	util "github.com/provideplatform/provide-go/common/util"
-	"golang.org/x/crypto/bcrypt"
 )
 
-const defaultResetPasswordTokenTimeout = time.Hour * 1
 const identUserIDKey = "ident_user_id"
 const natsSiaUserNotificationSubject = "sia.user.notification"
 const natsSiaUserDeleteNotificationSubject = "sia.user.deleted"
 
+// errUserSuspended is returned when a suspended user attempts to authenticate
+var errUserSuspended = errors.New("authentication failed; user has been suspended")
+
+// errUserExpired is returned when a user whose account has expired attempts to authenticate
+var errUserExpired = errors.New("user authentication failed; user account has expired")
+
 // User model
 type User struct {
 	provide.Model
@@ -34,19 +38,36 @@ type User struct {
 	FirstName              *string                `sql:"not null" json:"first_name"`
 	LastName               *string                `sql:"not null" json:"last_name"`
 	Email                  *string                `sql:"not null" json:"email,omitempty"`
+	EmailVerifiedAt        *time.Time             `json:"email_verified_at"`
+	PendingEmail           *string                `json:"pending_email,omitempty"`
 	ExpiresAt              *time.Time             `json:"-"`
+	ExpirationNotifiedAt   *time.Time             `json:"-"`
+	DisabledAt             *time.Time             `json:"disabled_at,omitempty"`
+	DisabledReason         *string                `json:"disabled_reason,omitempty"`
+	ErasedAt               *time.Time             `json:"-"`
 	Permissions            common.Permission      `sql:"not null" json:"permissions,omitempty"`
+	LDAPPermissions        common.Permission      `sql:"not null" json:"-"` // subset of permissions granted by mapped ldap groups
 	EphemeralMetadata      *EphemeralUserMetadata `sql:"-" json:"metadata,omitempty"`
+	Metadata               *json.RawMessage       `sql:"type:json" json:"attributes,omitempty"`
 	Password               *string                `json:"-"`
 	PrivacyPolicyAgreedAt  *time.Time             `json:"privacy_policy_agreed_at"`
 	TermsOfServiceAgreedAt *time.Time             `json:"terms_of_service_agreed_at"`
 	ResetPasswordToken     *string                `json:"-"`
+
+	federated bool // provisioned by an upstream identity provider and authenticated exclusively by it
+
+	replacedPasswordHash *string // password hash replaced by rehashPassword, retained in the password history upon update
 }
 
 // AuthenticationResponse is returned upon successful authentication using an email address
 type AuthenticationResponse struct {
-	User  *Response       `json:"user"`
-	Token *token.Response `json:"token"`
+	User            *Response         `json:"user"`
+	Token           *token.Response   `json:"token"`
+	ConsentRequired []*legal.Document `json:"consent_required,omitempty"`
+
+	// applicationID and organizationID scope the token vended following federated authentication
+	applicationID  *uuid.UUID
+	organizationID *uuid.UUID
 }
 
 // Response is preferred over writing an entire User instance as JSON
@@ -57,10 +78,17 @@ type Response struct {
 	FirstName              string                 `json:"first_name"`
 	LastName               string                 `json:"last_name"`
 	Email                  string                 `json:"email"`
+	EmailVerifiedAt        *time.Time             `json:"email_verified_at"`
+	PendingEmail           *string                `json:"pending_email,omitempty"`
+	ExpiresAt              *time.Time             `json:"expires_at,omitempty"`
+	DisabledAt             *time.Time             `json:"disabled_at,omitempty"`
+	DisabledReason         *string                `json:"disabled_reason,omitempty"`
+	ErasedAt               *time.Time             `json:"erased_at,omitempty"`
 	Permissions            common.Permission      `json:"permissions,omitempty"`
 	PrivacyPolicyAgreedAt  *time.Time             `json:"privacy_policy_agreed_at"`
 	TermsOfServiceAgreedAt *time.Time             `json:"terms_of_service_agreed_at"`
 	Metadata               *EphemeralUserMetadata `json:"metadata,omitempty"`
+	Attributes             *json.RawMessage       `json:"attributes,omitempty"`
 }
 
 // Find returns a user for the given id
@@ -110,8 +138,10 @@ func Exists(email string, applicationID *uuid.UUID, organizationID *uuid.UUID) b
 }
 
 // AuthenticateUser attempts to authenticate by email address and password;
-// i.e., this is equivalent to grant_type=password under the OAuth 2 spec
-func AuthenticateUser(tx *gorm.DB, email, password string, applicationID *uuid.UUID, scope *string) (*AuthenticationResponse, error) {
+// i.e., this is equivalent to grant_type=password under the OAuth 2 spec;
+// when a session is given, it is established for the authenticated user; platform users
+// cannot authenticate while the configured ldap directory is unavailable
+func AuthenticateUser(tx *gorm.DB, email, password string, applicationID *uuid.UUID, scope *string, session *token.Session) (*AuthenticationResponse, error) {
 	var db *gorm.DB
 	if tx != nil {
 		db = tx
@@ -120,6 +150,32 @@ func AuthenticateUser(tx *gorm.DB, email, password string, applicationID *uuid.U
 	}
 
 	var user = &User{}
+	if isLDAPAuthenticationEnabled(applicationID) {
+		ldapUser, err := authenticateLDAPUser(db, email, password)
+		if err == nil {
+			if !ldapUser.hasPermission(common.Authenticate) {
+				common.Log.Debugf("authentication failed for ldap user: %s; revoked authenticate permission", ldapUser.ID)
+				return nil, errInvalidCredentials
+			}
+			if ldapUser.IsDisabled() {
+				common.Log.Debugf("authentication failed for ldap user: %s; user has been suspended", ldapUser.ID)
+				return nil, errUserSuspended
+			}
+			if ldapUser.IsExpired() {
+				common.Log.Debugf("authentication failed for ldap user: %s; user account has expired", ldapUser.ID)
+				return nil, errUserExpired
+			}
+			return ldapUser.authenticationResponse(db, scope, session)
+		} else if errors.Is(err, errLDAPUnavailable) {
+			common.Log.Warningf("ldap authentication failed for %s; %s", email, err.Error())
+			return nil, errLDAPUnavailable
+		} else if err != errLDAPUserNotFound {
+			common.Log.Debugf("ldap authentication failed for %s; %s", email, err.Error())
+			return nil, errInvalidCredentials
+		}
+		// users which do not exist in the directory authenticate using their local password
+	}
+
 	query := db.Where("email = ?", strings.ToLower(email))
 	if applicationID != nil && *applicationID != uuid.Nil {
 		query = query.Where("application_id = ?", applicationID)
@@ -129,61 +185,110 @@ func AuthenticateUser(tx *gorm.DB, email, password string, applicationID *uuid.U
 
 	query.First(&user)
 	if user != nil && user.ID != uuid.Nil {
+		if !user.authenticate(password) {
+			common.Log.Debugf("authentication failed for user: %s; invalid password", user.ID)
+			return nil, errInvalidCredentials
+		}
+
 		if !user.hasPermission(common.Authenticate) {
-			return nil, errors.New("authentication failed due to revoked authenticate permission")
+			common.Log.Debugf("authentication failed for user: %s; revoked authenticate permission", user.ID)
+			return nil, errInvalidCredentials
 		}
 
-		if !user.authenticate(password) {
-			return nil, errors.New("authentication failed with given credentials")
+		if user.IsDisabled() {
+			common.Log.Debugf("authentication failed for user: %s; user has been suspended", user.ID)
+			return nil, errUserSuspended
+		}
+
+		if user.IsExpired() {
+			common.Log.Debugf("authentication failed for user: %s; user account has expired", user.ID)
+			return nil, errUserExpired
+		}
+
+		if passwordNeedsRehash(*user.Password) {
+			user.upgradePasswordHash(db, password)
+		}
+
+		if applicationID != nil && *applicationID != uuid.Nil && !user.IsEmailVerified() && ApplicationRequiresVerifiedEmail(db, *applicationID) {
+			return nil, errors.New("authentication failed; email address has not been verified")
 		}
 	} else {
-		return nil, fmt.Errorf("invalid email")
+		compareDummyPassword(password)
+		return nil, errInvalidCredentials
+	}
+
+	return user.authenticationResponse(db, scope, session)
+}
+
+// authenticationResponse vends a token on behalf of the authenticated user
+func (u *User) authenticationResponse(db *gorm.DB, scope *string, session *token.Session) (*AuthenticationResponse, error) {
+	if resp := u.consentRequiredResponse(db); resp != nil {
+		return resp, errConsentRequired
 	}
 
 	token := &token.Token{
-		UserID:      &user.ID,
+		UserID:      &u.ID,
 		Scope:       scope,
-		Permissions: user.Permissions,
+		Permissions: u.Permissions,
 	}
 
-	if !token.Vend() {
+	if !u.vendToken(db, token, session) {
 		var err error
 		if len(token.Errors) > 0 {
-			err = fmt.Errorf("failed to create token for authenticated user: %s; %s", *user.Email, *token.Errors[0].Message)
+			err = fmt.Errorf("failed to create token for authenticated user: %s; %s", *u.Email, *token.Errors[0].Message)
 			common.Log.Warningf(err.Error())
 		}
 
 		return &AuthenticationResponse{
-			User:  user.AsResponse(),
+			User:  u.AsResponse(),
 			Token: nil,
 		}, err
 	}
 
 	return &AuthenticationResponse{
-		User:  user.AsResponse(),
+		User:  u.AsResponse(),
 		Token: token.AsResponse(),
 	}, nil
 }
 
 // AuthenticateApplicationUser vends a user token on behalf of the owning application
-func AuthenticateApplicationUser(email string, applicationID uuid.UUID, scope *string) (*AuthenticationResponse, error) {
+func AuthenticateApplicationUser(email string, applicationID uuid.UUID, scope *string, session *token.Session) (*AuthenticationResponse, error) {
 	var user = &User{}
 	db := dbconf.DatabaseConnection()
 	query := db.Where("application_id = ? AND email = ?", applicationID, strings.ToLower(email))
 	query.First(&user)
 	if user != nil && user.ID != uuid.Nil {
+		// locked accounts are indistinguishable from invalid credentials
+		if user.IsLocked() {
+			common.Log.Debugf("application user authentication failed for user: %s; authentication locked", user.ID)
+			return nil, errInvalidCredentials
+		}
+
 		if !user.hasPermission(common.Authenticate) {
-			return nil, errors.New("authentication failed due to revoked authenticate permission")
+			common.Log.Debugf("application user authentication failed for user: %s; revoked authenticate permission", user.ID)
+			return nil, errInvalidCredentials
+		}
+
+		if user.IsDisabled() {
+			return nil, errUserSuspended
 		}
 
 		if user.Password != nil {
 			return nil, errors.New("application user authentication not currently supported if user password is set")
 		}
+
+		if !user.IsEmailVerified() && ApplicationRequiresVerifiedEmail(db, applicationID) {
+			return nil, errors.New("application user authentication failed; email address has not been verified")
+		}
 	} else {
-		return nil, errors.New("application user authentication failed with given credentials")
+		return nil, errInvalidCredentials
+	}
+	if user.IsExpired() {
+		return nil, errUserExpired
 	}
-	if user.ExpiresAt != nil && time.Now().After(*user.ExpiresAt) {
-		return nil, errors.New("user authentication failed; user account has expired")
+
+	if resp := user.consentRequiredResponse(db); resp != nil {
+		return resp, errConsentRequired
 	}
 
 	token := &token.Token{
@@ -191,7 +296,7 @@ func AuthenticateApplicationUser(email string, applicationID uuid.UUID, scope *s
 		Scope:       scope,
 		Permissions: user.Permissions,
 	}
-	if !token.Vend() {
+	if !user.vendToken(db, token, session) {
 		var err error
 		if len(token.Errors) > 0 {
 			err = fmt.Errorf("failed to create token for application-authenticated user: %s; %s", *user.Email, *token.Errors[0].Message)
@@ -208,12 +313,92 @@ func AuthenticateApplicationUser(email string, applicationID uuid.UUID, scope *s
 	}, nil
 }
 
+// vendFederatedToken vends a token for the user following its authentication by an upstream
+// identity provider; errConsentRequired is returned along with the pending legal documents if
+// the user has yet to consent to the latest published legal documents. The token is scoped to
+// the application or organization of the identity provider and never carries the elevated
+// permissions of the user, as the identity provider is not authoritative for them
+func (u *User) vendFederatedToken(db *gorm.DB, applicationID, organizationID *uuid.UUID, scope *string, session *token.Session) (*AuthenticationResponse, error) {
+	if resp := u.consentRequiredResponse(db); resp != nil {
+		resp.applicationID = applicationID
+		resp.organizationID = organizationID
+		return resp, errConsentRequired
+	}
+
+	accessToken := &token.Token{
+		ApplicationID:  applicationID,
+		OrganizationID: organizationID,
+		UserID:         &u.ID,
+		Scope:          scope,
+		Permissions:    u.Permissions & common.DefaultUserPermission,
+	}
+
+	if organizationID != nil {
+		// the resource permissions of a user are scoped by its role in the organization
+		mask, extendedPermissions, ok := role.EffectiveOrganizationPermissions(db, *organizationID, u.ID)
+		if !ok {
+			return nil, fmt.Errorf("failed to create token for federated user: %s; user is not a member of the organization", *u.Email)
+		}
+		accessToken.Permissions = (accessToken.Permissions &^ role.ResourcePermissions) | (mask & role.ResourcePermissions)
+		if extendedPermissions != nil {
+			rawExtendedPermissions, _ := json.Marshal(extendedPermissions)
+			extendedPermissionsJSON := json.RawMessage(rawExtendedPermissions)
+			accessToken.ExtendedPermissions = &extendedPermissionsJSON
+		}
+	}
+
+	if !u.vendToken(db, accessToken, session) {
+		var err error
+		if len(accessToken.Errors) > 0 {
+			err = fmt.Errorf("failed to create token for federated user: %s; %s", *u.Email, *accessToken.Errors[0].Message)
+			common.Log.Warningf(err.Error())
+		}
+		return &AuthenticationResponse{
+			User:           u.AsResponse(),
+			Token:          nil,
+			applicationID:  applicationID,
+			organizationID: organizationID,
+		}, err
+	}
+
+	return &AuthenticationResponse{
+		User:           u.AsResponse(),
+		Token:          accessToken.AsResponse(),
+		applicationID:  applicationID,
+		organizationID: organizationID,
+	}, nil
+}
+
+// vendToken vends the given token for the user; when a session is given, the session
+// is established and the token is vended on its behalf
+func (u *User) vendToken(db *gorm.DB, tkn *token.Token, session *token.Session) bool {
+	if u.IsDisabled() {
+		tkn.Errors = append(tkn.Errors, &provide.Error{
+			Message: common.StringOrNil(errUserSuspended.Error()),
+		})
+		return false
+	}
+
+	if session == nil {
+		return tkn.Vend()
+	}
+
+	session.UserID = &u.ID
+	session.ApplicationID = u.ApplicationID
+	if !session.Create(db) {
+		tkn.Errors = append(tkn.Errors, session.Errors...)
+		return false
+	}
+
+	return session.Vend(db, tkn)
+}
+
 // authenticate returns true if the User can be authenticated using the given password
 func (u *User) authenticate(password string) bool {
 	if u.Password == nil {
 		return false
 	}
-	return bcrypt.CompareHashAndPassword([]byte(*u.Password), []byte(password)) == nil
+	return comparePassword(*u.Password, password)
 }
 
 // hasPermission returns true if the permissioned User has the given permissions
@@ -283,6 +468,12 @@ func (u *User) Create(tx *gorm.DB, createAuth0User bool) bool {
 					natsutil.NatsJetstreamPublish(natsSiaUserNotificationSubject, payload)
 				}
 
+				// when the user is created within a caller transaction, the caller dispatches
+				// the verification request once the transaction has been committed
+				if success && tx == nil && !u.IsEmailVerified() {
+					u.requestEmailVerification()
+				}
+
 				return success
 			}
 		}
@@ -300,6 +491,20 @@ func (u *User) Update() bool {
 	}
 
 	tx := db.Begin()
+	defer tx.RollbackUnlessCommitted()
+
+	if u.replacedPasswordHash != nil {
+		// the user is locked such that concurrent password changes are serialized
+		var currentPasswordHash *string
+		tx.Raw("SELECT password FROM users WHERE id = ? FOR UPDATE", u.ID).Row().Scan(&currentPasswordHash)
+		if currentPasswordHash == nil || *currentPasswordHash != *u.replacedPasswordHash {
+			u.Errors = append(u.Errors, &provide.Error{
+				Message: common.StringOrNil("password was changed concurrently"),
+			})
+			return false
+		}
+	}
+
 	result := tx.Save(&u)
 	success := result.RowsAffected > 0
 	errors := result.GetErrors()
@@ -311,6 +516,17 @@ func (u *User) Update() bool {
 		}
 	}
 
+	if success && u.replacedPasswordHash != nil {
+		err := u.recordPasswordHistory(tx, *u.replacedPasswordHash)
+		if err != nil {
+			u.Errors = append(u.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+			return false
+		}
+		u.replacedPasswordHash = nil
+	}
+
 	if success && common.Auth0IntegrationEnabled && !common.Auth0IntegrationCustomDatabase {
 		err := u.updateAuth0User()
 		if err != nil {
@@ -327,7 +543,7 @@ func (u *User) Update() bool {
 	return success
 }
 
-func (u *User) addApplicationAssociation(tx *gorm.DB, appID uuid.UUID, permissions common.Permission) bool {
+func (u *User) addApplicationAssociation(tx *gorm.DB, appID uuid.UUID, permissions common.Permission, roleID *uuid.UUID) bool {
 	var db *gorm.DB
 	if tx != nil {
 		db = tx
@@ -336,7 +552,7 @@ func (u *User) addApplicationAssociation(tx *gorm.DB, appID uuid.UUID, permissio
 	}
 
 	common.Log.Debugf("adding user %s to application: %s", u.ID, appID)
-	result := db.Exec("INSERT INTO applications_users (application_id, user_id, permissions) VALUES (?, ?, ?)", appID, u.ID, permissions)
+	result := db.Exec("INSERT INTO applications_users (application_id, user_id, permissions, role_id) VALUES (?, ?, ?, ?)", appID, u.ID, permissions, roleID)
 	success := result.RowsAffected == 1
 	if success {
 		common.Log.Debugf("added user %s to application: %s", u.ID, appID)
@@ -354,7 +570,7 @@ func (u *User) addApplicationAssociation(tx *gorm.DB, appID uuid.UUID, permissio
 	return success
 }
 
-func (u *User) addOrganizationAssociation(tx *gorm.DB, orgID uuid.UUID, permissions common.Permission) bool {
+func (u *User) addOrganizationAssociation(tx *gorm.DB, orgID uuid.UUID, permissions common.Permission, roleID *uuid.UUID) bool {
 	var db *gorm.DB
 	if tx != nil {
 		db = tx
@@ -363,7 +579,7 @@ func (u *User) addOrganizationAssociation(tx *gorm.DB, orgID uuid.UUID, permissi
 	}
 
 	common.Log.Debugf("adding user %s to organization: %s", u.ID, orgID)
-	result := db.Exec("INSERT INTO organizations_users (organization_id, user_id, permissions) VALUES (?, ?, ?)", orgID, u.ID, permissions)
+	result := db.Exec("INSERT INTO organizations_users (organization_id, user_id, permissions, role_id) VALUES (?, ?, ?, ?)", orgID, u.ID, permissions, roleID)
 	success := result.RowsAffected == 1
 	if success {
 		common.Log.Debugf("added user %s to organization: %s", u.ID, orgID)
@@ -480,7 +696,7 @@ func (u *User) validate() bool {
 	u.Errors = make([]*provide.Error, 0)
 	db := dbconf.DatabaseConnection()
 	if db.NewRecord(u) {
-		if u.Password != nil || u.ApplicationID == nil {
+		if !u.federated && (u.Password != nil || u.ApplicationID == nil) {
 			u.verifyEmailAddress()
 			u.rehashPassword()
 		}
@@ -491,23 +707,52 @@ func (u *User) validate() bool {
 	return len(u.Errors) == 0
 }
 
-func (u *User) rehashPassword() {
-	if u.Password != nil {
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(*u.Password), bcrypt.DefaultCost)
-		if err != nil {
+// rehashPassword validates the plaintext password set on the user against the password
+// policy and replaces it with its hash; the replaced hash is retained in the password history
+// within the transaction which subsequently updates the user
+func (u *User) rehashPassword() bool {
+	if u.Password == nil {
+		u.Errors = append(u.Errors, &provide.Error{
+			Message: common.StringOrNil("invalid password"),
+		})
+		return false
+	}
+
+	password := *u.Password
+	if !u.validatePassword(password) {
+		u.Password = nil
+		return false
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	var currentPasswordHash *string
+	if !db.NewRecord(u) {
+		currentPasswordHash = u.currentPasswordHash(db)
+		if u.isPasswordReused(db, currentPasswordHash, password) {
 			u.Password = nil
 			u.Errors = append(u.Errors, &provide.Error{
-				Message: common.StringOrNil(err.Error()),
+				Message: common.StringOrNil(fmt.Sprintf("password must not match any of the last %d passwords", common.PasswordHistorySize)),
 			})
-		} else {
-			u.Password = common.StringOrNil(string(hashedPassword))
-			u.ResetPasswordToken = nil
+			return false
 		}
-	} else {
+	}
+
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		u.Password = nil
 		u.Errors = append(u.Errors, &provide.Error{
-			Message: common.StringOrNil("invalid password"),
+			Message: common.StringOrNil(err.Error()),
 		})
+		return false
 	}
+
+	u.Password = common.StringOrNil(hashedPassword)
+	u.ResetPasswordToken = nil
+
+	u.replacedPasswordHash = currentPasswordHash
+
+	return true
 }
 
 // Delete a user
@@ -549,6 +794,108 @@ func (u *User) Delete() bool {
 	return success
 }
 
+// IsDisabled returns true if the user has been suspended
+func (u *User) IsDisabled() bool {
+	return u.DisabledAt != nil
+}
+
+// Suspend the user; suspended users cannot authenticate, refresh access tokens or accept
+// invitations, and all outstanding tokens are revoked. The user and its memberships are
+// retained so the user can subsequently be reactivated.
+func (u *User) Suspend(tx *gorm.DB, reason *string) bool {
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+		db = db.Begin()
+		defer db.RollbackUnlessCommitted()
+	}
+
+	disabledAt := time.Now()
+	result := db.Model(u).Updates(map[string]interface{}{
+		"disabled_at":     disabledAt,
+		"disabled_reason": reason,
+	})
+	if result.Error != nil {
+		u.Errors = append(u.Errors, &provide.Error{
+			Message: common.StringOrNil(result.Error.Error()),
+		})
+		return false
+	}
+
+	err := token.RevokeUserTokens(db, u.ID)
+	if err != nil {
+		u.Errors = append(u.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+		return false
+	}
+
+	if tx == nil {
+		db.Commit()
+	}
+
+	u.DisabledAt = &disabledAt
+	u.DisabledReason = reason
+	common.Log.Debugf("suspended user: %s", u.ID)
+	return true
+}
+
+// Reactivate a previously-suspended user
+func (u *User) Reactivate(tx *gorm.DB) bool {
+	var db *gorm.DB
+	if tx != nil {
+		db = tx
+	} else {
+		db = dbconf.DatabaseConnection()
+	}
+
+	if !u.IsDisabled() {
+		u.Errors = append(u.Errors, &provide.Error{
+			Message: common.StringOrNil("user is not suspended"),
+		})
+		return false
+	}
+
+	if u.IsErasureRequested() {
+		u.Errors = append(u.Errors, &provide.Error{
+			Message: common.StringOrNil("user has been erased or is pending erasure"),
+		})
+		return false
+	}
+
+	if u.IsExpired() {
+		u.Errors = append(u.Errors, &provide.Error{
+			Message: common.StringOrNil("user account has expired; the expiration must be extended prior to reactivation"),
+		})
+		return false
+	}
+
+	result := db.Model(u).Updates(map[string]interface{}{
+		"disabled_at":     gorm.Expr("NULL"),
+		"disabled_reason": gorm.Expr("NULL"),
+	})
+	if result.Error != nil {
+		u.Errors = append(u.Errors, &provide.Error{
+			Message: common.StringOrNil(result.Error.Error()),
+		})
+		return false
+	}
+
+	u.DisabledAt = nil
+	u.DisabledReason = nil
+	common.Log.Debugf("reactivated user: %s", u.ID)
+	return true
+}
+
+// isOrganizationSuspended returns true if the organization with the given id has been suspended
+func isOrganizationSuspended(db *gorm.DB, organizationID uuid.UUID) bool {
+	var suspended int
+	db.Table("organizations").Where("id = ? AND disabled_at IS NOT NULL", organizationID).Count(&suspended)
+	return suspended > 0
+}
+
 // AsResponse marshals a user into a user response
 func (u *User) AsResponse() *Response {
 	return &Response{
@@ -558,7 +905,14 @@ func (u *User) AsResponse() *Response {
 		FirstName:              *u.FirstName,
 		LastName:               *u.LastName,
 		Email:                  *u.Email,
+		EmailVerifiedAt:        u.EmailVerifiedAt,
+		PendingEmail:           u.PendingEmail,
+		ExpiresAt:              u.ExpiresAt,
+		DisabledAt:             u.DisabledAt,
+		DisabledReason:         u.DisabledReason,
+		ErasedAt:               u.ErasedAt,
 		Metadata:               u.EphemeralMetadata,
+		Attributes:             u.Metadata,
 		Permissions:            u.Permissions,
 		PrivacyPolicyAgreedAt:  u.PrivacyPolicyAgreedAt,
 		TermsOfServiceAgreedAt: u.TermsOfServiceAgreedAt,
@@ -570,45 +924,54 @@ func (u *User) requestPasswordReset(db *gorm.DB) bool {
 	if u.CreateResetPasswordToken(db) {
 		common.Log.Debugf("created reset password token for user: %s", u.ID)
 		common.Log.Warningf("TODO: dispatch reset password token to user out-of-band...")
-		common.Log.Debugf("%s", *u.ResetPasswordToken)
 		return true
 	}
 
 	return false
 }
 
-// CreateResetPasswordToken creates a reset password token
+// CreateResetPasswordToken creates a signed, single-use reset password token; any
+// previously-issued reset password token for the user is revoked
 func (u *User) CreateResetPasswordToken(db *gorm.DB) bool {
-	issuedAt := time.Now()
-	tokenID, err := uuid.NewV4()
-	if err != nil {
-		common.Log.Warningf("failed to generate reset password JWT token; %s", err.Error())
-		return false
+	if u.ResetPasswordToken != nil {
+		prevToken, err := token.Parse(*u.ResetPasswordToken)
+		if err == nil && !prevToken.IsRevoked() {
+			if !prevToken.Revoke(nil) {
+				common.Log.Warningf("failed to revoke previously-issued reset password token for user: %s", u.ID)
+			}
+		}
 	}
-	appClaims := map[string]interface{}{
+
+	dataJSON, _ := json.Marshal(map[string]interface{}{
 		"name":       u.FullName(),
 		"first_name": u.FirstName,
 		"last_name":  u.LastName,
-	}
-	if u.ApplicationID != nil {
-		appClaims["application_id"] = u.ApplicationID
-	}
-	claims := map[string]interface{}{
-		"jti":                        tokenID,
-		"exp":                        issuedAt.Add(defaultResetPasswordTokenTimeout).Unix(),
-		"iat":                        issuedAt.Unix(),
-		"sub":                        fmt.Sprintf("user:%s", u.ID.String()),
-		util.JWTApplicationClaimsKey: appClaims,
-	}
-
-	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims(claims))
-	token, err := jwtToken.SignedString([]byte{})
-	if err != nil {
-		common.Log.Warningf("failed to sign reset password JWT token; %s", err.Error())
+	})
+	data := json.RawMessage(dataJSON)
+
+	ttl := int(common.ResetPasswordTokenTTL.Seconds())
+	resetToken := &token.Token{
+		ApplicationID: u.ApplicationID,
+		UserID:        &u.ID,
+		Audience:      common.StringOrNil(token.PasswordResetAudience),
+		Subject:       common.StringOrNil(fmt.Sprintf("user:%s", u.ID.String())),
+		Data:          &data,
+		TTL:           &ttl,
+	}
+
+	if !resetToken.Vend() {
+		msg := "failed to vend reset password token"
+		if len(resetToken.Errors) > 0 {
+			msg = fmt.Sprintf("%s; %s", msg, *resetToken.Errors[0].Message)
+		}
+		common.Log.Warning(msg)
+		u.Errors = append(u.Errors, &provide.Error{
+			Message: common.StringOrNil(msg),
+		})
 		return false
 	}
 
-	u.ResetPasswordToken = common.StringOrNil(token)
+	u.ResetPasswordToken = resetToken.Token
 
 	result := db.Save(u)
 	errors := result.GetErrors()
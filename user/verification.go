@@ -0,0 +1,173 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/badoux/checkmail"
+	"github.com/jinzhu/gorm"
+	natsutil "github.com/kthomas/go-natsutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/ident/common"
+	"github.com/provideplatform/ident/token"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+const requireVerifiedEmailKey = "require_verified_email"
+
+// IsEmailVerified returns true if the user has verified ownership of their email address
+func (u *User) IsEmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// VendEmailVerificationToken vends a signed, single-use token which verifies ownership of
+// the user's pending email address or, if there is no pending address, the current one
+func (u *User) VendEmailVerificationToken() (*token.Token, error) {
+	email := u.Email
+	if u.PendingEmail != nil {
+		email = u.PendingEmail
+	}
+	if email == nil {
+		return nil, errors.New("failed to vend email verification token; no email address")
+	}
+
+	dataJSON, _ := json.Marshal(map[string]interface{}{
+		"email": email,
+	})
+	data := json.RawMessage(dataJSON)
+
+	ttl := int(common.EmailVerificationTokenTTL.Seconds())
+	verificationToken := &token.Token{
+		ApplicationID: u.ApplicationID,
+		UserID:        &u.ID,
+		Audience:      common.StringOrNil(token.EmailVerificationAudience),
+		Subject:       common.StringOrNil(fmt.Sprintf("user:%s", u.ID.String())),
+		Data:          &data,
+		TTL:           &ttl,
+	}
+
+	if !verificationToken.Vend() {
+		msg := "failed to vend email verification token"
+		if len(verificationToken.Errors) > 0 {
+			msg = fmt.Sprintf("%s; %s", msg, *verificationToken.Errors[0].Message)
+		}
+		return nil, errors.New(msg)
+	}
+
+	return verificationToken, nil
+}
+
+// requestEmailVerification vends an email verification token and dispatches it to the
+// address being verified out-of-band
+func (u *User) requestEmailVerification() bool {
+	verificationToken, err := u.VendEmailVerificationToken()
+	if err != nil {
+		common.Log.Warningf("failed to request email verification for user: %s; %s", u.ID, err.Error())
+		return false
+	}
+
+	payload, _ := json.Marshal(verificationToken)
+	natsutil.NatsJetstreamPublish(natsDispatchEmailVerificationSubject, payload)
+
+	common.Log.Debugf("dispatched email verification request for user: %s", u.ID)
+	return true
+}
+
+// requestEmailChange stages the given email address as pending until ownership of
+// the new address has been verified
+func (u *User) requestEmailChange(email string) bool {
+	email = strings.ToLower(email)
+	err := checkmail.ValidateFormat(email)
+	if err != nil {
+		u.Errors = append(u.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("invalid email address: %s; %s", email, err.Error())),
+		})
+		return false
+	}
+
+	if Exists(email, u.ApplicationID, nil) {
+		u.Errors = append(u.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("user exists: %s", email)),
+		})
+		return false
+	}
+
+	u.PendingEmail = common.StringOrNil(email)
+	return true
+}
+
+// verifyEmail marks the given email address as verified; if the address is the user's
+// pending email address, it replaces the current one
+func (u *User) verifyEmail(email string) bool {
+	u.Errors = make([]*provide.Error, 0)
+
+	if u.PendingEmail != nil && *u.PendingEmail == email {
+		if Exists(email, u.ApplicationID, nil) {
+			u.Errors = append(u.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("user exists: %s", email)),
+			})
+			return false
+		}
+		u.Email = u.PendingEmail
+		u.PendingEmail = nil
+	} else if u.Email == nil || *u.Email != email {
+		u.Errors = append(u.Errors, &provide.Error{
+			Message: common.StringOrNil("email address does not match"),
+		})
+		return false
+	}
+
+	verifiedAt := time.Now()
+	u.EmailVerifiedAt = &verifiedAt
+	return u.Update()
+}
+
+// markEmailVerified marks the user's current email address as verified within
+// the given transaction; this is used when ownership of the address has been
+// proven out-of-band (i.e., by redeeming an invitation sent to the address)
+func (u *User) markEmailVerified(tx *gorm.DB) bool {
+	verifiedAt := time.Now()
+	result := tx.Model(&User{}).Where("id = ?", u.ID).Update("email_verified_at", verifiedAt)
+	success := result.RowsAffected == 1
+	if success {
+		u.EmailVerifiedAt = &verifiedAt
+	}
+	return success
+}
+
+// ApplicationRequiresVerifiedEmail returns true if the application with the
+// given id refuses to authenticate or invite users with unverified email addresses
+func ApplicationRequiresVerifiedEmail(db *gorm.DB, applicationID uuid.UUID) bool {
+	var cfg *string
+	db.Raw("SELECT config FROM applications WHERE id = ?", applicationID).Row().Scan(&cfg)
+	return requiresVerifiedEmail(cfg)
+}
+
+// OrganizationRequiresVerifiedEmail returns true if the organization with the
+// given id refuses to authenticate or invite users with unverified email addresses
+func OrganizationRequiresVerifiedEmail(db *gorm.DB, organizationID uuid.UUID) bool {
+	var metadata *string
+	db.Raw("SELECT metadata FROM organizations WHERE id = ?", organizationID).Row().Scan(&metadata)
+	return requiresVerifiedEmail(metadata)
+}
+
+// requiresVerifiedEmail returns true if the given application config or organization
+// metadata has the verified email requirement set
+func requiresVerifiedEmail(raw *string) bool {
+	if raw == nil {
+		return false
+	}
+
+	params := map[string]interface{}{}
+	err := json.Unmarshal([]byte(*raw), &params)
+	if err != nil {
+		common.Log.Warningf("failed to unmarshal params to check verified email requirement; %s", err.Error())
+		return false
+	}
+
+	required, requiredOk := params[requireVerifiedEmailKey].(bool)
+	return requiredOk && required
+}
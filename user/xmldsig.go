@@ -0,0 +1,179 @@
+package user
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+)
+
+const xmldsigIDAttribute = "ID"
+
+// errXMLSignatureMissing is returned when verifying an element which has not been signed
+var errXMLSignatureMissing = errors.New("xml element is not signed")
+
+// xmldsigSignatureMethods are the supported signature algorithms; sha1 is intentionally unsupported
+var xmldsigSignatureMethods = map[string]bool{
+	dsig.RSASHA256SignatureMethod: true,
+	dsig.RSASHA512SignatureMethod: true,
+}
+
+// xmldsigDigestMethods are the supported reference digest algorithms
+var xmldsigDigestMethods = map[string]bool{
+	"http://www.w3.org/2001/04/xmlenc#sha256": true,
+	"http://www.w3.org/2001/04/xmlenc#sha512": true,
+}
+
+// parseXMLDocument parses the given XML document and returns its root element; DTDs are refused
+func parseXMLDocument(data []byte) (*etree.Element, error) {
+	doc := etree.NewDocument()
+	err := doc.ReadFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, token := range doc.Child {
+		if _, directiveOk := token.(*etree.Directive); directiveOk {
+			return nil, errors.New("xml document must not contain directives")
+		}
+	}
+
+	root := doc.Root()
+	if root == nil {
+		return nil, errors.New("xml document has no root element")
+	}
+	return root, nil
+}
+
+// xmlElementIs returns true if the given element has the given namespace and local name
+func xmlElementIs(el *etree.Element, namespace, local string) bool {
+	return el.Tag == local && el.NamespaceURI() == namespace
+}
+
+// xmlChildElements returns the child elements of the given element having the given namespace and local name
+func xmlChildElements(el *etree.Element, namespace, local string) []*etree.Element {
+	children := make([]*etree.Element, 0)
+	for _, child := range el.ChildElements() {
+		if xmlElementIs(child, namespace, local) {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// countXMLID returns the number of elements within the given tree having the given id
+func countXMLID(el *etree.Element, id string) int {
+	count := 0
+	if el.SelectAttrValue(xmldsigIDAttribute, "") == id {
+		count++
+	}
+	for _, child := range el.ChildElements() {
+		count += countXMLID(child, id)
+	}
+	return count
+}
+
+// decodeXMLBase64 decodes base64 content which may be wrapped across several lines
+func decodeXMLBase64(val string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(val), ""))
+}
+
+// parseX509Certificate parses a PEM-encoded or bare base64-encoded DER certificate
+func parseX509Certificate(val string) (*x509.Certificate, error) {
+	if block, _ := pem.Decode([]byte(strings.TrimSpace(val))); block != nil {
+		return x509.ParseCertificate(block.Bytes)
+	}
+
+	der, err := decodeXMLBase64(val)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// verifyXMLSignature verifies the enveloped signature of the given element using one of the given
+// trusted certificates, and returns the signed element serialized as a standalone document with
+// the signature removed; callers must only consume the returned bytes, which are exactly those
+// covered by the signature
+func verifyXMLSignature(el *etree.Element, certificates []*x509.Certificate) ([]byte, error) {
+	signatures := xmlChildElements(el, dsig.Namespace, dsig.SignatureTag)
+	if len(signatures) == 0 {
+		return nil, errXMLSignatureMissing
+	} else if len(signatures) > 1 {
+		return nil, errors.New("xml element has multiple signatures")
+	}
+	signature := signatures[0]
+
+	signedInfo := xmlChildElements(signature, dsig.Namespace, dsig.SignedInfoTag)
+	if len(signedInfo) != 1 {
+		return nil, errors.New("xml signature must have exactly one signed info")
+	}
+
+	signatureMethods := xmlChildElements(signedInfo[0], dsig.Namespace, dsig.SignatureMethodTag)
+	if len(signatureMethods) != 1 || !xmldsigSignatureMethods[signatureMethods[0].SelectAttrValue(dsig.AlgorithmAttr, "")] {
+		return nil, errors.New("xml signature uses an unsupported signature method")
+	}
+
+	references := xmlChildElements(signedInfo[0], dsig.Namespace, dsig.ReferenceTag)
+	if len(references) != 1 {
+		return nil, errors.New("xml signature must have exactly one reference")
+	}
+
+	id := el.SelectAttrValue(xmldsigIDAttribute, "")
+	if id == "" || references[0].SelectAttrValue(dsig.URIAttr, "") != fmt.Sprintf("#%s", id) {
+		return nil, errors.New("xml signature does not reference the signed element")
+	}
+
+	root := el
+	for root.Parent() != nil {
+		root = root.Parent()
+	}
+	if countXMLID(root, id) != 1 {
+		return nil, errors.New("xml document has duplicate element ids")
+	}
+
+	for _, digestMethod := range xmlChildElements(references[0], dsig.Namespace, dsig.DigestMethodTag) {
+		if !xmldsigDigestMethods[digestMethod.SelectAttrValue(dsig.AlgorithmAttr, "")] {
+			return nil, errors.New("xml signature uses an unsupported digest method")
+		}
+	}
+
+	// the element is detached from the document along with the namespaces declared by its
+	// ancestors, so the verified element can be consumed on its own
+	nsCtx, err := etreeutils.NSBuildParentContext(el)
+	if err != nil {
+		return nil, err
+	}
+	nsCtx, err = nsCtx.SubContext(el)
+	if err != nil {
+		return nil, err
+	}
+	detached, err := etreeutils.NSDetatch(nsCtx, el)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, certificate := range certificates {
+		ctx := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{
+			Roots: []*x509.Certificate{certificate},
+		})
+		ctx.IdAttribute = xmldsigIDAttribute
+
+		verified, err := ctx.Validate(detached)
+		if err != nil {
+			continue
+		}
+
+		doc := etree.NewDocument()
+		doc.SetRoot(verified)
+		return doc.WriteToBytes()
+	}
+
+	return nil, errors.New("xml signature verification failed")
+}